@@ -0,0 +1,374 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// defaultTTL is the time after which a pending request expires, when no
+	// ttl is configured.
+	defaultTTL = 1 * time.Hour
+	// retention is the time a request is kept after it was created. Pending
+	// requests are always kept until they are expired.
+	retention = 7 * 24 * time.Hour
+)
+
+// approvalsResource is the resource which is used in the returned errors.
+var approvalsResource = schema.GroupResource{Resource: "approvals"}
+
+// Status is the status of an approval request. An approved request has the
+// status "approved" while the action is running and the status "executed" or
+// "failed" after the action was run.
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusApproved Status = "approved"
+	StatusRejected Status = "rejected"
+	StatusExpired  Status = "expired"
+	StatusExecuted Status = "executed"
+	StatusFailed   Status = "failed"
+)
+
+// Request is a request to run an action, which must be approved by another
+// user. It contains the exact method, url and body of the original request, so
+// that the action can be run with the same options after it was approved.
+type Request struct {
+	Id        string    `json:"id"`
+	Status    Status    `json:"status"`
+	Requester string    `json:"requester"`
+	Verb      string    `json:"verb"`
+	Resource  string    `json:"resource"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Method    string    `json:"method"`
+	Url       string    `json:"url"`
+	Body      string    `json:"body,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Approver  string    `json:"approver,omitempty"`
+	DecidedAt time.Time `json:"decidedAt,omitzero"`
+	Result    *Result   `json:"result,omitempty"`
+}
+
+// Result is the response of the action, after the request was approved.
+type Result struct {
+	StatusCode int    `json:"statusCode"`
+	Body       string `json:"body"`
+}
+
+// record is a request with the headers of the original request, which are
+// required to run the action with the identity of the requester. The headers
+// are only kept in the state file and are never returned by the store.
+type record struct {
+	Request Request     `json:"request"`
+	Header  http.Header `json:"header"`
+}
+
+// Store keeps the approval requests in a local JSON file, so that pending
+// requests are not lost when the plugin is restarted.
+//
+// All methods can be called on a nil store, which is used when the approval
+// mode is disabled.
+type Store struct {
+	path       string
+	ttl        time.Duration
+	teams      []string
+	namespaces []*regexp.Regexp
+	records    []record
+	lock       sync.Mutex
+}
+
+// Matches returns true, when an action in the provided namespace must be
+// approved. If no namespaces are configured, all actions must be approved.
+// Actions for cluster-scoped resources must only be approved, when no
+// namespaces are configured.
+func (s *Store) Matches(namespace string) bool {
+	if s == nil {
+		return false
+	}
+
+	if len(s.namespaces) == 0 {
+		return true
+	}
+	if namespace == "" {
+		return false
+	}
+
+	return slices.ContainsFunc(s.namespaces, func(filter *regexp.Regexp) bool {
+		return filter.MatchString(namespace)
+	})
+}
+
+// IsApprover returns true, when one of the provided teams is an approver team.
+func (s *Store) IsApprover(teams []string) bool {
+	if s == nil {
+		return false
+	}
+
+	return slices.ContainsFunc(teams, func(team string) bool {
+		return slices.Contains(s.teams, team)
+	})
+}
+
+// Create creates a new pending request. The provided headers are used to run
+// the action with the identity of the requester, after the request was
+// approved.
+func (s *Store) Create(request Request, header http.Header) (Request, error) {
+	if s == nil {
+		return Request{}, fmt.Errorf("approvals are not enabled")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+
+	request.Id = uuid.NewString()
+	request.Status = StatusPending
+	request.CreatedAt = now
+	request.ExpiresAt = now.Add(s.ttl)
+
+	s.prune(now)
+	s.records = append(s.records, record{Request: request, Header: header})
+
+	if err := s.save(); err != nil {
+		s.records = s.records[:len(s.records)-1]
+		return Request{}, err
+	}
+
+	return request, nil
+}
+
+// List returns all requests, the newest request is returned first. If the
+// status is set, only requests with the provided status are returned.
+func (s *Store) List(status Status) ([]Request, error) {
+	if s == nil {
+		return nil, fmt.Errorf("approvals are not enabled")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+
+	var requests []Request
+	for _, r := range slices.Backward(s.records) {
+		request := r.Request
+		if request.Status == StatusPending && now.After(request.ExpiresAt) {
+			request.Status = StatusExpired
+		}
+
+		if status == "" || request.Status == status {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests, nil
+}
+
+// Reject rejects the pending request with the provided id. The requester can
+// also reject the own request, to withdraw it.
+func (s *Store) Reject(id, approver string) (Request, error) {
+	request, _, err := s.decide(id, approver, StatusRejected, true)
+	return request, err
+}
+
+// Approve approves the pending request with the provided id and returns the
+// request with the headers of the requester, so that the action can be run
+// with the identity of the requester. The action must be run by the caller
+// and the result must be set via the "Complete" method. A request can not be
+// approved by the requester.
+func (s *Store) Approve(id, approver string) (Request, http.Header, error) {
+	return s.decide(id, approver, StatusApproved, false)
+}
+
+// Complete sets the result of the action for an approved request.
+func (s *Store) Complete(id string, result Result) (Request, error) {
+	if s == nil {
+		return Request{}, fmt.Errorf("approvals are not enabled")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	i := slices.IndexFunc(s.records, func(r record) bool { return r.Request.Id == id })
+	if i == -1 {
+		return Request{}, apierrors.NewNotFound(approvalsResource, id)
+	}
+
+	s.records[i].Request.Result = &result
+	s.records[i].Request.Status = StatusExecuted
+	if result.StatusCode < 200 || result.StatusCode >= 300 {
+		s.records[i].Request.Status = StatusFailed
+	}
+	s.records[i].Header = nil
+
+	return s.records[i].Request, s.save()
+}
+
+// decide sets the status and approver of the pending request with the
+// provided id and returns the request with the headers of the requester. If
+// the approver is the requester, an error is returned, unless it is allowed
+// via "allowRequester".
+func (s *Store) decide(id, approver string, status Status, allowRequester bool) (Request, http.Header, error) {
+	if s == nil {
+		return Request{}, nil, fmt.Errorf("approvals are not enabled")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	i := slices.IndexFunc(s.records, func(r record) bool { return r.Request.Id == id })
+	if i == -1 {
+		return Request{}, nil, apierrors.NewNotFound(approvalsResource, id)
+	}
+
+	request := s.records[i].Request
+	header := s.records[i].Header
+	now := time.Now()
+
+	if request.Status != StatusPending {
+		return Request{}, nil, apierrors.NewConflict(approvalsResource, id, fmt.Errorf("request is %s", request.Status))
+	}
+	if now.After(request.ExpiresAt) {
+		s.prune(now)
+		if err := s.save(); err != nil {
+			return Request{}, nil, err
+		}
+		return Request{}, nil, apierrors.NewConflict(approvalsResource, id, fmt.Errorf("request is expired"))
+	}
+	if !allowRequester && request.Requester == approver {
+		return Request{}, nil, apierrors.NewForbidden(approvalsResource, id, fmt.Errorf("request can not be approved by the requester"))
+	}
+
+	request.Status = status
+	request.Approver = approver
+	request.DecidedAt = now
+	s.records[i].Request = request
+	if status != StatusApproved {
+		s.records[i].Header = nil
+	}
+
+	if err := s.save(); err != nil {
+		return Request{}, nil, err
+	}
+
+	return request, header, nil
+}
+
+// prune removes all requests, which are older than the retention. Pending
+// requests are marked as expired and the headers are removed, when the
+// request is expired. It must be called with the lock held.
+func (s *Store) prune(now time.Time) {
+	s.records = slices.DeleteFunc(s.records, func(r record) bool {
+		if r.Request.Status == StatusPending && now.Before(r.Request.ExpiresAt) {
+			return false
+		}
+		return now.Sub(r.Request.CreatedAt) > retention
+	})
+
+	for i := range s.records {
+		if s.records[i].Request.Status == StatusPending && now.After(s.records[i].Request.ExpiresAt) {
+			s.records[i].Request.Status = StatusExpired
+			s.records[i].Header = nil
+		}
+	}
+}
+
+// save writes all requests to the state file. The requests are written to a
+// temporary file first, which is renamed afterwards, so that the state file is
+// never corrupted. It must be called with the lock held.
+func (s *Store) save() error {
+	data, err := json.Marshal(s.records)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), s.path)
+}
+
+// NewStore creates a new store, which keeps the requests in the file at the
+// provided path. If the file exists, the requests are loaded from the file.
+// Only members of the provided teams can approve requests.
+func NewStore(path string, ttl time.Duration, teams, namespaces []string) (*Store, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path for the approvals is required")
+	}
+	if len(teams) == 0 {
+		return nil, fmt.Errorf("approver teams are required")
+	}
+
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	var filters []*regexp.Regexp
+	for _, namespace := range namespaces {
+		filter, err := regexp.Compile(namespace)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	var records []record
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("could not unmarshal approvals: %w", err)
+		}
+	}
+
+	return &Store{
+		path:       path,
+		ttl:        ttl,
+		teams:      teams,
+		namespaces: filters,
+		records:    records,
+	}, nil
+}
+
+type approvedKey struct{}
+
+// WithApproved returns a context, which marks a request as approved, so that
+// the action is run without creating a new approval request.
+func WithApproved(ctx context.Context) context.Context {
+	return context.WithValue(ctx, approvedKey{}, true)
+}
+
+// IsApproved returns true, when the request was approved.
+func IsApproved(ctx context.Context) bool {
+	approved, _ := ctx.Value(approvedKey{}).(bool)
+	return approved
+}
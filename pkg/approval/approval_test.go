@@ -0,0 +1,178 @@
+package approval
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestStore(t *testing.T) {
+	header := http.Header{"X-Grafana-Id": []string{"token"}}
+
+	newTestStore := func(t *testing.T, ttl time.Duration) (*Store, string) {
+		path := filepath.Join(t.TempDir(), "approvals.json")
+		store, err := NewStore(path, ttl, []string{"sre"}, []string{"^production$"})
+		require.NoError(t, err)
+		return store, path
+	}
+
+	t.Run("should approve request", func(t *testing.T) {
+		store, path := newTestStore(t, time.Hour)
+
+		request, err := store.Create(Request{Requester: "alice", Verb: "uninstall", Resource: "helmreleases", Namespace: "production", Name: "echoserver", Method: http.MethodPost, Url: "/helm/production/echoserver/1/uninstall", Body: `{"wait":true}`}, header)
+		require.NoError(t, err)
+		require.Equal(t, StatusPending, request.Status)
+		require.NotEmpty(t, request.Id)
+
+		_, _, err = store.Approve(request.Id, "alice")
+		require.True(t, apierrors.IsForbidden(err))
+
+		approved, approvedHeader, err := store.Approve(request.Id, "bob")
+		require.NoError(t, err)
+		require.Equal(t, StatusApproved, approved.Status)
+		require.Equal(t, "bob", approved.Approver)
+		require.Equal(t, `{"wait":true}`, approved.Body)
+		require.Equal(t, header, approvedHeader)
+
+		_, _, err = store.Approve(request.Id, "carol")
+		require.True(t, apierrors.IsConflict(err))
+
+		completed, err := store.Complete(request.Id, Result{StatusCode: http.StatusOK, Body: "{}"})
+		require.NoError(t, err)
+		require.Equal(t, StatusExecuted, completed.Status)
+
+		t.Run("should load requests from file", func(t *testing.T) {
+			loaded, err := NewStore(path, time.Hour, []string{"sre"}, nil)
+			require.NoError(t, err)
+
+			requests, err := loaded.List("")
+			require.NoError(t, err)
+			require.Len(t, requests, 1)
+			require.Equal(t, StatusExecuted, requests[0].Status)
+			require.Equal(t, "bob", requests[0].Approver)
+			require.Nil(t, loaded.records[0].Header)
+		})
+	})
+
+	t.Run("should mark failed actions", func(t *testing.T) {
+		store, _ := newTestStore(t, time.Hour)
+
+		request, err := store.Create(Request{Requester: "alice"}, header)
+		require.NoError(t, err)
+		_, _, err = store.Approve(request.Id, "bob")
+		require.NoError(t, err)
+
+		completed, err := store.Complete(request.Id, Result{StatusCode: http.StatusInternalServerError, Body: "error"})
+		require.NoError(t, err)
+		require.Equal(t, StatusFailed, completed.Status)
+	})
+
+	t.Run("should reject request", func(t *testing.T) {
+		store, _ := newTestStore(t, time.Hour)
+
+		request, err := store.Create(Request{Requester: "alice"}, header)
+		require.NoError(t, err)
+
+		rejected, err := store.Reject(request.Id, "alice")
+		require.NoError(t, err)
+		require.Equal(t, StatusRejected, rejected.Status)
+
+		_, _, err = store.Approve(request.Id, "bob")
+		require.True(t, apierrors.IsConflict(err))
+	})
+
+	t.Run("should expire request", func(t *testing.T) {
+		store, _ := newTestStore(t, time.Millisecond)
+
+		request, err := store.Create(Request{Requester: "alice"}, header)
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+
+		requests, err := store.List(StatusExpired)
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+
+		_, _, err = store.Approve(request.Id, "bob")
+		require.True(t, apierrors.IsConflict(err))
+		require.Nil(t, store.records[0].Header)
+	})
+
+	t.Run("should list requests", func(t *testing.T) {
+		store, _ := newTestStore(t, time.Hour)
+
+		first, err := store.Create(Request{Requester: "alice", Name: "first"}, header)
+		require.NoError(t, err)
+		_, err = store.Create(Request{Requester: "alice", Name: "second"}, header)
+		require.NoError(t, err)
+		_, err = store.Reject(first.Id, "bob")
+		require.NoError(t, err)
+
+		requests, err := store.List("")
+		require.NoError(t, err)
+		require.Len(t, requests, 2)
+		require.Equal(t, "second", requests[0].Name)
+
+		requests, err = store.List(StatusPending)
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		require.Equal(t, "second", requests[0].Name)
+	})
+
+	t.Run("should return not found error", func(t *testing.T) {
+		store, _ := newTestStore(t, time.Hour)
+
+		_, _, err := store.Approve("unknown", "bob")
+		require.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("should match namespaces and teams", func(t *testing.T) {
+		store, _ := newTestStore(t, time.Hour)
+
+		require.True(t, store.Matches("production"))
+		require.False(t, store.Matches("staging"))
+		require.False(t, store.Matches(""))
+		require.True(t, store.IsApprover([]string{"dev", "sre"}))
+		require.False(t, store.IsApprover([]string{"dev"}))
+
+		all, err := NewStore(filepath.Join(t.TempDir(), "approvals.json"), time.Hour, []string{"sre"}, nil)
+		require.NoError(t, err)
+		require.True(t, all.Matches("staging"))
+		require.True(t, all.Matches(""))
+	})
+
+	t.Run("should handle nil store", func(t *testing.T) {
+		var store *Store
+		require.False(t, store.Matches("production"))
+		require.False(t, store.IsApprover([]string{"sre"}))
+
+		_, err := store.Create(Request{}, nil)
+		require.Error(t, err)
+	})
+
+	t.Run("should validate settings", func(t *testing.T) {
+		_, err := NewStore("", time.Hour, []string{"sre"}, nil)
+		require.Error(t, err)
+
+		_, err = NewStore(filepath.Join(t.TempDir(), "approvals.json"), time.Hour, nil, nil)
+		require.Error(t, err)
+
+		_, err = NewStore(filepath.Join(t.TempDir(), "approvals.json"), time.Hour, []string{"sre"}, []string{"["})
+		require.Error(t, err)
+
+		path := filepath.Join(t.TempDir(), "approvals.json")
+		require.NoError(t, os.WriteFile(path, []byte("invalid"), 0600))
+		_, err = NewStore(path, time.Hour, []string{"sre"}, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestWithApproved(t *testing.T) {
+	require.False(t, IsApproved(context.Background()))
+	require.True(t, IsApproved(WithApproved(context.Background())))
+}
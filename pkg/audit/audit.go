@@ -0,0 +1,302 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// defaultMaxSize is the maximum size of the audit log file in bytes, when
+	// no size is configured. When the size is reached, the file is rotated.
+	defaultMaxSize = 100 * 1024 * 1024
+	// defaultMaxBackups is the number of rotated audit log files which are
+	// kept, when no number is configured.
+	defaultMaxBackups = 5
+)
+
+// Entry is a single entry in the audit log. The verb is the Kubernetes verb
+// for requests which are proxied to the Kubernetes API (e.g. "create" or
+// "patch") or the name of the action for the action endpoints of the plugin
+// (e.g. "restart" or "rollback"). The resource is the plural name of the
+// resource, followed by the API group and subresource, like it is used by
+// kubectl (e.g. "deployments.apps" or "pods/exec").
+type Entry struct {
+	Time       time.Time `json:"time"`
+	RequestId  string    `json:"requestId"`
+	User       string    `json:"user"`
+	Groups     []string  `json:"groups,omitempty"`
+	Verb       string    `json:"verb"`
+	Resource   string    `json:"resource"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	StatusCode int       `json:"statusCode"`
+	DryRun     bool      `json:"dryRun"`
+}
+
+// String returns a short description of the entry, e.g. "rollback
+// helmreleases default/echoserver by admin".
+func (e Entry) String() string {
+	name := e.Name
+	if e.Namespace != "" {
+		name = e.Namespace + "/" + e.Name
+	}
+
+	text := fmt.Sprintf("%s %s %s", e.Verb, e.Resource, name)
+	if e.User != "" {
+		text = fmt.Sprintf("%s by %s", text, e.User)
+	}
+	return text
+}
+
+// Logger writes the audit log entries to a JSON lines file. When the file
+// exceeds the maximum size, it is rotated: the current file is renamed to
+// "<path>.1", an existing "<path>.1" is renamed to "<path>.2" and so on. The
+// oldest file is removed, when the maximum number of backups is reached.
+//
+// All methods can be called on a nil logger, which is used when the audit log
+// is disabled.
+type Logger struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	logger     log.Logger
+	lock       sync.Mutex
+}
+
+// Log writes the provided entry to the audit log. If the time or the request
+// id of the entry are not set, they are set to the current time and the
+// request id from the context.
+func (l *Logger) Log(ctx context.Context, entry Entry) error {
+	if l == nil {
+		return nil
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if entry.RequestId == "" {
+		entry.RequestId = RequestId(ctx)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size = l.size + int64(n)
+	return err
+}
+
+// Record returns a response writer, which records the status code of the
+// response, and a function, which writes the provided entry with the recorded
+// status code to the audit log. The function must be called when the request
+// is finished. If the time or the request id of the entry are not set, they
+// are set to the current time and the request id from the context. The request
+// id of the entry is also returned in the "X-Request-Id" header, so that users
+// can find the entry for a request.
+func (l *Logger) Record(ctx context.Context, w http.ResponseWriter, entry Entry) (http.ResponseWriter, func()) {
+	if l == nil {
+		return w, func() {}
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if entry.RequestId == "" {
+		entry.RequestId = RequestId(ctx)
+	}
+	w.Header().Set("X-Request-Id", entry.RequestId)
+
+	rw := NewResponseWriter(w)
+	return rw, func() {
+		entry.StatusCode = rw.StatusCode()
+		if err := l.Log(ctx, entry); err != nil {
+			l.logger.Error("Failed to write audit log entry", "error", err.Error())
+		}
+	}
+}
+
+// Query returns all entries within the provided time range, where the user,
+// verb, resource, namespace or name matches the provided regular expression.
+// The newest entry is returned first.
+func (l *Logger) Query(from, to time.Time, filter *regexp.Regexp) ([]Entry, error) {
+	if l == nil {
+		return nil, fmt.Errorf("audit log is not enabled")
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var entries []Entry
+
+	// Read the files from the oldest backup to the current file, so that the
+	// entries are sorted by their time.
+	paths := []string{l.path}
+	for i := 1; i <= l.maxBackups; i++ {
+		paths = append(paths, fmt.Sprintf("%s.%d", l.path, i))
+	}
+	slices.Reverse(paths)
+
+	for _, path := range paths {
+		fileEntries, err := readEntries(path, from, to, filter)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// Close closes the audit log file.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.file.Close()
+}
+
+// rotate closes the current file, renames all files and opens a new file. It
+// must be called with the lock held.
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	l.file = file
+	l.size = 0
+	return nil
+}
+
+// readEntries reads all entries from the provided file, which are matching
+// the time range and filter. If the file doesn't exist, no entries are
+// returned. Lines which can not be parsed are ignored.
+func readEntries(path string, from, to time.Time, filter *regexp.Regexp) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		if entry.Time.Before(from) || entry.Time.After(to) {
+			continue
+		}
+		if filter != nil && !filter.MatchString(strings.Join([]string{entry.User, entry.Verb, entry.Resource, entry.Namespace, entry.Name}, " ")) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// RequestId returns the id of the request from the provided context. If the
+// context contains a trace, the trace id is used, so that an entry in the
+// audit log can be correlated with the trace of the request. Otherwise a
+// random id is returned.
+func RequestId(ctx context.Context) string {
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		return spanContext.TraceID().String()
+	}
+
+	return uuid.NewString()
+}
+
+// NewLogger creates a new audit logger, which appends the entries to the file
+// at the provided path. If no maximum size or number of backups is set, the
+// defaults are used.
+func NewLogger(path string, maxSize int64, maxBackups int, logger log.Logger) (*Logger, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path for the audit log is required")
+	}
+
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = defaultMaxBackups
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Logger{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		file:       file,
+		size:       info.Size(),
+		logger:     logger,
+	}, nil
+}
@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	t.Run("should write and query entries", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		logger, err := NewLogger(path, 0, 0, log.DefaultLogger)
+		require.NoError(t, err)
+		defer logger.Close()
+
+		now := time.Now()
+		require.NoError(t, logger.Log(context.Background(), Entry{Time: now.Add(-2 * time.Hour), User: "admin", Verb: "delete", Resource: "pods", Namespace: "default", Name: "echoserver"}))
+		require.NoError(t, logger.Log(context.Background(), Entry{Time: now.Add(-time.Minute), User: "admin", Verb: "patch", Resource: "deployments.apps", Namespace: "default", Name: "echoserver", DryRun: true}))
+		require.NoError(t, logger.Log(context.Background(), Entry{User: "editor", Verb: "restart", Resource: "deployments.apps", Namespace: "kube-system", Name: "coredns"}))
+
+		entries, err := logger.Query(now.Add(-time.Hour), now.Add(time.Hour), nil)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, "editor", entries[0].User)
+		require.NotEmpty(t, entries[0].RequestId)
+		require.Equal(t, "patch", entries[1].Verb)
+		require.True(t, entries[1].DryRun)
+
+		entries, err = logger.Query(now.Add(-3*time.Hour), now.Add(time.Hour), regexp.MustCompile("kube-system"))
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, "coredns", entries[0].Name)
+	})
+
+	t.Run("should rotate file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		logger, err := NewLogger(path, 200, 2, log.DefaultLogger)
+		require.NoError(t, err)
+		defer logger.Close()
+
+		now := time.Now()
+		for i := range 5 {
+			require.NoError(t, logger.Log(context.Background(), Entry{Time: now.Add(time.Duration(i) * time.Second), User: "admin", Verb: "delete", Resource: "pods", Name: "echoserver"}))
+		}
+
+		require.FileExists(t, path+".1")
+		require.FileExists(t, path+".2")
+		require.NoFileExists(t, path+".3")
+
+		entries, err := logger.Query(now.Add(-time.Hour), now.Add(time.Hour), nil)
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		require.Equal(t, now.Add(4*time.Second).Unix(), entries[0].Time.Unix())
+		require.Equal(t, now.Add(2*time.Second).Unix(), entries[2].Time.Unix())
+	})
+
+	t.Run("should record status code and request id", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		logger, err := NewLogger(path, 0, 0, log.DefaultLogger)
+		require.NoError(t, err)
+		defer logger.Close()
+
+		recorder := httptest.NewRecorder()
+		w, done := logger.Record(context.Background(), recorder, Entry{User: "admin", Verb: "create", Resource: "pods", Namespace: "default"})
+		w.WriteHeader(http.StatusForbidden)
+		done()
+
+		entries, err := logger.Query(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), nil)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, http.StatusForbidden, entries[0].StatusCode)
+		require.Equal(t, recorder.Header().Get("X-Request-Id"), entries[0].RequestId)
+	})
+
+	t.Run("should ignore nil logger", func(t *testing.T) {
+		var logger *Logger
+		require.NoError(t, logger.Log(context.Background(), Entry{}))
+		require.NoError(t, logger.Close())
+
+		recorder := httptest.NewRecorder()
+		w, done := logger.Record(context.Background(), recorder, Entry{})
+		done()
+		require.Equal(t, recorder, w)
+
+		_, err := logger.Query(time.Now(), time.Now(), nil)
+		require.Error(t, err)
+	})
+
+	t.Run("should require path", func(t *testing.T) {
+		_, err := NewLogger("", 0, 0, log.DefaultLogger)
+		require.Error(t, err)
+	})
+
+	t.Run("should append to existing file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		require.NoError(t, os.WriteFile(path, []byte("invalid\n"), 0600))
+
+		logger, err := NewLogger(path, 0, 0, log.DefaultLogger)
+		require.NoError(t, err)
+		defer logger.Close()
+
+		require.NoError(t, logger.Log(context.Background(), Entry{User: "admin", Verb: "delete", Resource: "pods"}))
+
+		entries, err := logger.Query(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), nil)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	})
+}
+
+func TestResponseWriter(t *testing.T) {
+	t.Run("should return default status code", func(t *testing.T) {
+		w := NewResponseWriter(httptest.NewRecorder())
+		require.Equal(t, http.StatusOK, w.StatusCode())
+
+		_, err := w.Write([]byte("ok"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, w.StatusCode())
+	})
+
+	t.Run("should return first status code", func(t *testing.T) {
+		w := NewResponseWriter(httptest.NewRecorder())
+		w.WriteHeader(http.StatusNotFound)
+		w.WriteHeader(http.StatusInternalServerError)
+		require.Equal(t, http.StatusNotFound, w.StatusCode())
+	})
+
+	t.Run("should overwrite status code of wrapped response writers", func(t *testing.T) {
+		inner := NewResponseWriter(httptest.NewRecorder())
+		w := NewResponseWriter(inner)
+		w.WriteHeader(http.StatusOK)
+		w.SetStatusCode(http.StatusConflict)
+		require.Equal(t, http.StatusConflict, w.StatusCode())
+		require.Equal(t, http.StatusConflict, inner.StatusCode())
+	})
+
+	t.Run("should return switching protocols for hijacked connections", func(t *testing.T) {
+		statusCodes := make(chan int, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := NewResponseWriter(w)
+			conn, _, err := rw.Hijack()
+			if err != nil {
+				statusCodes <- 0
+				return
+			}
+			defer conn.Close()
+
+			statusCodes <- rw.StatusCode()
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+		}))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusSwitchingProtocols, <-statusCodes)
+	})
+}
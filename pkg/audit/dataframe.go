@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// CreateDataFrame returns a data frame for the provided audit log entries,
+// which can be shown in a table.
+func CreateDataFrame(entries []Entry) *data.Frame {
+	var times []time.Time
+	var requestIds, users, groups, verbs, resources, namespaces, names []string
+	var statusCodes []int64
+	var dryRuns []bool
+
+	for _, entry := range entries {
+		times = append(times, entry.Time)
+		requestIds = append(requestIds, entry.RequestId)
+		users = append(users, entry.User)
+		groups = append(groups, strings.Join(entry.Groups, ", "))
+		verbs = append(verbs, entry.Verb)
+		resources = append(resources, entry.Resource)
+		namespaces = append(namespaces, entry.Namespace)
+		names = append(names, entry.Name)
+		statusCodes = append(statusCodes, int64(entry.StatusCode))
+		dryRuns = append(dryRuns, entry.DryRun)
+	}
+
+	frame := data.NewFrame(
+		"Audit Log",
+		data.NewField("Time", nil, times),
+		data.NewField("Request ID", nil, requestIds),
+		data.NewField("User", nil, users),
+		data.NewField("Groups", nil, groups),
+		data.NewField("Verb", nil, verbs),
+		data.NewField("Resource", nil, resources),
+		data.NewField("Namespace", nil, namespaces),
+		data.NewField("Name", nil, names),
+		data.NewField("Status Code", nil, statusCodes),
+		data.NewField("Dry Run", nil, dryRuns),
+	)
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	return frame
+}
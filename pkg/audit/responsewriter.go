@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter is a response writer, which records the status code of the
+// response. When the connection is hijacked, e.g. for "kubectl exec" or
+// "kubectl port-forward", the status code is set to "101 Switching
+// Protocols".
+type ResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *ResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *ResponseWriter) Flush() {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.statusCode == 0 {
+		w.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// StatusCode returns the recorded status code. If nothing was written to the
+// response writer, the status code is "200 OK", like it is returned by the
+// HTTP server.
+func (w *ResponseWriter) StatusCode() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+	return w.statusCode
+}
+
+// SetStatusCode overwrites the recorded status code. It can be used when the
+// status code was already written, but the request failed afterwards, e.g.
+// when an error occurs while a response is streamed. The status code is also
+// set for all response writers, which are wrapped by the response writer.
+func (w *ResponseWriter) SetStatusCode(statusCode int) {
+	w.statusCode = statusCode
+	if rw, ok := w.ResponseWriter.(*ResponseWriter); ok {
+		rw.SetStatusCode(statusCode)
+	}
+}
+
+// NewResponseWriter returns a new response writer, which records the status
+// code of the provided response writer.
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	goapi "github.com/grafana/grafana-openapi-client-go/client"
+	"github.com/grafana/grafana-openapi-client-go/client/annotations"
 	"github.com/grafana/grafana-openapi-client-go/client/service_accounts"
 	"github.com/grafana/grafana-openapi-client-go/client/users"
 	"github.com/grafana/grafana-openapi-client-go/models"
@@ -33,7 +34,10 @@ type Client interface {
 	GetUrl() *url.URL
 	GetImpersonateUser(ctx context.Context, headers http.Header) (string, error)
 	GetImpersonateGroups(ctx context.Context, headers http.Header) ([]string, error)
+	GetTeams(ctx context.Context, headers http.Header) ([]string, error)
+	GetUser(ctx context.Context, headers http.Header) (string, error)
 	CreateUserToken(ctx context.Context, user string, tokenTTL int64) (string, error)
+	CreateAnnotation(ctx context.Context, text string, tags []string, timestamp time.Time) error
 }
 
 type client struct {
@@ -127,6 +131,13 @@ func (c *client) GetImpersonateUser(ctx context.Context, headers http.Header) (s
 	return c.getUser(ctx, headers)
 }
 
+// GetUser returns the name of the user which makes the request. In contrast
+// to the "GetImpersonateUser" function, the user is also returned when the
+// impersonate feature is disabled, e.g. to record the user of an exec session.
+func (c *client) GetUser(ctx context.Context, headers http.Header) (string, error) {
+	return c.getUser(ctx, headers)
+}
+
 // getUser returns the user name based on the Grafana user sign in token header
 // ("X-Grafana-Id"). The token in the header can be related to a user or a
 // service account. If the token is related to a user or a service account is
@@ -272,6 +283,24 @@ func (c *client) GetImpersonateGroups(ctx context.Context, headers http.Header)
 		return nil, nil
 	}
 
+	groups, err := c.GetTeams(ctx, headers)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// GetTeams returns the names of all teams of the user which makes the request.
+// In contrast to the "GetImpersonateGroups" function, the teams are also
+// returned when the impersonate groups feature is disabled, so that they can
+// be used to restrict features to some teams.
+func (c *client) GetTeams(ctx context.Context, headers http.Header) ([]string, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetTeams")
+	defer span.End()
+
 	user, err := c.getUser(ctx, headers)
 	if err != nil {
 		span.RecordError(err)
@@ -345,6 +374,37 @@ func (c *client) CreateUserToken(ctx context.Context, user string, tokenTTL int6
 	return c.createToken(ctx, tokenTTL, serviceAccount)
 }
 
+// CreateAnnotation creates an organization-wide annotation with the provided
+// text and tags. Because the annotation isn't related to a dashboard, it is
+// shown in all dashboards, which are using an annotation query for the tags.
+func (c *client) CreateAnnotation(ctx context.Context, text string, tags []string, timestamp time.Time) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "CreateAnnotation")
+	defer span.End()
+
+	res, err := c.client.Annotations.PostAnnotationWithParams(&annotations.PostAnnotationParams{
+		Body: &models.PostAnnotationsCmd{
+			Text: &text,
+			Tags: tags,
+			Time: timestamp.UnixMilli(),
+		},
+		Context: ctx,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if !res.IsSuccess() {
+		err := fmt.Errorf("failed to create annotation: %s", res.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	return nil
+}
+
 func (c *client) getServiceAccount(ctx context.Context, user string) (*models.ServiceAccountDTO, error) {
 	ctx, span := tracing.DefaultTracer().Start(ctx, "GetImpersonateGroups")
 	defer span.End()
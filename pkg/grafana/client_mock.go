@@ -14,6 +14,7 @@ import (
 	http "net/http"
 	url "net/url"
 	reflect "reflect"
+	time "time"
 
 	gomock "go.uber.org/mock/gomock"
 )
@@ -42,6 +43,20 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 	return m.recorder
 }
 
+// CreateAnnotation mocks base method.
+func (m *MockClient) CreateAnnotation(ctx context.Context, text string, tags []string, timestamp time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAnnotation", ctx, text, tags, timestamp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAnnotation indicates an expected call of CreateAnnotation.
+func (mr *MockClientMockRecorder) CreateAnnotation(ctx, text, tags, timestamp any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAnnotation", reflect.TypeOf((*MockClient)(nil).CreateAnnotation), ctx, text, tags, timestamp)
+}
+
 // CreateUserToken mocks base method.
 func (m *MockClient) CreateUserToken(ctx context.Context, user string, tokenTTL int64) (string, error) {
 	m.ctrl.T.Helper()
@@ -87,6 +102,21 @@ func (mr *MockClientMockRecorder) GetImpersonateUser(ctx, headers any) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImpersonateUser", reflect.TypeOf((*MockClient)(nil).GetImpersonateUser), ctx, headers)
 }
 
+// GetTeams mocks base method.
+func (m *MockClient) GetTeams(ctx context.Context, headers http.Header) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTeams", ctx, headers)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTeams indicates an expected call of GetTeams.
+func (mr *MockClientMockRecorder) GetTeams(ctx, headers any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTeams", reflect.TypeOf((*MockClient)(nil).GetTeams), ctx, headers)
+}
+
 // GetUrl mocks base method.
 func (m *MockClient) GetUrl() *url.URL {
 	m.ctrl.T.Helper()
@@ -100,3 +130,18 @@ func (mr *MockClientMockRecorder) GetUrl() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUrl", reflect.TypeOf((*MockClient)(nil).GetUrl))
 }
+
+// GetUser mocks base method.
+func (m *MockClient) GetUser(ctx context.Context, headers http.Header) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUser", ctx, headers)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUser indicates an expected call of GetUser.
+func (mr *MockClientMockRecorder) GetUser(ctx, headers any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockClient)(nil).GetUser), ctx, headers)
+}
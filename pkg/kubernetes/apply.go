@@ -0,0 +1,161 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// FieldManager is the field manager which is used for all server-side apply
+// requests of the plugin.
+const FieldManager = "grafana-kubernetes-plugin"
+
+// ApplyResult is the result of applying a manifest. It contains the manifest
+// returned by the Kubernetes API and a unified diff between the manifest
+// before and after the apply.
+type ApplyResult struct {
+	DryRun   bool   `json:"dryRun"`
+	Manifest string `json:"manifest"`
+	Diff     string `json:"diff"`
+}
+
+// ApplyConflictError is returned when a manifest could not be applied because
+// of a conflict. The type of the conflict is "resourceVersion" when the
+// object was modified since it was loaded, or "fieldManager" when the manifest
+// changes fields which are owned by another field manager.
+type ApplyConflictError struct {
+	Type      string          `json:"type"`
+	Message   string          `json:"message"`
+	Conflicts []ApplyConflict `json:"conflicts,omitempty"`
+}
+
+type ApplyConflict struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *ApplyConflictError) Error() string {
+	return e.Message
+}
+
+// newApplyConflictError converts a conflict returned by the Kubernetes API
+// into an "ApplyConflictError". If the provided error is not a conflict, the
+// error is returned unchanged.
+func newApplyConflictError(err error) error {
+	if !apierrors.IsConflict(err) {
+		return err
+	}
+
+	var statusErr apierrors.APIStatus
+	if !errors.As(err, &statusErr) {
+		return err
+	}
+
+	status := statusErr.Status()
+	conflictErr := &ApplyConflictError{
+		Type:    "resourceVersion",
+		Message: status.Message,
+	}
+
+	if status.Details != nil {
+		for _, cause := range status.Details.Causes {
+			if cause.Type == metav1.CauseTypeFieldManagerConflict {
+				conflictErr.Type = "fieldManager"
+				conflictErr.Conflicts = append(conflictErr.Conflicts, ApplyConflict{
+					Field:   cause.Field,
+					Message: cause.Message,
+				})
+			}
+		}
+	}
+
+	return conflictErr
+}
+
+// stripManagedFields removes the managed fields from the provided JSON object,
+// because they are not relevant for users which want to view or edit a
+// resource.
+func stripManagedFields(object []byte) (map[string]any, error) {
+	var obj map[string]any
+	if err := json.Unmarshal(object, &obj); err != nil {
+		return nil, err
+	}
+
+	if metadata, ok := obj["metadata"].(map[string]any); ok {
+		delete(metadata, "managedFields")
+	}
+
+	return obj, nil
+}
+
+// objectToYAML converts the provided JSON object into YAML without the
+// managed fields.
+func objectToYAML(object []byte) ([]byte, error) {
+	obj, err := stripManagedFields(object)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(obj)
+}
+
+// prepareApplyManifest converts the provided YAML manifest into JSON and
+// verifies that the name and namespace of the manifest match the object which
+// should be applied. If the manifest doesn't contain a namespace, the provided
+// namespace is used. The managed fields are removed, because they are not
+// allowed in server-side apply requests.
+func prepareApplyManifest(manifest []byte, namespace, name string) ([]byte, error) {
+	manifestJSON, err := yaml.YAMLToJSON(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := stripManagedFields(manifestJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, ok := obj["metadata"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("manifest must contain metadata")
+	}
+
+	if metadataName, _ := metadata["name"].(string); metadataName != name {
+		return nil, fmt.Errorf("name of the manifest %q does not match %q", metadataName, name)
+	}
+
+	if namespace != "" {
+		metadataNamespace, _ := metadata["namespace"].(string)
+		if metadataNamespace == "" {
+			metadata["namespace"] = namespace
+		} else if metadataNamespace != namespace {
+			return nil, fmt.Errorf("namespace of the manifest %q does not match %q", metadataNamespace, namespace)
+		}
+	}
+
+	return json.Marshal(obj)
+}
+
+// diffYAML returns a unified diff between the two provided YAML documents.
+func diffYAML(from, to []byte) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(from),
+		B:        splitLines(to),
+		FromFile: "current",
+		ToFile:   "applied",
+		Context:  3,
+	})
+}
+
+func splitLines(content []byte) []string {
+	if strings.TrimSpace(string(content)) == "" {
+		return nil
+	}
+	return difflib.SplitLines(strings.TrimSpace(string(content)))
+}
@@ -0,0 +1,69 @@
+package kubernetes
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestObjectToYAML(t *testing.T) {
+	manifest, err := objectToYAML([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"echoserver","namespace":"default","managedFields":[{"manager":"kubectl"}]},"data":{"key":"value"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "apiVersion: v1\ndata:\n  key: value\nkind: ConfigMap\nmetadata:\n  name: echoserver\n  namespace: default\n", string(manifest))
+}
+
+func TestPrepareApplyManifest(t *testing.T) {
+	t.Run("should set namespace", func(t *testing.T) {
+		manifest, err := prepareApplyManifest([]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: echoserver\n"), "default", "echoserver")
+		require.NoError(t, err)
+		require.JSONEq(t, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"echoserver","namespace":"default"}}`, string(manifest))
+	})
+
+	t.Run("should fail for different name", func(t *testing.T) {
+		_, err := prepareApplyManifest([]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: nginx\n"), "default", "echoserver")
+		require.Error(t, err)
+	})
+
+	t.Run("should fail for different namespace", func(t *testing.T) {
+		_, err := prepareApplyManifest([]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: echoserver\n  namespace: kube-system\n"), "default", "echoserver")
+		require.Error(t, err)
+	})
+}
+
+func TestDiffYAML(t *testing.T) {
+	diff, err := diffYAML([]byte("spec:\n  replicas: 1\n"), []byte("spec:\n  replicas: 2\n"))
+	require.NoError(t, err)
+	require.Equal(t, "--- current\n+++ applied\n@@ -1,2 +1,2 @@\n spec:\n-  replicas: 1\n+  replicas: 2\n", diff)
+}
+
+func TestNewApplyConflictError(t *testing.T) {
+	gr := schema.GroupResource{Group: "apps", Resource: "deployments"}
+
+	t.Run("should return resource version conflict", func(t *testing.T) {
+		err := newApplyConflictError(apierrors.NewConflict(gr, "echoserver", errors.New("the object has been modified")))
+
+		var conflictErr *ApplyConflictError
+		require.ErrorAs(t, err, &conflictErr)
+		require.Equal(t, "resourceVersion", conflictErr.Type)
+	})
+
+	t.Run("should return field manager conflict", func(t *testing.T) {
+		statusErr := apierrors.NewConflict(gr, "echoserver", errors.New("apply failed"))
+		statusErr.ErrStatus.Details.Causes = []metav1.StatusCause{{Type: metav1.CauseTypeFieldManagerConflict, Field: ".spec.replicas", Message: `conflict with "helm"`}}
+		err := newApplyConflictError(statusErr)
+
+		var conflictErr *ApplyConflictError
+		require.ErrorAs(t, err, &conflictErr)
+		require.Equal(t, "fieldManager", conflictErr.Type)
+		require.Equal(t, []ApplyConflict{{Field: ".spec.replicas", Message: `conflict with "helm"`}}, conflictErr.Conflicts)
+	})
+
+	t.Run("should return other errors unchanged", func(t *testing.T) {
+		notFoundErr := apierrors.NewNotFound(gr, "echoserver")
+		require.Equal(t, notFoundErr, newApplyConflictError(notFoundErr))
+	})
+}
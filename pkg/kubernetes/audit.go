@@ -0,0 +1,76 @@
+package kubernetes
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+)
+
+// auditSubresources are the subresources which are recorded in the audit log,
+// even when they are requested with the "get" verb, because they can be used
+// to modify a pod or to access its network.
+var auditSubresources = []string{"exec", "attach", "portforward", "proxy"}
+
+// NewAuditEntry returns the audit log entry for a request against the
+// Kubernetes API. Only mutating requests and requests for the "exec",
+// "attach", "portforward" and "proxy" subresources are recorded, for all other
+// requests false is returned.
+func NewAuditEntry(method, requestUrl string) (audit.Entry, bool, error) {
+	info, err := newRequestInfo(method, requestUrl)
+	if err != nil {
+		return audit.Entry{}, false, err
+	}
+
+	if !info.IsResourceRequest {
+		return audit.Entry{}, false, nil
+	}
+	if slices.Contains([]string{"get", "list", "watch"}, info.Verb) && !slices.Contains(auditSubresources, info.Subresource) {
+		return audit.Entry{}, false, nil
+	}
+
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		return audit.Entry{}, false, err
+	}
+
+	return audit.Entry{
+		Verb:      info.Verb,
+		Resource:  newAuditResource(info.Resource, info.APIGroup, info.Subresource),
+		Namespace: info.Namespace,
+		Name:      info.Name,
+		DryRun:    parsedUrl.Query().Get("dryRun") != "",
+	}, true, nil
+}
+
+// newAuditResource returns the resource in the format which is used in the
+// audit log, e.g. "deployments.apps/scale".
+func newAuditResource(resource, group, subresource string) string {
+	if group != "" {
+		resource = resource + "." + group
+	}
+	if subresource != "" {
+		resource = resource + "/" + subresource
+	}
+	return resource
+}
+
+// NewAuditResource returns the provided resource and subresource in the
+// format which is used in the audit log, e.g. "deployments.apps" for the
+// resource with the id "deployment.apps".
+func NewAuditResource(resource Resource, subresource string) string {
+	group, _, ok := strings.Cut(resource.APIVersion, "/")
+	if !ok {
+		group = ""
+	}
+	return newAuditResource(resource.Name, group, subresource)
+}
+
+// IsStreamingAuditEntry returns true, when the entry is for a request which
+// opens a streaming connection to a pod, i.e. "create" requests for the
+// "exec", "attach" and "portforward" subresources. These requests can not be
+// replayed, so that they can not be approved.
+func IsStreamingAuditEntry(entry audit.Entry) bool {
+	return entry.Verb == "create" && slices.Contains([]string{"pods/exec", "pods/attach", "pods/portforward"}, entry.Resource)
+}
@@ -0,0 +1,57 @@
+package kubernetes
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuditEntry(t *testing.T) {
+	t.Run("should create entry for mutating requests", func(t *testing.T) {
+		entry, ok, err := NewAuditEntry(http.MethodPatch, "/apis/apps/v1/namespaces/default/deployments/echoserver/scale?dryRun=All")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, audit.Entry{Verb: "patch", Resource: "deployments.apps/scale", Namespace: "default", Name: "echoserver", DryRun: true}, entry)
+
+		entry, ok, err = NewAuditEntry(http.MethodDelete, "/api/v1/nodes/node1")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, audit.Entry{Verb: "delete", Resource: "nodes", Name: "node1"}, entry)
+	})
+
+	t.Run("should create entry for exec requests", func(t *testing.T) {
+		entry, ok, err := NewAuditEntry(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver/exec?command=sh")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "pods/exec", entry.Resource)
+	})
+
+	t.Run("should skip read requests", func(t *testing.T) {
+		_, ok, err := NewAuditEntry(http.MethodGet, "/api/v1/namespaces/default/pods")
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, ok, err = NewAuditEntry(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver/log")
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, ok, err = NewAuditEntry(http.MethodGet, "/version")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+}
+
+func TestNewAuditResource(t *testing.T) {
+	require.Equal(t, "deployments.apps", NewAuditResource(Resource{APIVersion: "apps/v1", Name: "deployments"}, ""))
+	require.Equal(t, "pods/exec", NewAuditResource(Resource{APIVersion: "v1", Name: "pods"}, "exec"))
+}
+
+func TestIsStreamingAuditEntry(t *testing.T) {
+	require.True(t, IsStreamingAuditEntry(audit.Entry{Verb: "create", Resource: "pods/exec"}))
+	require.True(t, IsStreamingAuditEntry(audit.Entry{Verb: "create", Resource: "pods/portforward"}))
+	require.False(t, IsStreamingAuditEntry(audit.Entry{Verb: "delete", Resource: "pods"}))
+	require.False(t, IsStreamingAuditEntry(audit.Entry{Verb: "create", Resource: "pods/eviction"}))
+}
@@ -1,6 +1,7 @@
 package kubernetes
 
 import (
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,6 +13,7 @@ type Cache interface {
 	IsValid() bool
 	GetDataFrameValues() ([]string, []string, []string, []string, []string, []string)
 	Get(id string) (Resource, bool)
+	GetByName(group, name string) (Resource, bool)
 	SetAll(resources map[string]Resource)
 }
 
@@ -67,6 +69,30 @@ func (c *cache) Get(id string) (Resource, bool) {
 	return resource, exists
 }
 
+// GetByName returns the resource with the provided API group and name (e.g.
+// "apps" and "deployments"), like it is used in the url of a request against
+// the Kubernetes API.
+func (c *cache) GetByName(group, name string) (Resource, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, resource := range c.resources {
+		if resource.Name != name {
+			continue
+		}
+
+		resourceGroup, _, found := strings.Cut(resource.APIVersion, "/")
+		if !found {
+			resourceGroup = ""
+		}
+		if resourceGroup == group {
+			return resource, true
+		}
+	}
+
+	return Resource{}, false
+}
+
 func (c *cache) SetAll(resources map[string]Resource) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
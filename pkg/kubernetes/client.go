@@ -6,12 +6,16 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"regexp"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,19 +26,31 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/client-go/transport"
+	utilexec "k8s.io/client-go/util/exec"
 	"k8s.io/client-go/util/jsonpath"
+	"k8s.io/streaming/pkg/httpstream"
 )
 
 type Client interface {
@@ -46,16 +62,55 @@ type Client interface {
 	GetContainers(ctx context.Context, user string, groups []string, resourceId, namespace, name string) (*data.Frame, error)
 	GetLogs(ctx context.Context, user string, groups []string, resourceId, namespace, name, container, filter string, tail int64, previous bool, timeRange backend.TimeRange) (*data.Frame, error)
 	StreamLogs(ctx context.Context, user string, groups []string, resourceId, namespace, name, container, filter string, sender *backend.StreamSender) error
+	StreamMetrics(ctx context.Context, user string, groups []string, resourceId, namespace, name string, containers bool, interval time.Duration, sender *backend.StreamSender) error
+	StreamResources(ctx context.Context, user string, groups []string, resourceId, namespace, parameterName, parameterValue string, wide bool, sender *backend.StreamSender) error
+	GetStats(ctx context.Context, user string, groups []string, node, namespace, filter, level, metric string, timeRange backend.TimeRange) ([]*data.Frame, error)
+	GetEvents(ctx context.Context, user string, groups []string, namespace, eventType, filter string, groupBy []string, timeRange backend.TimeRange, interval time.Duration) ([]*data.Frame, error)
+	GetResourceYAML(ctx context.Context, user string, groups []string, resourceId, namespace, name string) ([]byte, error)
+	ApplyResourceYAML(ctx context.Context, user string, groups []string, resourceId, namespace, name string, manifest []byte, dryRun, force bool) (*ApplyResult, error)
+	RunWorkloadAction(ctx context.Context, user string, groups []string, resourceId, namespace, name, action string, options WorkloadActionOptions) (*RolloutState, error)
+	GetRolloutStatus(ctx context.Context, user string, groups []string, resourceId, namespace, filter string) ([]*data.Frame, error)
+	GetRolloutHistory(ctx context.Context, user string, groups []string, namespace, name string) (*data.Frame, error)
+	RollbackDeployment(ctx context.Context, user string, groups []string, namespace, name string, revision int64, options DeploymentRollbackOptions) (*DeploymentRollbackResult, error)
+	Exec(ctx context.Context, user string, groups []string, namespace, name string, options ExecOptions) (*ExecResult, error)
+	Download(ctx context.Context, user string, groups []string, namespace, name string, options DownloadOptions) (*Download, error)
+	Debug(ctx context.Context, user string, groups []string, namespace, name string, options DebugOptions) (*DebugResult, error)
+	RunJob(ctx context.Context, user string, groups []string, resourceId, namespace, name string, options JobRunOptions) (*JobRunResult, error)
+	RunNodeAction(ctx context.Context, user string, groups []string, name, action string, options NodeDrainOptions) (*NodeActionResult, error)
+	GetManagedFields(ctx context.Context, user string, groups []string, resourceId, namespace, name string) (*data.Frame, error)
+	GetHistory(ctx context.Context, user string, groups []string, resourceId, namespace, filter, mode string, timeRange backend.TimeRange) (*data.Frame, error)
 	GetResource(ctx context.Context, resourceId string) (*Resource, error)
 	Proxy(user string, groups []string, requestUrl string, w http.ResponseWriter, r *http.Request)
+	AuthorizeSubresource(method, requestUrl string, getTeams func() ([]string, error)) error
+	AuthorizeNamespace(namespace string) error
+	RecordSession(method, requestUrl string, getUser func() (string, error), w http.ResponseWriter) (http.ResponseWriter, error)
+	ListRecordings(ctx context.Context) ([]Recording, error)
+	GetRecording(ctx context.Context, id string) (io.ReadCloser, error)
+	Preview(user string, groups []string, kind, namespace, name, port, requestUrl, publicPath string, w http.ResponseWriter, r *http.Request)
+	GetMetricsCollector() prometheus.Collector
+	Close()
 }
 
 type client struct {
-	logger          log.Logger
-	restConfig      *rest.Config
-	clientset       kubernetes.Interface
-	discoveryClient discovery.DiscoveryInterface
-	cache           Cache
+	logger            log.Logger
+	restConfig        *rest.Config
+	clientset         kubernetes.Interface
+	discoveryClient   discovery.DiscoveryInterface
+	cache             Cache
+	stats             *statsStore
+	history           *historyStore
+	historyIds        []string
+	execAllowlist     [][]*regexp.Regexp
+	execTimeout       time.Duration
+	downloadAllowlist []*regexp.Regexp
+	downloadMaxSize   int64
+	debugImages       []string
+	previewPorts      []string
+	policy            *policy
+	subresources      subresources
+	namespaces        *namespaceRestriction
+	recordings        *recordingStore
+	cancel            context.CancelFunc
 }
 
 // refreshCache refreshed the cache if it is not valid anymore by calling
@@ -115,6 +170,21 @@ func (c *client) GetResourceIds(ctx context.Context) (*data.Frame, error) {
 	// Get the values for the data frame from the resource cache.
 	ids, kinds, apiVersions, names, paths, namespaced := c.cache.GetDataFrameValues()
 
+	// If cluster-scoped resources are hidden, we remove them from the list, so
+	// that they can not be selected in the query editor.
+	if c.namespaces != nil && c.namespaces.hideClusterScoped {
+		for i := len(namespaced) - 1; i >= 0; i-- {
+			if namespaced[i] == "false" {
+				ids = slices.Delete(ids, i, i+1)
+				kinds = slices.Delete(kinds, i, i+1)
+				apiVersions = slices.Delete(apiVersions, i, i+1)
+				names = slices.Delete(names, i, i+1)
+				paths = slices.Delete(paths, i, i+1)
+				namespaced = slices.Delete(namespaced, i, i+1)
+			}
+		}
+	}
+
 	frame := data.NewFrame(
 		"Resources",
 		data.NewField("ids", nil, ids),
@@ -134,7 +204,8 @@ func (c *client) GetResourceIds(ctx context.Context) (*data.Frame, error) {
 }
 
 // GetNamespaces return a list of all namespace names in the Kubernetes cluster
-// as data frame.
+// as data frame. If the namespaces are restricted, only the allowed namespaces
+// are returned.
 func (c *client) GetNamespaces(ctx context.Context) (*data.Frame, error) {
 	ctx, span := tracing.DefaultTracer().Start(ctx, "GetNamespaces")
 	defer span.End()
@@ -150,6 +221,7 @@ func (c *client) GetNamespaces(ctx context.Context) (*data.Frame, error) {
 	for _, ns := range namespaces.Items {
 		names = append(names, ns.Name)
 	}
+	names = c.namespaces.filter(names)
 
 	frame := data.NewFrame(
 		"Namespaces",
@@ -192,10 +264,12 @@ func (c *client) GetResources(ctx context.Context, user string, groups []string,
 		return nil, err
 	}
 
-	if namespace == "*" || namespace == ".*" || namespace == ".+" || !resource.Namespaced {
-		namespace = ""
+	namespaces, err := c.resolveNamespaces(ctx, resourceGroupResource(resource), resource.Namespaced, namespace)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
 	}
-	namespaces := strings.Split(namespace, ",")
 
 	// Check if the parameter name is "jsonPath" or "regex". If this is the
 	// case, we remove the parameter name and value to get all resources without
@@ -392,6 +466,12 @@ func (c *client) getPodsAndContainers(ctx context.Context, user string, groups [
 	span.SetAttributes(attribute.Key("namespace").String(namespace))
 	span.SetAttributes(attribute.Key("name").String(name))
 
+	if err := c.namespaces.authorize(schema.GroupResource{Resource: "pods"}, true, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, nil, err
+	}
+
 	c.refreshCache(ctx)
 
 	switch resourceId {
@@ -762,12 +842,33 @@ func (c *client) StreamLogs(ctx context.Context, user string, groups []string, r
 	return nil
 }
 
-// GetResource returns the resource for the given resource ID from the cache. If
-// the resource is not found in the cache, an error is returned.
-func (c *client) GetResource(ctx context.Context, resourceId string) (*Resource, error) {
-	ctx, span := tracing.DefaultTracer().Start(ctx, "GetResource")
+// StreamMetrics polls the "metrics.k8s.io" API in the provided interval and
+// sends the CPU and memory usage for all pods or nodes as data frame to the
+// stream sender. The resource id must be "podmetrics.metrics.k8s.io" or
+// "nodemetrics.metrics.k8s.io".
+//
+// The namespace parameter can also be a string with multiple namespaces in the
+// form "namespace1,namespace2,..." or "*" for all namespaces. If a name is
+// provided only the usage of the pod or node with the provided name is sent.
+// If the containers parameter is true, the usage of pods is sent per
+// container.
+func (c *client) StreamMetrics(ctx context.Context, user string, groups []string, resourceId, namespace, name string, containers bool, interval time.Duration, sender *backend.StreamSender) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "StreamMetrics")
 	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
 	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("containers").Bool(containers))
+	span.SetAttributes(attribute.Key("interval").String(interval.String()))
+
+	if resourceId != "podmetrics.metrics.k8s.io" && resourceId != "nodemetrics.metrics.k8s.io" {
+		err := fmt.Errorf("resource %s is not a metrics resource", resourceId)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
 
 	c.refreshCache(ctx)
 
@@ -776,187 +877,2452 @@ func (c *client) GetResource(ctx context.Context, resourceId string) (*Resource,
 		err := fmt.Errorf("resource %s not found", resourceId)
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-		return nil, err
+		return err
 	}
 
-	return &resource, nil
+	namespaces, err := c.resolveNamespaces(ctx, resourceGroupResource(resource), resource.Namespaced, namespace)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var metrics []Metrics
+
+		for _, namespace := range namespaces {
+			result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+			if err != nil {
+				c.logger.Error("Failed to get metrics", "namespace", namespace, "error", err.Error())
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				continue
+			}
+
+			var metricsList MetricsList
+			if err := json.Unmarshal(result, &metricsList); err != nil {
+				c.logger.Error("Failed to unmarshal metrics", "namespace", namespace, "error", err.Error())
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				continue
+			}
+
+			metrics = append(metrics, metricsList.Items...)
+		}
+
+		frame := createMetricsDataFrame(resource, metrics, time.Now(), name, containers)
+		if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+			c.logger.Error("Failed to send frame", "error", err.Error())
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
 }
 
-// Proxy proxies a request to the Kubernetes API server. The request is
-// modified to add the "Impersonate-User" header with the given user. The
-// "Authorization" header is removed from the request.
-//
-// Create a user for testing:
+// StreamResources watches the requested resources and sends a data frame for
+// each added, modified or deleted resource. The data frame contains the same
+// columns as the data frame returned by "GetResources" and an additional
+// "Event" column with the type of the watch event.
 //
-//	kubectl create clusterrole grafana-admin-user --verb list,get --resource pods
-//	kubectl create clusterrolebinding grafana-admin-user --clusterrole grafana-admin-user --user admin
-//	kubectl create clusterrole grafana-admin-team --verb list,get --resource pods
-//	kubectl create clusterrolebinding grafana-admin-team --clusterrole grafana-admin-team --group admin
-//
-//	kubectl delete clusterrolebinding grafana-admin-user
-//	kubectl delete clusterrole grafana-admin-user
-//	kubectl delete clusterrolebinding grafana-admin-team
-//	kubectl delete clusterrole grafana-admin-team
-//
-// Caddy (required for kubectl, because token is only send when https is used):
-//
-//	caddy run --config /tmp/Caddyfile
-//
-//	grafana.localhost {
-//	  reverse_proxy localhost:3000
-//	}
-func (c *client) Proxy(user string, groups []string, requestUrl string, w http.ResponseWriter, r *http.Request) {
-	ctx, span := tracing.DefaultTracer().Start(r.Context(), "Proxy")
+// The namespace and parameter value can contain multiple values like in the
+// "GetResources" method, in this case we start one watch for each combination.
+// When a watch is closed by the Kubernetes API server, we start a new watch
+// from the last seen resource version until the context is canceled.
+func (c *client) StreamResources(ctx context.Context, user string, groups []string, resourceId, namespace, parameterName, parameterValue string, wide bool, sender *backend.StreamSender) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "StreamResources")
 	defer span.End()
-
 	span.SetAttributes(attribute.Key("user").String(user))
 	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
-	span.SetAttributes(attribute.Key("method").String(r.Method))
-	span.SetAttributes(attribute.Key("requestUrl").String(requestUrl))
+	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("parameterName").String(parameterName))
+	span.SetAttributes(attribute.Key("parameterValue").String(parameterValue))
+	span.SetAttributes(attribute.Key("wide").Bool(wide))
 
-	r.Header.Del("Authorization")
+	c.refreshCache(ctx)
 
-	// Parse the URL of the request and create a new URL for the request against
-	// the Kubernetes API server.
-	url, err := url.Parse(fmt.Sprintf("%s/%s", c.restConfig.Host, requestUrl))
-	if err != nil {
-		c.logger.Error("Failed to parse url", "error", err.Error())
+	resource, ok := c.cache.Get(resourceId)
+	if !ok {
+		err := fmt.Errorf("resource %s not found", resourceId)
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-
-		http.Error(w, "Failed to parse url", http.StatusBadGateway)
-		return
+		return err
 	}
 
-	// Create round tripper for the request based on the Kubernetes rest config.
-	tlsConfig, err := rest.TLSConfigFor(c.restConfig)
+	namespaces, err := c.resolveNamespaces(ctx, resourceGroupResource(resource), resource.Namespaced, namespace)
 	if err != nil {
-		c.logger.Error("Failed to create tls config", "error", err.Error())
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-
-		http.Error(w, "Failed to create tls config", http.StatusBadGateway)
-		return
+		return err
 	}
 
-	tlsTransport := otelhttp.NewTransport(&http.Transport{
-		Proxy:           http.ProxyFromEnvironment,
-		TLSClientConfig: tlsConfig,
-	})
-
-	restTransportConfig, err := c.restConfig.TransportConfig()
-	if err != nil {
-		c.logger.Error("Failed to create transporter config", "error", err.Error())
+	// A JSONPath filter requires the complete manifests of all resources, which
+	// are not part of the watch events, so that we can not support it here. A
+	// regular expression is applied when the data frame for an event is
+	// created.
+	var regex *regexp.Regexp
+	switch parameterName {
+	case "jsonPath":
+		err := fmt.Errorf("jsonPath filter is not supported for streaming resources")
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-
-		http.Error(w, "Failed to create transporter config", http.StatusBadGateway)
-		return
+		return err
+	case "regex":
+		var err error
+		regex, err = regexp.Compile(parameterValue)
+		if err != nil {
+			c.logger.Error("Failed to compile regex", "error", err.Error())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+		parameterName = ""
+		parameterValue = ""
 	}
+	parameterValues := strings.Split(parameterValue, "||")
 
-	clientRoundTripper, err := transport.HTTPWrappersForConfig(restTransportConfig, tlsTransport)
-	if err != nil {
-		c.logger.Error("Failed to create round tripper", "error", err.Error())
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
+	var watchesWG sync.WaitGroup
+	watchesWG.Add(len(namespaces) * len(parameterValues))
 
-		http.Error(w, "Failed to create round tripper", http.StatusBadGateway)
-		return
+	for _, namespace := range namespaces {
+		for _, parameterValue := range parameterValues {
+			go func(namespace, parameterValue string) {
+				defer watchesWG.Done()
+				c.watchResources(ctx, user, groups, resource, namespace, parameterName, parameterValue, wide, regex, sender)
+			}(namespace, parameterValue)
+		}
 	}
 
-	// Create the reverse proxy and modify the request. Then use the proxy to
-	// serve the request.
-	proxy := &httputil.ReverseProxy{
-		Rewrite: func(preq *httputil.ProxyRequest) {
-			c.logger.Debug("IN HEADERS", "headers", preq.In.Header)
-			c.logger.Debug("OUT HEADERS BEFORE", "headers", preq.Out.Header)
-			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(preq.Out.Header))
+	watchesWG.Wait()
+	return nil
+}
 
-			preq.Out.URL = url
+// watchResources starts a watch for the provided resource and sends a data
+// frame for each event, until the context is canceled.
+//
+// The events are requested as Kubernetes Table objects, so that we can reuse
+// the logic to create the data frames from the "GetResources" method. Since
+// only the first event of a watch contains the column definitions, we have to
+// remember them for all following events.
+func (c *client) watchResources(ctx context.Context, user string, groups []string, resource Resource, namespace, parameterName, parameterValue string, wide bool, regex *regexp.Regexp, sender *backend.StreamSender) {
+	var resourceVersion string
+	var columnDefinitions []metav1.TableColumnDefinition
+
+	// If the user is not allowed to watch the resources (anymore), we retry
+	// the watch with an exponential backoff, to avoid flooding the Kubernetes
+	// API server with requests, which are denied anyway.
+	backoff := watchRetryInterval
+
+	for {
+		c.logger.Debug("Watching resources", "name", resource.Name, "path", resource.Path, "namespace", namespace, "parameterName", parameterName, "parameterValue", parameterValue, "resourceVersion", resourceVersion, "user", user)
+
+		request := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).Param(parameterName, parameterValue).Param("watch", "true")
+		if resourceVersion != "" {
+			request = request.Param("resourceVersion", resourceVersion)
+		}
 
-			if preq.Out.Header == nil {
-				preq.Out.Header = make(http.Header)
+		stream, err := request.SetHeader("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io,application/json;as=Table;v=v1beta1;g=meta.k8s.io,application/json").SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).Stream(ctx)
+		if err != nil {
+			c.logger.Error("Failed to watch resources", "error", err.Error())
+			if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+				backoff = min(backoff*2, maxWatchRetryInterval)
 			}
+		} else {
+			backoff = watchRetryInterval
+			decoder := json.NewDecoder(stream)
+
+			for {
+				var event metav1.WatchEvent
+				if err := decoder.Decode(&event); err != nil {
+					if err != io.EOF && ctx.Err() == nil {
+						c.logger.Error("Failed to decode watch event", "error", err.Error())
+					}
+					break
+				}
 
-			// Always delete the impersonate user, uid and group headers, to ensure
-			// that privileges can not be escalated. Then set the impersonate user
-			// and group header if the feature is enabled and a user and list of
-			// groups was provided.
-			//
-			// NOTE: This also means that we do not support the "--as", "--as-uid"
-			// and "--as-group" flags of kubectl.
-			preq.Out.Header.Del("Impersonate-User")
-			preq.Out.Header.Del("Impersonate-Uid")
-			preq.Out.Header.Del("Impersonate-Group")
+				// If the watch returns an error, e.g. because the resource
+				// version is too old, we start a new watch from the current
+				// state of the resources.
+				if event.Type == "ERROR" {
+					c.logger.Debug("Watch returned an error", "error", string(event.Object.Raw))
+					resourceVersion = ""
+					break
+				}
 
-			if user != "" {
-				preq.Out.Header.Add("Impersonate-User", user)
-			}
+				var table metav1.Table
+				if err := json.Unmarshal(event.Object.Raw, &table); err != nil {
+					c.logger.Error("Failed to unmarshal watch event", "error", err.Error())
+					continue
+				}
 
-			for _, group := range groups {
-				preq.Out.Header.Add("Impersonate-Group", group)
+				if len(table.ColumnDefinitions) > 0 {
+					columnDefinitions = table.ColumnDefinitions
+				}
+				table.ColumnDefinitions = columnDefinitions
+
+				for _, row := range table.Rows {
+					var metadata metav1.PartialObjectMetadata
+					if err := json.Unmarshal(row.Object.Raw, &metadata); err == nil && metadata.ResourceVersion != "" {
+						resourceVersion = metadata.ResourceVersion
+					}
+				}
+
+				frame, err := createWatchEventDataFrame(resource, event.Type, table, wide, regex)
+				if err != nil {
+					c.logger.Error("Failed to create data frame", "error", err.Error())
+					continue
+				}
+				if frame.Rows() == 0 {
+					continue
+				}
+
+				if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+					c.logger.Error("Failed to send frame", "error", err.Error())
+				}
 			}
-		},
-		Transport:     clientRoundTripper,
-		FlushInterval: -1,
-		ModifyResponse: func(resp *http.Response) error {
-			c.logger.Info("Proxy", "user", user, "groups", groups, "method", r.Method, "requestUrl", requestUrl, "responseCode", resp.StatusCode)
-			span.SetAttributes(attribute.Key("responseCode").Int(resp.StatusCode))
-			return nil
-		},
-		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
-			c.logger.Error("Client request failed", "error", err.Error())
-			span.RecordError(err)
-			span.SetStatus(codes.Error, err.Error())
 
-			http.Error(w, "Client request failed", http.StatusBadGateway)
-		},
-	}
+			stream.Close()
+		}
 
-	proxy.ServeHTTP(w, r)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
 }
 
-// NewClient creates a new Kubernetes client, which is used by the datasource to
-// interact with the Kubernetes cluster. To create a new Kubernetes client we
-// create a new "restConfig" using the "newRestConfig" function first. The rest
-// config is then used to create a new "clientset". The last step in the client
-// creation is to create a new "cache".
-func NewClient(ctx context.Context, config *models.PluginSettings, logger log.Logger) (Client, error) {
-	restConfig, err := newRestConfig(config)
-	if err != nil {
+// GetStats returns the CPU, memory, network or filesystem usage of pods,
+// containers or volumes as time series data frames. The usage is read from the
+// "/stats/summary" endpoint of the kubelet on the requested nodes, so that it
+// also works in clusters without a metrics stack.
+//
+// The node and namespace parameters can be a single value, multiple values in
+// the form "value1,value2,..." or "*" for all nodes / namespaces. The filter
+// parameter is a regular expression which must match the name of a pod.
+//
+// Each call adds a new sample to the in-memory stats store, so that repeated
+// calls (e.g. by refreshing a dashboard) build up a short time series. Only
+// the samples within the provided time range are returned.
+//
+// If cluster-scoped resources are hidden, only the stats of all nodes can be
+// requested and the node label is removed from the returned series, so that
+// the names of the nodes are not exposed.
+func (c *client) GetStats(ctx context.Context, user string, groups []string, node, namespace, filter, level, metric string, timeRange backend.TimeRange) ([]*data.Frame, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetStats")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("node").String(node))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("filter").String(filter))
+	span.SetAttributes(attribute.Key("level").String(level))
+	span.SetAttributes(attribute.Key("metric").String(metric))
+
+	if !slices.Contains(statsMetrics[level], metric) {
+		err := fmt.Errorf("metric %s is not supported for level %s", metric, level)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
 
-	restConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
-		return otelhttp.NewTransport(rt)
+	var r *regexp.Regexp
+	if filter != "" {
+		var err error
+		r, err = regexp.Compile(filter)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
 	}
 
-	clientset, err := kubernetes.NewForConfig(restConfig)
+	// The stats are only filtered by namespace, when namespaces are provided.
+	// If the namespaces of the datasource are restricted, the allowed
+	// namespaces are used when all namespaces are requested.
+	namespaces, err := c.resolveNamespaces(ctx, schema.GroupResource{Resource: "pods"}, true, namespace)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
+	if len(namespaces) == 1 && namespaces[0] == "" {
+		namespaces = nil
+	}
 
-	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
-	if err != nil {
+	allNodes := node == "" || node == "*" || node == ".*" || node == ".+"
+	hideNodes := c.namespaces != nil && c.namespaces.hideClusterScoped
+	if hideNodes && !allNodes {
+		err := c.namespaces.authorize(nodesResource, false, "")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
 
-	client := &client{
-		logger:          logger,
-		restConfig:      restConfig,
-		clientset:       clientset,
-		discoveryClient: discoveryClient,
-	}
+	// If no node or all nodes are requested, we have to get the names of all
+	// nodes first, so that we can call the "/stats/summary" endpoint for each
+	// node.
+	var nodes []string
+	if allNodes {
+		result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1/nodes").SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
 
-	// Use the "client" to get a map of all resources in the cluster. The map
-	// contains all default Kubernetes resources and CustomResourceDefinitions
-	// deployed in the cluster and is used to initialize a new cache.
-	resources, err := client.getResources(ctx)
-	if err != nil {
-		return nil, err
+		var nodeList corev1.NodeList
+		if err := json.Unmarshal(result, &nodeList); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		for _, n := range nodeList.Items {
+			nodes = append(nodes, n.Name)
+		}
+	} else {
+		nodes = strings.Split(node, ",")
+	}
+
+	var errors []error
+	errorsMutex := &sync.Mutex{}
+
+	// The stats store is shared by all users, so that we only return the
+	// samples for the nodes, which could be read by the current user.
+	var readNodes []string
+	readNodesMutex := &sync.Mutex{}
+
+	var summariesWG sync.WaitGroup
+	summariesWG.Add(len(nodes))
+
+	for _, node := range nodes {
+		go func(node string) {
+			defer summariesWG.Done()
+			c.logger.Debug("Getting stats summary", "node", node, "user", user)
+
+			result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1/nodes", node, "proxy/stats/summary").SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+			if err != nil {
+				c.logger.Error("Failed to get stats summary", "node", node, "error", err.Error())
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+
+				errorsMutex.Lock()
+				errors = append(errors, err)
+				errorsMutex.Unlock()
+				return
+			}
+
+			var summary StatsSummary
+			if err := json.Unmarshal(result, &summary); err != nil {
+				c.logger.Error("Failed to unmarshal stats summary", "node", node, "error", err.Error())
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+
+				errorsMutex.Lock()
+				errors = append(errors, err)
+				errorsMutex.Unlock()
+				return
+			}
+
+			c.stats.addSummary(summary)
+
+			readNodesMutex.Lock()
+			readNodes = append(readNodes, node)
+			readNodesMutex.Unlock()
+		}(node)
+	}
+
+	summariesWG.Wait()
+
+	if len(errors) == len(nodes) && len(errors) > 0 {
+		return nil, errors[0]
+	}
+
+	return c.stats.getDataFrames(level, metric, readNodes, namespaces, r, hideNodes, timeRange), nil
+}
+
+// GetEvents returns the number of events per interval as time series data
+// frames. The events are grouped by the provided fields, which can be
+// "reason", "type", "namespace" and "kind" (kind of the involved object).
+//
+// The namespace parameter can be a single namespace, multiple namespaces in the
+// form "namespace1,namespace2,..." or "*" for all namespaces. The event type
+// can be used to only count "Normal" or "Warning" events and the filter is a
+// regular expression which must match the reason of an event.
+func (c *client) GetEvents(ctx context.Context, user string, groups []string, namespace, eventType, filter string, groupBy []string, timeRange backend.TimeRange, interval time.Duration) ([]*data.Frame, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetEvents")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("eventType").String(eventType))
+	span.SetAttributes(attribute.Key("filter").String(filter))
+	span.SetAttributes(attribute.Key("groupBy").StringSlice(groupBy))
+	span.SetAttributes(attribute.Key("interval").String(interval.String()))
+
+	if err := validateEventsGroupBy(groupBy); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var r *regexp.Regexp
+	if filter != "" {
+		var err error
+		r, err = regexp.Compile(filter)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	}
+
+	namespaces, err := c.resolveNamespaces(ctx, schema.GroupResource{Resource: "events"}, true, namespace)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var errors []error
+	errorsMutex := &sync.Mutex{}
+
+	var events []corev1.Event
+	eventsMutex := &sync.Mutex{}
+
+	var eventsWG sync.WaitGroup
+	eventsWG.Add(len(namespaces))
+
+	for _, namespace := range namespaces {
+		go func(namespace string) {
+			defer eventsWG.Done()
+			c.logger.Debug("Getting events", "namespace", namespace, "user", user)
+
+			result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1").Namespace(namespace).Resource("events").SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+			if err != nil {
+				c.logger.Error("Failed to get events", "namespace", namespace, "error", err.Error())
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+
+				errorsMutex.Lock()
+				errors = append(errors, err)
+				errorsMutex.Unlock()
+				return
+			}
+
+			var eventList corev1.EventList
+			if err := json.Unmarshal(result, &eventList); err != nil {
+				c.logger.Error("Failed to unmarshal events", "namespace", namespace, "error", err.Error())
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+
+				errorsMutex.Lock()
+				errors = append(errors, err)
+				errorsMutex.Unlock()
+				return
+			}
+
+			eventsMutex.Lock()
+			events = append(events, eventList.Items...)
+			eventsMutex.Unlock()
+		}(namespace)
+	}
+
+	eventsWG.Wait()
+
+	if len(errors) == len(namespaces) && len(errors) > 0 {
+		return nil, errors[0]
+	}
+
+	return createEventsDataFrames(events, eventType, r, groupBy, timeRange, interval), nil
+}
+
+// GetResourceYAML returns the requested object as YAML. The managed fields are
+// removed from the object, so that the YAML can be used to edit the object.
+func (c *client) GetResourceYAML(ctx context.Context, user string, groups []string, resourceId, namespace, name string) ([]byte, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetResourceYAML")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+
+	c.refreshCache(ctx)
+
+	resource, ok := c.cache.Get(resourceId)
+	if !ok {
+		err := fmt.Errorf("resource %s not found", resourceId)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if err := c.namespaces.authorize(resourceGroupResource(resource), resource.Namespaced, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if !resource.Namespaced {
+		namespace = ""
+	}
+
+	result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).Name(name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	manifest, err := objectToYAML(result)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// ApplyResourceYAML applies the provided YAML manifest via server-side apply,
+// using the "grafana-kubernetes-plugin" field manager. If dryRun is true, the
+// manifest is only validated by the Kubernetes API and not persisted. The
+// returned result always contains a diff between the current object and the
+// applied object, so that it can be used to preview the changes.
+//
+// If the manifest contains a resource version, the Kubernetes API rejects the
+// manifest when the object was modified in the meantime. This and conflicts
+// with other field managers are returned as "ApplyConflictError". Conflicts
+// with other field managers can be overwritten by setting force to true.
+func (c *client) ApplyResourceYAML(ctx context.Context, user string, groups []string, resourceId, namespace, name string, manifest []byte, dryRun, force bool) (*ApplyResult, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "ApplyResourceYAML")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("dryRun").Bool(dryRun))
+	span.SetAttributes(attribute.Key("force").Bool(force))
+
+	c.refreshCache(ctx)
+
+	resource, ok := c.cache.Get(resourceId)
+	if !ok {
+		err := fmt.Errorf("resource %s not found", resourceId)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if err := c.namespaces.authorize(resourceGroupResource(resource), resource.Namespaced, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if !resource.Namespaced {
+		namespace = ""
+	}
+
+	if err := c.policy.authorizeResource(http.MethodPatch, resource.Path, namespace, resource.Name, name, ""); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	manifestJSON, err := prepareApplyManifest(manifest, namespace, name)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	// Get the current object to create the diff. If the object doesn't exist
+	// yet, the diff contains the complete applied object.
+	var currentManifest []byte
+	current, err := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).Name(name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	} else {
+		currentManifest, err = objectToYAML(current)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	}
+
+	request := c.clientset.CoreV1().RESTClient().Patch(types.ApplyPatchType).AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).Name(name).Param("fieldManager", FieldManager).Param("force", strconv.FormatBool(force))
+	if dryRun {
+		request = request.Param("dryRun", "All")
+	}
+
+	result, err := request.Body(manifestJSON).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		err = newApplyConflictError(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	appliedManifest, err := objectToYAML(result)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	diff, err := diffYAML(currentManifest, appliedManifest)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return &ApplyResult{
+		DryRun:   dryRun,
+		Manifest: string(appliedManifest),
+		Diff:     diff,
+	}, nil
+}
+
+// RunWorkloadAction runs the provided action ("scale", "restart", "pause" or
+// "resume") for a Deployment, StatefulSet or DaemonSet. The action is applied
+// via a JSON merge patch with the identity of the user. Afterwards the current
+// rollout state of the workload is returned.
+func (c *client) RunWorkloadAction(ctx context.Context, user string, groups []string, resourceId, namespace, name, action string, options WorkloadActionOptions) (*RolloutState, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "RunWorkloadAction")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("action").String(action))
+
+	if err := c.namespaces.authorize(namespacesResource, true, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	subresource, patch, err := newWorkloadActionPatch(resourceId, action, options, time.Now())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	c.refreshCache(ctx)
+
+	resource, ok := c.cache.Get(resourceId)
+	if !ok {
+		err := fmt.Errorf("resource %s not found", resourceId)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if err := c.policy.authorizeResource(http.MethodPatch, resource.Path, namespace, resource.Name, name, subresource); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	_, err = c.clientset.CoreV1().RESTClient().Patch(types.MergePatchType).AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).Name(name).SubResource(subresource).Param("fieldManager", FieldManager).Body(patch).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).Name(name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	state, err := newRolloutState(resourceId, result)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// GetRolloutStatus returns the rollout status of all Deployments, StatefulSets
+// or DaemonSets in the requested namespaces, similar to the "kubectl rollout
+// status" command. If no resource id is provided, the status of all three
+// workload types is returned. The filter is a regular expression which must
+// match the name of a workload.
+func (c *client) GetRolloutStatus(ctx context.Context, user string, groups []string, resourceId, namespace, filter string) ([]*data.Frame, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetRolloutStatus")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("filter").String(filter))
+
+	resourceIds := []string{"deployment.apps", "statefulset.apps", "daemonset.apps"}
+	if resourceId != "" && resourceId != "*" {
+		if !slices.Contains(resourceIds, resourceId) {
+			err := fmt.Errorf("resource %s is not a workload", resourceId)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		resourceIds = []string{resourceId}
+	}
+
+	var r *regexp.Regexp
+	if filter != "" {
+		var err error
+		r, err = regexp.Compile(filter)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	}
+
+	namespaces, err := c.resolveNamespaces(ctx, namespacesResource, true, namespace)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	c.refreshCache(ctx)
+
+	var errors []error
+	errorsMutex := &sync.Mutex{}
+
+	var states []RolloutState
+	statesMutex := &sync.Mutex{}
+
+	var statesWG sync.WaitGroup
+	statesWG.Add(len(resourceIds) * len(namespaces))
+
+	for _, resourceId := range resourceIds {
+		for _, namespace := range namespaces {
+			go func(resourceId, namespace string) {
+				defer statesWG.Done()
+				c.logger.Debug("Getting rollout status", "resourceId", resourceId, "namespace", namespace, "user", user)
+
+				resource, ok := c.cache.Get(resourceId)
+				if !ok {
+					return
+				}
+
+				result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+				if err != nil {
+					c.logger.Error("Failed to get workloads", "resourceId", resourceId, "namespace", namespace, "error", err.Error())
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+
+					errorsMutex.Lock()
+					errors = append(errors, err)
+					errorsMutex.Unlock()
+					return
+				}
+
+				var list struct {
+					Items []json.RawMessage `json:"items"`
+				}
+				if err := json.Unmarshal(result, &list); err != nil {
+					c.logger.Error("Failed to unmarshal workloads", "resourceId", resourceId, "namespace", namespace, "error", err.Error())
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+
+					errorsMutex.Lock()
+					errors = append(errors, err)
+					errorsMutex.Unlock()
+					return
+				}
+
+				for _, item := range list.Items {
+					state, err := newRolloutState(resourceId, item)
+					if err != nil {
+						c.logger.Error("Failed to get rollout state", "resourceId", resourceId, "namespace", namespace, "error", err.Error())
+						continue
+					}
+					if r != nil && !r.MatchString(state.Name) {
+						continue
+					}
+
+					statesMutex.Lock()
+					states = append(states, *state)
+					statesMutex.Unlock()
+				}
+			}(resourceId, namespace)
+		}
+	}
+
+	statesWG.Wait()
+
+	if len(states) == 0 && len(errors) > 0 {
+		return nil, errors[0]
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Kind != states[j].Kind {
+			return states[i].Kind < states[j].Kind
+		}
+		if states[i].Namespace != states[j].Namespace {
+			return states[i].Namespace < states[j].Namespace
+		}
+		return states[i].Name < states[j].Name
+	})
+
+	return createRolloutStatusDataFrames(states), nil
+}
+
+// GetRolloutHistory returns the revisions of a Deployment. The revisions are
+// read from the ReplicaSets which are controlled by the Deployment.
+func (c *client) GetRolloutHistory(ctx context.Context, user string, groups []string, namespace, name string) (*data.Frame, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetRolloutHistory")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+
+	if err := c.namespaces.authorize(schema.GroupResource{Group: "apps", Resource: "deployments"}, true, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	deployment, replicaSets, err := c.getDeploymentAndReplicaSets(ctx, user, groups, namespace, name)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return createRolloutHistoryDataFrame(*deployment, replicaSets), nil
+}
+
+// RollbackDeployment rolls back a Deployment to the provided revision, like
+// "kubectl rollout undo". If the revision is 0, the Deployment is rolled back
+// to the previous revision. If the dry run option is set, the rollback is
+// only validated by the Kubernetes API.
+func (c *client) RollbackDeployment(ctx context.Context, user string, groups []string, namespace, name string, revision int64, options DeploymentRollbackOptions) (*DeploymentRollbackResult, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "RollbackDeployment")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("revision").Int64(revision))
+	span.SetAttributes(attribute.Key("dryRun").Bool(options.DryRun))
+
+	if err := c.namespaces.authorize(schema.GroupResource{Group: "apps", Resource: "deployments"}, true, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if err := c.policy.authorizeResource(http.MethodPatch, "/apis/apps/v1", namespace, "deployments", name, ""); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	deployment, replicaSets, err := c.getDeploymentAndReplicaSets(ctx, user, groups, namespace, name)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	patch, result, err := newDeploymentRollbackPatch(*deployment, replicaSets, revision)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	result.DryRun = options.DryRun
+
+	if result.Skipped {
+		return result, nil
+	}
+
+	request := c.clientset.CoreV1().RESTClient().Patch(types.JSONPatchType).AbsPath("/apis/apps/v1").Namespace(namespace).Resource("deployments").Name(name).Param("fieldManager", FieldManager)
+	if options.DryRun {
+		request = request.Param("dryRun", "All")
+	}
+
+	_, err = request.Body(patch).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// getDeploymentAndReplicaSets returns the requested Deployment and all
+// ReplicaSets controlled by the Deployment.
+func (c *client) getDeploymentAndReplicaSets(ctx context.Context, user string, groups []string, namespace, name string) (*appsv1.Deployment, []appsv1.ReplicaSet, error) {
+	result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/apis/apps/v1").Namespace(namespace).Resource("deployments").Name(name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var deployment appsv1.Deployment
+	if err := json.Unmarshal(result, &deployment); err != nil {
+		return nil, nil, err
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err = c.clientset.CoreV1().RESTClient().Get().AbsPath("/apis/apps/v1").Namespace(namespace).Resource("replicasets").Param("labelSelector", selector.String()).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var replicaSetList appsv1.ReplicaSetList
+	if err := json.Unmarshal(result, &replicaSetList); err != nil {
+		return nil, nil, err
+	}
+
+	return &deployment, filterDeploymentReplicaSets(deployment, replicaSetList.Items), nil
+}
+
+// Exec runs a command in a container of the requested pod and returns the
+// output and exit code of the command. The command must match one of the
+// patterns of the configured allowlist. The command is run via the "exec"
+// subresource as the impersonated user, so that the RBAC rules for
+// "pods/exec" are applied. We try to use WebSockets first and fall back to
+// SPDY for older API servers.
+func (c *client) Exec(ctx context.Context, user string, groups []string, namespace, name string, options ExecOptions) (*ExecResult, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "Exec")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("container").String(options.Container))
+	span.SetAttributes(attribute.Key("command").StringSlice(options.Command))
+
+	if err := c.namespaces.authorize(schema.GroupResource{Resource: "pods"}, true, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if !isExecAllowed(c.execAllowlist, options.Command) {
+		err := apierrors.NewForbidden(schema.GroupResource{Resource: "pods/exec"}, name, fmt.Errorf("command %q is not allowed", strings.Join(options.Command, " ")))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	executor, err := c.newExecutor(user, groups, namespace, name, &corev1.PodExecOptions{
+		Container: options.Container,
+		Command:   options.Command,
+		Stdout:    true,
+		Stderr:    true,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	execCtx, execCancel := context.WithTimeout(ctx, c.execTimeout)
+	defer execCancel()
+
+	stdout := &limitedBuffer{limit: maxExecOutputSize}
+	stderr := &limitedBuffer{limit: maxExecOutputSize}
+
+	var stdoutWriter, stderrWriter io.Writer = stdout, stderr
+	if recording := c.recordPluginSession(ctx, user, namespace, name, options.Container, options.Command); recording != nil {
+		defer recording.close()
+		stdoutWriter = io.MultiWriter(stdout, recordingWriter{recording: recording, logger: c.logger})
+		stderrWriter = io.MultiWriter(stderr, recordingWriter{recording: recording, logger: c.logger})
+	}
+
+	err = executor.StreamWithContext(execCtx, remotecommand.StreamOptions{
+		Stdout: stdoutWriter,
+		Stderr: stderrWriter,
+	})
+
+	result := &ExecResult{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
+
+	if err != nil {
+		var exitErr utilexec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitStatus()
+			span.SetAttributes(attribute.Key("exitCode").Int(result.ExitCode))
+			return result, nil
+		}
+
+		if execCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("command timed out after %s", c.execTimeout)
+		}
+
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Download streams a file or directory from a container of the requested
+// pod. Like "kubectl cp", the file or directory is archived via tar in the
+// container, so that the container must contain the tar binary. Only paths
+// which match the download allowlist can be downloaded. The returned download
+// must be closed by the caller.
+func (c *client) Download(ctx context.Context, user string, groups []string, namespace, name string, options DownloadOptions) (*Download, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "Download")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("container").String(options.Container))
+	span.SetAttributes(attribute.Key("path").String(options.Path))
+	span.SetAttributes(attribute.Key("format").String(options.Format))
+
+	if err := c.namespaces.authorize(schema.GroupResource{Resource: "pods"}, true, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	command, err := newDownloadCommand(options.Path)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if !isDownloadAllowed(c.downloadAllowlist, options.Path) {
+		err := apierrors.NewForbidden(schema.GroupResource{Resource: "pods/exec"}, name, fmt.Errorf("download of path %q is not allowed", options.Path))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	executor, err := c.newExecutor(user, groups, namespace, name, &corev1.PodExecOptions{
+		Container: options.Container,
+		Command:   command,
+		Stdout:    true,
+		Stderr:    true,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	// Only the metadata of a download is recorded, because the output is a
+	// binary tar archive, which can not be replayed.
+	if recording := c.recordPluginSession(ctx, user, namespace, name, options.Container, command); recording != nil {
+		recording.close()
+	}
+
+	streamCtx, streamCancel := context.WithCancel(ctx)
+	reader, writer := io.Pipe()
+	stderr := &limitedBuffer{limit: 4096}
+
+	go func() {
+		err := executor.StreamWithContext(streamCtx, remotecommand.StreamOptions{
+			Stdout: writer,
+			Stderr: stderr,
+		})
+		if err != nil {
+			err = newDownloadError(err, stderr.String())
+			c.logger.Error("Failed to download file", "namespace", namespace, "name", name, "container", options.Container, "path", options.Path, "error", err.Error())
+		}
+		writer.CloseWithError(err)
+	}()
+
+	downloadReader, filename, contentType, err := newDownloadReader(reader, options.Path, options.Format, c.downloadMaxSize)
+	if err != nil {
+		streamCancel()
+		reader.Close()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return &Download{
+		Filename:    filename,
+		ContentType: contentType,
+		reader:      downloadReader,
+		close: func() error {
+			streamCancel()
+			return reader.Close()
+		},
+	}, nil
+}
+
+// Debug adds an ephemeral debug container to the requested pod, similar to
+// "kubectl debug". The image must be one of the configured debug images. We
+// wait until the container is running and return its name, so that the user
+// can attach to the container.
+func (c *client) Debug(ctx context.Context, user string, groups []string, namespace, name string, options DebugOptions) (*DebugResult, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "Debug")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("image").String(options.Image))
+	span.SetAttributes(attribute.Key("targetContainer").String(options.TargetContainer))
+
+	if err := c.namespaces.authorize(schema.GroupResource{Resource: "pods"}, true, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if err := c.policy.authorizeResource(http.MethodPatch, "/api/v1", namespace, "pods", name, "ephemeralcontainers"); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1").Namespace(namespace).Resource("pods").Name(name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(result, &pod); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	container, err := newDebugContainer(pod, c.debugImages, options)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	patch, err := newDebugContainerPatch(container)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	_, err = c.clientset.CoreV1().RESTClient().Patch(types.StrategicMergePatchType).AbsPath("/api/v1").Namespace(namespace).Resource("pods").Name(name).SubResource("ephemeralcontainers").Param("fieldManager", FieldManager).Body(patch).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, debugContainerTimeout)
+	defer waitCancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1").Namespace(namespace).Resource("pods").Name(name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(waitCtx)
+		if err == nil {
+			var pod corev1.Pod
+			if err := json.Unmarshal(result, &pod); err == nil {
+				running, err := debugContainerRunning(pod, container.Name)
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+					return nil, err
+				}
+				if running {
+					return &DebugResult{Name: container.Name, Image: container.Image, TargetContainer: container.TargetContainerName}, nil
+				}
+			}
+		}
+
+		select {
+		case <-waitCtx.Done():
+			err := fmt.Errorf("timed out waiting for debug container %s to be running", container.Name)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		case <-ticker.C:
+		}
+	}
+}
+
+// newExecutor returns an executor to run a command in a container via the
+// "exec" subresource of a pod. The command is run as the impersonated user.
+// We try to use WebSockets first and fall back to SPDY for older API servers.
+func (c *client) newExecutor(user string, groups []string, namespace, name string, options *corev1.PodExecOptions) (remotecommand.Executor, error) {
+	if err := c.policy.authorizeResource(http.MethodPost, "/api/v1", namespace, "pods", name, "exec"); err != nil {
+		return nil, err
+	}
+
+	restConfig := rest.CopyConfig(c.restConfig)
+	restConfig.WrapTransport = nil
+	if user != "" {
+		restConfig.Impersonate = rest.ImpersonationConfig{UserName: user, Groups: groups}
+	}
+
+	request := c.clientset.CoreV1().RESTClient().Post().AbsPath("/api/v1").Namespace(namespace).Resource("pods").Name(name).SubResource("exec").VersionedParams(options, scheme.ParameterCodec)
+
+	websocketExecutor, err := remotecommand.NewWebSocketExecutor(restConfig, http.MethodGet, request.URL().String())
+	if err != nil {
+		return nil, err
+	}
+
+	spdyExecutor, err := remotecommand.NewSPDYExecutor(restConfig, http.MethodPost, request.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	return remotecommand.NewFallbackExecutor(websocketExecutor, spdyExecutor, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+}
+
+// RunJob creates a new Job from the requested CronJob or re-runs the
+// requested Job, by creating a copy of the Job with a new name. The returned
+// result contains the created Job and its pods. Since the pods are created
+// asynchronously by the Job controller, we wait a few seconds for the pods to
+// be created.
+func (c *client) RunJob(ctx context.Context, user string, groups []string, resourceId, namespace, name string, options JobRunOptions) (*JobRunResult, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "RunJob")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+
+	if err := c.namespaces.authorize(namespacesResource, true, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if err := c.policy.authorizeResource(http.MethodPost, "/apis/batch/v1", namespace, "jobs", "", ""); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var job *batchv1.Job
+
+	switch resourceId {
+	case "cronjob.batch":
+		result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/apis/batch/v1").Namespace(namespace).Resource("cronjobs").Name(name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		var cronJob batchv1.CronJob
+		if err := json.Unmarshal(result, &cronJob); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		job = newJobFromCronJob(cronJob, newManualJobName(cronJob.Name), options.Env)
+	case "job.batch":
+		result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/apis/batch/v1").Namespace(namespace).Resource("jobs").Name(name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		var existingJob batchv1.Job
+		if err := json.Unmarshal(result, &existingJob); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		// If the Job was created by a CronJob, we use the name of the CronJob
+		// for the new Job, so that the name doesn't grow with each re-run.
+		jobName := existingJob.Name
+		for _, ownerReference := range existingJob.OwnerReferences {
+			if ownerReference.Kind == "CronJob" {
+				jobName = ownerReference.Name
+			}
+		}
+
+		job, err = newJobFromJob(existingJob, newManualJobName(jobName), options.Env)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	default:
+		err := fmt.Errorf("resource %s can not be run", resourceId)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	result, err := c.clientset.CoreV1().RESTClient().Post().AbsPath("/apis/batch/v1").Namespace(namespace).Resource("jobs").Param("fieldManager", FieldManager).Body(body).SetHeader("Content-Type", "application/json").SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	runResult := &JobRunResult{}
+	if err := json.Unmarshal(result, &runResult.Job); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(runResult.Job.Spec.Selector)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	podsCtx, podsCancel := context.WithTimeout(ctx, jobPodsTimeout)
+	defer podsCancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1").Namespace(namespace).Resource("pods").Param("labelSelector", selector.String()).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(podsCtx)
+		if err == nil {
+			var podList corev1.PodList
+			if err := json.Unmarshal(result, &podList); err == nil && len(podList.Items) > 0 {
+				runResult.Pods = podList.Items
+				return runResult, nil
+			}
+		} else {
+			c.logger.Warn("Failed to get pods for job", "namespace", namespace, "job", runResult.Job.Name, "error", err.Error())
+		}
+
+		select {
+		case <-podsCtx.Done():
+			return runResult, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunNodeAction cordons, uncordons or drains the requested node. To drain a
+// node, the node is cordoned first and then all pods on the node are evicted
+// via the Eviction API, so that PodDisruptionBudgets are honoured. Pods which
+// are managed by a DaemonSet and mirror pods are skipped. If an eviction is
+// blocked by a PodDisruptionBudget, it is retried until the timeout is
+// reached. The progress function from the options is called for each evicted
+// pod. The calls are serialized, so that the function does not need to be
+// safe for concurrent use.
+func (c *client) RunNodeAction(ctx context.Context, user string, groups []string, name, action string, options NodeDrainOptions) (*NodeActionResult, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "RunNodeAction")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("action").String(action))
+	span.SetAttributes(attribute.Key("dryRun").Bool(options.DryRun))
+
+	// Nodes are cluster-scoped, so that node actions are not allowed, when
+	// cluster-scoped resources are hidden. Draining a node evicts the pods in
+	// all namespaces, so that it is also not allowed, when the namespaces are
+	// restricted.
+	if err := c.namespaces.authorize(nodesResource, false, ""); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if action == NodeActionDrain && c.namespaces.isRestricted() {
+		err := apierrors.NewForbidden(nodesResource, name, fmt.Errorf("drain is not allowed, because the namespaces of the datasource are restricted"))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if err := c.policy.authorizeResource(http.MethodPatch, "/api/v1", "", "nodes", name, ""); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	patch, err := newNodeActionPatch(action)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	request := c.clientset.CoreV1().RESTClient().Patch(types.MergePatchType).AbsPath("/api/v1").Resource("nodes").Name(name).Param("fieldManager", FieldManager)
+	if options.DryRun {
+		request = request.Param("dryRun", "All")
+	}
+
+	result, err := request.Body(patch).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var node corev1.Node
+	if err := json.Unmarshal(result, &node); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	actionResult := &NodeActionResult{
+		Node:          name,
+		Action:        action,
+		DryRun:        options.DryRun,
+		Unschedulable: node.Spec.Unschedulable,
+	}
+
+	if action != NodeActionDrain {
+		return actionResult, nil
+	}
+
+	result, err = c.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1/pods").Param("fieldSelector", "spec.nodeName="+name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var podList corev1.PodList
+	if err := json.Unmarshal(result, &podList); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	// The PodDisruptionBudgets are only required to plan the drain. For the
+	// real drain the budgets are enforced by the Eviction API, so that we can
+	// continue without them, when the user is not allowed to list them.
+	var pdbList policyv1.PodDisruptionBudgetList
+	result, err = c.clientset.CoreV1().RESTClient().Get().AbsPath("/apis/policy/v1/poddisruptionbudgets").SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err == nil {
+		err = json.Unmarshal(result, &pdbList)
+	}
+	if err != nil {
+		if options.DryRun {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		c.logger.Warn("Failed to get PodDisruptionBudgets", "node", name, "error", err.Error())
+	}
+
+	plan := planNodeDrain(podList.Items, pdbList.Items, options)
+	actionResult.Pods = plan
+	if options.DryRun {
+		return actionResult, nil
+	}
+
+	// The node is already cordoned at this point, like it is done by "kubectl
+	// drain", when pods are refused.
+	if err := nodeDrainRefusedError(name, plan); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return actionResult, err
+	}
+
+	timeout := defaultNodeDrainTimeout
+	if options.TimeoutSeconds > 0 {
+		timeout = time.Duration(options.TimeoutSeconds) * time.Second
+	}
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, timeout)
+	defer drainCancel()
+
+	var evictionsWG sync.WaitGroup
+	var progressMutex sync.Mutex
+
+	for i := range plan {
+		if plan[i].Status == NodeDrainPodStatusSkipped {
+			continue
+		}
+
+		evictionsWG.Add(1)
+		go func(i int) {
+			defer evictionsWG.Done()
+			plan[i] = c.evictPod(drainCtx, user, groups, podList.Items[i], plan[i], options.GracePeriodSeconds)
+			c.logger.Info("Drain node", "node", name, "namespace", plan[i].Namespace, "pod", plan[i].Name, "status", plan[i].Status, "message", plan[i].Message)
+
+			if options.Progress != nil {
+				progressMutex.Lock()
+				options.Progress(plan[i])
+				progressMutex.Unlock()
+			}
+		}(i)
+	}
+
+	evictionsWG.Wait()
+
+	// The result is also returned, when not all pods were evicted, so that the
+	// user can see which pods are still running on the node.
+	if err := nodeDrainIncompleteError(name, plan); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return actionResult, err
+	}
+
+	return actionResult, nil
+}
+
+// evictPod evicts the provided pod and waits until it is deleted. If the
+// eviction is blocked by a PodDisruptionBudget, the eviction is retried until
+// the context is canceled.
+func (c *client) evictPod(ctx context.Context, user string, groups []string, pod corev1.Pod, result NodeDrainPodResult, gracePeriodSeconds *int64) NodeDrainPodResult {
+	eviction, err := json.Marshal(policyv1.Eviction{
+		TypeMeta:      metav1.TypeMeta{APIVersion: "policy/v1", Kind: "Eviction"},
+		ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		DeleteOptions: &metav1.DeleteOptions{GracePeriodSeconds: gracePeriodSeconds},
+	})
+	if err != nil {
+		result.Status = NodeDrainPodStatusFailed
+		result.Message = err.Error()
+		return result
+	}
+
+	if err := c.policy.authorizeResource(http.MethodPost, "/api/v1", pod.Namespace, "pods", pod.Name, "eviction"); err != nil {
+		result.Status = NodeDrainPodStatusFailed
+		result.Message = err.Error()
+		return result
+	}
+
+	for {
+		_, err := c.clientset.CoreV1().RESTClient().Post().AbsPath("/api/v1").Namespace(pod.Namespace).Resource("pods").Name(pod.Name).SubResource("eviction").Body(eviction).SetHeader("Content-Type", "application/json").SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+		if err == nil || apierrors.IsNotFound(err) {
+			break
+		}
+
+		if !apierrors.IsTooManyRequests(err) || ctx.Err() != nil {
+			result.Status = NodeDrainPodStatusFailed
+			if ctx.Err() != nil {
+				result.Status = NodeDrainPodStatusTimedOut
+			}
+			result.Message = err.Error()
+			return result
+		}
+
+		result.Status = NodeDrainPodStatusBlocked
+		result.Message = err.Error()
+
+		select {
+		case <-ctx.Done():
+			result.Status = NodeDrainPodStatusTimedOut
+			return result
+		case <-time.After(nodeDrainRetryInterval):
+		}
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		podResult, err := c.clientset.CoreV1().RESTClient().Get().AbsPath("/api/v1").Namespace(pod.Namespace).Resource("pods").Name(pod.Name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+		if apierrors.IsNotFound(err) {
+			break
+		}
+		if err == nil {
+			var current corev1.Pod
+			if err := json.Unmarshal(podResult, &current); err == nil && current.UID != pod.UID {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return NodeDrainPodResult{Namespace: pod.Namespace, Name: pod.Name, Status: NodeDrainPodStatusTimedOut, Message: "timed out waiting for the pod to be deleted"}
+		case <-ticker.C:
+		}
+	}
+
+	return NodeDrainPodResult{Namespace: pod.Namespace, Name: pod.Name, Status: NodeDrainPodStatusEvicted}
+}
+
+// GetManagedFields returns a timeline of the managed fields of the requested
+// object, which contains the manager, operation and time of each change and
+// the fields which were changed.
+func (c *client) GetManagedFields(ctx context.Context, user string, groups []string, resourceId, namespace, name string) (*data.Frame, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetManagedFields")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+
+	c.refreshCache(ctx)
+
+	resource, ok := c.cache.Get(resourceId)
+	if !ok {
+		err := fmt.Errorf("resource %s not found", resourceId)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if err := c.namespaces.authorize(resourceGroupResource(resource), resource.Namespaced, namespace); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if !resource.Namespaced {
+		namespace = ""
+	}
+
+	result, err := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).Name(name).SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var metadata metav1.PartialObjectMetadata
+	if err := json.Unmarshal(result, &metadata); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	frame, err := createManagedFieldsDataFrame(name, metadata.ManagedFields)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// GetHistory returns the recorded history of the requested resource. If the
+// mode is "snapshot", the data frame contains all objects as they existed at
+// the end of the provided time range. If the mode is "changes", the data frame
+// contains all changes within the provided time range.
+//
+// Since the history is recorded with the identity of the plugin, we verify
+// that the user is allowed to list the resource in the requested namespaces,
+// before we return the history.
+func (c *client) GetHistory(ctx context.Context, user string, groups []string, resourceId, namespace, filter, mode string, timeRange backend.TimeRange) (*data.Frame, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetHistory")
+	defer span.End()
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("filter").String(filter))
+	span.SetAttributes(attribute.Key("mode").String(mode))
+
+	if c.history == nil || !slices.Contains(c.historyIds, resourceId) {
+		err := fmt.Errorf("history is not recorded for resource %s", resourceId)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	c.refreshCache(ctx)
+
+	resource, ok := c.cache.Get(resourceId)
+	if !ok {
+		err := fmt.Errorf("resource %s not found", resourceId)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var r *regexp.Regexp
+	if filter != "" {
+		var err error
+		r, err = regexp.Compile(filter)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	}
+
+	permissionNamespaces, err := c.resolveNamespaces(ctx, resourceGroupResource(resource), resource.Namespaced, namespace)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	var namespaces []string
+	if len(permissionNamespaces) > 1 || permissionNamespaces[0] != "" {
+		namespaces = permissionNamespaces
+	}
+
+	for _, namespace := range permissionNamespaces {
+		_, err := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Namespace(namespace).Resource(resource.Name).Param("limit", "1").SetHeader("Impersonate-User", user).SetHeader("Impersonate-Group", groups...).DoRaw(ctx)
+		if err != nil {
+			c.logger.Error("User is not allowed to list resource", "resourceId", resourceId, "namespace", namespace, "error", err.Error())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	}
+
+	switch mode {
+	case HistoryModeSnapshot:
+		return createHistorySnapshotDataFrame(resource, c.history.snapshot(resourceId, namespaces, r, timeRange.To)), nil
+	case HistoryModeChanges:
+		return createHistoryChangesDataFrame(resource, c.history.changes(resourceId, namespaces, r, timeRange.From, timeRange.To)), nil
+	default:
+		err := fmt.Errorf("history mode %s is not supported", mode)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+}
+
+// recordHistory watches all objects of the provided resource with the identity
+// of the plugin and adds each change to the history store, until the context
+// is canceled.
+//
+// Before the watch is started without a resource version (on start and when
+// the resource version expired), all objects are listed and reconciled with
+// the history store, so that objects which were deleted in the meantime are
+// recorded as deleted and unchanged objects are not recorded again.
+func (c *client) recordHistory(ctx context.Context, resourceId string) {
+	var resourceVersion string
+
+	for {
+		c.refreshCache(ctx)
+
+		resource, ok := c.cache.Get(resourceId)
+		if !ok {
+			c.logger.Error("Failed to record history", "resourceId", resourceId, "error", "resource not found")
+		} else {
+			c.logger.Debug("Recording history", "resourceId", resourceId, "resourceVersion", resourceVersion)
+
+			if resourceVersion == "" {
+				entries, listResourceVersion, err := c.listHistoryEntries(ctx, resourceId, resource)
+				if err != nil {
+					c.logger.Error("Failed to list resources", "resourceId", resourceId, "error", err.Error())
+				} else if err := c.history.sync(resourceId, entries, time.Now()); err != nil {
+					c.logger.Error("Failed to sync history", "resourceId", resourceId, "error", err.Error())
+				} else {
+					resourceVersion = listResourceVersion
+				}
+			}
+
+			request := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Resource(resource.Name).Param("watch", "true")
+			if resourceVersion != "" {
+				request = request.Param("resourceVersion", resourceVersion)
+			}
+
+			stream, err := request.Stream(ctx)
+			if err != nil {
+				c.logger.Error("Failed to watch resources", "resourceId", resourceId, "error", err.Error())
+			} else {
+				decoder := json.NewDecoder(stream)
+
+				for {
+					var event metav1.WatchEvent
+					if err := decoder.Decode(&event); err != nil {
+						if err != io.EOF && ctx.Err() == nil {
+							c.logger.Error("Failed to decode watch event", "resourceId", resourceId, "error", err.Error())
+						}
+						break
+					}
+
+					if event.Type == "ERROR" {
+						c.logger.Debug("Watch returned an error", "resourceId", resourceId, "error", string(event.Object.Raw))
+						resourceVersion = ""
+						break
+					}
+
+					entry, err := newHistoryEntry(resourceId, event.Type, event.Object.Raw, time.Now())
+					if err != nil {
+						c.logger.Error("Failed to create history entry", "resourceId", resourceId, "error", err.Error())
+						continue
+					}
+					resourceVersion = entry.ResourceVersion
+
+					if err := c.history.add(entry); err != nil {
+						c.logger.Error("Failed to add history entry", "resourceId", resourceId, "error", err.Error())
+					}
+				}
+
+				stream.Close()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// listHistoryEntries lists all objects of the provided resource with the
+// identity of the plugin and returns a history entry for each object and the
+// resource version of the list, which can be used to start a watch. The kind
+// and api version are added to the objects, because they are not set for the
+// items of a list.
+func (c *client) listHistoryEntries(ctx context.Context, resourceId string, resource Resource) ([]historyEntry, string, error) {
+	content, err := c.clientset.CoreV1().RESTClient().Get().AbsPath(resource.Path).Resource(resource.Name).DoRaw(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var list struct {
+		Metadata metav1.ListMeta              `json:"metadata"`
+		Items    []map[string]json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, "", err
+	}
+
+	kind, err := json.Marshal(resource.Kind)
+	if err != nil {
+		return nil, "", err
+	}
+	apiVersion, err := json.Marshal(resource.APIVersion)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	var entries []historyEntry
+	for _, item := range list.Items {
+		item["kind"] = kind
+		item["apiVersion"] = apiVersion
+
+		object, err := json.Marshal(item)
+		if err != nil {
+			return nil, "", err
+		}
+
+		entry, err := newHistoryEntry(resourceId, "ADDED", object, now)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, list.Metadata.ResourceVersion, nil
+}
+
+// pruneHistory removes old changes from the history store every minute, until
+// the context is canceled.
+func (c *client) pruneHistory(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.history.prune(time.Now()) {
+				if err := c.history.compact(); err != nil {
+					c.logger.Error("Failed to compact history", "error", err.Error())
+				}
+			}
+		}
+	}
+}
+
+// GetResource returns the resource for the given resource ID from the cache. If
+// the resource is not found in the cache, an error is returned.
+func (c *client) GetResource(ctx context.Context, resourceId string) (*Resource, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GetResource")
+	defer span.End()
+	span.SetAttributes(attribute.Key("resourceId").String(resourceId))
+
+	c.refreshCache(ctx)
+
+	resource, ok := c.cache.Get(resourceId)
+	if !ok {
+		err := fmt.Errorf("resource %s not found", resourceId)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return &resource, nil
+}
+
+// Proxy proxies a request to the Kubernetes API server. The request is
+// modified to add the "Impersonate-User" header with the given user. The
+// "Authorization" header is removed from the request.
+//
+// Create a user for testing:
+//
+//	kubectl create clusterrole grafana-admin-user --verb list,get --resource pods
+//	kubectl create clusterrolebinding grafana-admin-user --clusterrole grafana-admin-user --user admin
+//	kubectl create clusterrole grafana-admin-team --verb list,get --resource pods
+//	kubectl create clusterrolebinding grafana-admin-team --clusterrole grafana-admin-team --group admin
+//
+//	kubectl delete clusterrolebinding grafana-admin-user
+//	kubectl delete clusterrole grafana-admin-user
+//	kubectl delete clusterrolebinding grafana-admin-team
+//	kubectl delete clusterrole grafana-admin-team
+//
+// Caddy (required for kubectl, because token is only send when https is used):
+//
+//	caddy run --config /tmp/Caddyfile
+//
+//	grafana.localhost {
+//	  reverse_proxy localhost:3000
+//	}
+func (c *client) Proxy(user string, groups []string, requestUrl string, w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "Proxy")
+	defer span.End()
+
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("method").String(r.Method))
+	span.SetAttributes(attribute.Key("requestUrl").String(requestUrl))
+
+	r.Header.Del("Authorization")
+
+	// Check if the request is allowed by the proxy policy of the datasource.
+	// If the request is not allowed, we return a Kubernetes status object, so
+	// that clients like kubectl can show a proper error message.
+	if err := c.policy.authorize(r.Method, "/"+requestUrl); err != nil {
+		c.logger.Warn("Proxy request denied by policy", "user", user, "groups", groups, "method", r.Method, "requestUrl", requestUrl, "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		WriteStatusError(w, err)
+		return
+	}
+
+	// Check if the request is allowed by the allowed namespaces of the
+	// datasource. This also applies to the requests of the kubeconfig server,
+	// because they are also forwarded via this method.
+	if err := c.namespaces.authorizeRequest(r.Method, "/"+requestUrl, c.isNamespaced); err != nil {
+		c.logger.Warn("Proxy request denied by allowed namespaces", "user", user, "groups", groups, "method", r.Method, "requestUrl", requestUrl, "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		WriteStatusError(w, err)
+		return
+	}
+
+	// Parse the URL of the request and create a new URL for the request against
+	// the Kubernetes API server.
+	url, err := url.Parse(fmt.Sprintf("%s/%s", c.restConfig.Host, requestUrl))
+	if err != nil {
+		c.logger.Error("Failed to parse url", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, "Failed to parse url", http.StatusBadGateway)
+		return
+	}
+
+	proxy, err := c.newReverseProxy(ctx, user, groups, url, func(resp *http.Response) error {
+		c.logger.Info("Proxy", "user", user, "groups", groups, "method", r.Method, "requestUrl", requestUrl, "responseCode", resp.StatusCode)
+		span.SetAttributes(attribute.Key("responseCode").Int(resp.StatusCode))
+		return nil
+	})
+	if err != nil {
+		c.logger.Error("Failed to create proxy", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, "Failed to create proxy", http.StatusBadGateway)
+		return
+	}
+
+	proxy.ServeHTTP(w, r)
+}
+
+// AuthorizeSubresource checks if the request for the provided method and url
+// is allowed to use the "exec", "attach", "portforward" or "proxy" subresource
+// of a pod. The function must be called before a request is forwarded to the
+// Kubernetes API, because the Kubernetes client doesn't know the Grafana teams
+// of the user. The teams are only requested via the provided function, when
+// the subresource is restricted to some teams.
+func (c *client) AuthorizeSubresource(method, requestUrl string, getTeams func() ([]string, error)) error {
+	return c.subresources.authorize(method, requestUrl, getTeams)
+}
+
+// AuthorizeNamespace returns a "Forbidden" error, when the provided namespace
+// is not allowed by the datasource. An empty namespace selects all namespaces,
+// which is only allowed, when the namespaces of the datasource are not
+// restricted. It is used for the Helm releases, because they are not managed
+// by this client.
+func (c *client) AuthorizeNamespace(namespace string) error {
+	return c.namespaces.authorize(namespacesResource, true, namespace)
+}
+
+// isNamespaced returns true, when the resource with the provided API group and
+// name is namespaced or when the resource is unknown.
+func (c *client) isNamespaced(group, name string) bool {
+	resource, ok := c.cache.GetByName(group, name)
+	return !ok || resource.Namespaced
+}
+
+// RecordSession returns a response writer, which records the exec or attach
+// session of the provided request. If the recording of sessions is disabled
+// or the request doesn't use the "exec" or "attach" subresource of a pod, the
+// provided response writer is returned. The user is only requested, when the
+// session is recorded.
+func (c *client) RecordSession(method, requestUrl string, getUser func() (string, error), w http.ResponseWriter) (http.ResponseWriter, error) {
+	if c.recordings == nil {
+		return w, nil
+	}
+
+	session, ok, err := newRecordingSession(method, requestUrl)
+	if err != nil || !ok {
+		return w, err
+	}
+
+	session.User, err = getUser()
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingResponseWriter{
+		ResponseWriter: w,
+		store:          c.recordings,
+		session:        session,
+		logger:         c.logger,
+	}, nil
+}
+
+// recordPluginSession creates a recording for a command, which is run via the
+// exec or download endpoint of the plugin, so that these commands are recorded
+// like the exec sessions which are started via the generated Kubeconfig. The
+// recording is created for the Grafana user from the context. If the
+// recording of sessions is disabled or the recording can not be created, nil
+// is returned.
+func (c *client) recordPluginSession(ctx context.Context, user, namespace, name, container string, command []string) *recording {
+	if c.recordings == nil {
+		return nil
+	}
+
+	if grafanaUser := backend.PluginConfigFromContext(ctx).User; grafanaUser != nil && grafanaUser.Login != "" {
+		user = grafanaUser.Login
+	}
+
+	recording, err := c.recordings.create(RecordingSession{
+		User:        user,
+		Subresource: "exec",
+		Namespace:   namespace,
+		Pod:         name,
+		Container:   container,
+		Command:     command,
+	}, time.Now())
+	if err != nil {
+		c.logger.Error("Failed to create recording", "namespace", namespace, "pod", name, "error", err.Error())
+		return nil
+	}
+
+	return recording
+}
+
+// ListRecordings returns all recorded exec and attach sessions. If the
+// recording of sessions is disabled, an error is returned.
+func (c *client) ListRecordings(ctx context.Context) ([]Recording, error) {
+	_, span := tracing.DefaultTracer().Start(ctx, "ListRecordings")
+	defer span.End()
+
+	if c.recordings == nil {
+		err := fmt.Errorf("recording of sessions is not enabled")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	recordings, err := c.recordings.list()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return recordings, nil
+}
+
+// GetRecording returns the recording with the provided id in the asciicast v2
+// format. The caller must close the returned reader.
+func (c *client) GetRecording(ctx context.Context, id string) (io.ReadCloser, error) {
+	_, span := tracing.DefaultTracer().Start(ctx, "GetRecording")
+	defer span.End()
+	span.SetAttributes(attribute.Key("id").String(id))
+
+	if c.recordings == nil {
+		err := fmt.Errorf("recording of sessions is not enabled")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	file, err := c.recordings.open(id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// pruneRecordings removes old recordings every hour, until the context is
+// canceled.
+func (c *client) pruneRecordings(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.recordings.prune(time.Now()); err != nil {
+				c.logger.Error("Failed to prune recordings", "error", err.Error())
+			}
+		}
+	}
+}
+
+// Preview proxies a HTTP request to a port of a pod or service via the "proxy"
+// subresource, so that simple web UIs (e.g. the "/metrics" endpoint of a pod)
+// can be opened in the browser. The port must be allowed in the datasource
+// settings. The public path is the path under which the preview is served by
+// Grafana. It is used to rewrite the "Location" header and the links in HTML
+// responses.
+func (c *client) Preview(user string, groups []string, kind, namespace, name, port, requestUrl, publicPath string, w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "Preview")
+	defer span.End()
+
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("kind").String(kind))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("port").String(port))
+	span.SetAttributes(attribute.Key("requestUrl").String(requestUrl))
+
+	if !isPreviewPortAllowed(c.previewPorts, port) {
+		c.logger.Warn("Preview port is not allowed", "user", user, "kind", kind, "namespace", namespace, "name", name, "port", port)
+		span.SetStatus(codes.Error, "port is not allowed")
+
+		http.Error(w, fmt.Sprintf("port %s is not allowed", port), http.StatusForbidden)
+		return
+	}
+
+	apiPath, err := newPreviewAPIPath(kind, namespace, name, port)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := c.policy.authorize(r.Method, apiPath+"/"+requestUrl); err != nil {
+		c.logger.Warn("Preview request denied by policy", "user", user, "groups", groups, "method", r.Method, "kind", kind, "namespace", namespace, "name", name, "port", port, "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		WriteStatusError(w, err)
+		return
+	}
+
+	if err := c.namespaces.authorizeRequest(r.Method, apiPath+"/"+requestUrl, c.isNamespaced); err != nil {
+		c.logger.Warn("Preview request denied by allowed namespaces", "user", user, "groups", groups, "method", r.Method, "kind", kind, "namespace", namespace, "name", name, "port", port, "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		WriteStatusError(w, err)
+		return
+	}
+
+	// Remove the credentials of the user for Grafana and the accepted
+	// encodings, so that we get an uncompressed response which can be
+	// rewritten.
+	r.Header.Del("Authorization")
+	r.Header.Del("Cookie")
+	r.Header.Del("Accept-Encoding")
+
+	url, err := url.Parse(fmt.Sprintf("%s%s/%s", c.restConfig.Host, apiPath, requestUrl))
+	if err != nil {
+		c.logger.Error("Failed to parse url", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, "Failed to parse url", http.StatusBadGateway)
+		return
+	}
+
+	proxy, err := c.newReverseProxy(ctx, user, groups, url, func(resp *http.Response) error {
+		c.logger.Info("Preview", "user", user, "groups", groups, "method", r.Method, "kind", kind, "namespace", namespace, "name", name, "port", port, "requestUrl", requestUrl, "responseCode", resp.StatusCode)
+		span.SetAttributes(attribute.Key("responseCode").Int(resp.StatusCode))
+		return rewritePreviewResponse(resp, c.restConfig.Host, apiPath, publicPath)
+	})
+	if err != nil {
+		c.logger.Error("Failed to create proxy", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, "Failed to create proxy", http.StatusBadGateway)
+		return
+	}
+
+	proxy.ServeHTTP(w, r)
+}
+
+// newReverseProxy returns a reverse proxy, which sends all requests to the
+// provided url using the transport and credentials from the rest config of the
+// client. The modify response function is called for each response of the
+// Kubernetes API server.
+func (c *client) newReverseProxy(ctx context.Context, user string, groups []string, url *url.URL, modifyResponse func(resp *http.Response) error) (*httputil.ReverseProxy, error) {
+	span := trace.SpanFromContext(ctx)
+
+	// Create round tripper for the request based on the Kubernetes rest config.
+	tlsConfig, err := rest.TLSConfigFor(c.restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tls config: %w", err)
+	}
+
+	tlsTransport := otelhttp.NewTransport(&http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	})
+
+	restTransportConfig, err := c.restConfig.TransportConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transporter config: %w", err)
+	}
+
+	clientRoundTripper, err := transport.HTTPWrappersForConfig(restTransportConfig, tlsTransport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create round tripper: %w", err)
+	}
+
+	// Create the reverse proxy and modify the request.
+	return &httputil.ReverseProxy{
+		Rewrite: func(preq *httputil.ProxyRequest) {
+			c.logger.Debug("IN HEADERS", "headers", preq.In.Header)
+			c.logger.Debug("OUT HEADERS BEFORE", "headers", preq.Out.Header)
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(preq.Out.Header))
+
+			preq.Out.URL = url
+
+			if preq.Out.Header == nil {
+				preq.Out.Header = make(http.Header)
+			}
+
+			// Always delete the impersonate user, uid and group headers, to ensure
+			// that privileges can not be escalated. Then set the impersonate user
+			// and group header if the feature is enabled and a user and list of
+			// groups was provided.
+			//
+			// NOTE: This also means that we do not support the "--as", "--as-uid"
+			// and "--as-group" flags of kubectl.
+			preq.Out.Header.Del("Impersonate-User")
+			preq.Out.Header.Del("Impersonate-Uid")
+			preq.Out.Header.Del("Impersonate-Group")
+
+			if user != "" {
+				preq.Out.Header.Add("Impersonate-User", user)
+			}
+
+			for _, group := range groups {
+				preq.Out.Header.Add("Impersonate-Group", group)
+			}
+		},
+		Transport:      clientRoundTripper,
+		FlushInterval:  -1,
+		ModifyResponse: modifyResponse,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			c.logger.Error("Client request failed", "error", err.Error())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+
+			http.Error(w, "Client request failed", http.StatusBadGateway)
+		},
+	}, nil
+}
+
+// GetMetricsCollector returns a Prometheus collector, which computes a core
+// set of kube-state-metrics metrics (pod phase, container restarts, deployment
+// replicas and node conditions) from the current state of the cluster. The
+// metrics are restricted to the allowed namespaces of the datasource.
+func (c *client) GetMetricsCollector() prometheus.Collector {
+	return &metricsCollector{
+		clientset:  c.clientset,
+		namespaces: c.namespaces,
+		logger:     c.logger,
+	}
+}
+
+// Close stops all background tasks of the client, like the recording of the
+// resource history.
+func (c *client) Close() {
+	c.cancel()
+
+	if c.history != nil {
+		if err := c.history.close(); err != nil {
+			c.logger.Error("Failed to close history store", "error", err.Error())
+		}
+	}
+}
+
+// NewClient creates a new Kubernetes client, which is used by the datasource to
+// interact with the Kubernetes cluster. To create a new Kubernetes client we
+// create a new "restConfig" using the "newRestConfig" function first. The rest
+// config is then used to create a new "clientset". The last step in the client
+// creation is to create a new "cache".
+func NewClient(ctx context.Context, config *models.PluginSettings, logger log.Logger) (Client, error) {
+	restConfig, err := newRestConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	restConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &client{
+		logger:          logger,
+		restConfig:      restConfig,
+		clientset:       clientset,
+		discoveryClient: discoveryClient,
+		stats:           newStatsStore(statsRetention),
+		execTimeout:     defaultExecTimeout,
+		downloadMaxSize: defaultDownloadMaxSize,
+		debugImages:     config.DebugImages,
+		previewPorts:    config.PreviewPorts,
+		subresources:    newSubresources(config),
+	}
+
+	client.policy, err = newPolicy(config.ProxyPolicy, config.ProxyPolicyRules)
+	if err != nil {
+		return nil, err
+	}
+
+	client.namespaces, err = newNamespaceRestriction(config.AllowedNamespaces, config.HideClusterScopedResources)
+	if err != nil {
+		return nil, err
+	}
+
+	client.execAllowlist, err = newExecAllowlist(config.ExecAllowlist)
+	if err != nil {
+		return nil, err
+	}
+	if config.ExecTimeout > 0 {
+		client.execTimeout = time.Duration(config.ExecTimeout) * time.Second
+	}
+	client.downloadAllowlist, err = newDownloadAllowlist(config.DownloadAllowlist)
+	if err != nil {
+		return nil, err
+	}
+	if config.DownloadMaxSize > 0 {
+		client.downloadMaxSize = config.DownloadMaxSize
+	}
+
+	// Use the "client" to get a map of all resources in the cluster. The map
+	// contains all default Kubernetes resources and CustomResourceDefinitions
+	// deployed in the cluster and is used to initialize a new cache.
+	resources, err := client.getResources(ctx)
+	if err != nil {
+		return nil, err
 	}
 	client.cache = NewCache(resources)
 
+	// If the history feature is enabled, we start to record the changes of the
+	// configured resources in the background. The recording is stopped when
+	// the "Close" method of the client is called.
+	var backgroundCtx context.Context
+	backgroundCtx, client.cancel = context.WithCancel(context.Background())
+
+	if config.History {
+		client.history, err = newHistoryStore(config.HistoryPath, time.Duration(config.HistoryRetention)*time.Second, int(config.HistoryMaxEntries))
+		if err != nil {
+			client.cancel()
+			return nil, err
+		}
+		client.historyIds = config.HistoryResourceIds
+
+		for _, resourceId := range client.historyIds {
+			go client.recordHistory(backgroundCtx, resourceId)
+		}
+		go client.pruneHistory(backgroundCtx)
+	}
+
+	// If the recording of sessions is enabled, all exec and attach sessions
+	// which are started via the Kubernetes server are recorded. Old
+	// recordings are removed in the background.
+	if config.Recordings {
+		client.recordings, err = newRecordingStore(config.RecordingsPath, time.Duration(config.RecordingsRetention)*time.Second)
+		if err != nil {
+			client.cancel()
+			return nil, err
+		}
+
+		if err := client.recordings.prune(time.Now()); err != nil {
+			client.logger.Error("Failed to prune recordings", "error", err.Error())
+		}
+		go client.pruneRecordings(backgroundCtx)
+	}
+
 	return client, nil
 }
 
@@ -11,11 +11,14 @@ package kubernetes
 
 import (
 	context "context"
+	io "io"
 	http "net/http"
 	reflect "reflect"
+	time "time"
 
 	backend "github.com/grafana/grafana-plugin-sdk-go/backend"
 	data "github.com/grafana/grafana-plugin-sdk-go/data"
+	prometheus "github.com/prometheus/client_golang/prometheus"
 	gomock "go.uber.org/mock/gomock"
 	rest "k8s.io/client-go/rest"
 )
@@ -44,6 +47,49 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 	return m.recorder
 }
 
+// ApplyResourceYAML mocks base method.
+func (m *MockClient) ApplyResourceYAML(ctx context.Context, user string, groups []string, resourceId, namespace, name string, manifest []byte, dryRun, force bool) (*ApplyResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApplyResourceYAML", ctx, user, groups, resourceId, namespace, name, manifest, dryRun, force)
+	ret0, _ := ret[0].(*ApplyResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ApplyResourceYAML indicates an expected call of ApplyResourceYAML.
+func (mr *MockClientMockRecorder) ApplyResourceYAML(ctx, user, groups, resourceId, namespace, name, manifest, dryRun, force any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyResourceYAML", reflect.TypeOf((*MockClient)(nil).ApplyResourceYAML), ctx, user, groups, resourceId, namespace, name, manifest, dryRun, force)
+}
+
+// AuthorizeNamespace mocks base method.
+func (m *MockClient) AuthorizeNamespace(namespace string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthorizeNamespace", namespace)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AuthorizeNamespace indicates an expected call of AuthorizeNamespace.
+func (mr *MockClientMockRecorder) AuthorizeNamespace(namespace any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthorizeNamespace", reflect.TypeOf((*MockClient)(nil).AuthorizeNamespace), namespace)
+}
+
+// AuthorizeSubresource mocks base method.
+func (m *MockClient) AuthorizeSubresource(method, requestUrl string, getTeams func() ([]string, error)) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthorizeSubresource", method, requestUrl, getTeams)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AuthorizeSubresource indicates an expected call of AuthorizeSubresource.
+func (mr *MockClientMockRecorder) AuthorizeSubresource(method, requestUrl, getTeams any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthorizeSubresource", reflect.TypeOf((*MockClient)(nil).AuthorizeSubresource), method, requestUrl, getTeams)
+}
+
 // CheckHealth mocks base method.
 func (m *MockClient) CheckHealth(ctx context.Context) error {
 	m.ctrl.T.Helper()
@@ -58,6 +104,63 @@ func (mr *MockClientMockRecorder) CheckHealth(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckHealth", reflect.TypeOf((*MockClient)(nil).CheckHealth), ctx)
 }
 
+// Close mocks base method.
+func (m *MockClient) Close() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Close")
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockClientMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockClient)(nil).Close))
+}
+
+// Debug mocks base method.
+func (m *MockClient) Debug(ctx context.Context, user string, groups []string, namespace, name string, options DebugOptions) (*DebugResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Debug", ctx, user, groups, namespace, name, options)
+	ret0, _ := ret[0].(*DebugResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Debug indicates an expected call of Debug.
+func (mr *MockClientMockRecorder) Debug(ctx, user, groups, namespace, name, options any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Debug", reflect.TypeOf((*MockClient)(nil).Debug), ctx, user, groups, namespace, name, options)
+}
+
+// Download mocks base method.
+func (m *MockClient) Download(ctx context.Context, user string, groups []string, namespace, name string, options DownloadOptions) (*Download, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Download", ctx, user, groups, namespace, name, options)
+	ret0, _ := ret[0].(*Download)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Download indicates an expected call of Download.
+func (mr *MockClientMockRecorder) Download(ctx, user, groups, namespace, name, options any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockClient)(nil).Download), ctx, user, groups, namespace, name, options)
+}
+
+// Exec mocks base method.
+func (m *MockClient) Exec(ctx context.Context, user string, groups []string, namespace, name string, options ExecOptions) (*ExecResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Exec", ctx, user, groups, namespace, name, options)
+	ret0, _ := ret[0].(*ExecResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Exec indicates an expected call of Exec.
+func (mr *MockClientMockRecorder) Exec(ctx, user, groups, namespace, name, options any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockClient)(nil).Exec), ctx, user, groups, namespace, name, options)
+}
+
 // GetContainers mocks base method.
 func (m *MockClient) GetContainers(ctx context.Context, user string, groups []string, resourceId, namespace, name string) (*data.Frame, error) {
 	m.ctrl.T.Helper()
@@ -73,6 +176,36 @@ func (mr *MockClientMockRecorder) GetContainers(ctx, user, groups, resourceId, n
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetContainers", reflect.TypeOf((*MockClient)(nil).GetContainers), ctx, user, groups, resourceId, namespace, name)
 }
 
+// GetEvents mocks base method.
+func (m *MockClient) GetEvents(ctx context.Context, user string, groups []string, namespace, eventType, filter string, groupBy []string, timeRange backend.TimeRange, interval time.Duration) ([]*data.Frame, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEvents", ctx, user, groups, namespace, eventType, filter, groupBy, timeRange, interval)
+	ret0, _ := ret[0].([]*data.Frame)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEvents indicates an expected call of GetEvents.
+func (mr *MockClientMockRecorder) GetEvents(ctx, user, groups, namespace, eventType, filter, groupBy, timeRange, interval any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEvents", reflect.TypeOf((*MockClient)(nil).GetEvents), ctx, user, groups, namespace, eventType, filter, groupBy, timeRange, interval)
+}
+
+// GetHistory mocks base method.
+func (m *MockClient) GetHistory(ctx context.Context, user string, groups []string, resourceId, namespace, filter, mode string, timeRange backend.TimeRange) (*data.Frame, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHistory", ctx, user, groups, resourceId, namespace, filter, mode, timeRange)
+	ret0, _ := ret[0].(*data.Frame)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHistory indicates an expected call of GetHistory.
+func (mr *MockClientMockRecorder) GetHistory(ctx, user, groups, resourceId, namespace, filter, mode, timeRange any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistory", reflect.TypeOf((*MockClient)(nil).GetHistory), ctx, user, groups, resourceId, namespace, filter, mode, timeRange)
+}
+
 // GetLogs mocks base method.
 func (m *MockClient) GetLogs(ctx context.Context, user string, groups []string, resourceId, namespace, name, container, filter string, tail int64, previous bool, timeRange backend.TimeRange) (*data.Frame, error) {
 	m.ctrl.T.Helper()
@@ -88,6 +221,35 @@ func (mr *MockClientMockRecorder) GetLogs(ctx, user, groups, resourceId, namespa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLogs", reflect.TypeOf((*MockClient)(nil).GetLogs), ctx, user, groups, resourceId, namespace, name, container, filter, tail, previous, timeRange)
 }
 
+// GetManagedFields mocks base method.
+func (m *MockClient) GetManagedFields(ctx context.Context, user string, groups []string, resourceId, namespace, name string) (*data.Frame, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetManagedFields", ctx, user, groups, resourceId, namespace, name)
+	ret0, _ := ret[0].(*data.Frame)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetManagedFields indicates an expected call of GetManagedFields.
+func (mr *MockClientMockRecorder) GetManagedFields(ctx, user, groups, resourceId, namespace, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetManagedFields", reflect.TypeOf((*MockClient)(nil).GetManagedFields), ctx, user, groups, resourceId, namespace, name)
+}
+
+// GetMetricsCollector mocks base method.
+func (m *MockClient) GetMetricsCollector() prometheus.Collector {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetricsCollector")
+	ret0, _ := ret[0].(prometheus.Collector)
+	return ret0
+}
+
+// GetMetricsCollector indicates an expected call of GetMetricsCollector.
+func (mr *MockClientMockRecorder) GetMetricsCollector() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetricsCollector", reflect.TypeOf((*MockClient)(nil).GetMetricsCollector))
+}
+
 // GetNamespaces mocks base method.
 func (m *MockClient) GetNamespaces(ctx context.Context) (*data.Frame, error) {
 	m.ctrl.T.Helper()
@@ -103,6 +265,21 @@ func (mr *MockClientMockRecorder) GetNamespaces(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNamespaces", reflect.TypeOf((*MockClient)(nil).GetNamespaces), ctx)
 }
 
+// GetRecording mocks base method.
+func (m *MockClient) GetRecording(ctx context.Context, id string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecording", ctx, id)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecording indicates an expected call of GetRecording.
+func (mr *MockClientMockRecorder) GetRecording(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecording", reflect.TypeOf((*MockClient)(nil).GetRecording), ctx, id)
+}
+
 // GetResource mocks base method.
 func (m *MockClient) GetResource(ctx context.Context, resourceId string) (*Resource, error) {
 	m.ctrl.T.Helper()
@@ -133,6 +310,21 @@ func (mr *MockClientMockRecorder) GetResourceIds(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetResourceIds", reflect.TypeOf((*MockClient)(nil).GetResourceIds), ctx)
 }
 
+// GetResourceYAML mocks base method.
+func (m *MockClient) GetResourceYAML(ctx context.Context, user string, groups []string, resourceId, namespace, name string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetResourceYAML", ctx, user, groups, resourceId, namespace, name)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetResourceYAML indicates an expected call of GetResourceYAML.
+func (mr *MockClientMockRecorder) GetResourceYAML(ctx, user, groups, resourceId, namespace, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetResourceYAML", reflect.TypeOf((*MockClient)(nil).GetResourceYAML), ctx, user, groups, resourceId, namespace, name)
+}
+
 // GetResources mocks base method.
 func (m *MockClient) GetResources(ctx context.Context, user string, groups []string, resourceId, namespace, parameterName, parameterValue string, wide bool) (*data.Frame, error) {
 	m.ctrl.T.Helper()
@@ -148,6 +340,78 @@ func (mr *MockClientMockRecorder) GetResources(ctx, user, groups, resourceId, na
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetResources", reflect.TypeOf((*MockClient)(nil).GetResources), ctx, user, groups, resourceId, namespace, parameterName, parameterValue, wide)
 }
 
+// GetRolloutHistory mocks base method.
+func (m *MockClient) GetRolloutHistory(ctx context.Context, user string, groups []string, namespace, name string) (*data.Frame, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRolloutHistory", ctx, user, groups, namespace, name)
+	ret0, _ := ret[0].(*data.Frame)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRolloutHistory indicates an expected call of GetRolloutHistory.
+func (mr *MockClientMockRecorder) GetRolloutHistory(ctx, user, groups, namespace, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRolloutHistory", reflect.TypeOf((*MockClient)(nil).GetRolloutHistory), ctx, user, groups, namespace, name)
+}
+
+// GetRolloutStatus mocks base method.
+func (m *MockClient) GetRolloutStatus(ctx context.Context, user string, groups []string, resourceId, namespace, filter string) ([]*data.Frame, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRolloutStatus", ctx, user, groups, resourceId, namespace, filter)
+	ret0, _ := ret[0].([]*data.Frame)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRolloutStatus indicates an expected call of GetRolloutStatus.
+func (mr *MockClientMockRecorder) GetRolloutStatus(ctx, user, groups, resourceId, namespace, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRolloutStatus", reflect.TypeOf((*MockClient)(nil).GetRolloutStatus), ctx, user, groups, resourceId, namespace, filter)
+}
+
+// GetStats mocks base method.
+func (m *MockClient) GetStats(ctx context.Context, user string, groups []string, node, namespace, filter, level, metric string, timeRange backend.TimeRange) ([]*data.Frame, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetStats", ctx, user, groups, node, namespace, filter, level, metric, timeRange)
+	ret0, _ := ret[0].([]*data.Frame)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetStats indicates an expected call of GetStats.
+func (mr *MockClientMockRecorder) GetStats(ctx, user, groups, node, namespace, filter, level, metric, timeRange any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStats", reflect.TypeOf((*MockClient)(nil).GetStats), ctx, user, groups, node, namespace, filter, level, metric, timeRange)
+}
+
+// ListRecordings mocks base method.
+func (m *MockClient) ListRecordings(ctx context.Context) ([]Recording, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRecordings", ctx)
+	ret0, _ := ret[0].([]Recording)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRecordings indicates an expected call of ListRecordings.
+func (mr *MockClientMockRecorder) ListRecordings(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRecordings", reflect.TypeOf((*MockClient)(nil).ListRecordings), ctx)
+}
+
+// Preview mocks base method.
+func (m *MockClient) Preview(user string, groups []string, kind, namespace, name, port, requestUrl, publicPath string, w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Preview", user, groups, kind, namespace, name, port, requestUrl, publicPath, w, r)
+}
+
+// Preview indicates an expected call of Preview.
+func (mr *MockClientMockRecorder) Preview(user, groups, kind, namespace, name, port, requestUrl, publicPath, w, r any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Preview", reflect.TypeOf((*MockClient)(nil).Preview), user, groups, kind, namespace, name, port, requestUrl, publicPath, w, r)
+}
+
 // Proxy mocks base method.
 func (m *MockClient) Proxy(user string, groups []string, requestUrl string, w http.ResponseWriter, r *http.Request) {
 	m.ctrl.T.Helper()
@@ -160,6 +424,21 @@ func (mr *MockClientMockRecorder) Proxy(user, groups, requestUrl, w, r any) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Proxy", reflect.TypeOf((*MockClient)(nil).Proxy), user, groups, requestUrl, w, r)
 }
 
+// RecordSession mocks base method.
+func (m *MockClient) RecordSession(method, requestUrl string, getUser func() (string, error), w http.ResponseWriter) (http.ResponseWriter, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordSession", method, requestUrl, getUser, w)
+	ret0, _ := ret[0].(http.ResponseWriter)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecordSession indicates an expected call of RecordSession.
+func (mr *MockClientMockRecorder) RecordSession(method, requestUrl, getUser, w any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordSession", reflect.TypeOf((*MockClient)(nil).RecordSession), method, requestUrl, getUser, w)
+}
+
 // RestConfig mocks base method.
 func (m *MockClient) RestConfig() rest.Config {
 	m.ctrl.T.Helper()
@@ -174,6 +453,66 @@ func (mr *MockClientMockRecorder) RestConfig() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestConfig", reflect.TypeOf((*MockClient)(nil).RestConfig))
 }
 
+// RollbackDeployment mocks base method.
+func (m *MockClient) RollbackDeployment(ctx context.Context, user string, groups []string, namespace, name string, revision int64, options DeploymentRollbackOptions) (*DeploymentRollbackResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RollbackDeployment", ctx, user, groups, namespace, name, revision, options)
+	ret0, _ := ret[0].(*DeploymentRollbackResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RollbackDeployment indicates an expected call of RollbackDeployment.
+func (mr *MockClientMockRecorder) RollbackDeployment(ctx, user, groups, namespace, name, revision, options any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RollbackDeployment", reflect.TypeOf((*MockClient)(nil).RollbackDeployment), ctx, user, groups, namespace, name, revision, options)
+}
+
+// RunJob mocks base method.
+func (m *MockClient) RunJob(ctx context.Context, user string, groups []string, resourceId, namespace, name string, options JobRunOptions) (*JobRunResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RunJob", ctx, user, groups, resourceId, namespace, name, options)
+	ret0, _ := ret[0].(*JobRunResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RunJob indicates an expected call of RunJob.
+func (mr *MockClientMockRecorder) RunJob(ctx, user, groups, resourceId, namespace, name, options any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunJob", reflect.TypeOf((*MockClient)(nil).RunJob), ctx, user, groups, resourceId, namespace, name, options)
+}
+
+// RunNodeAction mocks base method.
+func (m *MockClient) RunNodeAction(ctx context.Context, user string, groups []string, name, action string, options NodeDrainOptions) (*NodeActionResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RunNodeAction", ctx, user, groups, name, action, options)
+	ret0, _ := ret[0].(*NodeActionResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RunNodeAction indicates an expected call of RunNodeAction.
+func (mr *MockClientMockRecorder) RunNodeAction(ctx, user, groups, name, action, options any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunNodeAction", reflect.TypeOf((*MockClient)(nil).RunNodeAction), ctx, user, groups, name, action, options)
+}
+
+// RunWorkloadAction mocks base method.
+func (m *MockClient) RunWorkloadAction(ctx context.Context, user string, groups []string, resourceId, namespace, name, action string, options WorkloadActionOptions) (*RolloutState, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RunWorkloadAction", ctx, user, groups, resourceId, namespace, name, action, options)
+	ret0, _ := ret[0].(*RolloutState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RunWorkloadAction indicates an expected call of RunWorkloadAction.
+func (mr *MockClientMockRecorder) RunWorkloadAction(ctx, user, groups, resourceId, namespace, name, action, options any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunWorkloadAction", reflect.TypeOf((*MockClient)(nil).RunWorkloadAction), ctx, user, groups, resourceId, namespace, name, action, options)
+}
+
 // StreamLogs mocks base method.
 func (m *MockClient) StreamLogs(ctx context.Context, user string, groups []string, resourceId, namespace, name, container, filter string, sender *backend.StreamSender) error {
 	m.ctrl.T.Helper()
@@ -187,3 +526,31 @@ func (mr *MockClientMockRecorder) StreamLogs(ctx, user, groups, resourceId, name
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamLogs", reflect.TypeOf((*MockClient)(nil).StreamLogs), ctx, user, groups, resourceId, namespace, name, container, filter, sender)
 }
+
+// StreamMetrics mocks base method.
+func (m *MockClient) StreamMetrics(ctx context.Context, user string, groups []string, resourceId, namespace, name string, containers bool, interval time.Duration, sender *backend.StreamSender) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StreamMetrics", ctx, user, groups, resourceId, namespace, name, containers, interval, sender)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StreamMetrics indicates an expected call of StreamMetrics.
+func (mr *MockClientMockRecorder) StreamMetrics(ctx, user, groups, resourceId, namespace, name, containers, interval, sender any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamMetrics", reflect.TypeOf((*MockClient)(nil).StreamMetrics), ctx, user, groups, resourceId, namespace, name, containers, interval, sender)
+}
+
+// StreamResources mocks base method.
+func (m *MockClient) StreamResources(ctx context.Context, user string, groups []string, resourceId, namespace, parameterName, parameterValue string, wide bool, sender *backend.StreamSender) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StreamResources", ctx, user, groups, resourceId, namespace, parameterName, parameterValue, wide, sender)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StreamResources indicates an expected call of StreamResources.
+func (mr *MockClientMockRecorder) StreamResources(ctx, user, groups, resourceId, namespace, parameterName, parameterValue, wide, sender any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamResources", reflect.TypeOf((*MockClient)(nil).StreamResources), ctx, user, groups, resourceId, namespace, parameterName, parameterValue, wide, sender)
+}
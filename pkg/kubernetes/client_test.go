@@ -20,6 +20,7 @@ import (
 	"github.com/testcontainers/testcontainers-go/wait"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
@@ -308,3 +309,64 @@ func TestProxy(t *testing.T) {
 		defer res.Body.Close()
 	})
 }
+
+type testPacketSender struct {
+	frames []*data.Frame
+	cancel context.CancelFunc
+}
+
+func (s *testPacketSender) Send(packet *backend.StreamPacket) error {
+	var frame data.Frame
+	if err := json.Unmarshal(packet.Data, &frame); err != nil {
+		return err
+	}
+
+	s.frames = append(s.frames, &frame)
+	s.cancel()
+	return nil
+}
+
+func TestStreamMetrics(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/apis/metrics.k8s.io/v1beta1/namespaces/default/pods", r.URL.Path)
+		require.Equal(t, "testuser", r.Header.Get("Impersonate-User"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items":[{"metadata":{"name":"echoserver","namespace":"default"},"containers":[{"name":"echoserver","usage":{"cpu":"250m","memory":"64Mi"}}]}]}`))
+	}))
+	defer testServer.Close()
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: testServer.URL})
+	require.NoError(t, err)
+
+	c := &client{
+		logger:    log.DefaultLogger,
+		clientset: clientset,
+		cache: NewCache(map[string]Resource{
+			"podmetrics.metrics.k8s.io": {ID: "podmetrics.metrics.k8s.io", Kind: "PodMetrics", APIVersion: "metrics.k8s.io/v1beta1", Name: "pods", Path: "/apis/metrics.k8s.io/v1beta1", Namespaced: true},
+		}),
+	}
+
+	t.Run("should send metrics frame", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		packetSender := &testPacketSender{cancel: cancel}
+		err := c.StreamMetrics(ctx, "testuser", nil, "podmetrics.metrics.k8s.io", "default", "", true, time.Second, backend.NewStreamSender(packetSender))
+		require.NoError(t, err)
+		require.Len(t, packetSender.frames, 1)
+
+		frame := packetSender.frames[0]
+		require.Equal(t, "PodMetrics", frame.Name)
+		require.Equal(t, 1, frame.Rows())
+		require.Len(t, frame.Fields, 6)
+		require.Equal(t, "container", frame.Fields[3].Name)
+		require.Equal(t, "echoserver", frame.Fields[3].At(0))
+		require.Equal(t, 0.25, frame.Fields[4].At(0))
+	})
+
+	t.Run("should fail for other resources", func(t *testing.T) {
+		err := c.StreamMetrics(context.Background(), "testuser", nil, "pod", "default", "", false, time.Second, backend.NewStreamSender(&testPacketSender{}))
+		require.Error(t, err)
+	})
+}
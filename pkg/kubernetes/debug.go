@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+// debugContainerTimeout is the maximum time we wait until an ephemeral debug
+// container is running.
+const debugContainerTimeout = 2 * time.Minute
+
+var ephemeralContainersResource = schema.GroupResource{Resource: "pods/ephemeralcontainers"}
+
+// DebugOptions are the options to add an ephemeral debug container to a pod.
+// If no image is set, the first allowed image is used. If a target container
+// is set, the debug container shares the process namespace of the target
+// container.
+type DebugOptions struct {
+	Image           string   `json:"image"`
+	TargetContainer string   `json:"targetContainer"`
+	Command         []string `json:"command"`
+}
+
+// DebugResult is the result of adding an ephemeral debug container to a pod.
+// The name can be used to attach to the container.
+type DebugResult struct {
+	Name            string `json:"name"`
+	Image           string `json:"image"`
+	TargetContainer string `json:"targetContainer,omitempty"`
+}
+
+// newDebugContainer returns a new ephemeral container for the provided pod.
+// The container is created with stdin and a TTY, so that it can be attached
+// like a container created by "kubectl debug -it".
+func newDebugContainer(pod corev1.Pod, allowedImages []string, options DebugOptions) (*corev1.EphemeralContainer, error) {
+	if len(allowedImages) == 0 {
+		return nil, apierrors.NewForbidden(ephemeralContainersResource, pod.Name, fmt.Errorf("debug containers are disabled, because no images are allowed"))
+	}
+
+	image := options.Image
+	if image == "" {
+		image = allowedImages[0]
+	}
+	if !slices.Contains(allowedImages, image) {
+		return nil, apierrors.NewForbidden(ephemeralContainersResource, pod.Name, fmt.Errorf("image %s is not allowed", image))
+	}
+
+	var names []string
+	for _, container := range pod.Spec.InitContainers {
+		names = append(names, container.Name)
+	}
+	for _, container := range pod.Spec.Containers {
+		names = append(names, container.Name)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		names = append(names, container.Name)
+	}
+
+	if options.TargetContainer != "" && !slices.ContainsFunc(pod.Spec.Containers, func(container corev1.Container) bool { return container.Name == options.TargetContainer }) {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("container %s not found in pod %s", options.TargetContainer, pod.Name))
+	}
+
+	name := "debugger-" + utilrand.String(5)
+	for slices.Contains(names, name) {
+		name = "debugger-" + utilrand.String(5)
+	}
+
+	return &corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    image,
+			Command:                  options.Command,
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			Stdin:                    true,
+			TTY:                      true,
+			TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+		},
+		TargetContainerName: options.TargetContainer,
+	}, nil
+}
+
+// newDebugContainerPatch returns the strategic merge patch to add the provided
+// ephemeral container via the "ephemeralcontainers" subresource.
+func newDebugContainerPatch(container *corev1.EphemeralContainer) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"ephemeralContainers": []corev1.EphemeralContainer{*container},
+		},
+	})
+}
+
+// debugContainerRunning returns true if the ephemeral container with the
+// provided name is running. If the container was terminated or can not be
+// started, because the image can not be pulled, an error is returned.
+func debugContainerRunning(pod corev1.Pod, name string) (bool, error) {
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		if status.Name != name {
+			continue
+		}
+
+		switch {
+		case status.State.Running != nil:
+			return true, nil
+		case status.State.Terminated != nil:
+			return false, fmt.Errorf("debug container %s terminated: %s %s", name, status.State.Terminated.Reason, status.State.Terminated.Message)
+		case status.State.Waiting != nil:
+			switch status.State.Waiting.Reason {
+			case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerError", "CreateContainerConfigError":
+				return false, fmt.Errorf("debug container %s can not be started: %s %s", name, status.State.Waiting.Reason, status.State.Waiting.Message)
+			}
+		}
+	}
+
+	return false, nil
+}
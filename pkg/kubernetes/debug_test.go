@@ -0,0 +1,67 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewDebugContainer(t *testing.T) {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "echoserver", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "echoserver"}}},
+	}
+	allowedImages := []string{"busybox:1.36", "nicolaka/netshoot:latest"}
+
+	t.Run("should use default image", func(t *testing.T) {
+		container, err := newDebugContainer(pod, allowedImages, DebugOptions{TargetContainer: "echoserver"})
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(container.Name, "debugger-"))
+		require.Equal(t, "busybox:1.36", container.Image)
+		require.Equal(t, "echoserver", container.TargetContainerName)
+		require.True(t, container.Stdin)
+		require.True(t, container.TTY)
+
+		patch, err := newDebugContainerPatch(container)
+		require.NoError(t, err)
+		require.Contains(t, string(patch), `{"spec":{"ephemeralContainers":[{"name":"`+container.Name+`","image":"busybox:1.36"`)
+	})
+
+	t.Run("should fail for not allowed image", func(t *testing.T) {
+		_, err := newDebugContainer(pod, allowedImages, DebugOptions{Image: "alpine:latest"})
+		require.True(t, apierrors.IsForbidden(err))
+
+		_, err = newDebugContainer(pod, nil, DebugOptions{})
+		require.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("should fail for unknown target container", func(t *testing.T) {
+		_, err := newDebugContainer(pod, allowedImages, DebugOptions{TargetContainer: "nginx"})
+		require.True(t, apierrors.IsBadRequest(err))
+	})
+}
+
+func TestDebugContainerRunning(t *testing.T) {
+	newPod := func(state corev1.ContainerState) corev1.Pod {
+		return corev1.Pod{Status: corev1.PodStatus{EphemeralContainerStatuses: []corev1.ContainerStatus{{Name: "debugger-abcde", State: state}}}}
+	}
+
+	running, err := debugContainerRunning(newPod(corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}), "debugger-abcde")
+	require.NoError(t, err)
+	require.True(t, running)
+
+	running, err = debugContainerRunning(newPod(corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}), "debugger-abcde")
+	require.NoError(t, err)
+	require.False(t, running)
+
+	_, err = debugContainerRunning(newPod(corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}), "debugger-abcde")
+	require.Error(t, err)
+
+	running, err = debugContainerRunning(corev1.Pod{}, "debugger-abcde")
+	require.NoError(t, err)
+	require.False(t, running)
+}
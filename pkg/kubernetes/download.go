@@ -0,0 +1,194 @@
+package kubernetes
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+const (
+	DownloadFormatTar  = "tar"
+	DownloadFormatFile = "file"
+)
+
+const defaultDownloadMaxSize = 100 * 1024 * 1024
+
+// DownloadOptions are the options to download a file or directory from a
+// container. The format can be "tar" (default) to download the file or
+// directory as tar archive or "file" to download a single file.
+type DownloadOptions struct {
+	Container string `json:"container"`
+	Path      string `json:"path"`
+	Format    string `json:"format"`
+}
+
+// Download is a file or directory which is streamed from a container. The
+// caller must close the download, to stop the command in the container.
+type Download struct {
+	Filename    string
+	ContentType string
+	reader      io.Reader
+	close       func() error
+}
+
+func (d *Download) Read(p []byte) (int, error) {
+	return d.reader.Read(p)
+}
+
+func (d *Download) Close() error {
+	return d.close()
+}
+
+// newDownloadAllowlist compiles the provided patterns. Each pattern must match
+// the whole cleaned path of a file or directory, e.g. "/tmp/dumps/.*".
+func newDownloadAllowlist(patterns []string) ([]*regexp.Regexp, error) {
+	var allowlist []*regexp.Regexp
+
+	for _, pattern := range patterns {
+		r, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid download allowlist pattern %q: %w", pattern, err)
+		}
+		allowlist = append(allowlist, r)
+	}
+
+	return allowlist, nil
+}
+
+// isDownloadAllowed returns true if the cleaned path matches one of the
+// patterns in the allowlist. The path is cleaned first, so that a path like
+// "/tmp/dumps/../../etc/shadow" can not be used to bypass the allowlist. If
+// the allowlist is empty, no downloads are allowed.
+func isDownloadAllowed(allowlist []*regexp.Regexp, filePath string) bool {
+	if !path.IsAbs(filePath) {
+		return false
+	}
+
+	filePath = path.Clean(filePath)
+	for _, r := range allowlist {
+		if r.MatchString(filePath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newDownloadCommand returns the tar command to archive the provided path, in
+// the same way as it is done by "kubectl cp". The archive contains the path
+// relative to its parent directory.
+func newDownloadCommand(filePath string) ([]string, error) {
+	if !path.IsAbs(filePath) {
+		return nil, fmt.Errorf("path %q must be absolute", filePath)
+	}
+
+	filePath = path.Clean(filePath)
+	if filePath == "/" {
+		return []string{"tar", "cf", "-", "-C", "/", "."}, nil
+	}
+
+	return []string{"tar", "cf", "-", "-C", path.Dir(filePath), path.Base(filePath)}, nil
+}
+
+// newDownloadError returns an error for a failed tar command. If the
+// container doesn't contain the tar binary, we return a clear error message,
+// because this is the most common reason why a download fails.
+func newDownloadError(err error, stderr string) error {
+	stderr = strings.TrimSpace(stderr)
+
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.ExitStatus() == 126 || exitErr.ExitStatus() == 127 {
+			return fmt.Errorf("tar is not available in the container, but it is required to download files")
+		}
+		if stderr != "" {
+			return fmt.Errorf("tar failed with exit code %d: %s", exitErr.ExitStatus(), stderr)
+		}
+		return fmt.Errorf("tar failed with exit code %d", exitErr.ExitStatus())
+	}
+
+	if strings.Contains(err.Error(), "executable file not found") || strings.Contains(err.Error(), `"tar": no such file or directory`) {
+		return fmt.Errorf("tar is not available in the container, but it is required to download files")
+	}
+
+	return err
+}
+
+// maxSizeReader is a reader which returns an error, when more than the
+// maximum number of bytes are read.
+type maxSizeReader struct {
+	reader    io.Reader
+	maxSize   int64
+	remaining int64
+}
+
+func newMaxSizeReader(reader io.Reader, maxSize int64) *maxSizeReader {
+	return &maxSizeReader{reader: reader, maxSize: maxSize, remaining: maxSize}
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		// Check if there is more data, so that a download with exactly the
+		// maximum size doesn't fail.
+		var b [1]byte
+		if n, err := r.reader.Read(b[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("download exceeds the maximum size of %d bytes", r.maxSize)
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// newDownloadReader returns the reader, filename and content type for the
+// provided tar stream. For the "tar" format the tar stream is returned as it
+// is. For the "file" format the first entry of the tar stream is read and
+// must be a regular file, which content is returned.
+func newDownloadReader(stream io.Reader, filePath, format string, maxSize int64) (io.Reader, string, string, error) {
+	filename := path.Base(path.Clean(filePath))
+	if filename == "/" {
+		filename = "root"
+	}
+
+	switch format {
+	case "", DownloadFormatTar:
+		reader := bufio.NewReader(newMaxSizeReader(stream, maxSize))
+		// Peek the first byte of the stream, so that we can return an error
+		// before the download is started, e.g. when tar is not available.
+		if _, err := reader.Peek(1); err != nil {
+			return nil, "", "", err
+		}
+		return reader, filename + ".tar", "application/x-tar", nil
+	case DownloadFormatFile:
+		tarReader := tar.NewReader(newMaxSizeReader(stream, maxSize))
+		header, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, "", "", fmt.Errorf("path %q not found", filePath)
+			}
+			return nil, "", "", err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, "", "", fmt.Errorf("path %q is not a regular file, use the tar format to download directories", filePath)
+		}
+		if header.Size > maxSize {
+			return nil, "", "", fmt.Errorf("file exceeds the maximum size of %d bytes", maxSize)
+		}
+		return tarReader, filename, "application/octet-stream", nil
+	default:
+		return nil, "", "", fmt.Errorf("format %s is not supported", format)
+	}
+}
@@ -0,0 +1,121 @@
+package kubernetes
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+func newTestTar(t *testing.T, headers ...tar.Header) []byte {
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+
+	for _, header := range headers {
+		require.NoError(t, writer.WriteHeader(&header))
+		if header.Typeflag == tar.TypeReg {
+			_, err := writer.Write(bytes.Repeat([]byte("a"), int(header.Size)))
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func TestIsDownloadAllowed(t *testing.T) {
+	allowlist, err := newDownloadAllowlist([]string{"/tmp/dumps/.*", "/etc/config"})
+	require.NoError(t, err)
+
+	require.True(t, isDownloadAllowed(allowlist, "/tmp/dumps/heap.hprof"))
+	require.True(t, isDownloadAllowed(allowlist, "/etc/config/"))
+	require.False(t, isDownloadAllowed(allowlist, "/etc/config/app.yaml"))
+	require.False(t, isDownloadAllowed(allowlist, "/tmp/dumps/../../etc/shadow"))
+	require.False(t, isDownloadAllowed(allowlist, "tmp/dumps/heap.hprof"))
+	require.False(t, isDownloadAllowed(nil, "/tmp/dumps/heap.hprof"))
+
+	_, err = newDownloadAllowlist([]string{"/tmp/("})
+	require.Error(t, err)
+}
+
+func TestNewDownloadCommand(t *testing.T) {
+	command, err := newDownloadCommand("/tmp/dumps/heap.hprof")
+	require.NoError(t, err)
+	require.Equal(t, []string{"tar", "cf", "-", "-C", "/tmp/dumps", "heap.hprof"}, command)
+
+	command, err = newDownloadCommand("/etc/config/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"tar", "cf", "-", "-C", "/etc", "config"}, command)
+
+	_, err = newDownloadCommand("etc/config")
+	require.Error(t, err)
+}
+
+func TestNewDownloadError(t *testing.T) {
+	err := newDownloadError(utilexec.CodeExitError{Err: errors.New("command terminated with exit code 127"), Code: 127}, "")
+	require.ErrorContains(t, err, "tar is not available in the container")
+
+	err = newDownloadError(errors.New(`exec: "tar": executable file not found in $PATH`), "")
+	require.ErrorContains(t, err, "tar is not available in the container")
+
+	err = newDownloadError(utilexec.CodeExitError{Err: errors.New("command terminated with exit code 2"), Code: 2}, "tar: heap.hprof: No such file or directory\n")
+	require.EqualError(t, err, "tar failed with exit code 2: tar: heap.hprof: No such file or directory")
+}
+
+func TestNewDownloadReader(t *testing.T) {
+	t.Run("should return tar stream", func(t *testing.T) {
+		archive := newTestTar(t, tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755}, tar.Header{Name: "config/app.yaml", Typeflag: tar.TypeReg, Mode: 0644, Size: 10})
+
+		reader, filename, contentType, err := newDownloadReader(bytes.NewReader(archive), "/etc/config", DownloadFormatTar, 1024*1024)
+		require.NoError(t, err)
+		require.Equal(t, "config.tar", filename)
+		require.Equal(t, "application/x-tar", contentType)
+
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, archive, content)
+	})
+
+	t.Run("should return single file", func(t *testing.T) {
+		archive := newTestTar(t, tar.Header{Name: "heap.hprof", Typeflag: tar.TypeReg, Mode: 0644, Size: 10})
+
+		reader, filename, _, err := newDownloadReader(bytes.NewReader(archive), "/tmp/heap.hprof", DownloadFormatFile, 1024*1024)
+		require.NoError(t, err)
+		require.Equal(t, "heap.hprof", filename)
+
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, "aaaaaaaaaa", string(content))
+	})
+
+	t.Run("should fail for directory in file format", func(t *testing.T) {
+		archive := newTestTar(t, tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0755})
+
+		_, _, _, err := newDownloadReader(bytes.NewReader(archive), "/etc/config", DownloadFormatFile, 1024*1024)
+		require.ErrorContains(t, err, "is not a regular file")
+	})
+
+	t.Run("should fail for too large file", func(t *testing.T) {
+		archive := newTestTar(t, tar.Header{Name: "heap.hprof", Typeflag: tar.TypeReg, Mode: 0644, Size: 2048})
+
+		_, _, _, err := newDownloadReader(bytes.NewReader(archive), "/tmp/heap.hprof", DownloadFormatFile, 1024)
+		require.Error(t, err)
+
+		reader, _, _, err := newDownloadReader(bytes.NewReader(archive), "/tmp/heap.hprof", DownloadFormatTar, 1024)
+		require.NoError(t, err)
+		_, err = io.ReadAll(reader)
+		require.ErrorContains(t, err, "download exceeds the maximum size of 1024 bytes")
+	})
+
+	t.Run("should return error of stream", func(t *testing.T) {
+		reader, writer := io.Pipe()
+		writer.CloseWithError(errors.New("tar is not available in the container"))
+
+		_, _, _, err := newDownloadReader(reader, "/tmp/heap.hprof", DownloadFormatTar, 1024)
+		require.ErrorContains(t, err, "tar is not available in the container")
+	})
+}
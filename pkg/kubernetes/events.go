@@ -0,0 +1,232 @@
+package kubernetes
+
+import (
+	"fmt"
+	"math/bits"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	EventsGroupByReason    = "reason"
+	EventsGroupByType      = "type"
+	EventsGroupByNamespace = "namespace"
+	EventsGroupByKind      = "kind"
+)
+
+// eventOccurrences returns the time of the first and last occurrence and the
+// number of occurrences of the provided event.
+//
+// Events created via the "events.k8s.io" API are using the "eventTime" and
+// "series" fields, while events created via the core API are using the
+// "firstTimestamp", "lastTimestamp" and "count" fields. If none of the fields
+// is set, we use the creation time of the event.
+func eventOccurrences(event corev1.Event) (time.Time, time.Time, int) {
+	if event.Series != nil {
+		first := event.EventTime.Time
+		if first.IsZero() {
+			first = event.FirstTimestamp.Time
+		}
+		last := event.Series.LastObservedTime.Time
+		if first.IsZero() || last.Before(first) {
+			first = last
+		}
+		return first, last, max(int(event.Series.Count), 1)
+	}
+
+	first := event.FirstTimestamp.Time
+	if first.IsZero() {
+		first = event.EventTime.Time
+	}
+	if first.IsZero() {
+		first = event.CreationTimestamp.Time
+	}
+
+	last := event.LastTimestamp.Time
+	if last.IsZero() || last.Before(first) {
+		last = first
+	}
+
+	return first, last, max(int(event.Count), 1)
+}
+
+// eventOccurrencesBefore returns the number of occurrences of an event before
+// the provided time, when the occurrences are distributed evenly between the
+// first and last occurrence. A single occurrence is at the last occurrence,
+// otherwise the occurrence i is at "first + (last - first) * i / (count - 1)",
+// so that the number of occurrences before t is
+// "ceil((t - first) * (count - 1) / (last - first))". The product is computed with 128 bits, so that it can not overflow for long
+// running events with a large number of occurrences.
+func eventOccurrencesBefore(first, last time.Time, count int, t time.Time) int {
+	if t.After(last) {
+		return count
+	}
+	if count == 1 || !t.After(first) {
+		return 0
+	}
+
+	offset := uint64(t.Sub(first))
+	duration := uint64(last.Sub(first))
+
+	hi, lo := bits.Mul64(offset, uint64(count-1))
+	quotient, remainder := bits.Div64(hi, lo, duration)
+	if remainder > 0 {
+		quotient++
+	}
+
+	return int(quotient)
+}
+
+func latestTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earliestTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// eventGroupLabels returns the labels of the provided event for the provided
+// group by fields.
+func eventGroupLabels(event corev1.Event, groupBy []string) data.Labels {
+	labels := data.Labels{}
+
+	for _, g := range groupBy {
+		switch g {
+		case EventsGroupByReason:
+			labels[g] = event.Reason
+		case EventsGroupByType:
+			labels[g] = event.Type
+		case EventsGroupByNamespace:
+			labels[g] = event.Namespace
+		case EventsGroupByKind:
+			labels[g] = event.InvolvedObject.Kind
+		}
+	}
+
+	return labels
+}
+
+// createEventsDataFrames aggregates the provided events into time series with
+// the number of occurrences per interval, grouped by the provided fields. If
+// an event type is provided, only events of this type are used. If a filter is
+// provided, only events where the reason matches the filter are used.
+//
+// An event can be a series of occurrences, where we only know the first and
+// last occurrence and the number of occurrences. In this case we distribute the
+// occurrences evenly between the first and last occurrence.
+//
+// All series contain a value for each interval in the time range, so that
+// intervals without events are returned as 0.
+func createEventsDataFrames(events []corev1.Event, eventType string, filter *regexp.Regexp, groupBy []string, timeRange backend.TimeRange, interval time.Duration) []*data.Frame {
+	from := timeRange.From.Truncate(interval)
+	buckets := int(timeRange.To.Sub(from)/interval) + 1
+
+	series := make(map[string][]float64)
+	seriesLabels := make(map[string]data.Labels)
+
+	for _, event := range events {
+		if eventType != "" && event.Type != eventType {
+			continue
+		}
+		if filter != nil && !filter.MatchString(event.Reason) {
+			continue
+		}
+
+		first, last, count := eventOccurrences(event)
+		if last.Before(timeRange.From) || first.After(timeRange.To) {
+			continue
+		}
+
+		labels := eventGroupLabels(event, groupBy)
+		key := labels.String()
+		if _, ok := series[key]; !ok {
+			series[key] = make([]float64, buckets)
+			seriesLabels[key] = labels
+		}
+
+		// Instead of looping over all occurrences, which can be hundreds of
+		// thousands for a crash looping container, we add the number of
+		// occurrences within each bucket between the first and last
+		// occurrence, so that the costs only depend on the number of buckets.
+		start := latestTime(first, timeRange.From)
+		end := earliestTime(last, timeRange.To)
+		for i := int(start.Sub(from) / interval); i <= int(end.Sub(from)/interval); i++ {
+			bucketStart := latestTime(from.Add(time.Duration(i)*interval), timeRange.From)
+			bucketEnd := earliestTime(from.Add(time.Duration(i+1)*interval), timeRange.To.Add(1))
+
+			series[key][i] += float64(eventOccurrencesBefore(first, last, count, bucketEnd) - eventOccurrencesBefore(first, last, count, bucketStart))
+		}
+	}
+
+	times := make([]time.Time, buckets)
+	for i := range times {
+		times[i] = from.Add(time.Duration(i) * interval)
+	}
+
+	var frames []*data.Frame
+
+	for key, values := range series {
+		labels := seriesLabels[key]
+
+		var names []string
+		for _, g := range groupBy {
+			if _, ok := labels[g]; ok {
+				names = append(names, labels[g])
+			}
+		}
+		name := strings.Join(names, "/")
+		if name == "" {
+			name = "events"
+		}
+
+		valueField := data.NewField("count", labels, values)
+		valueField.SetConfig(&data.FieldConfig{
+			DisplayNameFromDS: name,
+			Unit:              "short",
+		})
+
+		frame := data.NewFrame(
+			name,
+			data.NewField("time", nil, slices.Clone(times)),
+			valueField,
+		)
+
+		frame.SetMeta(&data.FrameMeta{
+			PreferredVisualization: data.VisTypeGraph,
+			Type:                   data.FrameTypeTimeSeriesMulti,
+		})
+
+		frames = append(frames, frame)
+	}
+
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].Name < frames[j].Name
+	})
+
+	return frames
+}
+
+// validateEventsGroupBy returns an error if one of the provided group by fields
+// is not supported.
+func validateEventsGroupBy(groupBy []string) error {
+	for _, g := range groupBy {
+		if !slices.Contains([]string{EventsGroupByReason, EventsGroupByType, EventsGroupByNamespace, EventsGroupByKind}, g) {
+			return fmt.Errorf("group by %s is not supported", g)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package kubernetes
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCreateEventsDataFrames(t *testing.T) {
+	from := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	timeRange := backend.TimeRange{From: from, To: from.Add(5 * time.Minute)}
+
+	events := []corev1.Event{
+		{
+			ObjectMeta:     metav1.ObjectMeta{Name: "echoserver.1", Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod"},
+			Reason:         "BackOff",
+			Type:           "Warning",
+			FirstTimestamp: metav1.NewTime(from.Add(30 * time.Second)),
+			LastTimestamp:  metav1.NewTime(from.Add(150 * time.Second)),
+			Count:          3,
+		},
+		{
+			ObjectMeta:     metav1.ObjectMeta{Name: "echoserver.2", Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod"},
+			Reason:         "OOMKilling",
+			Type:           "Warning",
+			EventTime:      metav1.NewMicroTime(from.Add(4 * time.Minute)),
+			Series:         &corev1.EventSeries{Count: 2, LastObservedTime: metav1.NewMicroTime(from.Add(4*time.Minute + 30*time.Second))},
+		},
+		{
+			ObjectMeta:     metav1.ObjectMeta{Name: "echoserver.3", Namespace: "kube-system"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod"},
+			Reason:         "Scheduled",
+			Type:           "Normal",
+			FirstTimestamp: metav1.NewTime(from.Add(1 * time.Minute)),
+		},
+		{
+			ObjectMeta:     metav1.ObjectMeta{Name: "echoserver.4", Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod"},
+			Reason:         "BackOff",
+			Type:           "Warning",
+			FirstTimestamp: metav1.NewTime(from.Add(-1 * time.Hour)),
+			Count:          1,
+		},
+	}
+
+	t.Run("should group by reason", func(t *testing.T) {
+		frames := createEventsDataFrames(events, "Warning", nil, []string{EventsGroupByReason}, timeRange, time.Minute)
+		require.Len(t, frames, 2)
+		require.Equal(t, "BackOff", frames[0].Name)
+		require.Equal(t, 6, frames[0].Fields[0].Len())
+		require.Equal(t, []float64{1, 1, 1, 0, 0, 0}, fieldFloat64Values(frames[0].Fields[1]))
+		require.Equal(t, "OOMKilling", frames[1].Name)
+		require.Equal(t, []float64{0, 0, 0, 0, 2, 0}, fieldFloat64Values(frames[1].Fields[1]))
+	})
+
+	t.Run("should group by namespace and type", func(t *testing.T) {
+		frames := createEventsDataFrames(events, "", nil, []string{EventsGroupByNamespace, EventsGroupByType}, timeRange, time.Minute)
+		require.Len(t, frames, 2)
+		require.Equal(t, "default/Warning", frames[0].Name)
+		require.Equal(t, "kube-system/Normal", frames[1].Name)
+		require.Equal(t, "Normal", frames[1].Fields[1].Labels["type"])
+	})
+
+	t.Run("should filter by reason", func(t *testing.T) {
+		frames := createEventsDataFrames(events, "", regexp.MustCompile("^OOM"), nil, timeRange, time.Minute)
+		require.Len(t, frames, 1)
+		require.Equal(t, "events", frames[0].Name)
+	})
+
+	t.Run("should distribute large number of occurrences", func(t *testing.T) {
+		crashLoop := []corev1.Event{
+			{
+				ObjectMeta:     metav1.ObjectMeta{Name: "echoserver.5", Namespace: "default"},
+				Reason:         "BackOff",
+				Type:           "Warning",
+				FirstTimestamp: metav1.NewTime(from.Add(-10 * 24 * time.Hour)),
+				LastTimestamp:  metav1.NewTime(from.Add(10 * 24 * time.Hour)),
+				Count:          1_000_000_000,
+			},
+		}
+
+		frames := createEventsDataFrames(crashLoop, "", nil, nil, timeRange, time.Minute)
+		require.Len(t, frames, 1)
+
+		values := fieldFloat64Values(frames[0].Fields[1])
+		require.Len(t, values, 6)
+		for _, value := range values[:5] {
+			require.InDelta(t, 34722, value, 1)
+		}
+	})
+}
+
+func TestEventOccurrencesBefore(t *testing.T) {
+	first := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	last := first.Add(7 * time.Minute)
+
+	for _, count := range []int{1, 2, 3, 7, 8, 100} {
+		for offset := -time.Minute; offset <= 8*time.Minute; offset += 13 * time.Second {
+			expected := 0
+			for i := range count {
+				occurrence := last
+				if count > 1 {
+					occurrence = first.Add(time.Duration(int64(last.Sub(first)) * int64(i) / int64(count-1)))
+				}
+				if occurrence.Before(first.Add(offset)) {
+					expected++
+				}
+			}
+
+			require.Equal(t, expected, eventOccurrencesBefore(first, last, count, first.Add(offset)), "count %d, offset %s", count, offset)
+		}
+	}
+}
+
+func fieldFloat64Values(field *data.Field) []float64 {
+	var values []float64
+	for i := range field.Len() {
+		values = append(values, field.At(i).(float64))
+	}
+	return values
+}
@@ -0,0 +1,108 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultExecTimeout = 30 * time.Second
+	maxExecOutputSize  = 1024 * 1024
+)
+
+// ExecOptions are the options to run a command in a container. If the
+// container is empty, the command is run in the default container of the pod.
+type ExecOptions struct {
+	Container string   `json:"container"`
+	Command   []string `json:"command"`
+}
+
+// ExecResult is the result of a command, which was run in a container. If the
+// output of the command exceeds the maximum size, the output is truncated.
+type ExecResult struct {
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	ExitCode  int    `json:"exitCode"`
+	Truncated bool   `json:"truncated"`
+}
+
+// newExecAllowlist compiles the provided patterns. Each pattern is a list with
+// one regular expression per argument, e.g. ["cat", "/etc/config/.*"] or
+// ["curl", "-s", "localhost:[0-9]+/healthz"]. A command is only allowed, when
+// it has the same number of arguments as the pattern and each argument fully
+// matches the corresponding expression.
+func newExecAllowlist(patterns [][]string) ([][]*regexp.Regexp, error) {
+	var allowlist [][]*regexp.Regexp
+
+	for _, pattern := range patterns {
+		var args []*regexp.Regexp
+		for _, arg := range pattern {
+			r, err := regexp.Compile("^(?:" + arg + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid exec allowlist pattern %q: %w", pattern, err)
+			}
+			args = append(args, r)
+		}
+		if len(args) > 0 {
+			allowlist = append(allowlist, args)
+		}
+	}
+
+	return allowlist, nil
+}
+
+// isExecAllowed returns true if the command matches one of the patterns in
+// the allowlist. The arguments of the command are matched one by one, so that
+// an expression like ".*" can not match multiple arguments. If the allowlist
+// is empty, no command is allowed.
+func isExecAllowed(allowlist [][]*regexp.Regexp, command []string) bool {
+	if len(command) == 0 {
+		return false
+	}
+
+	for _, args := range allowlist {
+		if len(args) != len(command) {
+			continue
+		}
+
+		allowed := true
+		for i, r := range args {
+			if !r.MatchString(command[i]) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limitedBuffer is a buffer which discards all writes after the limit is
+// reached, so that a command with a large output can not exhaust the memory of
+// the plugin.
+type limitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.buffer.Len(); remaining < len(p) {
+		b.truncated = true
+		if remaining > 0 {
+			b.buffer.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+
+	return b.buffer.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buffer.String()
+}
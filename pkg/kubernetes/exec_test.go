@@ -0,0 +1,50 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsExecAllowed(t *testing.T) {
+	allowlist, err := newExecAllowlist([][]string{
+		{"cat", "/etc/config/.*"},
+		{"curl", "-s", "localhost:[0-9]+/healthz"},
+		{"grep", "-e", "error|warning", "/var/log/app log.txt"},
+	})
+	require.NoError(t, err)
+
+	require.True(t, isExecAllowed(allowlist, []string{"cat", "/etc/config/app.yaml"}))
+	require.True(t, isExecAllowed(allowlist, []string{"curl", "-s", "localhost:8080/healthz"}))
+	require.False(t, isExecAllowed(allowlist, []string{"curl", "-s", "localhost:8080/healthz;", "rm", "-rf", "/"}))
+	require.False(t, isExecAllowed(allowlist, []string{"sh", "-c", "cat /etc/config/app.yaml"}))
+	require.False(t, isExecAllowed(allowlist, []string{"cat", "/etc/config/app.yaml", "/etc/shadow"}))
+	require.False(t, isExecAllowed(allowlist, []string{"curl", "-s localhost:8080/healthz"}))
+	require.False(t, isExecAllowed(allowlist, []string{"cat"}))
+	require.False(t, isExecAllowed(allowlist, nil))
+	require.False(t, isExecAllowed(nil, []string{"cat", "/etc/config/app.yaml"}))
+
+	// Arguments can contain whitespace and alternations are only applied to a
+	// single argument.
+	require.True(t, isExecAllowed(allowlist, []string{"grep", "-e", "warning", "/var/log/app log.txt"}))
+	require.False(t, isExecAllowed(allowlist, []string{"grep", "-e", "error|warning", "/var/log/app", "log.txt"}))
+	require.False(t, isExecAllowed(allowlist, []string{"grep", "-e", "error /var/log/app log.txt"}))
+
+	_, err = newExecAllowlist([][]string{{"cat", "("}})
+	require.Error(t, err)
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	buffer := &limitedBuffer{limit: 5}
+
+	n, err := buffer.Write([]byte("abc"))
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.False(t, buffer.truncated)
+
+	n, err = buffer.Write([]byte("defgh"))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	require.True(t, buffer.truncated)
+	require.Equal(t, "abcde", buffer.String())
+}
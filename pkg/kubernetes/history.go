@@ -0,0 +1,511 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	HistoryModeSnapshot = "snapshot"
+	HistoryModeChanges  = "changes"
+)
+
+const (
+	// historyDefaultRetention is the duration for which the recorded changes
+	// are kept, when no retention is configured.
+	historyDefaultRetention = 24 * time.Hour
+	// historyDefaultMaxEntries is the maximum number of recorded changes, when
+	// no limit is configured. If the limit is reached the oldest changes are
+	// removed.
+	historyDefaultMaxEntries = 100000
+)
+
+// historyEntry is a single recorded change of a resource. Each entry contains
+// the complete object after the change (without the managed fields) and for
+// modifications a JSON merge patch with the difference to the previous
+// version of the object.
+type historyEntry struct {
+	Time            time.Time       `json:"time"`
+	ResourceId      string          `json:"resourceId"`
+	Namespace       string          `json:"namespace,omitempty"`
+	Name            string          `json:"name"`
+	Event           string          `json:"event"`
+	ResourceVersion string          `json:"resourceVersion"`
+	Object          json.RawMessage `json:"object"`
+	Patch           json.RawMessage `json:"patch,omitempty"`
+	Baseline        bool            `json:"baseline,omitempty"`
+}
+
+func (e historyEntry) key() string {
+	return e.ResourceId + "/" + e.Namespace + "/" + e.Name
+}
+
+// historyStore is a thread-safe store for the recorded changes of resources.
+// The changes are kept in memory and, if a path is configured, appended to a
+// JSON lines file, so that they survive restarts of the plugin. The file is
+// rewritten when old changes are removed.
+//
+// When changes are removed, the last removed version of each object, which
+// still exists, is kept as baseline. The baseline is used to return objects in
+// a snapshot, which were not changed within the retention.
+type historyStore struct {
+	path       string
+	file       *os.File
+	baseline   map[string]historyEntry
+	entries    []historyEntry
+	latest     map[string]historyEntry
+	retention  time.Duration
+	maxEntries int
+	lock       sync.Mutex
+}
+
+// add adds a new change to the store. If the last recorded version of the
+// object has the same resource version, the change is ignored. This happens
+// when the watch is restarted and all existing objects are returned again.
+// Deletions are always recorded, because deletions detected by a relist use
+// the last recorded resource version of the object.
+func (s *historyStore) add(entry historyEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	previous, ok := s.latest[entry.key()]
+	if ok && previous.ResourceVersion == entry.ResourceVersion && previous.Event != "DELETED" && entry.Event != "DELETED" {
+		return nil
+	}
+	if !ok && entry.Event == "DELETED" {
+		return nil
+	}
+
+	if ok && entry.Event == "MODIFIED" {
+		patch, err := jsonpatch.CreateMergePatch(previous.Object, entry.Object)
+		if err != nil {
+			return err
+		}
+		entry.Patch = patch
+	}
+
+	if s.file != nil {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := s.file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	s.entries = append(s.entries, entry)
+	s.latest[entry.key()] = entry
+
+	return nil
+}
+
+// prune removes all changes which are older than the retention and the oldest
+// changes if the store contains more changes than allowed. It returns true if
+// changes were removed.
+func (s *historyStore) prune(now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	index := 0
+	for index < len(s.entries) && s.entries[index].Time.Before(now.Add(-s.retention)) {
+		index++
+	}
+	index = max(index, len(s.entries)-s.maxEntries)
+
+	if index == 0 {
+		return false
+	}
+
+	for _, entry := range s.entries[:index] {
+		if entry.Event == "DELETED" {
+			delete(s.baseline, entry.key())
+		} else {
+			entry.Baseline = true
+			entry.Patch = nil
+			s.baseline[entry.key()] = entry
+		}
+	}
+	s.entries = slices.Clone(s.entries[index:])
+
+	for key, entry := range s.latest {
+		if entry.Event == "DELETED" && entry.Time.Before(now.Add(-s.retention)) {
+			delete(s.latest, key)
+		}
+	}
+
+	return true
+}
+
+// compact rewrites the file of the store with the baseline and the changes
+// which are currently kept in memory. The content is written to a temporary
+// file first, which is renamed afterwards, so that the file is never
+// corrupted, e.g. when the file is still used by an old instance of the
+// datasource.
+func (s *historyStore) compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	baseline := slices.Collect(maps.Values(s.baseline))
+	sort.Slice(baseline, func(i, j int) bool {
+		return baseline[i].Time.Before(baseline[j].Time)
+	})
+
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	for _, entry := range slices.Concat(baseline, s.entries) {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(content.Bytes()); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpFile.Name(), s.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = file
+
+	return nil
+}
+
+func (s *historyStore) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// snapshot returns the last recorded version of all objects of the provided
+// resource, which existed at the provided time. The objects from the baseline
+// are used as starting point, so that objects which were not changed within
+// the retention are also returned.
+func (s *historyStore) snapshot(resourceId string, namespaces []string, regex *regexp.Regexp, t time.Time) []historyEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	objects := make(map[string]historyEntry)
+	for key, entry := range s.baseline {
+		if !entry.Time.After(t) && s.matches(entry, resourceId, namespaces, regex) {
+			objects[key] = entry
+		}
+	}
+
+	for _, entry := range s.entries {
+		if entry.Time.After(t) {
+			break
+		}
+		if !s.matches(entry, resourceId, namespaces, regex) {
+			continue
+		}
+
+		if entry.Event == "DELETED" {
+			delete(objects, entry.key())
+		} else {
+			objects[entry.key()] = entry
+		}
+	}
+
+	var entries []historyEntry
+	for _, entry := range objects {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key() < entries[j].key()
+	})
+
+	return entries
+}
+
+// changes returns all recorded changes for the provided resource between the
+// provided times.
+func (s *historyStore) changes(resourceId string, namespaces []string, regex *regexp.Regexp, from, to time.Time) []historyEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var entries []historyEntry
+	for _, entry := range s.entries {
+		if entry.Time.Before(from) || entry.Time.After(to) {
+			continue
+		}
+		if !s.matches(entry, resourceId, namespaces, regex) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// sync reconciles the recorded objects of the provided resource with the
+// provided list of all existing objects. This is required when the watch must
+// be restarted without a resource version (e.g. after the resource version
+// expired), because the changes between the last event and the restart are
+// not returned by the watch. Objects which were changed are recorded as
+// modified, unchanged objects are ignored and objects which do not exist
+// anymore are recorded as deleted.
+func (s *historyStore) sync(resourceId string, entries []historyEntry, t time.Time) error {
+	existing := make(map[string]bool)
+	for _, entry := range entries {
+		existing[entry.key()] = true
+	}
+
+	s.lock.Lock()
+	var deleted []historyEntry
+	for key, previous := range s.latest {
+		if previous.ResourceId == resourceId && previous.Event != "DELETED" && !existing[key] {
+			deleted = append(deleted, historyEntry{
+				Time:            t,
+				ResourceId:      previous.ResourceId,
+				Namespace:       previous.Namespace,
+				Name:            previous.Name,
+				Event:           "DELETED",
+				ResourceVersion: previous.ResourceVersion,
+				Object:          previous.Object,
+			})
+		}
+	}
+	for i, entry := range entries {
+		if previous, ok := s.latest[entry.key()]; ok && previous.Event != "DELETED" {
+			entries[i].Event = "MODIFIED"
+		} else {
+			entries[i].Event = "ADDED"
+		}
+	}
+	s.lock.Unlock()
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].key() < deleted[j].key()
+	})
+
+	for _, entry := range slices.Concat(entries, deleted) {
+		if err := s.add(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *historyStore) matches(entry historyEntry, resourceId string, namespaces []string, regex *regexp.Regexp) bool {
+	if entry.ResourceId != resourceId {
+		return false
+	}
+	if len(namespaces) > 0 && !slices.Contains(namespaces, entry.Namespace) {
+		return false
+	}
+	if regex != nil && !regex.MatchString(entry.Name) {
+		return false
+	}
+	return true
+}
+
+// newHistoryEntry creates a new history entry for the provided watch event
+// object. The managed fields are removed from the object, because they are
+// changed with nearly every update and would only bloat the store.
+func newHistoryEntry(resourceId, eventType string, object []byte, t time.Time) (historyEntry, error) {
+	var obj map[string]any
+	if err := json.Unmarshal(object, &obj); err != nil {
+		return historyEntry{}, err
+	}
+
+	var metadata metav1.PartialObjectMetadata
+	if err := json.Unmarshal(object, &metadata); err != nil {
+		return historyEntry{}, err
+	}
+
+	if objMetadata, ok := obj["metadata"].(map[string]any); ok {
+		delete(objMetadata, "managedFields")
+	}
+
+	objJSON, err := json.Marshal(obj)
+	if err != nil {
+		return historyEntry{}, err
+	}
+
+	return historyEntry{
+		Time:            t,
+		ResourceId:      resourceId,
+		Namespace:       metadata.Namespace,
+		Name:            metadata.Name,
+		Event:           eventType,
+		ResourceVersion: metadata.ResourceVersion,
+		Object:          objJSON,
+	}, nil
+}
+
+// createHistorySnapshotDataFrame creates a table data frame with all objects
+// of a resource at a point in time. The "Updated" column contains the time of
+// the last recorded change of the object and the "Manifest" column the
+// complete object.
+func createHistorySnapshotDataFrame(resource Resource, entries []historyEntry) *data.Frame {
+	var namespaces, names, resourceVersions, manifests []string
+	var updated []time.Time
+
+	for _, entry := range entries {
+		namespaces = append(namespaces, entry.Namespace)
+		names = append(names, entry.Name)
+		updated = append(updated, entry.Time)
+		resourceVersions = append(resourceVersions, entry.ResourceVersion)
+		manifests = append(manifests, string(entry.Object))
+	}
+
+	frame := data.NewFrame(resource.Kind)
+	if resource.Namespaced {
+		frame.Fields = append(frame.Fields, data.NewField("Namespace", nil, namespaces))
+	}
+	frame.Fields = append(frame.Fields,
+		data.NewField("Name", nil, names),
+		data.NewField("Updated", nil, updated),
+		data.NewField("Resource Version", nil, resourceVersions),
+		data.NewField("Manifest", nil, manifests),
+	)
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	return frame
+}
+
+// createHistoryChangesDataFrame creates a table data frame with all recorded
+// changes of a resource. The "Diff" column contains the JSON merge patch for
+// modified objects.
+func createHistoryChangesDataFrame(resource Resource, entries []historyEntry) *data.Frame {
+	var events, namespaces, names, resourceVersions, diffs []string
+	var times []time.Time
+
+	for _, entry := range entries {
+		times = append(times, entry.Time)
+		events = append(events, entry.Event)
+		namespaces = append(namespaces, entry.Namespace)
+		names = append(names, entry.Name)
+		resourceVersions = append(resourceVersions, entry.ResourceVersion)
+		diffs = append(diffs, string(entry.Patch))
+	}
+
+	frame := data.NewFrame(resource.Kind, data.NewField("Time", nil, times), data.NewField("Event", nil, events))
+	if resource.Namespaced {
+		frame.Fields = append(frame.Fields, data.NewField("Namespace", nil, namespaces))
+	}
+	frame.Fields = append(frame.Fields,
+		data.NewField("Name", nil, names),
+		data.NewField("Resource Version", nil, resourceVersions),
+		data.NewField("Diff", nil, diffs),
+	)
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	return frame
+}
+
+// newHistoryStore creates a new history store. If a path is provided, all
+// changes from the file are loaded into the store and new changes are
+// appended to the file.
+func newHistoryStore(path string, retention time.Duration, maxEntries int) (*historyStore, error) {
+	if retention <= 0 {
+		retention = historyDefaultRetention
+	}
+	if maxEntries <= 0 {
+		maxEntries = historyDefaultMaxEntries
+	}
+
+	store := &historyStore{
+		path:       path,
+		baseline:   make(map[string]historyEntry),
+		latest:     make(map[string]historyEntry),
+		retention:  retention,
+		maxEntries: maxEntries,
+		lock:       sync.Mutex{},
+	}
+
+	if path == "" {
+		return store, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Lines which can not be decoded (e.g. a partially written last line,
+	// when the plugin was stopped during a write) are skipped. The file is
+	// compacted afterwards, so that these lines are removed from the file.
+	var invalid bool
+	for line := range bytes.Lines(content) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var entry historyEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			invalid = true
+			continue
+		}
+
+		if entry.Baseline {
+			store.baseline[entry.key()] = entry
+		} else {
+			store.entries = append(store.entries, entry)
+		}
+		store.latest[entry.key()] = entry
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	store.file = file
+
+	if store.prune(time.Now()) || invalid {
+		if err := store.compact(); err != nil {
+			store.close()
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
@@ -0,0 +1,193 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func newTestHistoryEntry(t *testing.T, eventType, name, resourceVersion, image string, ts time.Time) historyEntry {
+	object := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"` + name + `","namespace":"default","resourceVersion":"` + resourceVersion + `","managedFields":[{"manager":"kubectl"}]},"spec":{"containers":[{"name":"echoserver","image":"` + image + `"}]}}`
+
+	entry, err := newHistoryEntry("pod", eventType, []byte(object), ts)
+	require.NoError(t, err)
+	return entry
+}
+
+func TestHistoryStore(t *testing.T) {
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	store, err := newHistoryStore(path, time.Hour, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "echoserver", "1", "echoserver:1", now.Add(-50*time.Minute))))
+	require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "echoserver", "1", "echoserver:1", now.Add(-45*time.Minute))))
+	require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "nginx", "2", "nginx:1", now.Add(-40*time.Minute))))
+	require.NoError(t, store.add(newTestHistoryEntry(t, "MODIFIED", "echoserver", "3", "echoserver:2", now.Add(-30*time.Minute))))
+	require.NoError(t, store.add(newTestHistoryEntry(t, "DELETED", "nginx", "4", "nginx:1", now.Add(-20*time.Minute))))
+
+	t.Run("should remove managed fields and ignore duplicates", func(t *testing.T) {
+		entries := store.changes("pod", nil, nil, now.Add(-time.Hour), now)
+		require.Len(t, entries, 4)
+		require.NotContains(t, string(entries[0].Object), "managedFields")
+	})
+
+	t.Run("should record diff for modified objects", func(t *testing.T) {
+		entries := store.changes("pod", []string{"default"}, nil, now.Add(-35*time.Minute), now.Add(-25*time.Minute))
+		require.Len(t, entries, 1)
+		require.Equal(t, "MODIFIED", entries[0].Event)
+		require.JSONEq(t, `{"metadata":{"resourceVersion":"3"},"spec":{"containers":[{"name":"echoserver","image":"echoserver:2"}]}}`, string(entries[0].Patch))
+	})
+
+	t.Run("should return objects at point in time", func(t *testing.T) {
+		entries := store.snapshot("pod", nil, nil, now.Add(-35*time.Minute))
+		require.Len(t, entries, 2)
+		require.Equal(t, "echoserver", entries[0].Name)
+		require.Equal(t, "1", entries[0].ResourceVersion)
+		require.Equal(t, "nginx", entries[1].Name)
+
+		entries = store.snapshot("pod", nil, nil, now)
+		require.Len(t, entries, 1)
+		require.Equal(t, "3", entries[0].ResourceVersion)
+	})
+
+	t.Run("should load entries from file", func(t *testing.T) {
+		require.NoError(t, store.close())
+
+		store, err = newHistoryStore(path, time.Hour, 0)
+		require.NoError(t, err)
+		require.Len(t, store.changes("pod", nil, nil, now.Add(-time.Hour), now), 4)
+	})
+
+	t.Run("should remove entries older than retention", func(t *testing.T) {
+		require.True(t, store.prune(now.Add(25*time.Minute)))
+		require.NoError(t, store.compact())
+		require.NoError(t, store.close())
+
+		store, err = newHistoryStore(path, time.Hour, 0)
+		require.NoError(t, err)
+		require.Len(t, store.changes("pod", nil, nil, now.Add(-time.Hour), now), 2)
+		require.NoError(t, store.close())
+	})
+
+	t.Run("should return unchanged objects from baseline after prune", func(t *testing.T) {
+		store, err := newHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"), time.Hour, 0)
+		require.NoError(t, err)
+		defer store.close()
+
+		require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "echoserver", "1", "echoserver:1", now.Add(-50*time.Minute))))
+		require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "nginx", "2", "nginx:1", now.Add(-40*time.Minute))))
+		require.NoError(t, store.add(newTestHistoryEntry(t, "DELETED", "nginx", "3", "nginx:1", now.Add(-30*time.Minute))))
+		require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "redis", "4", "redis:1", now.Add(-20*time.Minute))))
+
+		require.True(t, store.prune(now.Add(35*time.Minute)))
+		require.NoError(t, store.compact())
+		require.Len(t, store.changes("pod", nil, nil, now.Add(-time.Hour), now), 1)
+
+		entries := store.snapshot("pod", nil, nil, now)
+		require.Len(t, entries, 2)
+		require.Equal(t, "echoserver", entries[0].Name)
+		require.Equal(t, "1", entries[0].ResourceVersion)
+		require.Equal(t, "redis", entries[1].Name)
+
+		require.NoError(t, store.close())
+		store, err = newHistoryStore(store.path, time.Hour, 0)
+		require.NoError(t, err)
+		require.Len(t, store.snapshot("pod", nil, nil, now), 2)
+		require.Len(t, store.changes("pod", nil, nil, now.Add(-time.Hour), now), 1)
+	})
+
+	t.Run("should sync objects after relist", func(t *testing.T) {
+		store, err := newHistoryStore("", time.Hour, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "echoserver", "1", "echoserver:1", now.Add(-50*time.Minute))))
+		require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "nginx", "2", "nginx:1", now.Add(-40*time.Minute))))
+		require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "redis", "3", "redis:1", now.Add(-30*time.Minute))))
+
+		require.NoError(t, store.sync("pod", []historyEntry{
+			newTestHistoryEntry(t, "ADDED", "echoserver", "1", "echoserver:1", now.Add(-10*time.Minute)),
+			newTestHistoryEntry(t, "ADDED", "nginx", "4", "nginx:2", now.Add(-10*time.Minute)),
+			newTestHistoryEntry(t, "ADDED", "postgres", "5", "postgres:1", now.Add(-10*time.Minute)),
+		}, now.Add(-10*time.Minute)))
+
+		entries := store.changes("pod", nil, nil, now.Add(-15*time.Minute), now)
+		require.Len(t, entries, 3)
+		require.Equal(t, "MODIFIED", entries[0].Event)
+		require.Equal(t, "nginx", entries[0].Name)
+		require.NotEmpty(t, entries[0].Patch)
+		require.Equal(t, "ADDED", entries[1].Event)
+		require.Equal(t, "postgres", entries[1].Name)
+		require.Equal(t, "DELETED", entries[2].Event)
+		require.Equal(t, "redis", entries[2].Name)
+		require.Equal(t, "3", entries[2].ResourceVersion)
+	})
+
+	t.Run("should skip invalid lines and compact to new file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+
+		store, err := newHistoryStore(path, time.Hour, 0)
+		require.NoError(t, err)
+		require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "echoserver", "1", "echoserver:1", now.Add(-50*time.Minute))))
+		require.NoError(t, store.close())
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"time":"`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		store, err = newHistoryStore(path, time.Hour, 0)
+		require.NoError(t, err)
+		require.Len(t, store.changes("pod", nil, nil, now.Add(-time.Hour), now), 1)
+
+		oldStore, err := newHistoryStore(path, time.Hour, 0)
+		require.NoError(t, err)
+		defer oldStore.close()
+
+		require.NoError(t, store.add(newTestHistoryEntry(t, "ADDED", "nginx", "2", "nginx:1", now.Add(-40*time.Minute))))
+		require.NoError(t, store.compact())
+		require.NoError(t, oldStore.add(newTestHistoryEntry(t, "ADDED", "redis", "3", "redis:1", now.Add(-30*time.Minute))))
+		require.NoError(t, store.add(newTestHistoryEntry(t, "MODIFIED", "nginx", "4", "nginx:2", now.Add(-20*time.Minute))))
+		require.NoError(t, store.close())
+
+		store, err = newHistoryStore(path, time.Hour, 0)
+		require.NoError(t, err)
+		defer store.close()
+		require.Len(t, store.changes("pod", nil, nil, now.Add(-time.Hour), now), 3)
+	})
+}
+
+func TestListHistoryEntries(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/pods", r.URL.Path)
+		require.Empty(t, r.URL.Query().Get("watch"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"10"},"items":[{"metadata":{"name":"echoserver","namespace":"default","resourceVersion":"5","managedFields":[{"manager":"kubectl"}]}}]}`))
+	}))
+	defer testServer.Close()
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: testServer.URL})
+	require.NoError(t, err)
+
+	c := &client{logger: log.DefaultLogger, clientset: clientset}
+
+	entries, resourceVersion, err := c.listHistoryEntries(context.Background(), "pod", Resource{ID: "pod", Kind: "Pod", APIVersion: "v1", Name: "pods", Path: "/api/v1", Namespaced: true})
+	require.NoError(t, err)
+	require.Equal(t, "10", resourceVersion)
+	require.Len(t, entries, 1)
+	require.Equal(t, "echoserver", entries[0].Name)
+	require.Equal(t, "5", entries[0].ResourceVersion)
+	require.JSONEq(t, `{"kind":"Pod","apiVersion":"v1","metadata":{"name":"echoserver","namespace":"default","resourceVersion":"5"}}`, string(entries[0].Object))
+}
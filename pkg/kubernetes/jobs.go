@@ -0,0 +1,179 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/utils/ptr"
+)
+
+// jobPodsTimeout is the maximum time we wait for the pods of a newly created
+// Job.
+const jobPodsTimeout = 10 * time.Second
+
+// instantiateAnnotation is the annotation which is set by "kubectl create job
+// --from=cronjob/<name>" to mark Jobs which were created manually.
+const instantiateAnnotation = "cronjob.kubernetes.io/instantiate"
+
+// jobControllerLabels are the labels which are added by the Job controller to
+// the Job and the pod template. They contain the uid of the original Job, so
+// that they must be removed when a Job is cloned.
+var jobControllerLabels = []string{
+	"controller-uid",
+	"job-name",
+	batchv1.ControllerUidLabel,
+	batchv1.JobNameLabel,
+}
+
+// JobRunOptions are the options to create a Job from a CronJob or to re-run a
+// Job. The environment variables are set in all containers of the Job and
+// override existing environment variables with the same name.
+type JobRunOptions struct {
+	Env map[string]string `json:"env"`
+}
+
+// JobRunResult is the result of triggering a CronJob or re-running a Job. It
+// contains the created Job and the pods which were created for the Job so far.
+type JobRunResult struct {
+	Job  batchv1.Job  `json:"job"`
+	Pods []corev1.Pod `json:"pods"`
+}
+
+// newManualJobName returns the name for a manually created Job, in the same
+// format as it is used by the frontend, e.g. "mycronjob-manual-abc123". The
+// name of the parent is truncated, so that the name is a valid label value.
+func newManualJobName(name string) string {
+	suffix := "-manual-" + utilrand.String(6)
+	if len(name)+len(suffix) > 63 {
+		name = name[:63-len(suffix)]
+	}
+	return name + suffix
+}
+
+// newJobFromCronJob returns a new Job from the Job template of the provided
+// CronJob. Like "kubectl create job --from=cronjob/<name>", the Job is owned
+// by the CronJob and annotated as manually instantiated.
+func newJobFromCronJob(cronJob batchv1.CronJob, name string, env map[string]string) *batchv1.Job {
+	annotations := map[string]string{instantiateAnnotation: "manual"}
+	for key, value := range cronJob.Spec.JobTemplate.Annotations {
+		annotations[key] = value
+	}
+
+	labels := make(map[string]string)
+	for key, value := range cronJob.Spec.JobTemplate.Labels {
+		labels[key] = value
+	}
+
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   cronJob.Namespace,
+			Annotations: annotations,
+			Labels:      labels,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1",
+				Kind:       "CronJob",
+				Name:       cronJob.Name,
+				UID:        cronJob.UID,
+				Controller: ptr.To(true),
+			}},
+		},
+		Spec: *cronJob.Spec.JobTemplate.Spec.DeepCopy(),
+	}
+
+	setJobEnv(&job.Spec.Template.Spec, env)
+	return job
+}
+
+// newJobFromJob returns a copy of the provided Job with a new name. Only
+// finished Jobs can be re-run. The selector and the labels which are added by
+// the Job controller are removed, so that they are generated for the new Job.
+// The owner references are kept, so that a re-run of a Job created by a
+// CronJob is still owned by the CronJob.
+func newJobFromJob(job batchv1.Job, name string, env map[string]string) (*batchv1.Job, error) {
+	if !isJobFinished(job) {
+		return nil, fmt.Errorf("job %s is not finished", job.Name)
+	}
+
+	annotations := map[string]string{instantiateAnnotation: "manual"}
+	for key, value := range job.Annotations {
+		if key != instantiateAnnotation {
+			annotations[key] = value
+		}
+	}
+
+	labels := make(map[string]string)
+	for key, value := range job.Labels {
+		labels[key] = value
+	}
+
+	spec := job.Spec.DeepCopy()
+	spec.Selector = nil
+	spec.ManualSelector = nil
+	for _, label := range jobControllerLabels {
+		delete(labels, label)
+		delete(spec.Template.Labels, label)
+	}
+
+	newJob := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       job.Namespace,
+			Annotations:     annotations,
+			Labels:          labels,
+			OwnerReferences: job.OwnerReferences,
+		},
+		Spec: *spec,
+	}
+
+	setJobEnv(&newJob.Spec.Template.Spec, env)
+	return newJob, nil
+}
+
+// isJobFinished returns true if the Job has the "Complete" or "Failed"
+// condition.
+func isJobFinished(job batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// setJobEnv sets the provided environment variables in all containers of the
+// pod spec. Existing environment variables with the same name are replaced,
+// including variables which are referencing a ConfigMap or Secret.
+func setJobEnv(spec *corev1.PodSpec, env map[string]string) {
+	if len(env) == 0 {
+		return
+	}
+
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i := range spec.Containers {
+		for _, name := range names {
+			replaced := false
+			for j := range spec.Containers[i].Env {
+				if spec.Containers[i].Env[j].Name == name {
+					spec.Containers[i].Env[j] = corev1.EnvVar{Name: name, Value: env[name]}
+					replaced = true
+				}
+			}
+			if !replaced {
+				spec.Containers[i].Env = append(spec.Containers[i].Env, corev1.EnvVar{Name: name, Value: env[name]})
+			}
+		}
+	}
+}
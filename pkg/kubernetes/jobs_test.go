@@ -0,0 +1,78 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewManualJobName(t *testing.T) {
+	require.True(t, strings.HasPrefix(newManualJobName("mycronjob"), "mycronjob-manual-"))
+	require.Len(t, newManualJobName(strings.Repeat("a", 100)), 63)
+}
+
+func TestNewJobFromCronJob(t *testing.T) {
+	cronJob := batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "mycronjob", Namespace: "default", UID: "1"},
+		Spec: batchv1.CronJobSpec{
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "mycronjob"}},
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "job", Env: []corev1.EnvVar{{Name: "MODE", Value: "full"}}}}},
+					},
+				},
+			},
+		},
+	}
+
+	job := newJobFromCronJob(cronJob, "mycronjob-manual-abc123", map[string]string{"MODE": "dry-run", "DEBUG": "true"})
+	require.Equal(t, "mycronjob-manual-abc123", job.Name)
+	require.Equal(t, "default", job.Namespace)
+	require.Equal(t, "manual", job.Annotations[instantiateAnnotation])
+	require.Equal(t, map[string]string{"app": "mycronjob"}, job.Labels)
+	require.Len(t, job.OwnerReferences, 1)
+	require.Equal(t, "CronJob", job.OwnerReferences[0].Kind)
+	require.Equal(t, "mycronjob", job.OwnerReferences[0].Name)
+	require.True(t, *job.OwnerReferences[0].Controller)
+	require.Equal(t, []corev1.EnvVar{{Name: "MODE", Value: "dry-run"}, {Name: "DEBUG", Value: "true"}}, job.Spec.Template.Spec.Containers[0].Env)
+	require.Equal(t, []corev1.EnvVar{{Name: "MODE", Value: "full"}}, cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env)
+}
+
+func TestNewJobFromJob(t *testing.T) {
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "myjob",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "myjob", batchv1.ControllerUidLabel: "1", batchv1.JobNameLabel: "myjob"},
+		},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{batchv1.ControllerUidLabel: "1"}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "myjob", batchv1.ControllerUidLabel: "1", batchv1.JobNameLabel: "myjob"}},
+			},
+		},
+	}
+
+	t.Run("should fail for running job", func(t *testing.T) {
+		_, err := newJobFromJob(job, "myjob-manual-abc123", nil)
+		require.Error(t, err)
+	})
+
+	t.Run("should clone finished job", func(t *testing.T) {
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+
+		newJob, err := newJobFromJob(job, "myjob-manual-abc123", nil)
+		require.NoError(t, err)
+		require.Equal(t, "myjob-manual-abc123", newJob.Name)
+		require.Equal(t, "manual", newJob.Annotations[instantiateAnnotation])
+		require.Equal(t, map[string]string{"app": "myjob"}, newJob.Labels)
+		require.Equal(t, map[string]string{"app": "myjob"}, newJob.Spec.Template.Labels)
+		require.Nil(t, newJob.Spec.Selector)
+		require.NotNil(t, job.Spec.Selector)
+	})
+}
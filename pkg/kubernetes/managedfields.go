@@ -0,0 +1,154 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// flattenFieldsV1 returns the paths of all fields in the provided "FieldsV1"
+// object of a managed fields entry. The keys of the object are converted as
+// follows:
+//
+//   - "f:<name>": ".<name>"
+//   - "k:<keys>": "[<key>=<value>,...]" (an item in a list of maps)
+//   - "v:<value>": "[=<value>]" (an item in a set)
+//   - "i:<index>": "[<index>]"
+//
+// Only the leafs of the object are returned, e.g. ".spec.replicas" and not
+// ".spec". If a key is marked with "." it is also owned by the manager and
+// returned.
+func flattenFieldsV1(fields *metav1.FieldsV1) ([]string, error) {
+	if fields == nil || len(fields.Raw) == 0 {
+		return nil, nil
+	}
+
+	var obj map[string]any
+	if err := json.Unmarshal(fields.Raw, &obj); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	flattenFieldsV1Object("", obj, &paths)
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+func flattenFieldsV1Object(prefix string, obj map[string]any, paths *[]string) {
+	for key, value := range obj {
+		if key == "." {
+			if prefix != "" {
+				*paths = append(*paths, prefix)
+			}
+			continue
+		}
+
+		path := prefix + formatFieldsV1Key(key)
+
+		children, ok := value.(map[string]any)
+		if !ok || len(children) == 0 {
+			*paths = append(*paths, path)
+			continue
+		}
+
+		flattenFieldsV1Object(path, children, paths)
+	}
+}
+
+func formatFieldsV1Key(key string) string {
+	switch {
+	case strings.HasPrefix(key, "f:"):
+		return "." + strings.TrimPrefix(key, "f:")
+	case strings.HasPrefix(key, "k:"):
+		var keys map[string]any
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(key, "k:")), &keys); err != nil {
+			return "[" + strings.TrimPrefix(key, "k:") + "]"
+		}
+
+		var parts []string
+		for k, v := range keys {
+			value, _ := json.Marshal(v)
+			parts = append(parts, k+"="+strings.Trim(string(value), `"`))
+		}
+		sort.Strings(parts)
+		return "[" + strings.Join(parts, ",") + "]"
+	case strings.HasPrefix(key, "v:"):
+		return "[=" + strings.Trim(strings.TrimPrefix(key, "v:"), `"`) + "]"
+	case strings.HasPrefix(key, "i:"):
+		return "[" + strings.TrimPrefix(key, "i:") + "]"
+	default:
+		return "." + key
+	}
+}
+
+// createManagedFieldsDataFrame creates a timeline of the provided managed
+// fields entries. The entries are sorted by time and for each entry we return
+// the fields owned by the manager and the fields which are owned by the
+// manager, but not by the manager of the previous entry. This allows users to
+// see which fields were changed by which manager (e.g. "kubectl-edit" changed
+// ".spec.replicas"), without collecting the audit logs of the cluster.
+func createManagedFieldsDataFrame(name string, managedFields []metav1.ManagedFieldsEntry) (*data.Frame, error) {
+	entries := slices.Clone(managedFields)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return managedFieldsTime(entries[i]).Before(managedFieldsTime(entries[j]))
+	})
+
+	var times []time.Time
+	var managers, operations, subresources, apiVersions, fields, changedFields []string
+	var previousPaths []string
+
+	for _, entry := range entries {
+		paths, err := flattenFieldsV1(entry.FieldsV1)
+		if err != nil {
+			return nil, err
+		}
+
+		var changedPaths []string
+		for _, path := range paths {
+			if !slices.Contains(previousPaths, path) {
+				changedPaths = append(changedPaths, path)
+			}
+		}
+
+		times = append(times, managedFieldsTime(entry))
+		managers = append(managers, entry.Manager)
+		operations = append(operations, string(entry.Operation))
+		subresources = append(subresources, entry.Subresource)
+		apiVersions = append(apiVersions, entry.APIVersion)
+		fields = append(fields, strings.Join(paths, ", "))
+		changedFields = append(changedFields, strings.Join(changedPaths, ", "))
+
+		previousPaths = paths
+	}
+
+	frame := data.NewFrame(
+		name,
+		data.NewField("Time", nil, times),
+		data.NewField("Manager", nil, managers),
+		data.NewField("Operation", nil, operations),
+		data.NewField("Subresource", nil, subresources),
+		data.NewField("API Version", nil, apiVersions),
+		data.NewField("Changed Fields", nil, changedFields),
+		data.NewField("Fields", nil, fields),
+	)
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	return frame, nil
+}
+
+func managedFieldsTime(entry metav1.ManagedFieldsEntry) time.Time {
+	if entry.Time == nil {
+		return time.Time{}
+	}
+	return entry.Time.Time
+}
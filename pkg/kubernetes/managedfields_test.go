@@ -0,0 +1,58 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFlattenFieldsV1(t *testing.T) {
+	paths, err := flattenFieldsV1(&metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{".":{},"f:app":{}}},"f:spec":{"f:replicas":{},"f:template":{"f:spec":{"f:containers":{"k:{\"name\":\"echoserver\"}":{".":{},"f:image":{}}}}}},"f:status":{"f:conditions":{"i:0":{}},"f:finalizers":{"v:\"foregroundDeletion\"":{}}}}`)})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		".metadata.labels",
+		".metadata.labels.app",
+		".spec.replicas",
+		".spec.template.spec.containers[name=echoserver]",
+		".spec.template.spec.containers[name=echoserver].image",
+		".status.conditions[0]",
+		".status.finalizers[=foregroundDeletion]",
+	}, paths)
+}
+
+func TestCreateManagedFieldsDataFrame(t *testing.T) {
+	now := time.Now()
+
+	frame, err := createManagedFieldsDataFrame("echoserver", []metav1.ManagedFieldsEntry{
+		{
+			Manager:   "kubectl-edit",
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			Time:      &metav1.Time{Time: now},
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{}}}`)},
+		},
+		{
+			Manager:   "helm",
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			Time:      &metav1.Time{Time: now.Add(-1 * time.Hour)},
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:selector":{}}}`)},
+		},
+		{
+			Manager:     "kube-controller-manager",
+			Operation:   metav1.ManagedFieldsOperationUpdate,
+			Subresource: "status",
+			Time:        &metav1.Time{Time: now.Add(1 * time.Minute)},
+			FieldsV1:    &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{}},"f:status":{"f:replicas":{}}}`)},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, frame.Rows())
+	require.Equal(t, "helm", frame.Fields[1].At(0))
+	require.Equal(t, ".spec.selector", frame.Fields[5].At(0))
+	require.Equal(t, "kubectl-edit", frame.Fields[1].At(1))
+	require.Equal(t, ".spec.replicas", frame.Fields[5].At(1))
+	require.Equal(t, "status", frame.Fields[3].At(2))
+	require.Equal(t, ".status.replicas", frame.Fields[5].At(2))
+	require.Equal(t, ".spec.replicas, .status.replicas", frame.Fields[6].At(2))
+}
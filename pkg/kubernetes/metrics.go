@@ -0,0 +1,232 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	podStatusPhaseDesc = prometheus.NewDesc(
+		"kube_pod_status_phase",
+		"The pods current phase.",
+		[]string{"namespace", "pod", "phase"}, nil,
+	)
+	podContainerStatusRestartsTotalDesc = prometheus.NewDesc(
+		"kube_pod_container_status_restarts_total",
+		"The number of container restarts per container.",
+		[]string{"namespace", "pod", "container"}, nil,
+	)
+	deploymentSpecReplicasDesc = prometheus.NewDesc(
+		"kube_deployment_spec_replicas",
+		"Number of desired pods for a deployment.",
+		[]string{"namespace", "deployment"}, nil,
+	)
+	deploymentStatusReplicasDesc = prometheus.NewDesc(
+		"kube_deployment_status_replicas",
+		"The number of replicas per deployment.",
+		[]string{"namespace", "deployment"}, nil,
+	)
+	deploymentStatusReplicasReadyDesc = prometheus.NewDesc(
+		"kube_deployment_status_replicas_ready",
+		"The number of ready replicas per deployment.",
+		[]string{"namespace", "deployment"}, nil,
+	)
+	deploymentStatusReplicasAvailableDesc = prometheus.NewDesc(
+		"kube_deployment_status_replicas_available",
+		"The number of available replicas per deployment.",
+		[]string{"namespace", "deployment"}, nil,
+	)
+	deploymentStatusReplicasUnavailableDesc = prometheus.NewDesc(
+		"kube_deployment_status_replicas_unavailable",
+		"The number of unavailable replicas per deployment.",
+		[]string{"namespace", "deployment"}, nil,
+	)
+	deploymentStatusReplicasUpdatedDesc = prometheus.NewDesc(
+		"kube_deployment_status_replicas_updated",
+		"The number of updated replicas per deployment.",
+		[]string{"namespace", "deployment"}, nil,
+	)
+	nodeStatusConditionDesc = prometheus.NewDesc(
+		"kube_node_status_condition",
+		"The condition of a cluster node.",
+		[]string{"node", "condition", "status"}, nil,
+	)
+	nodeSpecUnschedulableDesc = prometheus.NewDesc(
+		"kube_node_spec_unschedulable",
+		"Whether a node can schedule new pods.",
+		[]string{"node"}, nil,
+	)
+)
+
+// metricsCollector is a Prometheus collector, which computes a core set of the
+// metrics exposed by kube-state-metrics from the current state of the cluster.
+// This allows us to use the dashboards of the plugin in clusters where
+// kube-state-metrics is not installed.
+//
+// The state is fetched via the clientset of the datasource on every scrape, so
+// the metrics always reflect the view of the configured Kubeconfig and not the
+// view of a single Grafana user. If the namespaces of the datasource are
+// restricted, only the metrics for the allowed namespaces are returned and the
+// node metrics are omitted, when cluster-scoped resources are hidden.
+type metricsCollector struct {
+	clientset  kubernetes.Interface
+	namespaces *namespaceRestriction
+	logger     log.Logger
+}
+
+func (m *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- podStatusPhaseDesc
+	ch <- podContainerStatusRestartsTotalDesc
+	ch <- deploymentSpecReplicasDesc
+	ch <- deploymentStatusReplicasDesc
+	ch <- deploymentStatusReplicasReadyDesc
+	ch <- deploymentStatusReplicasAvailableDesc
+	ch <- deploymentStatusReplicasUnavailableDesc
+	ch <- deploymentStatusReplicasUpdatedDesc
+	ch <- nodeStatusConditionDesc
+	ch <- nodeSpecUnschedulableDesc
+}
+
+// Collect lists all pods, deployments and nodes and creates the metrics for
+// them. If one of the lists fails, we log the error and continue with the
+// next one, so that a missing permission for a single resource doesn't break
+// the whole scrape.
+func (m *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if pods, err := m.clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{}); err != nil {
+		m.logger.Error("Failed to list pods for metrics", "error", err.Error())
+	} else {
+		pods.Items = slices.DeleteFunc(pods.Items, func(pod corev1.Pod) bool {
+			return !m.namespaces.isAllowed(pod.Namespace)
+		})
+
+		collectPodMetrics(ch, pods.Items)
+	}
+
+	if deployments, err := m.clientset.AppsV1().Deployments("").List(ctx, metav1.ListOptions{}); err != nil {
+		m.logger.Error("Failed to list deployments for metrics", "error", err.Error())
+	} else {
+		deployments.Items = slices.DeleteFunc(deployments.Items, func(deployment appsv1.Deployment) bool {
+			return !m.namespaces.isAllowed(deployment.Namespace)
+		})
+
+		for _, deployment := range deployments.Items {
+			replicas := int32(1)
+			if deployment.Spec.Replicas != nil {
+				replicas = *deployment.Spec.Replicas
+			}
+
+			ch <- prometheus.MustNewConstMetric(deploymentSpecReplicasDesc, prometheus.GaugeValue, float64(replicas), deployment.Namespace, deployment.Name)
+			ch <- prometheus.MustNewConstMetric(deploymentStatusReplicasDesc, prometheus.GaugeValue, float64(deployment.Status.Replicas), deployment.Namespace, deployment.Name)
+			ch <- prometheus.MustNewConstMetric(deploymentStatusReplicasReadyDesc, prometheus.GaugeValue, float64(deployment.Status.ReadyReplicas), deployment.Namespace, deployment.Name)
+			ch <- prometheus.MustNewConstMetric(deploymentStatusReplicasAvailableDesc, prometheus.GaugeValue, float64(deployment.Status.AvailableReplicas), deployment.Namespace, deployment.Name)
+			ch <- prometheus.MustNewConstMetric(deploymentStatusReplicasUnavailableDesc, prometheus.GaugeValue, float64(deployment.Status.UnavailableReplicas), deployment.Namespace, deployment.Name)
+			ch <- prometheus.MustNewConstMetric(deploymentStatusReplicasUpdatedDesc, prometheus.GaugeValue, float64(deployment.Status.UpdatedReplicas), deployment.Namespace, deployment.Name)
+		}
+	}
+
+	// Nodes are cluster-scoped, so the node metrics are omitted, when
+	// cluster-scoped resources are hidden by the datasource.
+	if err := m.namespaces.authorize(schema.GroupResource{Resource: "nodes"}, false, ""); err != nil {
+		return
+	}
+
+	if nodes, err := m.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{}); err != nil {
+		m.logger.Error("Failed to list nodes for metrics", "error", err.Error())
+	} else {
+		collectNodeMetrics(ch, nodes.Items)
+	}
+}
+
+func collectPodMetrics(ch chan<- prometheus.Metric, pods []corev1.Pod) {
+	phases := []corev1.PodPhase{corev1.PodPending, corev1.PodRunning, corev1.PodSucceeded, corev1.PodFailed, corev1.PodUnknown}
+
+	for _, pod := range pods {
+		for _, phase := range phases {
+			ch <- prometheus.MustNewConstMetric(podStatusPhaseDesc, prometheus.GaugeValue, boolToFloat64(pod.Status.Phase == phase), pod.Namespace, pod.Name, string(phase))
+		}
+
+		for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+			for _, status := range statuses {
+				ch <- prometheus.MustNewConstMetric(podContainerStatusRestartsTotalDesc, prometheus.CounterValue, float64(status.RestartCount), pod.Namespace, pod.Name, status.Name)
+			}
+		}
+	}
+}
+
+func collectNodeMetrics(ch chan<- prometheus.Metric, nodes []corev1.Node) {
+	statuses := []corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionFalse, corev1.ConditionUnknown}
+
+	for _, node := range nodes {
+		ch <- prometheus.MustNewConstMetric(nodeSpecUnschedulableDesc, prometheus.GaugeValue, boolToFloat64(node.Spec.Unschedulable), node.Name)
+
+		for _, condition := range node.Status.Conditions {
+			for _, status := range statuses {
+				ch <- prometheus.MustNewConstMetric(nodeStatusConditionDesc, prometheus.GaugeValue, boolToFloat64(condition.Status == status), node.Name, string(condition.Type), toLowerConditionStatus(status))
+			}
+		}
+	}
+}
+
+// toLowerConditionStatus returns the condition status in the format used by
+// kube-state-metrics ("true", "false" and "unknown").
+func toLowerConditionStatus(status corev1.ConditionStatus) string {
+	switch status {
+	case corev1.ConditionTrue:
+		return "true"
+	case corev1.ConditionFalse:
+		return "false"
+	default:
+		return "unknown"
+	}
+}
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// newMetricsHandler returns the handler for the "/kube-state-metrics" endpoint
+// of the Kubernetes server, which serves the metrics of the provided
+// collector. The handler requires the provided token as bearer token in the
+// "Authorization" header, because the endpoint is served on all interfaces of
+// the server and the metrics contain the state of the whole cluster.
+func newMetricsHandler(collector prometheus.Collector, token string) (http.Handler, error) {
+	if token == "" {
+		return nil, fmt.Errorf("token for kube-state-metrics is required")
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		return nil, err
+	}
+
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kube-state-metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,170 @@
+package kubernetes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	replicas := int32(3)
+
+	clientset := fake.NewClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "echoserver", Namespace: "default"},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "echoserver", RestartCount: 2},
+				},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "echoserver", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{
+				Replicas:            3,
+				ReadyReplicas:       2,
+				AvailableReplicas:   2,
+				UnavailableReplicas: 1,
+				UpdatedReplicas:     3,
+			},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{
+					{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+				},
+			},
+		},
+	)
+
+	collector := &metricsCollector{
+		clientset: clientset,
+		logger:    log.DefaultLogger,
+	}
+
+	t.Run("should return pod metrics", func(t *testing.T) {
+		expected := `
+# HELP kube_pod_container_status_restarts_total The number of container restarts per container.
+# TYPE kube_pod_container_status_restarts_total counter
+kube_pod_container_status_restarts_total{container="echoserver",namespace="default",pod="echoserver"} 2
+# HELP kube_pod_status_phase The pods current phase.
+# TYPE kube_pod_status_phase gauge
+kube_pod_status_phase{namespace="default",phase="Failed",pod="echoserver"} 0
+kube_pod_status_phase{namespace="default",phase="Pending",pod="echoserver"} 0
+kube_pod_status_phase{namespace="default",phase="Running",pod="echoserver"} 1
+kube_pod_status_phase{namespace="default",phase="Succeeded",pod="echoserver"} 0
+kube_pod_status_phase{namespace="default",phase="Unknown",pod="echoserver"} 0
+`
+		err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "kube_pod_status_phase", "kube_pod_container_status_restarts_total")
+		require.NoError(t, err)
+	})
+
+	t.Run("should return deployment metrics", func(t *testing.T) {
+		expected := `
+# HELP kube_deployment_spec_replicas Number of desired pods for a deployment.
+# TYPE kube_deployment_spec_replicas gauge
+kube_deployment_spec_replicas{deployment="echoserver",namespace="default"} 3
+# HELP kube_deployment_status_replicas_available The number of available replicas per deployment.
+# TYPE kube_deployment_status_replicas_available gauge
+kube_deployment_status_replicas_available{deployment="echoserver",namespace="default"} 2
+# HELP kube_deployment_status_replicas_unavailable The number of unavailable replicas per deployment.
+# TYPE kube_deployment_status_replicas_unavailable gauge
+kube_deployment_status_replicas_unavailable{deployment="echoserver",namespace="default"} 1
+`
+		err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "kube_deployment_spec_replicas", "kube_deployment_status_replicas_available", "kube_deployment_status_replicas_unavailable")
+		require.NoError(t, err)
+	})
+
+	t.Run("should return node metrics", func(t *testing.T) {
+		expected := `
+# HELP kube_node_status_condition The condition of a cluster node.
+# TYPE kube_node_status_condition gauge
+kube_node_status_condition{condition="Ready",node="node1",status="false"} 0
+kube_node_status_condition{condition="Ready",node="node1",status="true"} 1
+kube_node_status_condition{condition="Ready",node="node1",status="unknown"} 0
+`
+		err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "kube_node_status_condition")
+		require.NoError(t, err)
+	})
+
+	t.Run("should only return metrics for allowed namespaces", func(t *testing.T) {
+		restrictedClientset := fake.NewClientset(
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "echoserver", Namespace: "default"},
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{{Name: "echoserver", RestartCount: 2}},
+				},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "echoserver", Namespace: "team-a"},
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{{Name: "echoserver", RestartCount: 1}},
+				},
+			},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "echoserver", Namespace: "default"}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		)
+
+		namespaces, err := newNamespaceRestriction([]string{"team-a"}, true)
+		require.NoError(t, err)
+
+		restrictedCollector := &metricsCollector{
+			clientset:  restrictedClientset,
+			namespaces: namespaces,
+			logger:     log.DefaultLogger,
+		}
+
+		expected := `
+# HELP kube_pod_container_status_restarts_total The number of container restarts per container.
+# TYPE kube_pod_container_status_restarts_total counter
+kube_pod_container_status_restarts_total{container="echoserver",namespace="team-a",pod="echoserver"} 1
+`
+		err = testutil.CollectAndCompare(restrictedCollector, strings.NewReader(expected), "kube_pod_container_status_restarts_total")
+		require.NoError(t, err)
+		require.Equal(t, 0, testutil.CollectAndCount(restrictedCollector, "kube_deployment_spec_replicas", "kube_node_status_condition"))
+	})
+}
+
+func TestMetricsHandler(t *testing.T) {
+	newCollector := func() *metricsCollector {
+		return &metricsCollector{clientset: fake.NewClientset(), logger: log.DefaultLogger}
+	}
+
+	t.Run("should return error without token", func(t *testing.T) {
+		_, err := newMetricsHandler(newCollector(), "")
+		require.Error(t, err)
+	})
+
+	t.Run("should require token", func(t *testing.T) {
+		handler, err := newMetricsHandler(newCollector(), "secret")
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/kube-state-metrics", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		r := httptest.NewRequest(http.MethodGet, "/kube-state-metrics", nil)
+		r.Header.Set("Authorization", "Bearer invalid")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		r = httptest.NewRequest(http.MethodGet, "/kube-state-metrics", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
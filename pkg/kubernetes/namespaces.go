@@ -0,0 +1,209 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// namespacesResource is the resource which is used in the returned errors,
+// when a namespace is checked without a specific resource.
+var namespacesResource = schema.GroupResource{Resource: "namespaces"}
+
+// namespaceRestriction restricts the namespaces which can be accessed through
+// the datasource. This allows to use one datasource per team, even if the
+// credentials of the datasource can access the whole cluster. Cluster-scoped
+// resources can optionally be hidden.
+//
+// All methods can be called on a nil restriction, which is used when no
+// namespaces are configured and cluster-scoped resources are not hidden.
+type namespaceRestriction struct {
+	patterns          []*regexp.Regexp
+	hideClusterScoped bool
+}
+
+// newNamespaceRestriction returns the restriction for the provided namespace
+// patterns. The patterns are regular expressions, which must match the whole
+// namespace name. If no patterns are provided and cluster-scoped resources are
+// not hidden, no restriction is returned.
+func newNamespaceRestriction(patterns []string, hideClusterScoped bool) (*namespaceRestriction, error) {
+	if len(patterns) == 0 && !hideClusterScoped {
+		return nil, nil
+	}
+
+	n := &namespaceRestriction{hideClusterScoped: hideClusterScoped}
+	for _, pattern := range patterns {
+		r, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed namespace %s: %w", pattern, err)
+		}
+		n.patterns = append(n.patterns, r)
+	}
+
+	return n, nil
+}
+
+// isAllNamespaces returns true, when the namespace parameter of a query selects
+// all namespaces.
+func isAllNamespaces(namespace string) bool {
+	return namespace == "" || namespace == "*" || namespace == ".*" || namespace == ".+"
+}
+
+// isRestricted returns true, when the namespaces are restricted.
+func (n *namespaceRestriction) isRestricted() bool {
+	return n != nil && len(n.patterns) > 0
+}
+
+// isAllowed returns true, when the provided namespace is allowed. An empty
+// namespace, which selects all namespaces, is only allowed when the namespaces
+// are not restricted.
+func (n *namespaceRestriction) isAllowed(namespace string) bool {
+	if !n.isRestricted() {
+		return true
+	}
+	if namespace == "" {
+		return false
+	}
+
+	return slices.ContainsFunc(n.patterns, func(pattern *regexp.Regexp) bool {
+		return pattern.MatchString(namespace)
+	})
+}
+
+// filter returns all allowed namespaces from the provided list.
+func (n *namespaceRestriction) filter(namespaces []string) []string {
+	if !n.isRestricted() {
+		return namespaces
+	}
+
+	var allowed []string
+	for _, namespace := range namespaces {
+		if n.isAllowed(namespace) {
+			allowed = append(allowed, namespace)
+		}
+	}
+	return allowed
+}
+
+// authorize returns an error if the provided resource can not be accessed in
+// the provided namespace. The error is a Kubernetes "Forbidden" status error,
+// so that it is handled like an error returned by the Kubernetes API.
+func (n *namespaceRestriction) authorize(resource schema.GroupResource, namespaced bool, namespace string) error {
+	if n == nil {
+		return nil
+	}
+
+	if !namespaced {
+		if n.hideClusterScoped {
+			return apierrors.NewForbidden(resource, "", fmt.Errorf("cluster-scoped resources are hidden by the datasource"))
+		}
+		return nil
+	}
+
+	if !n.isAllowed(namespace) {
+		if namespace == "" {
+			return apierrors.NewForbidden(resource, "", fmt.Errorf("access to all namespaces is not allowed by the datasource"))
+		}
+		return apierrors.NewForbidden(resource, "", fmt.Errorf("namespace %s is not allowed by the datasource", namespace))
+	}
+
+	return nil
+}
+
+// authorizeRequest returns an error if the request for the provided method and
+// url accesses a namespace or cluster-scoped resource, which is not allowed.
+// The provided function is used to check if a resource without a namespace in
+// the url is cluster-scoped or if all namespaces are requested. Unknown
+// resources are handled like namespaced resources.
+func (n *namespaceRestriction) authorizeRequest(method, requestUrl string, isNamespaced func(group, resource string) bool) error {
+	if n == nil {
+		return nil
+	}
+
+	info, err := newRequestInfo(method, requestUrl)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsResourceRequest {
+		return nil
+	}
+
+	resource := schema.GroupResource{Group: info.APIGroup, Resource: info.Resource}
+
+	// The url of a namespace (e.g. "/api/v1/namespaces/default") is parsed
+	// with the name of the namespace as namespace, so that it is handled like
+	// a namespaced resource. Requests for the list of all namespaces are
+	// denied when the namespaces are restricted, because the list would
+	// contain the names of all namespaces. Otherwise they are handled like
+	// requests for a cluster-scoped resource.
+	if info.Namespace != "" {
+		return n.authorize(resource, true, info.Namespace)
+	}
+	if info.APIGroup == "" && info.Resource == "namespaces" {
+		return n.authorize(resource, n.isRestricted(), "")
+	}
+
+	return n.authorize(resource, isNamespaced(info.APIGroup, info.Resource), "")
+}
+
+// resolveNamespaces returns the namespaces for the namespace parameter of a
+// query, which can be a single namespace, multiple namespaces in the form
+// "namespace1,namespace2,..." or "*" for all namespaces. If all namespaces are
+// requested and the namespaces are restricted, the allowed namespaces are
+// returned instead, so that the requests can be run for each allowed
+// namespace. If all namespaces are allowed, a list with an empty namespace is
+// returned.
+func (c *client) resolveNamespaces(ctx context.Context, resource schema.GroupResource, namespaced bool, namespace string) ([]string, error) {
+	if !namespaced {
+		if err := c.namespaces.authorize(resource, false, ""); err != nil {
+			return nil, err
+		}
+		return []string{""}, nil
+	}
+
+	if !isAllNamespaces(namespace) {
+		namespaces := strings.Split(namespace, ",")
+		for _, namespace := range namespaces {
+			if err := c.namespaces.authorize(resource, true, namespace); err != nil {
+				return nil, err
+			}
+		}
+		return namespaces, nil
+	}
+
+	if !c.namespaces.isRestricted() {
+		return []string{""}, nil
+	}
+
+	namespaceList, err := c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, ns := range namespaceList.Items {
+		names = append(names, ns.Name)
+	}
+
+	allowed := c.namespaces.filter(names)
+	if len(allowed) == 0 {
+		return nil, apierrors.NewForbidden(resource, "", fmt.Errorf("no namespace is allowed by the datasource"))
+	}
+
+	return allowed, nil
+}
+
+// resourceGroupResource returns the group and resource of the provided
+// resource, which are used in the errors returned by the namespace
+// restriction.
+func resourceGroupResource(resource Resource) schema.GroupResource {
+	gv, _ := schema.ParseGroupVersion(resource.APIVersion)
+	return schema.GroupResource{Group: gv.Group, Resource: resource.Name}
+}
@@ -0,0 +1,250 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestNamespaceRestriction(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	nodes := schema.GroupResource{Resource: "nodes"}
+
+	t.Run("should not return restriction without settings", func(t *testing.T) {
+		n, err := newNamespaceRestriction(nil, false)
+		require.NoError(t, err)
+		require.Nil(t, n)
+
+		require.True(t, n.isAllowed(""))
+		require.Equal(t, []string{"default", "kube-system"}, n.filter([]string{"default", "kube-system"}))
+		require.NoError(t, n.authorize(pods, true, ""))
+		require.NoError(t, n.authorize(nodes, false, ""))
+	})
+
+	t.Run("should fail for invalid pattern", func(t *testing.T) {
+		_, err := newNamespaceRestriction([]string{"["}, false)
+		require.Error(t, err)
+	})
+
+	t.Run("should match whole namespace name", func(t *testing.T) {
+		n, err := newNamespaceRestriction([]string{"team-a", "team-a-.*"}, false)
+		require.NoError(t, err)
+
+		require.True(t, n.isAllowed("team-a"))
+		require.True(t, n.isAllowed("team-a-staging"))
+		require.False(t, n.isAllowed("team-ab"))
+		require.False(t, n.isAllowed("my-team-a"))
+		require.False(t, n.isAllowed(""))
+		require.Equal(t, []string{"team-a", "team-a-staging"}, n.filter([]string{"default", "team-a", "team-a-staging", "team-b"}))
+	})
+
+	t.Run("should authorize namespaces and cluster-scoped resources", func(t *testing.T) {
+		n, err := newNamespaceRestriction([]string{"team-a"}, false)
+		require.NoError(t, err)
+
+		require.NoError(t, n.authorize(pods, true, "team-a"))
+		require.NoError(t, n.authorize(nodes, false, ""))
+
+		err = n.authorize(pods, true, "kube-system")
+		require.True(t, apierrors.IsForbidden(err))
+		require.EqualError(t, err, `pods is forbidden: namespace kube-system is not allowed by the datasource`)
+
+		err = n.authorize(pods, true, "")
+		require.True(t, apierrors.IsForbidden(err))
+
+		hidden, err := newNamespaceRestriction(nil, true)
+		require.NoError(t, err)
+		require.NoError(t, hidden.authorize(pods, true, ""))
+		require.True(t, apierrors.IsForbidden(hidden.authorize(nodes, false, "")))
+	})
+
+	t.Run("should authorize requests", func(t *testing.T) {
+		n, err := newNamespaceRestriction([]string{"team-a"}, true)
+		require.NoError(t, err)
+
+		isNamespaced := func(group, resource string) bool {
+			return resource != "nodes" && resource != "clusterroles"
+		}
+
+		require.NoError(t, n.authorizeRequest(http.MethodGet, "/api", isNamespaced))
+		require.NoError(t, n.authorizeRequest(http.MethodGet, "/api/v1/namespaces/team-a/pods", isNamespaced))
+		require.NoError(t, n.authorizeRequest(http.MethodGet, "/api/v1/namespaces/team-a", isNamespaced))
+		require.NoError(t, n.authorizeRequest(http.MethodPatch, "/apis/apps/v1/namespaces/team-a/deployments/echoserver/scale", isNamespaced))
+
+		require.True(t, apierrors.IsForbidden(n.authorizeRequest(http.MethodGet, "/api/v1/namespaces/kube-system/pods", isNamespaced)))
+		require.True(t, apierrors.IsForbidden(n.authorizeRequest(http.MethodGet, "/api/v1/namespaces/kube-system", isNamespaced)))
+		require.True(t, apierrors.IsForbidden(n.authorizeRequest(http.MethodGet, "/api/v1/pods", isNamespaced)))
+		require.True(t, apierrors.IsForbidden(n.authorizeRequest(http.MethodGet, "/api/v1/namespaces", isNamespaced)))
+		require.True(t, apierrors.IsForbidden(n.authorizeRequest(http.MethodGet, "/api/v1/nodes", isNamespaced)))
+		require.True(t, apierrors.IsForbidden(n.authorizeRequest(http.MethodGet, "/apis/rbac.authorization.k8s.io/v1/clusterroles", isNamespaced)))
+	})
+
+	t.Run("should deny list of all namespaces when namespaces are restricted", func(t *testing.T) {
+		isNamespaced := func(group, resource string) bool {
+			return resource != "nodes" && resource != "clusterroles"
+		}
+
+		restricted, err := newNamespaceRestriction([]string{"team-a"}, false)
+		require.NoError(t, err)
+		require.True(t, apierrors.IsForbidden(restricted.authorizeRequest(http.MethodGet, "/api/v1/namespaces", isNamespaced)))
+		require.NoError(t, restricted.authorizeRequest(http.MethodGet, "/api/v1/namespaces/team-a", isNamespaced))
+		require.NoError(t, restricted.authorizeRequest(http.MethodGet, "/api/v1/nodes", isNamespaced))
+
+		hidden, err := newNamespaceRestriction(nil, true)
+		require.NoError(t, err)
+		require.True(t, apierrors.IsForbidden(hidden.authorizeRequest(http.MethodGet, "/api/v1/namespaces", isNamespaced)))
+	})
+}
+
+func TestResolveNamespaces(t *testing.T) {
+	clientset := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a-staging"}},
+	)
+	pods := schema.GroupResource{Resource: "pods"}
+	nodes := schema.GroupResource{Resource: "nodes"}
+
+	t.Run("should return all namespaces without restriction", func(t *testing.T) {
+		c := &client{clientset: clientset}
+
+		namespaces, err := c.resolveNamespaces(context.Background(), pods, true, "*")
+		require.NoError(t, err)
+		require.Equal(t, []string{""}, namespaces)
+
+		namespaces, err = c.resolveNamespaces(context.Background(), pods, true, "default,team-a")
+		require.NoError(t, err)
+		require.Equal(t, []string{"default", "team-a"}, namespaces)
+
+		namespaces, err = c.resolveNamespaces(context.Background(), nodes, false, "default")
+		require.NoError(t, err)
+		require.Equal(t, []string{""}, namespaces)
+	})
+
+	t.Run("should expand all namespaces to allowed namespaces", func(t *testing.T) {
+		n, err := newNamespaceRestriction([]string{"team-a.*"}, false)
+		require.NoError(t, err)
+		c := &client{clientset: clientset, namespaces: n}
+
+		for _, namespace := range []string{"", "*", ".*", ".+"} {
+			namespaces, err := c.resolveNamespaces(context.Background(), pods, true, namespace)
+			require.NoError(t, err)
+			require.Equal(t, []string{"team-a", "team-a-staging"}, namespaces)
+		}
+
+		namespaces, err := c.resolveNamespaces(context.Background(), pods, true, "team-a-staging")
+		require.NoError(t, err)
+		require.Equal(t, []string{"team-a-staging"}, namespaces)
+
+		_, err = c.resolveNamespaces(context.Background(), pods, true, "team-a,default")
+		require.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("should fail when no namespace is allowed", func(t *testing.T) {
+		n, err := newNamespaceRestriction([]string{"team-b"}, false)
+		require.NoError(t, err)
+		c := &client{clientset: clientset, namespaces: n}
+
+		_, err = c.resolveNamespaces(context.Background(), pods, true, "*")
+		require.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("should fail for hidden cluster-scoped resources", func(t *testing.T) {
+		n, err := newNamespaceRestriction(nil, true)
+		require.NoError(t, err)
+		c := &client{clientset: clientset, namespaces: n}
+
+		_, err = c.resolveNamespaces(context.Background(), nodes, false, "")
+		require.True(t, apierrors.IsForbidden(err))
+	})
+}
+
+func TestNamespaceRestrictionClient(t *testing.T) {
+	n, err := newNamespaceRestriction([]string{"team-a"}, true)
+	require.NoError(t, err)
+
+	c := &client{
+		logger: log.DefaultLogger,
+		cache: NewCache(map[string]Resource{
+			"pod":  {ID: "pod", Kind: "Pod", APIVersion: "v1", Name: "pods", Path: "/api/v1", Namespaced: true},
+			"node": {ID: "node", Kind: "Node", APIVersion: "v1", Name: "nodes", Path: "/api/v1", Namespaced: false},
+		}),
+		clientset:  fake.NewClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}}),
+		namespaces: n,
+	}
+
+	t.Run("should hide cluster-scoped resources", func(t *testing.T) {
+		frame, err := c.GetResourceIds(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, frame.Rows())
+		require.Equal(t, "pod", frame.Fields[0].At(0))
+	})
+
+	t.Run("should only return allowed namespaces", func(t *testing.T) {
+		frame, err := c.GetNamespaces(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, frame.Rows())
+		require.Equal(t, "team-a", frame.Fields[0].At(0))
+	})
+
+	t.Run("should authorize namespace", func(t *testing.T) {
+		require.NoError(t, c.AuthorizeNamespace("team-a"))
+		require.True(t, apierrors.IsForbidden(c.AuthorizeNamespace("team-b")))
+		require.True(t, apierrors.IsForbidden(c.AuthorizeNamespace("")))
+	})
+
+	t.Run("should deny node actions for hidden cluster-scoped resources", func(t *testing.T) {
+		_, err := c.RunNodeAction(context.Background(), "admin", nil, "node1", NodeActionCordon, NodeDrainOptions{})
+		require.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("should deny stats for single nodes for hidden cluster-scoped resources", func(t *testing.T) {
+		_, err := c.GetStats(context.Background(), "admin", nil, "node1", "team-a", "", StatsLevelPod, StatsMetricCPU, backend.TimeRange{})
+		require.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("should deny proxy requests for other namespaces", func(t *testing.T) {
+		testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer testServer.Close()
+		c.restConfig = &rest.Config{Host: testServer.URL}
+
+		for requestUrl, statusCode := range map[string]int{
+			"api/v1/namespaces/team-a/pods": http.StatusOK,
+			"api/v1/namespaces/team-b/pods": http.StatusForbidden,
+			"api/v1/pods":                   http.StatusForbidden,
+			"api/v1/nodes":                  http.StatusForbidden,
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/"+requestUrl, nil)
+			w := httptest.NewRecorder()
+
+			c.Proxy("", nil, requestUrl, w, req)
+			require.Equal(t, statusCode, w.Code, requestUrl)
+		}
+	})
+}
+
+func TestNamespaceRestrictionDrain(t *testing.T) {
+	n, err := newNamespaceRestriction([]string{"team-a"}, false)
+	require.NoError(t, err)
+
+	c := &client{logger: log.DefaultLogger, namespaces: n}
+
+	// Draining a node evicts the pods in all namespaces, so that the drain
+	// must be denied before the node is cordoned.
+	_, err = c.RunNodeAction(context.Background(), "admin", nil, "node1", NodeActionDrain, NodeDrainOptions{})
+	require.True(t, apierrors.IsForbidden(err))
+	require.Contains(t, err.Error(), "drain is not allowed")
+}
@@ -0,0 +1,274 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	NodeActionCordon   = "cordon"
+	NodeActionUncordon = "uncordon"
+	NodeActionDrain    = "drain"
+)
+
+const (
+	NodeDrainPodStatusEvicted  = "Evicted"
+	NodeDrainPodStatusEvict    = "Evict"
+	NodeDrainPodStatusSkipped  = "Skipped"
+	NodeDrainPodStatusRefused  = "Refused"
+	NodeDrainPodStatusBlocked  = "Blocked"
+	NodeDrainPodStatusFailed   = "Failed"
+	NodeDrainPodStatusTimedOut = "TimedOut"
+)
+
+const (
+	defaultNodeDrainTimeout = 5 * time.Minute
+	nodeDrainRetryInterval  = 5 * time.Second
+)
+
+// mirrorPodAnnotation is the annotation which is set by the kubelet on mirror
+// pods of static pods. These pods can not be evicted via the API server.
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+var nodesResource = schema.GroupResource{Resource: "nodes"}
+
+// NodeDrainOptions are the options for draining a node. If the grace period is
+// not set, the termination grace period of the pods is used. If the timeout is
+// not set, the default timeout of 5 minutes is used. When dry run is enabled,
+// the node is not cordoned and no pods are evicted, instead the result
+// contains the pods which would be evicted and the pods which would be
+// blocked by a PodDisruptionBudget.
+//
+// Like "kubectl drain", pods which are not managed by a controller are only
+// evicted when force is enabled, because they are not recreated, and pods with
+// emptyDir volumes are only evicted when deleteEmptyDirData is enabled,
+// because the data of the volumes is lost.
+//
+// If a progress function is set, it is called with the result of each pod as
+// soon as the eviction of the pod is finished, so that the progress of a long
+// running drain can be reported to the user.
+type NodeDrainOptions struct {
+	GracePeriodSeconds *int64                          `json:"gracePeriodSeconds"`
+	TimeoutSeconds     int64                           `json:"timeoutSeconds"`
+	DryRun             bool                            `json:"dryRun"`
+	Force              bool                            `json:"force"`
+	DeleteEmptyDirData bool                            `json:"deleteEmptyDirData"`
+	Progress           func(result NodeDrainPodResult) `json:"-"`
+}
+
+// NodeActionResult is the result of a node action. For the "drain" action it
+// contains the result for each pod which was running on the node.
+type NodeActionResult struct {
+	Node          string               `json:"node"`
+	Action        string               `json:"action"`
+	DryRun        bool                 `json:"dryRun"`
+	Unschedulable bool                 `json:"unschedulable"`
+	Pods          []NodeDrainPodResult `json:"pods,omitempty"`
+}
+
+// NodeDrainPodResult is the result of the eviction of a single pod. If the
+// eviction is (or would be) blocked by a PodDisruptionBudget, the name of the
+// budget is set.
+type NodeDrainPodResult struct {
+	Namespace           string `json:"namespace"`
+	Name                string `json:"name"`
+	Status              string `json:"status"`
+	Message             string `json:"message,omitempty"`
+	PodDisruptionBudget string `json:"podDisruptionBudget,omitempty"`
+}
+
+// newNodeActionPatch returns the JSON merge patch to cordon or uncordon a
+// node. Draining a node also cordons it first.
+func newNodeActionPatch(action string) ([]byte, error) {
+	switch action {
+	case NodeActionCordon, NodeActionDrain:
+		return []byte(`{"spec":{"unschedulable":true}}`), nil
+	case NodeActionUncordon:
+		return []byte(`{"spec":{"unschedulable":null}}`), nil
+	default:
+		return nil, fmt.Errorf("action %s is not supported", action)
+	}
+}
+
+// skipDrainReason returns the reason why a pod is not evicted during a drain
+// or an empty string if the pod should be evicted. Like "kubectl drain" we
+// skip pods which are managed by a DaemonSet, because the DaemonSet
+// controller ignores the unschedulable flag and would recreate them, and
+// mirror pods, which can not be deleted via the API server.
+func skipDrainReason(pod corev1.Pod) string {
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return "pod is a mirror pod"
+	}
+
+	for _, ownerReference := range pod.OwnerReferences {
+		if ownerReference.Controller != nil && *ownerReference.Controller && ownerReference.Kind == "DaemonSet" {
+			return "pod is managed by a DaemonSet"
+		}
+	}
+
+	if pod.DeletionTimestamp != nil {
+		return "pod is already terminating"
+	}
+
+	return ""
+}
+
+// refuseDrainReason returns the reason why a pod can not be evicted with the
+// provided options or an empty string if the pod can be evicted. Like
+// "kubectl drain" we refuse to evict pods with emptyDir volumes without the
+// "deleteEmptyDirData" option and pods which are not managed by a controller
+// without the "force" option. Pods which are not running anymore can always be
+// evicted.
+func refuseDrainReason(pod corev1.Pod, options NodeDrainOptions) string {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return ""
+	}
+
+	if !options.DeleteEmptyDirData {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.EmptyDir != nil {
+				return "pod uses emptyDir volumes, which would be deleted (use deleteEmptyDirData to override)"
+			}
+		}
+	}
+
+	if !options.Force && metav1.GetControllerOf(&pod) == nil {
+		return "pod is not managed by a controller and would be deleted permanently (use force to override)"
+	}
+
+	return ""
+}
+
+// matchingDisruptionBudgets returns the names of all PodDisruptionBudgets in
+// the namespace of the pod, which are selecting the pod.
+func matchingDisruptionBudgets(pod corev1.Pod, pdbs []policyv1.PodDisruptionBudget) []string {
+	var names []string
+
+	for _, pdb := range pdbs {
+		if pdb.Namespace != pod.Namespace || pdb.Spec.Selector == nil {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() {
+			continue
+		}
+
+		if selector.Matches(labels.Set(pod.Labels)) {
+			names = append(names, pdb.Name)
+		}
+	}
+
+	return names
+}
+
+// planNodeDrain returns the planned result for each pod on a node. Pods which
+// are skipped get the "Skipped" status and pods which can not be evicted with
+// the provided options get the "Refused" status. For all other pods we
+// simulate the eviction against the allowed disruptions of the matching
+// PodDisruptionBudgets: Each planned eviction consumes one allowed disruption
+// and pods which exceed the allowed disruptions of a budget get the "Blocked"
+// status and the name of the budget.
+func planNodeDrain(pods []corev1.Pod, pdbs []policyv1.PodDisruptionBudget, options NodeDrainOptions) []NodeDrainPodResult {
+	disruptionsAllowed := make(map[string]int32)
+	for _, pdb := range pdbs {
+		disruptionsAllowed[pdb.Namespace+"/"+pdb.Name] = pdb.Status.DisruptionsAllowed
+	}
+
+	var results []NodeDrainPodResult
+
+	for _, pod := range pods {
+		result := NodeDrainPodResult{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Status:    NodeDrainPodStatusEvict,
+		}
+
+		if reason := skipDrainReason(pod); reason != "" {
+			result.Status = NodeDrainPodStatusSkipped
+			result.Message = reason
+			results = append(results, result)
+			continue
+		}
+
+		if reason := refuseDrainReason(pod, options); reason != "" {
+			result.Status = NodeDrainPodStatusRefused
+			result.Message = reason
+			results = append(results, result)
+			continue
+		}
+
+		// Pods which are not running do not count against the budget, so that
+		// they can always be evicted.
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			results = append(results, result)
+			continue
+		}
+
+		budgets := matchingDisruptionBudgets(pod, pdbs)
+		for _, budget := range budgets {
+			if disruptionsAllowed[pod.Namespace+"/"+budget] <= 0 {
+				result.Status = NodeDrainPodStatusBlocked
+				result.Message = "eviction would violate the pod's disruption budget"
+				result.PodDisruptionBudget = budget
+				break
+			}
+		}
+
+		if result.Status == NodeDrainPodStatusEvict {
+			for _, budget := range budgets {
+				disruptionsAllowed[pod.Namespace+"/"+budget]--
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// nodeDrainRefusedError returns an error, when the planned drain contains pods
+// which can not be evicted with the provided options. Like "kubectl drain" the
+// drain is aborted before any pod is evicted in this case.
+func nodeDrainRefusedError(node string, results []NodeDrainPodResult) error {
+	pods := nodeDrainPodsWithStatus(results, func(status string) bool {
+		return status == NodeDrainPodStatusRefused
+	})
+	if len(pods) == 0 {
+		return nil
+	}
+
+	return apierrors.NewBadRequest(fmt.Sprintf("cannot drain node %s, because the following pods can not be evicted without the force or deleteEmptyDirData option: %s", node, strings.Join(pods, ", ")))
+}
+
+// nodeDrainIncompleteError returns an error, when not all pods of a drain were
+// evicted, e.g. because an eviction was blocked by a PodDisruptionBudget until
+// the timeout was reached.
+func nodeDrainIncompleteError(node string, results []NodeDrainPodResult) error {
+	pods := nodeDrainPodsWithStatus(results, func(status string) bool {
+		return status != NodeDrainPodStatusEvicted && status != NodeDrainPodStatusSkipped
+	})
+	if len(pods) == 0 {
+		return nil
+	}
+
+	return apierrors.NewConflict(nodesResource, node, fmt.Errorf("drain is incomplete, because the following pods were not evicted: %s", strings.Join(pods, ", ")))
+}
+
+func nodeDrainPodsWithStatus(results []NodeDrainPodResult, matches func(status string) bool) []string {
+	var pods []string
+	for _, result := range results {
+		if matches(result.Status) {
+			pods = append(pods, result.Namespace+"/"+result.Name)
+		}
+	}
+	return pods
+}
@@ -0,0 +1,121 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestNewNodeActionPatch(t *testing.T) {
+	patch, err := newNodeActionPatch(NodeActionDrain)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"spec":{"unschedulable":true}}`, string(patch))
+
+	patch, err = newNodeActionPatch(NodeActionUncordon)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"spec":{"unschedulable":null}}`, string(patch))
+
+	_, err = newNodeActionPatch("reboot")
+	require.Error(t, err)
+}
+
+func TestPlanNodeDrain(t *testing.T) {
+	newPod := func(name string, labels map[string]string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				Labels:          labels,
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: name + "-rs", Controller: ptr.To(true)}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	daemonSetPod := newPod("fluent-bit-1", nil)
+	daemonSetPod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "fluent-bit", Controller: ptr.To(true)}}
+
+	mirrorPod := newPod("kube-apiserver", nil)
+	mirrorPod.Annotations = map[string]string{mirrorPodAnnotation: "1"}
+
+	completedPod := newPod("job-1", map[string]string{"app": "echoserver"})
+	completedPod.OwnerReferences = nil
+	completedPod.Spec.Volumes = []corev1.Volume{{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+	completedPod.Status.Phase = corev1.PodSucceeded
+
+	barePod := newPod("debug", nil)
+	barePod.OwnerReferences = nil
+
+	emptyDirPod := newPod("cache-1", nil)
+	emptyDirPod.Spec.Volumes = []corev1.Volume{{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+
+	pdbs := []policyv1.PodDisruptionBudget{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "echoserver", Namespace: "default"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "echoserver"}}},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 1},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "echoserver", Namespace: "kube-system"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}}},
+		},
+	}
+
+	pods := []corev1.Pod{
+		daemonSetPod,
+		mirrorPod,
+		completedPod,
+		newPod("echoserver-1", map[string]string{"app": "echoserver"}),
+		newPod("echoserver-2", map[string]string{"app": "echoserver"}),
+		newPod("nginx-1", map[string]string{"app": "nginx"}),
+		barePod,
+		emptyDirPod,
+	}
+
+	t.Run("should refuse pods without controller and with emptyDir volumes", func(t *testing.T) {
+		results := planNodeDrain(pods, pdbs, NodeDrainOptions{})
+
+		require.Equal(t, []NodeDrainPodResult{
+			{Namespace: "default", Name: "fluent-bit-1", Status: NodeDrainPodStatusSkipped, Message: "pod is managed by a DaemonSet"},
+			{Namespace: "default", Name: "kube-apiserver", Status: NodeDrainPodStatusSkipped, Message: "pod is a mirror pod"},
+			{Namespace: "default", Name: "job-1", Status: NodeDrainPodStatusEvict},
+			{Namespace: "default", Name: "echoserver-1", Status: NodeDrainPodStatusEvict},
+			{Namespace: "default", Name: "echoserver-2", Status: NodeDrainPodStatusBlocked, Message: "eviction would violate the pod's disruption budget", PodDisruptionBudget: "echoserver"},
+			{Namespace: "default", Name: "nginx-1", Status: NodeDrainPodStatusEvict},
+			{Namespace: "default", Name: "debug", Status: NodeDrainPodStatusRefused, Message: "pod is not managed by a controller and would be deleted permanently (use force to override)"},
+			{Namespace: "default", Name: "cache-1", Status: NodeDrainPodStatusRefused, Message: "pod uses emptyDir volumes, which would be deleted (use deleteEmptyDirData to override)"},
+		}, results)
+
+		err := nodeDrainRefusedError("node1", results)
+		require.Error(t, err)
+		require.Equal(t, "cannot drain node node1, because the following pods can not be evicted without the force or deleteEmptyDirData option: default/debug, default/cache-1", err.Error())
+	})
+
+	t.Run("should evict pods without controller and with emptyDir volumes", func(t *testing.T) {
+		results := planNodeDrain(pods, pdbs, NodeDrainOptions{Force: true, DeleteEmptyDirData: true})
+
+		require.Equal(t, NodeDrainPodStatusEvict, results[6].Status)
+		require.Equal(t, NodeDrainPodStatusEvict, results[7].Status)
+		require.NoError(t, nodeDrainRefusedError("node1", results))
+	})
+}
+
+func TestNodeDrainIncompleteError(t *testing.T) {
+	require.NoError(t, nodeDrainIncompleteError("node1", []NodeDrainPodResult{
+		{Namespace: "default", Name: "fluent-bit-1", Status: NodeDrainPodStatusSkipped},
+		{Namespace: "default", Name: "echoserver-1", Status: NodeDrainPodStatusEvicted},
+	}))
+
+	err := nodeDrainIncompleteError("node1", []NodeDrainPodResult{
+		{Namespace: "default", Name: "echoserver-1", Status: NodeDrainPodStatusEvicted},
+		{Namespace: "default", Name: "echoserver-2", Status: NodeDrainPodStatusTimedOut},
+		{Namespace: "default", Name: "nginx-1", Status: NodeDrainPodStatusFailed},
+	})
+	require.True(t, apierrors.IsConflict(err))
+	require.Contains(t, err.Error(), "the following pods were not evicted: default/echoserver-2, default/nginx-1")
+}
@@ -0,0 +1,245 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+const (
+	ProxyPolicyNone     = ""
+	ProxyPolicyReadOnly = "readOnly"
+	ProxyPolicyCustom   = "custom"
+)
+
+// readOnlyPolicyRules are the rules of the "readOnly" proxy policy. They allow
+// to get, list and watch all resources, but not to use subresources like
+// "exec", "attach", "portforward" or "proxy", which can be used to modify a
+// pod, even if they are requested via the "get" verb.
+var readOnlyPolicyRules = []models.ProxyPolicyRule{{
+	Verbs:        []string{"get", "list", "watch"},
+	APIGroups:    []string{"*"},
+	Resources:    []string{"*"},
+	Subresources: []string{"", "log", "status"},
+	Namespaces:   []string{"*"},
+}}
+
+// requestInfoFactory is used to get the verb, API group, resource,
+// subresource and namespace of a request against the Kubernetes API, in the
+// same way as it is done by the Kubernetes API server.
+var requestInfoFactory = &request.RequestInfoFactory{
+	APIPrefixes:          sets.NewString("api", "apis"),
+	GrouplessAPIPrefixes: sets.NewString("api"),
+}
+
+// policy restricts the requests which can be made through the proxy. The
+// rules are only allow rules, so that a request is denied if no rule matches.
+type policy struct {
+	rules []models.ProxyPolicyRule
+}
+
+// newPolicy returns the policy for the provided mode. If the mode is empty, no
+// policy is returned and all requests are allowed.
+func newPolicy(mode string, rules []models.ProxyPolicyRule) (*policy, error) {
+	p := &policy{}
+
+	switch mode {
+	case ProxyPolicyNone:
+		return nil, nil
+	case ProxyPolicyReadOnly:
+		p.rules = readOnlyPolicyRules
+	case ProxyPolicyCustom:
+		p.rules = rules
+	default:
+		return nil, fmt.Errorf("proxy policy %s is not supported", mode)
+	}
+
+	return p, nil
+}
+
+// authorize returns an error if the request for the provided method and url is
+// not allowed by the policy. The error is a Kubernetes "Forbidden" status
+// error, so that clients like kubectl can handle it. Requests for non-resource
+// urls (e.g. "/api" or "/version") are allowed for the "get" verb, because
+// they are required for the discovery of the available resources.
+func (p *policy) authorize(method, requestUrl string) error {
+	if p == nil {
+		return nil
+	}
+
+	info, err := newRequestInfo(method, requestUrl)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsResourceRequest {
+		if info.Verb == "get" || info.Verb == "head" {
+			return nil
+		}
+		return apierrors.NewForbidden(schema.GroupResource{}, "", fmt.Errorf("%s %s is not allowed by the proxy policy of the datasource", strings.ToUpper(info.Verb), info.Path))
+	}
+
+	for _, rule := range p.rules {
+		if policyRuleMatches(rule.Verbs, info.Verb) &&
+			policyRuleMatches(rule.APIGroups, info.APIGroup) &&
+			policyRuleMatches(rule.Resources, info.Resource) &&
+			policySubresourceMatches(rule.Subresources, info.Subresource) &&
+			policyRuleMatches(rule.Namespaces, info.Namespace) {
+			return nil
+		}
+	}
+
+	resource := info.Resource
+	if info.Subresource != "" {
+		resource = resource + "/" + info.Subresource
+	}
+
+	return apierrors.NewForbidden(schema.GroupResource{Group: info.APIGroup, Resource: resource}, info.Name, fmt.Errorf("%s is not allowed by the proxy policy of the datasource", info.Verb))
+}
+
+// authorizeResource returns an error if the request for the provided method,
+// resource and subresource is not allowed by the policy. It is used for the
+// actions of the plugin (e.g. apply, scale or exec), which are not made
+// through the proxy, so that the policy is applied to them in the same way as
+// to the equivalent requests through the proxy. The namespace and name can be
+// empty for cluster-scoped resources and for collections.
+func (p *policy) authorizeResource(method, apiPath, namespace, resource, name, subresource string) error {
+	if p == nil {
+		return nil
+	}
+
+	requestUrl := apiPath
+	if namespace != "" {
+		requestUrl = requestUrl + "/namespaces/" + namespace
+	}
+	requestUrl = requestUrl + "/" + resource
+	if name != "" {
+		requestUrl = requestUrl + "/" + name
+		if subresource != "" {
+			requestUrl = requestUrl + "/" + subresource
+		}
+	}
+
+	return p.authorize(method, requestUrl)
+}
+
+// newRequestInfo returns the request info for the provided method and url of a
+// request against the Kubernetes API.
+func newRequestInfo(method, requestUrl string) (*request.RequestInfo, error) {
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	info, err := requestInfoFactory.NewRequestInfo(&http.Request{Method: method, URL: parsedUrl})
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	return info, nil
+}
+
+// subresources contains the settings for the "exec", "attach", "portforward"
+// and "proxy" subresources of pods.
+type subresources map[string]models.ProxySubresource
+
+// newSubresources returns the subresource settings from the plugin settings.
+func newSubresources(config *models.PluginSettings) subresources {
+	return subresources{
+		"exec":        config.ProxyPodExec,
+		"attach":      config.ProxyPodAttach,
+		"portforward": config.ProxyPodPortForward,
+		"proxy":       config.ProxyPodProxy,
+	}
+}
+
+// authorize returns an error if the request for the provided method and url
+// is using a pod subresource, which is disabled or which is restricted to
+// teams the user is not a member of. The teams of the user are only requested
+// when they are required, to avoid unnecessary requests against the Grafana
+// API.
+func (s subresources) authorize(method, requestUrl string, getTeams func() ([]string, error)) error {
+	info, err := newRequestInfo(method, requestUrl)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsResourceRequest || info.APIGroup != "" || info.Resource != "pods" {
+		return nil
+	}
+
+	settings, ok := s[info.Subresource]
+	if !ok {
+		return nil
+	}
+
+	gr := schema.GroupResource{Resource: "pods/" + info.Subresource}
+
+	if settings.Disabled {
+		return apierrors.NewForbidden(gr, info.Name, fmt.Errorf("%s is disabled for the datasource", info.Subresource))
+	}
+
+	if len(settings.Teams) == 0 {
+		return nil
+	}
+
+	teams, err := getTeams()
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+
+	for _, team := range teams {
+		if slices.Contains(settings.Teams, team) {
+			return nil
+		}
+	}
+
+	return apierrors.NewForbidden(gr, info.Name, fmt.Errorf("%s is only allowed for members of the teams %s", info.Subresource, strings.Join(settings.Teams, ", ")))
+}
+
+// policyRuleMatches returns true if the values of a rule are matching the
+// provided value. An empty list or "*" matches all values.
+func policyRuleMatches(values []string, value string) bool {
+	return len(values) == 0 || slices.Contains(values, "*") || slices.Contains(values, value)
+}
+
+// policySubresourceMatches returns true if the subresources of a rule are
+// matching the provided subresource. Other than for the other values of a
+// rule, an empty list only matches requests without a subresource, so that a
+// rule for a resource doesn't allow subresources like "exec" by accident.
+func policySubresourceMatches(values []string, value string) bool {
+	if len(values) == 0 {
+		return value == ""
+	}
+	return slices.Contains(values, "*") || slices.Contains(values, value)
+}
+
+// WriteStatusError writes the provided error as Kubernetes status object to
+// the response writer.
+func WriteStatusError(w http.ResponseWriter, err error) {
+	var statusErr apierrors.APIStatus
+	if !errors.As(err, &statusErr) {
+		statusErr = apierrors.NewInternalError(err)
+	}
+
+	status := statusErr.Status()
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+
+	data, _ := json.Marshal(status)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(status.Code))
+	w.Write(data)
+}
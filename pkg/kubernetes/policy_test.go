@@ -0,0 +1,177 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Run("should allow all requests without policy", func(t *testing.T) {
+		p, err := newPolicy(ProxyPolicyNone, nil)
+		require.NoError(t, err)
+		require.NoError(t, p.authorize(http.MethodDelete, "/api/v1/namespaces/default/pods/echoserver"))
+	})
+
+	t.Run("should fail for unknown policy", func(t *testing.T) {
+		_, err := newPolicy("admin", nil)
+		require.Error(t, err)
+	})
+
+	t.Run("should only allow read requests for read-only policy", func(t *testing.T) {
+		p, err := newPolicy(ProxyPolicyReadOnly, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, p.authorize(http.MethodGet, "/api"))
+		require.NoError(t, p.authorize(http.MethodGet, "/apis/apps/v1/namespaces/default/deployments"))
+		require.NoError(t, p.authorize(http.MethodGet, "/api/v1/pods?watch=true"))
+		require.NoError(t, p.authorize(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver/log?follow=true"))
+
+		err = p.authorize(http.MethodDelete, "/api/v1/namespaces/default/pods/echoserver")
+		require.True(t, apierrors.IsForbidden(err))
+		require.EqualError(t, err, `pods "echoserver" is forbidden: delete is not allowed by the proxy policy of the datasource`)
+
+		err = p.authorize(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver/exec?command=sh")
+		require.True(t, apierrors.IsForbidden(err))
+
+		err = p.authorize(http.MethodPost, "/api/v1/namespaces/default/pods/echoserver/portforward")
+		require.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("should apply custom rules", func(t *testing.T) {
+		p, err := newPolicy(ProxyPolicyCustom, []models.ProxyPolicyRule{
+			{Verbs: []string{"get", "list"}, Resources: []string{"pods"}, Subresources: []string{""}, Namespaces: []string{"default"}},
+			{Verbs: []string{"patch"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Subresources: []string{"scale"}},
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, p.authorize(http.MethodGet, "/api/v1/namespaces/default/pods"))
+		require.NoError(t, p.authorize(http.MethodPatch, "/apis/apps/v1/namespaces/kube-system/deployments/coredns/scale"))
+		require.Error(t, p.authorize(http.MethodGet, "/api/v1/namespaces/kube-system/pods"))
+		require.Error(t, p.authorize(http.MethodGet, "/api/v1/pods"))
+		require.Error(t, p.authorize(http.MethodPatch, "/apis/apps/v1/namespaces/kube-system/deployments/coredns"))
+		require.Error(t, p.authorize(http.MethodPost, "/version"))
+	})
+
+	t.Run("should only match requests without subresource for empty subresources", func(t *testing.T) {
+		p, err := newPolicy(ProxyPolicyCustom, []models.ProxyPolicyRule{
+			{Verbs: []string{"*"}, Resources: []string{"pods"}},
+			{Verbs: []string{"get"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Subresources: []string{"*"}},
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, p.authorize(http.MethodDelete, "/api/v1/namespaces/default/pods/echoserver"))
+		require.True(t, apierrors.IsForbidden(p.authorize(http.MethodPost, "/api/v1/namespaces/default/pods/echoserver/exec")))
+		require.True(t, apierrors.IsForbidden(p.authorize(http.MethodPatch, "/api/v1/namespaces/default/pods/echoserver/ephemeralcontainers")))
+		require.NoError(t, p.authorize(http.MethodGet, "/apis/apps/v1/namespaces/default/deployments/echoserver/scale"))
+	})
+
+	t.Run("should authorize actions of the plugin", func(t *testing.T) {
+		p, err := newPolicy(ProxyPolicyReadOnly, nil)
+		require.NoError(t, err)
+
+		for _, err := range []error{
+			p.authorizeResource(http.MethodPatch, "/apis/apps/v1", "default", "deployments", "echoserver", ""),
+			p.authorizeResource(http.MethodPatch, "/apis/apps/v1", "default", "deployments", "echoserver", "scale"),
+			p.authorizeResource(http.MethodPatch, "/api/v1", "", "nodes", "node1", ""),
+			p.authorizeResource(http.MethodPost, "/apis/batch/v1", "default", "jobs", "", ""),
+			p.authorizeResource(http.MethodPost, "/api/v1", "default", "pods", "echoserver", "exec"),
+			p.authorizeResource(http.MethodPost, "/api/v1", "default", "pods", "echoserver", "eviction"),
+			p.authorizeResource(http.MethodPatch, "/api/v1", "default", "pods", "echoserver", "ephemeralcontainers"),
+		} {
+			require.True(t, apierrors.IsForbidden(err))
+		}
+
+		var noPolicy *policy
+		require.NoError(t, noPolicy.authorizeResource(http.MethodPost, "/api/v1", "default", "pods", "echoserver", "exec"))
+	})
+
+	t.Run("should deny actions of the plugin for read-only policy", func(t *testing.T) {
+		p, err := newPolicy(ProxyPolicyReadOnly, nil)
+		require.NoError(t, err)
+		c := &client{policy: p}
+
+		_, err = c.RunNodeAction(context.Background(), "admin", nil, "node1", NodeActionCordon, NodeDrainOptions{})
+		require.True(t, apierrors.IsForbidden(err))
+
+		_, err = c.RunJob(context.Background(), "admin", nil, "cronjob.batch", "default", "backup", JobRunOptions{})
+		require.True(t, apierrors.IsForbidden(err))
+
+		_, err = c.RollbackDeployment(context.Background(), "admin", nil, "default", "echoserver", 0, DeploymentRollbackOptions{})
+		require.True(t, apierrors.IsForbidden(err))
+
+		_, err = c.newExecutor("admin", nil, "default", "echoserver", &corev1.PodExecOptions{Command: []string{"cat", "/etc/hostname"}})
+		require.True(t, apierrors.IsForbidden(err))
+	})
+}
+
+func TestSubresources(t *testing.T) {
+	s := newSubresources(&models.PluginSettings{
+		ProxyPodExec:        models.ProxySubresource{Teams: []string{"admins"}},
+		ProxyPodAttach:      models.ProxySubresource{Disabled: true},
+		ProxyPodPortForward: models.ProxySubresource{},
+	})
+
+	getTeams := func(teams []string) func() ([]string, error) {
+		return func() ([]string, error) { return teams, nil }
+	}
+	failGetTeams := func() ([]string, error) {
+		t.Fatal("teams should not be requested")
+		return nil, nil
+	}
+
+	t.Run("should allow requests without subresource", func(t *testing.T) {
+		require.NoError(t, s.authorize(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver", failGetTeams))
+		require.NoError(t, s.authorize(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver/log", failGetTeams))
+		require.NoError(t, s.authorize(http.MethodGet, "/version", failGetTeams))
+	})
+
+	t.Run("should allow subresources without restrictions", func(t *testing.T) {
+		require.NoError(t, s.authorize(http.MethodPost, "/api/v1/namespaces/default/pods/echoserver/portforward", failGetTeams))
+		require.NoError(t, s.authorize(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver:8080/proxy/healthz", failGetTeams))
+	})
+
+	t.Run("should deny disabled subresources", func(t *testing.T) {
+		err := s.authorize(http.MethodPost, "/api/v1/namespaces/default/pods/echoserver/attach?stdin=true", failGetTeams)
+		require.True(t, apierrors.IsForbidden(err))
+		require.EqualError(t, err, `pods/attach "echoserver" is forbidden: attach is disabled for the datasource`)
+	})
+
+	t.Run("should check teams of the user", func(t *testing.T) {
+		require.NoError(t, s.authorize(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver/exec?command=sh", getTeams([]string{"developers", "admins"})))
+
+		err := s.authorize(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver/exec?command=sh", getTeams([]string{"developers"}))
+		require.True(t, apierrors.IsForbidden(err))
+		require.EqualError(t, err, `pods/exec "echoserver" is forbidden: exec is only allowed for members of the teams admins`)
+	})
+
+	t.Run("should ignore subresources of other resources", func(t *testing.T) {
+		require.NoError(t, s.authorize(http.MethodGet, "/api/v1/namespaces/default/services/echoserver:8080/proxy", failGetTeams))
+	})
+}
+
+func TestWriteStatusError(t *testing.T) {
+	p, err := newPolicy(ProxyPolicyReadOnly, nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	WriteStatusError(w, p.authorize(http.MethodDelete, "/apis/apps/v1/namespaces/default/deployments/echoserver"))
+
+	var status metav1.Status
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.Equal(t, "Status", status.Kind)
+	require.Equal(t, metav1.StatusReasonForbidden, status.Reason)
+	require.Equal(t, "apps", status.Details.Group)
+	require.Equal(t, "deployments", status.Details.Kind)
+}
@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// previewBaseRegexp matches the "href" attribute of the base element in a
+// HTML document, when it contains an absolute path.
+var previewBaseRegexp = regexp.MustCompile(`(?i)(<base\s[^>]*href=["'])(/[^"']*)(["'])`)
+
+// previewContentSecurityPolicy is set for all preview responses. The sandbox
+// ensures that the preview is handled as a unique origin by the browser, so
+// that scripts of the previewed application can not access the Grafana
+// session of the user.
+const previewContentSecurityPolicy = "sandbox allow-scripts allow-forms allow-popups"
+
+// isPreviewPortAllowed returns true if the port is in the list of allowed
+// ports. The port can be a port number or the name of a port. If the list is
+// empty, no port is allowed.
+func isPreviewPortAllowed(allowedPorts []string, port string) bool {
+	return port != "" && slices.Contains(allowedPorts, port)
+}
+
+// newPreviewAPIPath returns the path of the "proxy" subresource of the
+// requested pod or service and port, e.g.
+// "/api/v1/namespaces/default/pods/echoserver:8080/proxy".
+func newPreviewAPIPath(kind, namespace, name, port string) (string, error) {
+	if kind != "pods" && kind != "services" {
+		return "", fmt.Errorf("kind %s is not supported", kind)
+	}
+
+	return fmt.Sprintf("/api/v1/namespaces/%s/%s/%s:%s/proxy", url.PathEscape(namespace), kind, url.PathEscape(name), url.PathEscape(port)), nil
+}
+
+// rewritePreviewPath rewrites an absolute path of the previewed application to
+// the public path. The Kubernetes API server already rewrites links in HTML
+// documents and "Location" headers to the path of the "proxy" subresource, so
+// that we have to replace the API path with the public path. Paths which are
+// not prefixed with the API path are prefixed with the public path.
+func rewritePreviewPath(path, apiPath, publicPath string) string {
+	if path == apiPath || strings.HasPrefix(path, apiPath+"/") {
+		return publicPath + strings.TrimPrefix(path, apiPath)
+	}
+	if strings.HasPrefix(path, publicPath) {
+		return path
+	}
+	return publicPath + path
+}
+
+// rewritePreviewLocation rewrites the "Location" header of a preview response.
+// Relative locations and locations pointing to other hosts than the
+// Kubernetes API server are not modified.
+func rewritePreviewLocation(location, host, apiPath, publicPath string) string {
+	locationUrl, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	if locationUrl.Host != "" {
+		hostUrl, err := url.Parse(host)
+		if err != nil || locationUrl.Host != hostUrl.Host {
+			return location
+		}
+	} else if !strings.HasPrefix(locationUrl.Path, "/") {
+		return location
+	}
+
+	rewritten := rewritePreviewPath(locationUrl.EscapedPath(), apiPath, publicPath)
+	if locationUrl.RawQuery != "" {
+		rewritten = rewritten + "?" + locationUrl.RawQuery
+	}
+	if locationUrl.Fragment != "" {
+		rewritten = rewritten + "#" + locationUrl.EscapedFragment()
+	}
+	return rewritten
+}
+
+// rewritePreviewHTML rewrites all links in a HTML document, which were
+// rewritten by the Kubernetes API server to the API path, and the base
+// element, so that the links are working when the document is served under
+// the public path.
+func rewritePreviewHTML(body []byte, apiPath, publicPath string) []byte {
+	body = previewBaseRegexp.ReplaceAllFunc(body, func(match []byte) []byte {
+		parts := previewBaseRegexp.FindSubmatch(match)
+		return []byte(string(parts[1]) + rewritePreviewPath(string(parts[2]), apiPath, publicPath) + string(parts[3]))
+	})
+
+	return bytes.ReplaceAll(body, []byte(apiPath), []byte(publicPath))
+}
+
+// rewritePreviewResponse rewrites the "Location" header and the body of HTML
+// responses of the previewed application. It also sets a content security
+// policy, so that the previewed application is sandboxed.
+func rewritePreviewResponse(resp *http.Response, host, apiPath, publicPath string) error {
+	resp.Header.Set("Content-Security-Policy", previewContentSecurityPolicy)
+
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", rewritePreviewLocation(location, host, apiPath, publicPath))
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	body = rewritePreviewHTML(body, apiPath, publicPath)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package kubernetes
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPreviewAPIPath    = "/api/v1/namespaces/default/pods/echoserver:8080/proxy"
+	testPreviewPublicPath = "/api/datasources/uid/kubernetes/resources/kubernetes/preview/pods/default/echoserver/8080"
+)
+
+func TestIsPreviewPortAllowed(t *testing.T) {
+	require.True(t, isPreviewPortAllowed([]string{"8080", "http-metrics"}, "8080"))
+	require.True(t, isPreviewPortAllowed([]string{"8080", "http-metrics"}, "http-metrics"))
+	require.False(t, isPreviewPortAllowed([]string{"8080", "http-metrics"}, "9090"))
+	require.False(t, isPreviewPortAllowed(nil, "8080"))
+}
+
+func TestNewPreviewAPIPath(t *testing.T) {
+	apiPath, err := newPreviewAPIPath("pods", "default", "echoserver", "8080")
+	require.NoError(t, err)
+	require.Equal(t, testPreviewAPIPath, apiPath)
+
+	_, err = newPreviewAPIPath("nodes", "", "node1", "10250")
+	require.Error(t, err)
+}
+
+func TestRewritePreviewLocation(t *testing.T) {
+	for _, tc := range []struct {
+		location string
+		expected string
+	}{
+		{location: testPreviewAPIPath + "/login?next=%2F", expected: testPreviewPublicPath + "/login?next=%2F"},
+		{location: "/login", expected: testPreviewPublicPath + "/login"},
+		{location: "https://kubernetes.default.svc" + testPreviewAPIPath + "/", expected: testPreviewPublicPath + "/"},
+		{location: "https://accounts.google.com/login", expected: "https://accounts.google.com/login"},
+		{location: "login", expected: "login"},
+	} {
+		require.Equal(t, tc.expected, rewritePreviewLocation(tc.location, "https://kubernetes.default.svc", testPreviewAPIPath, testPreviewPublicPath))
+	}
+}
+
+func TestRewritePreviewResponse(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:   io.NopCloser(strings.NewReader(`<html><head><base href="/ui/"></head><body><a href="` + testPreviewAPIPath + `/metrics">Metrics</a></body></html>`)),
+	}
+
+	err := rewritePreviewResponse(resp, "https://kubernetes.default.svc", testPreviewAPIPath, testPreviewPublicPath)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, `<html><head><base href="`+testPreviewPublicPath+`/ui/"></head><body><a href="`+testPreviewPublicPath+`/metrics">Metrics</a></body></html>`, string(body))
+	require.Equal(t, int64(len(body)), resp.ContentLength)
+	require.Equal(t, previewContentSecurityPolicy, resp.Header.Get("Content-Security-Policy"))
+}
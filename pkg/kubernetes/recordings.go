@@ -0,0 +1,328 @@
+package kubernetes
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+const (
+	// recordingDefaultRetention is the duration for which the recordings are
+	// kept, when no retention is configured.
+	recordingDefaultRetention = 30 * 24 * time.Hour
+	// recordingMaxSize is the maximum size of a single recording. When the
+	// size is reached, all further events of the session are dropped, e.g.
+	// when a large file is copied via "kubectl cp".
+	recordingMaxSize = 100 * 1024 * 1024
+	// recordingExtension is the file extension of the recordings, which is
+	// also used by asciinema.
+	recordingExtension = ".cast"
+)
+
+var (
+	recordingIdRegexp = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[a-z0-9]{5}$`)
+	recordingResource = schema.GroupResource{Resource: "recordings"}
+)
+
+// RecordingSession is the metadata of an exec or attach session, which is
+// recorded. The user is the Grafana user which started the session.
+type RecordingSession struct {
+	User        string   `json:"user"`
+	Subresource string   `json:"subresource"`
+	Namespace   string   `json:"namespace"`
+	Pod         string   `json:"pod"`
+	Container   string   `json:"container,omitempty"`
+	Command     []string `json:"command,omitempty"`
+	TTY         bool     `json:"tty"`
+}
+
+// Recording is a recorded exec or attach session. The end time is the time of
+// the last recorded event.
+type Recording struct {
+	Id string `json:"id"`
+	RecordingSession
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Size      int64     `json:"size"`
+}
+
+// recordingHeader is the header of a recording in the asciicast v2 format. The
+// metadata of the session is added in the "kubernetes" field, which is ignored
+// by asciinema players.
+type recordingHeader struct {
+	Version   int              `json:"version"`
+	Width     int              `json:"width"`
+	Height    int              `json:"height"`
+	Timestamp int64            `json:"timestamp"`
+	Command   string           `json:"command,omitempty"`
+	Title     string           `json:"title"`
+	Session   RecordingSession `json:"kubernetes"`
+}
+
+// newRecordingSession returns the session metadata for the provided request,
+// when the request uses the "exec" or "attach" subresource of a pod. If the
+// request can not be recorded, false is returned.
+func newRecordingSession(method, requestUrl string) (RecordingSession, bool, error) {
+	info, err := newRequestInfo(method, requestUrl)
+	if err != nil {
+		return RecordingSession{}, false, err
+	}
+
+	if !info.IsResourceRequest || info.APIGroup != "" || info.Resource != "pods" || (info.Subresource != "exec" && info.Subresource != "attach") {
+		return RecordingSession{}, false, nil
+	}
+
+	parsedUrl, err := url.Parse(requestUrl)
+	if err != nil {
+		return RecordingSession{}, false, err
+	}
+	query := parsedUrl.Query()
+
+	return RecordingSession{
+		Subresource: info.Subresource,
+		Namespace:   info.Namespace,
+		Pod:         info.Name,
+		Container:   query.Get("container"),
+		Command:     query["command"],
+		TTY:         query.Get("tty") == "true" || query.Get("tty") == "1",
+	}, true, nil
+}
+
+// recording is a single recording, which is written in the asciicast v2
+// format. Each event is written as JSON array containing the seconds since
+// the start of the session, the event type ("o" for output, "i" for input
+// and "r" for resize) and the data of the event.
+type recording struct {
+	file      *os.File
+	start     time.Time
+	size      int64
+	truncated bool
+	lock      sync.Mutex
+}
+
+func (r *recording) event(eventType, data string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.truncated {
+		return nil
+	}
+
+	line, err := json.Marshal([]any{time.Since(r.start).Seconds(), eventType, data})
+	if err != nil {
+		return err
+	}
+
+	if r.size+int64(len(line))+1 > recordingMaxSize {
+		r.truncated = true
+		return nil
+	}
+
+	n, err := r.file.Write(append(line, '\n'))
+	r.size = r.size + int64(n)
+	return err
+}
+
+func (r *recording) close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.file.Close()
+}
+
+// recordingWriter writes all data as output events to a recording. It is used
+// to record the output of commands, which are run via the exec endpoint of
+// the plugin. Errors are only logged, so that a failing recording doesn't
+// abort the command.
+type recordingWriter struct {
+	recording *recording
+	logger    log.Logger
+}
+
+func (w recordingWriter) Write(p []byte) (int, error) {
+	if err := w.recording.event("o", string(p)); err != nil {
+		w.logger.Error("Failed to record output", "error", err.Error())
+	}
+	return len(p), nil
+}
+
+// recordingStore stores the recordings as files in the configured directory.
+// Each recording is stored in its own file, so that it can be downloaded and
+// replayed with asciinema.
+type recordingStore struct {
+	path      string
+	retention time.Duration
+}
+
+// create creates a new recording for the provided session and writes the
+// header of the recording.
+func (s *recordingStore) create(session RecordingSession, now time.Time) (*recording, error) {
+	id := now.UTC().Format("20060102T150405Z") + "-" + utilrand.String(5)
+
+	file, err := os.OpenFile(filepath.Join(s.path, id+recordingExtension), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(recordingHeader{
+		Version:   2,
+		Width:     80,
+		Height:    24,
+		Timestamp: now.Unix(),
+		Command:   strings.Join(session.Command, " "),
+		Title:     fmt.Sprintf("%s %s/%s by %s", session.Subresource, session.Namespace, session.Pod, session.User),
+		Session:   session,
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	n, err := file.Write(append(header, '\n'))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &recording{file: file, start: now, size: int64(n)}, nil
+}
+
+// list returns all recordings, sorted by their start time. The newest
+// recording is returned first. Files which are not valid recordings are
+// ignored.
+func (s *recordingStore) list() ([]Recording, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var recordings []Recording
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), recordingExtension)
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), recordingExtension) || !recordingIdRegexp.MatchString(id) {
+			continue
+		}
+
+		recording, err := s.read(id)
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, recording)
+	}
+
+	slices.SortFunc(recordings, func(a, b Recording) int {
+		return b.StartTime.Compare(a.StartTime)
+	})
+
+	return recordings, nil
+}
+
+// read returns the metadata of a recording from the header of the recording
+// file.
+func (s *recordingStore) read(id string) (Recording, error) {
+	file, err := os.Open(filepath.Join(s.path, id+recordingExtension))
+	if err != nil {
+		return Recording{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return Recording{}, err
+	}
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return Recording{}, err
+	}
+
+	var header recordingHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return Recording{}, err
+	}
+
+	return Recording{
+		Id:               id,
+		RecordingSession: header.Session,
+		StartTime:        time.Unix(header.Timestamp, 0),
+		EndTime:          info.ModTime(),
+		Size:             info.Size(),
+	}, nil
+}
+
+// open opens the recording with the provided id. The id is validated, so that
+// only files in the recordings directory can be opened.
+func (s *recordingStore) open(id string) (*os.File, error) {
+	if !recordingIdRegexp.MatchString(id) {
+		return nil, apierrors.NewNotFound(recordingResource, id)
+	}
+
+	file, err := os.Open(filepath.Join(s.path, id+recordingExtension))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, apierrors.NewNotFound(recordingResource, id)
+		}
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// prune removes all recordings which were not modified within the retention.
+func (s *recordingStore) prune(now time.Time) error {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), recordingExtension) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		if info.ModTime().Before(now.Add(-s.retention)) {
+			if err := os.Remove(filepath.Join(s.path, entry.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// newRecordingStore creates a new recording store. The directory for the
+// recordings is created if it doesn't exist. If no retention is set, the
+// default retention is used.
+func newRecordingStore(path string, retention time.Duration) (*recordingStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path for recordings is required")
+	}
+
+	if retention <= 0 {
+		retention = recordingDefaultRetention
+	}
+
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	return &recordingStore{path: path, retention: retention}, nil
+}
@@ -0,0 +1,420 @@
+package kubernetes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/moby/spdystream/spdy"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// maxRecordingHeaderSize is the maximum size of the response header of an
+	// upgraded request. If the header is larger, the session is not recorded.
+	maxRecordingHeaderSize = 64 * 1024
+	// maxRecordingMessageSize is the maximum size of a single WebSocket
+	// message, which is recorded.
+	maxRecordingMessageSize = 16 * 1024 * 1024
+)
+
+// recordingStreamTypes maps the channels of the WebSocket based streaming
+// protocol to the stream types, which are used by the SPDY based protocol.
+var recordingStreamTypes = map[byte]string{
+	0: corev1.StreamTypeStdin,
+	1: corev1.StreamTypeStdout,
+	2: corev1.StreamTypeStderr,
+	3: corev1.StreamTypeError,
+	4: corev1.StreamTypeResize,
+}
+
+// recordingResponseWriter is a response writer, which records the streams of
+// an exec or attach session. The streams are only recorded, when the
+// connection is upgraded, which happens in the "Hijack" method. All other
+// methods are handled by the wrapped response writer.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	store   *recordingStore
+	session RecordingSession
+	logger  log.Logger
+}
+
+func (w *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *recordingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recordingConn := &recordingConn{
+		Conn:   conn,
+		reader: rw.Reader,
+		recorder: &sessionRecorder{
+			store:   w.store,
+			session: w.session,
+			logger:  w.logger,
+		},
+	}
+
+	return recordingConn, bufio.NewReadWriter(bufio.NewReader(recordingConn), bufio.NewWriter(recordingConn)), nil
+}
+
+// recordingConn is a hijacked connection, which passes all data, which is
+// read from the client and written to the client, to the session recorder.
+// The reader is the buffered reader of the hijacked connection, so that no
+// data which was already buffered is lost.
+type recordingConn struct {
+	net.Conn
+	reader   io.Reader
+	recorder *sessionRecorder
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if n > 0 {
+		c.recorder.writeInput(p[:n])
+	}
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.recorder.writeOutput(p[:n])
+	}
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	err := c.Conn.Close()
+	c.recorder.close()
+	return err
+}
+
+// sessionRecorder parses the data which is send between the client and the
+// Kubernetes API server and writes the stdin, stdout, stderr and resize
+// streams to a recording.
+//
+// The first data which is written to the client is the response header. The
+// recording is only created, when the response header contains the "101
+// Switching Protocols" status code. Afterwards the data of both directions is
+// parsed in its own goroutine, based on the negotiated protocol (WebSocket or
+// SPDY). If the data can not be parsed, the recording is stopped, but the
+// session itself is not affected.
+type sessionRecorder struct {
+	store   *recordingStore
+	session RecordingSession
+	logger  log.Logger
+
+	header    []byte
+	disabled  bool
+	recording *recording
+	input     *io.PipeWriter
+	output    *io.PipeWriter
+	streams   map[spdy.StreamId]string
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	lock      sync.Mutex
+}
+
+func (r *sessionRecorder) writeInput(p []byte) {
+	r.lock.Lock()
+	input := r.input
+	r.lock.Unlock()
+
+	if input != nil {
+		input.Write(p)
+	}
+}
+
+func (r *sessionRecorder) writeOutput(p []byte) {
+	r.lock.Lock()
+	if r.disabled {
+		r.lock.Unlock()
+		return
+	}
+
+	if r.recording == nil {
+		r.header = append(r.header, p...)
+
+		index := bytes.Index(r.header, []byte("\r\n\r\n"))
+		if index == -1 {
+			if len(r.header) > maxRecordingHeaderSize {
+				r.disabled = true
+				r.header = nil
+			}
+			r.lock.Unlock()
+			return
+		}
+
+		p = r.header[index+4:]
+		if err := r.start(r.header[:index+4]); err != nil {
+			r.logger.Error("Failed to start recording", "error", err.Error())
+			r.disabled = true
+			r.header = nil
+			r.lock.Unlock()
+			return
+		}
+		r.header = nil
+	}
+
+	output := r.output
+	r.lock.Unlock()
+
+	if output != nil && len(p) > 0 {
+		output.Write(p)
+	}
+}
+
+// start parses the response header and creates the recording, when the
+// connection was upgraded. It must be called with the lock held.
+func (r *sessionRecorder) start(header []byte) error {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(header)), nil)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		r.disabled = true
+		return nil
+	}
+
+	var read func(reader io.Reader) error
+	switch upgrade := strings.ToLower(res.Header.Get("Upgrade")); {
+	case upgrade == "websocket":
+		base64Encoded := strings.HasPrefix(res.Header.Get("Sec-Websocket-Protocol"), "base64.")
+		read = func(reader io.Reader) error {
+			return r.readWebSocket(reader, base64Encoded)
+		}
+	case strings.HasPrefix(upgrade, "spdy/"):
+		r.streams = make(map[spdy.StreamId]string)
+		read = r.readSPDY
+	default:
+		return fmt.Errorf("protocol %s is not supported", res.Header.Get("Upgrade"))
+	}
+
+	r.recording, err = r.store.create(r.session, time.Now())
+	if err != nil {
+		return err
+	}
+
+	inputReader, inputWriter := io.Pipe()
+	outputReader, outputWriter := io.Pipe()
+	r.input = inputWriter
+	r.output = outputWriter
+
+	for _, reader := range []*io.PipeReader{inputReader, outputReader} {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			if err := read(reader); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF && err != io.ErrClosedPipe {
+				r.logger.Error("Failed to parse session stream", "error", err.Error())
+			}
+			// Drain the stream, so that the session isn't blocked, when the
+			// stream can not be parsed.
+			io.Copy(io.Discard, reader)
+		}()
+	}
+
+	return nil
+}
+
+// close stops the parsing of the streams and closes the recording, when all
+// remaining data was written.
+func (r *sessionRecorder) close() {
+	r.closeOnce.Do(func() {
+		r.lock.Lock()
+		r.disabled = true
+		recording := r.recording
+		input := r.input
+		output := r.output
+		r.lock.Unlock()
+
+		if recording == nil {
+			return
+		}
+
+		input.Close()
+		output.Close()
+
+		go func() {
+			r.wg.Wait()
+			if err := recording.close(); err != nil {
+				r.logger.Error("Failed to close recording", "error", err.Error())
+			}
+		}()
+	})
+}
+
+// record writes the data of the provided stream to the recording. The stdout
+// and stderr streams are recorded as output, the stdin stream as input and
+// the resize stream as resize event. All other streams are ignored.
+func (r *sessionRecorder) record(streamType string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	var err error
+	switch streamType {
+	case corev1.StreamTypeStdout, corev1.StreamTypeStderr:
+		err = r.recording.event("o", string(data))
+	case corev1.StreamTypeStdin:
+		err = r.recording.event("i", string(data))
+	case corev1.StreamTypeResize:
+		var size struct {
+			Width  uint16
+			Height uint16
+		}
+		if json.Unmarshal(data, &size) == nil {
+			err = r.recording.event("r", fmt.Sprintf("%dx%d", size.Width, size.Height))
+		}
+	}
+
+	if err != nil {
+		r.logger.Error("Failed to write recording event", "error", err.Error())
+	}
+}
+
+// readWebSocket reads the WebSocket messages of one direction of the
+// connection. The first byte of each message is the channel of the message,
+// the remaining bytes are the data of the stream. For the "base64" protocols
+// the channel is send as ASCII character and the data is base64 encoded.
+func (r *sessionRecorder) readWebSocket(reader io.Reader, base64Encoded bool) error {
+	return readWebSocketMessages(reader, func(message []byte) {
+		if len(message) == 0 {
+			return
+		}
+
+		channel := message[0]
+		data := message[1:]
+
+		if base64Encoded {
+			channel = channel - '0'
+			decoded, err := base64.StdEncoding.DecodeString(string(data))
+			if err != nil {
+				return
+			}
+			data = decoded
+		}
+
+		if streamType, ok := recordingStreamTypes[channel]; ok {
+			r.record(streamType, data)
+		}
+	})
+}
+
+// readSPDY reads the SPDY frames of one direction of the connection. The
+// client creates a SPDY stream for each stream type, so that we have to
+// remember the stream type of each stream id, to record the data frames.
+func (r *sessionRecorder) readSPDY(reader io.Reader) error {
+	framer, err := spdy.NewFramer(io.Discard, reader)
+	if err != nil {
+		return err
+	}
+
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return err
+		}
+
+		switch frame := frame.(type) {
+		case *spdy.SynStreamFrame:
+			r.lock.Lock()
+			r.streams[frame.StreamId] = frame.Headers.Get(corev1.StreamType)
+			r.lock.Unlock()
+		case *spdy.DataFrame:
+			r.lock.Lock()
+			streamType := r.streams[frame.StreamId]
+			r.lock.Unlock()
+
+			r.record(streamType, frame.Data)
+		}
+	}
+}
+
+// readWebSocketMessages reads all WebSocket frames from the reader and calls
+// the provided function for each complete data message. Fragmented messages
+// are joined and masked frames, which are send by the client, are unmasked.
+// Control frames are ignored. The function returns when a close frame is read
+// or the reader returns an error.
+func readWebSocketMessages(reader io.Reader, fn func(message []byte)) error {
+	bufferedReader := bufio.NewReader(reader)
+
+	var message []byte
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(bufferedReader, header[:]); err != nil {
+			return err
+		}
+
+		fin := header[0]&0x80 != 0
+		opcode := header[0] & 0x0f
+		masked := header[1]&0x80 != 0
+		length := uint64(header[1] & 0x7f)
+
+		switch length {
+		case 126:
+			var extended [2]byte
+			if _, err := io.ReadFull(bufferedReader, extended[:]); err != nil {
+				return err
+			}
+			length = uint64(binary.BigEndian.Uint16(extended[:]))
+		case 127:
+			var extended [8]byte
+			if _, err := io.ReadFull(bufferedReader, extended[:]); err != nil {
+				return err
+			}
+			length = binary.BigEndian.Uint64(extended[:])
+		}
+
+		if length+uint64(len(message)) > maxRecordingMessageSize {
+			return fmt.Errorf("websocket message exceeds the maximum size of %d bytes", maxRecordingMessageSize)
+		}
+
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(bufferedReader, mask[:]); err != nil {
+				return err
+			}
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(bufferedReader, payload); err != nil {
+			return err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] = payload[i] ^ mask[i%4]
+			}
+		}
+
+		// Opcodes >= 8 are control frames (close, ping and pong), which can be
+		// send between the frames of a fragmented message.
+		if opcode >= 0x8 {
+			if opcode == 0x8 {
+				return nil
+			}
+			continue
+		}
+
+		message = append(message, payload...)
+		if fin {
+			fn(message)
+			message = nil
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package kubernetes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/moby/spdystream/spdy"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func newTestWebSocketFrame(opcode byte, fin bool, mask []byte, payload []byte) []byte {
+	var frame []byte
+
+	first := opcode
+	if fin {
+		first = first | 0x80
+	}
+	frame = append(frame, first)
+
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	default:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+
+	if mask != nil {
+		frame = append(frame, mask...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		return append(frame, masked...)
+	}
+
+	return append(frame, payload...)
+}
+
+func readTestRecording(t *testing.T, path string) (recordingHeader, [][]any) {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	require.True(t, scanner.Scan())
+
+	var header recordingHeader
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+
+	var events [][]any
+	for scanner.Scan() {
+		var event []any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+
+	return header, events
+}
+
+func TestNewRecordingSession(t *testing.T) {
+	session, ok, err := newRecordingSession(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver/exec?command=sh&command=-c&command=ls&container=echoserver&stdin=true&stdout=true&tty=true")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, RecordingSession{Subresource: "exec", Namespace: "default", Pod: "echoserver", Container: "echoserver", Command: []string{"sh", "-c", "ls"}, TTY: true}, session)
+
+	session, ok, err = newRecordingSession(http.MethodPost, "/api/v1/namespaces/default/pods/echoserver/attach?container=debugger-abcde&stdin=true")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "attach", session.Subresource)
+	require.False(t, session.TTY)
+
+	_, ok, err = newRecordingSession(http.MethodPost, "/api/v1/namespaces/default/pods/echoserver/portforward")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, ok, err = newRecordingSession(http.MethodGet, "/api/v1/namespaces/default/pods")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestRecordingStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recordings")
+
+	store, err := newRecordingStore(path, time.Hour)
+	require.NoError(t, err)
+
+	now := time.Now()
+	first, err := store.create(RecordingSession{User: "admin", Subresource: "exec", Namespace: "default", Pod: "echoserver", Command: []string{"sh"}}, now.Add(-2*time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, first.event("o", "$ "))
+	require.NoError(t, first.close())
+
+	second, err := store.create(RecordingSession{User: "editor", Subresource: "attach", Namespace: "default", Pod: "nginx"}, now)
+	require.NoError(t, err)
+	require.NoError(t, second.close())
+
+	require.NoError(t, os.WriteFile(filepath.Join(path, "notes.cast"), []byte("{}\n"), 0600))
+
+	t.Run("should list recordings", func(t *testing.T) {
+		recordings, err := store.list()
+		require.NoError(t, err)
+		require.Len(t, recordings, 2)
+		require.Equal(t, "editor", recordings[0].User)
+		require.Equal(t, "admin", recordings[1].User)
+		require.Equal(t, []string{"sh"}, recordings[1].Command)
+		require.Equal(t, now.Add(-2*time.Minute).Unix(), recordings[1].StartTime.Unix())
+	})
+
+	t.Run("should open recording", func(t *testing.T) {
+		recordings, err := store.list()
+		require.NoError(t, err)
+
+		file, err := store.open(recordings[1].Id)
+		require.NoError(t, err)
+		defer file.Close()
+
+		header, events := readTestRecording(t, file.Name())
+		require.Equal(t, 2, header.Version)
+		require.Equal(t, "sh", header.Command)
+		require.Equal(t, "exec default/echoserver by admin", header.Title)
+		require.Len(t, events, 1)
+		require.Equal(t, "o", events[0][1])
+		require.Equal(t, "$ ", events[0][2])
+	})
+
+	t.Run("should not open invalid ids", func(t *testing.T) {
+		_, err := store.open("../recordings/notes")
+		require.True(t, apierrors.IsNotFound(err))
+
+		_, err = store.open("20200101T000000Z-abcde")
+		require.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("should prune old recordings", func(t *testing.T) {
+		require.NoError(t, store.prune(now.Add(2*time.Hour)))
+
+		recordings, err := store.list()
+		require.NoError(t, err)
+		require.Empty(t, recordings)
+	})
+
+	t.Run("should require path", func(t *testing.T) {
+		_, err := newRecordingStore("", time.Hour)
+		require.Error(t, err)
+	})
+}
+
+func TestRecordPluginSession(t *testing.T) {
+	t.Run("should not record without recording store", func(t *testing.T) {
+		c := &client{logger: log.DefaultLogger}
+		require.Nil(t, c.recordPluginSession(context.Background(), "admin", "default", "echoserver", "", []string{"cat", "/etc/hostname"}))
+	})
+
+	t.Run("should record output with grafana user", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "recordings")
+		store, err := newRecordingStore(path, time.Hour)
+		require.NoError(t, err)
+
+		c := &client{logger: log.DefaultLogger, recordings: store}
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{User: &backend.User{Login: "editor"}})
+
+		recording := c.recordPluginSession(ctx, "", "default", "echoserver", "app", []string{"cat", "/etc/hostname"})
+		require.NotNil(t, recording)
+
+		n, err := recordingWriter{recording: recording, logger: c.logger}.Write([]byte("echoserver\n"))
+		require.NoError(t, err)
+		require.Equal(t, 11, n)
+		require.NoError(t, recording.close())
+
+		recordings, err := store.list()
+		require.NoError(t, err)
+		require.Len(t, recordings, 1)
+		require.Equal(t, "editor", recordings[0].User)
+		require.Equal(t, "exec", recordings[0].Subresource)
+		require.Equal(t, "app", recordings[0].Container)
+		require.Equal(t, []string{"cat", "/etc/hostname"}, recordings[0].Command)
+
+		_, events := readTestRecording(t, filepath.Join(path, recordings[0].Id+recordingExtension))
+		require.Len(t, events, 1)
+		require.Equal(t, "echoserver\n", events[0][2])
+	})
+}
+
+func TestReadWebSocketMessages(t *testing.T) {
+	var stream []byte
+	stream = append(stream, newTestWebSocketFrame(0x2, true, nil, []byte("\x01hello"))...)
+	stream = append(stream, newTestWebSocketFrame(0x2, false, []byte{1, 2, 3, 4}, []byte("\x01wor"))...)
+	stream = append(stream, newTestWebSocketFrame(0x9, true, nil, nil)...)
+	stream = append(stream, newTestWebSocketFrame(0x0, true, []byte{5, 6, 7, 8}, []byte("ld"))...)
+	stream = append(stream, newTestWebSocketFrame(0x2, true, nil, bytes.Repeat([]byte("a"), 300))...)
+	stream = append(stream, newTestWebSocketFrame(0x8, true, nil, nil)...)
+	stream = append(stream, newTestWebSocketFrame(0x2, true, nil, []byte("\x01ignored"))...)
+
+	var messages []string
+	err := readWebSocketMessages(bytes.NewReader(stream), func(message []byte) {
+		messages = append(messages, string(message))
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"\x01hello", "\x01world", string(bytes.Repeat([]byte("a"), 300))}, messages)
+}
+
+func TestSessionRecorder(t *testing.T) {
+	t.Run("should record websocket session", func(t *testing.T) {
+		path := t.TempDir()
+		store, err := newRecordingStore(path, time.Hour)
+		require.NoError(t, err)
+
+		recorder := &sessionRecorder{store: store, session: RecordingSession{User: "admin", Subresource: "exec", Namespace: "default", Pod: "echoserver"}, logger: log.DefaultLogger}
+
+		header := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-Websocket-Protocol: v5.channel.k8s.io\r\n\r\n"
+		recorder.writeOutput([]byte(header[:20]))
+		recorder.writeOutput(append([]byte(header[20:]), newTestWebSocketFrame(0x2, true, nil, []byte("\x01$ "))...))
+		recorder.writeInput(newTestWebSocketFrame(0x2, true, []byte{1, 2, 3, 4}, []byte("\x04"+`{"Width":120,"Height":40}`)))
+		recorder.writeInput(newTestWebSocketFrame(0x2, true, []byte{1, 2, 3, 4}, []byte("\x00ls\r")))
+		recorder.writeOutput(newTestWebSocketFrame(0x2, true, nil, []byte("\x02error")))
+		recorder.close()
+		recorder.wg.Wait()
+
+		recordings, err := store.list()
+		require.NoError(t, err)
+		require.Len(t, recordings, 1)
+
+		_, events := readTestRecording(t, filepath.Join(path, recordings[0].Id+recordingExtension))
+		var output, input []string
+		for _, event := range events {
+			switch event[1] {
+			case "o":
+				output = append(output, event[2].(string))
+			case "i", "r":
+				input = append(input, event[2].(string))
+			}
+		}
+		require.Equal(t, []string{"$ ", "error"}, output)
+		require.Equal(t, []string{"120x40", "ls\r"}, input)
+	})
+
+	t.Run("should record spdy session", func(t *testing.T) {
+		path := t.TempDir()
+		store, err := newRecordingStore(path, time.Hour)
+		require.NoError(t, err)
+
+		recorder := &sessionRecorder{store: store, session: RecordingSession{User: "admin", Subresource: "attach", Namespace: "default", Pod: "echoserver"}, logger: log.DefaultLogger}
+
+		var input bytes.Buffer
+		inputFramer, err := spdy.NewFramer(&input, nil)
+		require.NoError(t, err)
+		require.NoError(t, inputFramer.WriteFrame(&spdy.SynStreamFrame{StreamId: 1, Headers: http.Header{"Streamtype": []string{"stdin"}}}))
+		require.NoError(t, inputFramer.WriteFrame(&spdy.SynStreamFrame{StreamId: 3, Headers: http.Header{"Streamtype": []string{"stdout"}}}))
+		require.NoError(t, inputFramer.WriteFrame(&spdy.DataFrame{StreamId: 1, Data: []byte("whoami\n")}))
+
+		var output bytes.Buffer
+		outputFramer, err := spdy.NewFramer(&output, nil)
+		require.NoError(t, err)
+		require.NoError(t, outputFramer.WriteFrame(&spdy.SynReplyFrame{StreamId: 1, Headers: http.Header{}}))
+		require.NoError(t, outputFramer.WriteFrame(&spdy.DataFrame{StreamId: 3, Data: []byte("root\n")}))
+
+		recorder.writeOutput([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: SPDY/3.1\r\nConnection: Upgrade\r\n\r\n"))
+		recorder.writeInput(input.Bytes())
+		recorder.writeOutput(output.Bytes())
+		recorder.close()
+		recorder.wg.Wait()
+
+		recordings, err := store.list()
+		require.NoError(t, err)
+		require.Len(t, recordings, 1)
+
+		_, events := readTestRecording(t, filepath.Join(path, recordings[0].Id+recordingExtension))
+		require.Len(t, events, 2)
+		require.ElementsMatch(t, [][]any{{"i", "whoami\n"}, {"o", "root\n"}}, [][]any{events[0][1:], events[1][1:]})
+	})
+
+	t.Run("should not record failed upgrades", func(t *testing.T) {
+		path := t.TempDir()
+		store, err := newRecordingStore(path, time.Hour)
+		require.NoError(t, err)
+
+		recorder := &sessionRecorder{store: store, logger: log.DefaultLogger}
+		recorder.writeOutput([]byte("HTTP/1.1 403 Forbidden\r\nContent-Type: application/json\r\n\r\n{}"))
+		recorder.writeInput([]byte("ls"))
+		recorder.close()
+
+		recordings, err := store.list()
+		require.NoError(t, err)
+		require.Empty(t, recordings)
+	})
+}
@@ -7,14 +7,25 @@ import (
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"go.opentelemetry.io/otel/codes"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// watchRetryInterval is the time after which a failed or closed watch is
+	// restarted.
+	watchRetryInterval = 1 * time.Second
+	// maxWatchRetryInterval is the maximum time after which a watch is
+	// restarted, when the user is not allowed to watch the resources.
+	maxWatchRetryInterval = 5 * time.Minute
+)
+
 func (c *client) getResources(ctx context.Context) (map[string]Resource, error) {
 	_, span := tracing.DefaultTracer().Start(ctx, "getResources")
 	defer span.End()
@@ -206,6 +217,106 @@ func createResourcesDataFrame(resource Resource, resources [][]byte, resourcesJS
 	return frame, nil
 }
 
+// createWatchEventDataFrame creates a data frame for a single watch event. The
+// table must contain the column definitions of the watch and the rows of the
+// event. The data frame contains the same fields as the data frame created by
+// "createResourcesDataFrame" and an additional "Event" field as first field,
+// which contains the type of the event (ADDED, MODIFIED or DELETED).
+func createWatchEventDataFrame(resource Resource, eventType string, table metav1.Table, wide bool, regex *regexp.Regexp) (*data.Frame, error) {
+	tableJSON, err := json.Marshal(table)
+	if err != nil {
+		return nil, err
+	}
+
+	frame, err := createResourcesDataFrame(resource, [][]byte{tableJSON}, nil, false, wide, regex)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]string, frame.Rows())
+	for i := range events {
+		events[i] = eventType
+	}
+	frame.Fields = append([]*data.Field{data.NewField("Event", nil, events)}, frame.Fields...)
+
+	return frame, nil
+}
+
+// createMetricsDataFrame creates a data frame in the long time series format
+// from the given "PodMetrics" or "NodeMetrics". The data frame contains one row
+// for each pod or node (or container if the containers parameter is true) with
+// the CPU usage in cores and the memory usage in bytes. All rows are using the
+// provided time, so that Grafana can convert the frame into a wide time series
+// frame with one series for each pod, container or node.
+//
+// If a name is provided, only the metrics for the pod or node with the
+// provided name are included in the data frame.
+func createMetricsDataFrame(metricsResource Resource, metrics []Metrics, t time.Time, name string, containers bool) *data.Frame {
+	var times []time.Time
+	var namespaces []string
+	var names []string
+	var containerNames []string
+	var cpus []float64
+	var memories []float64
+
+	appendRow := func(metadata metav1.ObjectMeta, container string, usage corev1.ResourceList) {
+		times = append(times, t)
+		namespaces = append(namespaces, metadata.Namespace)
+		names = append(names, metadata.Name)
+		containerNames = append(containerNames, container)
+		cpus = append(cpus, float64(usage.Cpu().MilliValue())/1000)
+		memories = append(memories, float64(usage.Memory().Value()))
+	}
+
+	for _, m := range metrics {
+		if name != "" && m.Metadata.Name != name {
+			continue
+		}
+
+		if len(m.Containers) == 0 {
+			appendRow(m.Metadata, "", m.Usage)
+			continue
+		}
+
+		if containers {
+			for _, container := range m.Containers {
+				appendRow(m.Metadata, container.Name, container.Usage)
+			}
+			continue
+		}
+
+		cpu := resource.Quantity{}
+		memory := resource.Quantity{}
+		for _, container := range m.Containers {
+			cpu.Add(*container.Usage.Cpu())
+			memory.Add(*container.Usage.Memory())
+		}
+		appendRow(m.Metadata, "", corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory})
+	}
+
+	frame := data.NewFrame(metricsResource.Kind, data.NewField("time", nil, times))
+	if metricsResource.Namespaced {
+		frame.Fields = append(frame.Fields, data.NewField("namespace", nil, namespaces))
+	}
+	frame.Fields = append(frame.Fields, data.NewField("name", nil, names))
+	if containers {
+		frame.Fields = append(frame.Fields, data.NewField("container", nil, containerNames))
+	}
+
+	cpuField := data.NewField("cpu", nil, cpus)
+	cpuField.SetConfig(&data.FieldConfig{Unit: "short"})
+	memoryField := data.NewField("memory", nil, memories)
+	memoryField.SetConfig(&data.FieldConfig{Unit: "bytes"})
+	frame.Fields = append(frame.Fields, cpuField, memoryField)
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeGraph,
+		Type:                   data.FrameTypeTimeSeriesLong,
+	})
+
+	return frame
+}
+
 func formatColumnName(resourceId, name string) string {
 	if (resourceId == "podmetrics.metrics.k8s.io" || resourceId == "nodemetrics.metrics.k8s.io") && name == "cpu" {
 		return "CPU"
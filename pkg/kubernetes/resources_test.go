@@ -0,0 +1,115 @@
+package kubernetes
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCreateWatchEventDataFrame(t *testing.T) {
+	resource := Resource{ID: "pod", Kind: "Pod", APIVersion: "v1", Name: "pods", Path: "/api/v1", Namespaced: true}
+
+	table := metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Name", Type: "string", Priority: 0},
+			{Name: "Status", Type: "string", Priority: 0},
+			{Name: "IP", Type: "string", Priority: 1},
+		},
+		Rows: []metav1.TableRow{{
+			Cells:  []any{"echoserver", "Running", "10.0.0.1"},
+			Object: runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"echoserver","namespace":"default","resourceVersion":"1"}}`)},
+		}},
+	}
+
+	t.Run("should add event field", func(t *testing.T) {
+		frame, err := createWatchEventDataFrame(resource, "MODIFIED", table, false, nil)
+		require.NoError(t, err)
+		require.Len(t, frame.Fields, 4)
+		require.Equal(t, "Event", frame.Fields[0].Name)
+		require.Equal(t, "MODIFIED", frame.Fields[0].At(0))
+		require.Equal(t, "default", frame.Fields[1].At(0))
+		require.Equal(t, "echoserver", frame.Fields[2].At(0))
+		require.Equal(t, "Running", frame.Fields[3].At(0))
+	})
+
+	t.Run("should filter rows by regex", func(t *testing.T) {
+		frame, err := createWatchEventDataFrame(resource, "ADDED", table, true, regexp.MustCompile("^nginx"))
+		require.NoError(t, err)
+		require.Equal(t, 0, frame.Rows())
+	})
+}
+
+func TestCreateMetricsDataFrame(t *testing.T) {
+	now := time.Now()
+	podMetricsResource := Resource{ID: "podmetrics.metrics.k8s.io", Kind: "PodMetrics", APIVersion: "metrics.k8s.io/v1beta1", Name: "pods", Path: "/apis/metrics.k8s.io/v1beta1", Namespaced: true}
+	nodeMetricsResource := Resource{ID: "nodemetrics.metrics.k8s.io", Kind: "NodeMetrics", APIVersion: "metrics.k8s.io/v1beta1", Name: "nodes", Path: "/apis/metrics.k8s.io/v1beta1", Namespaced: false}
+
+	podMetrics := []Metrics{
+		{
+			Metadata: metav1.ObjectMeta{Name: "echoserver", Namespace: "default"},
+			Containers: []ContainerMetrics{
+				{Name: "echoserver", Usage: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("64Mi")}},
+				{Name: "sidecar", Usage: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m"), corev1.ResourceMemory: resource.MustParse("16Mi")}},
+			},
+		},
+		{
+			Metadata:   metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+			Containers: []ContainerMetrics{{Name: "nginx", Usage: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}}},
+		},
+	}
+
+	t.Run("should sum container usage for pods", func(t *testing.T) {
+		frame := createMetricsDataFrame(podMetricsResource, podMetrics, now, "", false)
+		require.Equal(t, "PodMetrics", frame.Name)
+		require.Equal(t, data.FrameTypeTimeSeriesLong, frame.Meta.Type)
+		require.Equal(t, []string{"time", "namespace", "name", "cpu", "memory"}, fieldNames(frame))
+		require.Equal(t, 2, frame.Rows())
+		require.Equal(t, now, frame.Fields[0].At(0))
+		require.Equal(t, "echoserver", frame.Fields[2].At(0))
+		require.Equal(t, 0.3, frame.Fields[3].At(0))
+		require.Equal(t, float64(80*1024*1024), frame.Fields[4].At(0))
+		require.Equal(t, "short", frame.Fields[3].Config.Unit)
+		require.Equal(t, "bytes", frame.Fields[4].Config.Unit)
+	})
+
+	t.Run("should return one row per container", func(t *testing.T) {
+		frame := createMetricsDataFrame(podMetricsResource, podMetrics, now, "echoserver", true)
+		require.Equal(t, []string{"time", "namespace", "name", "container", "cpu", "memory"}, fieldNames(frame))
+		require.Equal(t, 2, frame.Rows())
+		require.Equal(t, "sidecar", frame.Fields[3].At(1))
+		require.Equal(t, 0.05, frame.Fields[4].At(1))
+	})
+
+	t.Run("should not return namespace for nodes", func(t *testing.T) {
+		nodeMetrics := []Metrics{{
+			Metadata: metav1.ObjectMeta{Name: "node1"},
+			Usage:    corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("2Gi")},
+		}}
+
+		frame := createMetricsDataFrame(nodeMetricsResource, nodeMetrics, now, "", false)
+		require.Equal(t, []string{"time", "name", "cpu", "memory"}, fieldNames(frame))
+		require.Equal(t, 1, frame.Rows())
+		require.Equal(t, 2.0, frame.Fields[2].At(0))
+	})
+
+	t.Run("should return empty frame for unknown name", func(t *testing.T) {
+		frame := createMetricsDataFrame(podMetricsResource, podMetrics, now, "unknown", false)
+		require.Equal(t, 0, frame.Rows())
+		require.Len(t, frame.Fields, 5)
+	})
+}
+
+func fieldNames(frame *data.Frame) []string {
+	var names []string
+	for _, field := range frame.Fields {
+		names = append(names, field.Name)
+	}
+	return names
+}
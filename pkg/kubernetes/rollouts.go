@@ -0,0 +1,221 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	revisionAnnotation    = "deployment.kubernetes.io/revision"
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+)
+
+// rollbackSkippedAnnotations are the annotations of a ReplicaSet, which are not
+// copied to the Deployment on a rollback. This is the same list as it is used
+// by "kubectl rollout undo".
+var rollbackSkippedAnnotations = map[string]bool{
+	corev1.LastAppliedConfigAnnotation:          true,
+	revisionAnnotation:                          true,
+	"deployment.kubernetes.io/revision-history": true,
+	"deployment.kubernetes.io/desired-replicas": true,
+	"deployment.kubernetes.io/max-replicas":     true,
+	"deprecated.deployment.rollback.to":         true,
+}
+
+// DeploymentRollbackOptions are the options for the rollback of a Deployment.
+type DeploymentRollbackOptions struct {
+	DryRun bool `json:"dryRun"`
+}
+
+// DeploymentRollbackResult is the result of the rollback of a Deployment. The
+// diff contains the changes of the pod template. If the pod template of the
+// Deployment already matches the requested revision, the rollback is skipped.
+type DeploymentRollbackResult struct {
+	Revision int64  `json:"revision"`
+	DryRun   bool   `json:"dryRun"`
+	Skipped  bool   `json:"skipped"`
+	Message  string `json:"message"`
+	Diff     string `json:"diff"`
+}
+
+// getRevision returns the revision of a ReplicaSet or Deployment from the
+// "deployment.kubernetes.io/revision" annotation.
+func getRevision(annotations map[string]string) int64 {
+	revision, err := strconv.ParseInt(annotations[revisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
+// filterDeploymentReplicaSets returns all ReplicaSets which are controlled by
+// the provided Deployment sorted by their revision.
+func filterDeploymentReplicaSets(deployment appsv1.Deployment, replicaSets []appsv1.ReplicaSet) []appsv1.ReplicaSet {
+	var owned []appsv1.ReplicaSet
+
+	for _, replicaSet := range replicaSets {
+		for _, ownerReference := range replicaSet.OwnerReferences {
+			if ownerReference.Controller != nil && *ownerReference.Controller && ownerReference.UID == deployment.UID {
+				owned = append(owned, replicaSet)
+				break
+			}
+		}
+	}
+
+	sort.Slice(owned, func(i, j int) bool {
+		return getRevision(owned[i].Annotations) < getRevision(owned[j].Annotations)
+	})
+
+	return owned
+}
+
+// createRolloutHistoryDataFrame creates a table data frame with one row for
+// each revision of the provided Deployment.
+func createRolloutHistoryDataFrame(deployment appsv1.Deployment, replicaSets []appsv1.ReplicaSet) *data.Frame {
+	currentRevision := getRevision(deployment.Annotations)
+
+	var revisions, replicas, readyReplicas, availableReplicas []int64
+	var current []bool
+	var changeCauses, images []string
+	var created []time.Time
+
+	for _, replicaSet := range replicaSets {
+		revision := getRevision(replicaSet.Annotations)
+
+		var containerImages []string
+		for _, container := range replicaSet.Spec.Template.Spec.Containers {
+			containerImages = append(containerImages, container.Image)
+		}
+
+		revisions = append(revisions, revision)
+		current = append(current, revision == currentRevision)
+		changeCauses = append(changeCauses, replicaSet.Annotations[changeCauseAnnotation])
+		images = append(images, strings.Join(containerImages, ", "))
+		created = append(created, replicaSet.CreationTimestamp.Time)
+		replicas = append(replicas, int64(ptrValue(replicaSet.Spec.Replicas, 0)))
+		readyReplicas = append(readyReplicas, int64(replicaSet.Status.ReadyReplicas))
+		availableReplicas = append(availableReplicas, int64(replicaSet.Status.AvailableReplicas))
+	}
+
+	frame := data.NewFrame(
+		deployment.Name,
+		data.NewField("Revision", nil, revisions),
+		data.NewField("Current", nil, current),
+		data.NewField("Change Cause", nil, changeCauses),
+		data.NewField("Images", nil, images),
+		data.NewField("Created", nil, created),
+		data.NewField("Replicas", nil, replicas),
+		data.NewField("Ready Replicas", nil, readyReplicas),
+		data.NewField("Available Replicas", nil, availableReplicas),
+	)
+
+	frame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	return frame
+}
+
+// newDeploymentRollbackPatch returns a JSON patch which replaces the pod
+// template and annotations of the Deployment with the ones from the
+// ReplicaSet of the requested revision, like "kubectl rollout undo". If the
+// revision is 0, the previous revision is used.
+//
+// If the pod template of the Deployment already matches the revision, the
+// returned patch is nil.
+func newDeploymentRollbackPatch(deployment appsv1.Deployment, replicaSets []appsv1.ReplicaSet, revision int64) ([]byte, *DeploymentRollbackResult, error) {
+	if deployment.Spec.Paused {
+		return nil, nil, fmt.Errorf("cannot rollback a paused deployment, resume it first")
+	}
+
+	if revision == 0 {
+		currentRevision := getRevision(deployment.Annotations)
+		for _, replicaSet := range replicaSets {
+			if r := getRevision(replicaSet.Annotations); r < currentRevision && r > revision {
+				revision = r
+			}
+		}
+		if revision == 0 {
+			return nil, nil, fmt.Errorf("no rollout history found for deployment %s", deployment.Name)
+		}
+	}
+
+	var target *appsv1.ReplicaSet
+	for _, replicaSet := range replicaSets {
+		if getRevision(replicaSet.Annotations) == revision {
+			target = &replicaSet
+			break
+		}
+	}
+	if target == nil {
+		return nil, nil, fmt.Errorf("unable to find revision %d for deployment %s", revision, deployment.Name)
+	}
+
+	// The ReplicaSet template contains the "pod-template-hash" label, which is
+	// added by the Deployment controller and must be removed before we compare
+	// and apply the template.
+	template := target.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+
+	currentTemplate, err := yaml.Marshal(deployment.Spec.Template)
+	if err != nil {
+		return nil, nil, err
+	}
+	targetTemplate, err := yaml.Marshal(template)
+	if err != nil {
+		return nil, nil, err
+	}
+	diff, err := diffYAML(currentTemplate, targetTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &DeploymentRollbackResult{
+		Revision: revision,
+		Diff:     diff,
+	}
+
+	if apiequality.Semantic.DeepEqual(deployment.Spec.Template, *template) {
+		result.Skipped = true
+		result.Message = fmt.Sprintf("skipped rollback (current template already matches revision %d)", revision)
+		return nil, result, nil
+	}
+
+	// Like "kubectl rollout undo" the annotations of the Deployment are
+	// replaced with the ones from the ReplicaSet. Only the skipped annotations
+	// of the Deployment are kept, so that e.g. a change cause of the current
+	// revision doesn't survive the rollback.
+	annotations := make(map[string]string)
+	for key, value := range deployment.Annotations {
+		if rollbackSkippedAnnotations[key] {
+			annotations[key] = value
+		}
+	}
+	for key, value := range target.Annotations {
+		if !rollbackSkippedAnnotations[key] {
+			annotations[key] = value
+		}
+	}
+
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "replace", "path": "/spec/template", "value": template},
+		{"op": "replace", "path": "/metadata/annotations", "value": annotations},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result.Message = fmt.Sprintf("rolled back to revision %d", revision)
+	return patch, result, nil
+}
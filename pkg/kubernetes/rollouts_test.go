@@ -0,0 +1,110 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func newTestReplicaSet(name, revision, image string, owner metav1.Object) appsv1.ReplicaSet {
+	return appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			Annotations:     map[string]string{revisionAnnotation: revision, changeCauseAnnotation: "set image " + image, "deployment.kubernetes.io/desired-replicas": "1"},
+			OwnerReferences: []metav1.OwnerReference{{Name: owner.GetName(), UID: owner.GetUID(), Controller: ptr.To(true)}},
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Replicas: ptr.To(int32(1)),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "echoserver", appsv1.DefaultDeploymentUniqueLabelKey: name}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "echoserver", Image: image}}},
+			},
+		},
+	}
+}
+
+func TestRolloutHistory(t *testing.T) {
+	deployment := appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "echoserver", Namespace: "default", UID: "1", Annotations: map[string]string{revisionAnnotation: "2"}},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "echoserver"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "echoserver", Image: "echoserver:2"}}},
+			},
+		},
+	}
+	otherDeployment := appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "nginx", UID: "2"}}
+
+	replicaSets := filterDeploymentReplicaSets(deployment, []appsv1.ReplicaSet{
+		newTestReplicaSet("echoserver-2", "2", "echoserver:2", &deployment),
+		newTestReplicaSet("echoserver-1", "1", "echoserver:1", &deployment),
+		newTestReplicaSet("nginx-1", "1", "nginx:1", &otherDeployment),
+	})
+
+	t.Run("should return revisions of deployment", func(t *testing.T) {
+		frame := createRolloutHistoryDataFrame(deployment, replicaSets)
+		require.Equal(t, 2, frame.Rows())
+		require.Equal(t, int64(1), frame.Fields[0].At(0))
+		require.Equal(t, false, frame.Fields[1].At(0))
+		require.Equal(t, "set image echoserver:1", frame.Fields[2].At(0))
+		require.Equal(t, "echoserver:1", frame.Fields[3].At(0))
+		require.Equal(t, int64(2), frame.Fields[0].At(1))
+		require.Equal(t, true, frame.Fields[1].At(1))
+	})
+
+	t.Run("should rollback to previous revision", func(t *testing.T) {
+		patch, result, err := newDeploymentRollbackPatch(deployment, replicaSets, 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), result.Revision)
+		require.False(t, result.Skipped)
+		require.Contains(t, result.Diff, "+  - image: echoserver:1")
+		require.JSONEq(t, `[{"op":"replace","path":"/spec/template","value":{"metadata":{"labels":{"app":"echoserver"}},"spec":{"containers":[{"name":"echoserver","image":"echoserver:1","resources":{}}]}}},{"op":"replace","path":"/metadata/annotations","value":{"deployment.kubernetes.io/revision":"2","kubernetes.io/change-cause":"set image echoserver:1"}}]`, string(patch))
+	})
+
+	t.Run("should replace annotations of deployment", func(t *testing.T) {
+		annotatedDeployment := *deployment.DeepCopy()
+		annotatedDeployment.Annotations = map[string]string{
+			revisionAnnotation:                 "2",
+			changeCauseAnnotation:              "set image echoserver:2",
+			"example.com/owner":                "team-a",
+			corev1.LastAppliedConfigAnnotation: "{}",
+		}
+		var targetReplicaSets []appsv1.ReplicaSet
+		for _, replicaSet := range replicaSets {
+			replicaSet = *replicaSet.DeepCopy()
+			if replicaSet.Name == "echoserver-1" {
+				delete(replicaSet.Annotations, changeCauseAnnotation)
+			}
+			targetReplicaSets = append(targetReplicaSets, replicaSet)
+		}
+
+		patch, _, err := newDeploymentRollbackPatch(annotatedDeployment, targetReplicaSets, 1)
+		require.NoError(t, err)
+		require.JSONEq(t, `[{"op":"replace","path":"/spec/template","value":{"metadata":{"labels":{"app":"echoserver"}},"spec":{"containers":[{"name":"echoserver","image":"echoserver:1","resources":{}}]}}},{"op":"replace","path":"/metadata/annotations","value":{"deployment.kubernetes.io/revision":"2","kubectl.kubernetes.io/last-applied-configuration":"{}"}}]`, string(patch))
+	})
+
+	t.Run("should skip rollback to current template", func(t *testing.T) {
+		patch, result, err := newDeploymentRollbackPatch(deployment, replicaSets, 2)
+		require.NoError(t, err)
+		require.Nil(t, patch)
+		require.True(t, result.Skipped)
+	})
+
+	t.Run("should fail for unknown revision", func(t *testing.T) {
+		_, _, err := newDeploymentRollbackPatch(deployment, replicaSets, 5)
+		require.Error(t, err)
+	})
+
+	t.Run("should fail for paused deployment", func(t *testing.T) {
+		pausedDeployment := *deployment.DeepCopy()
+		pausedDeployment.Spec.Paused = true
+
+		_, _, err := newDeploymentRollbackPatch(pausedDeployment, replicaSets, 1)
+		require.Error(t, err)
+	})
+}
@@ -7,13 +7,20 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"time"
 
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/approval"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
 	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/grafana"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type Server interface {
@@ -65,9 +72,9 @@ func (s *server) Stop() error {
 	return nil
 }
 
-// NewServer creates a new HTTP server on the given port. The server will proxy
-// requests to the Kubernetes API server using the provided Kubernetes client.
-// The implementation for proxing requests should be the same as in the
+// NewServer creates a new HTTP server on the configured port. The server will
+// proxy requests to the Kubernetes API server using the provided Kubernetes
+// client. The implementation for proxing requests should be the same as in the
 // "handleKubernetesProxy" handler of the datasource. The server can be accessed
 // via the "kubectl" proxy route of the datasource as defined in the
 // "src/datasource/plugin.json" file.
@@ -80,44 +87,169 @@ func (s *server) Stop() error {
 //
 // The server will be used in the generated kubeconfig via the following url:
 // "<GRAFANA_URL>api/datasources/proxy/uid/<DATASOURCE_UID>/proxy". Since the
-// server is only used in the kubeconfig, it should only be started when this
-// feature is activated by in the datasource configuration.
-func NewServer(port int64, kubeClient Client, grafanaClient grafana.Client, logger log.Logger) (Server, error) {
+// server is only used in the kubeconfig, the proxy is only registered when
+// this feature is activated by in the datasource configuration.
+//
+// When the audit log is enabled, all mutating requests are written to the audit
+// log. The provided notify function is called with the audit log entry of each
+// mutating request, when the request is finished, so that the datasource can
+// create annotations and notify webhooks also for changes which are made via
+// kubectl. The function can be nil.
+//
+// When the approval mode is enabled, mutating requests for the namespaces
+// which require an approval are rejected, because they can not be approved
+// via kubectl. Users have to request the approval for such changes via the
+// plugin instead.
+//
+// When the recording of sessions is enabled, all exec and attach sessions,
+// which are started via the server, are recorded. The commands which are run
+// via the exec and download endpoints of the plugin are recorded by the
+// client.
+//
+// When the kube-state-metrics feature is enabled, the server also serves the
+// computed kube-state-metrics on the "/kube-state-metrics" endpoint, so that
+// they can be scraped by a local Prometheus or Grafana Agent. A separate path
+// is used, so that the "/metrics" endpoint of the Kubernetes API server can
+// still be accessed via the generated Kubeconfig. The endpoint requires the
+// configured token, so the server can not be created without it.
+func NewServer(config *models.PluginSettings, kubeClient Client, grafanaClient grafana.Client, auditLogger *audit.Logger, approvals *approval.Store, notify func(ctx context.Context, entry audit.Entry), logger log.Logger) (Server, error) {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/{pathname...}", func(w http.ResponseWriter, r *http.Request) {
-		ctx, span := tracing.DefaultTracer().Start(r.Context(), "serverRequest")
-		defer span.End()
 
-		user, err := grafanaClient.GetImpersonateUser(ctx, r.Header)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	if config.KubeStateMetrics {
+		var token string
+		if config.Secrets != nil {
+			token = config.Secrets.KubeStateMetricsToken
 		}
 
-		groups, err := grafanaClient.GetImpersonateGroups(ctx, r.Header)
+		handler, err := newMetricsHandler(kubeClient.GetMetricsCollector(), token)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return nil, err
 		}
 
-		pathname := r.PathValue("pathname")
-		requestUrl := fmt.Sprintf("%s?%s", pathname, r.URL.RawQuery)
-
-		logger.Info("Kubernetes server request", "user", user, "groups", groups, "method", r.Method, "requestUrl", requestUrl)
-		span.SetAttributes(attribute.Key("user").String(user))
-		span.SetAttributes(attribute.Key("groups").StringSlice(groups))
-		span.SetAttributes(attribute.Key("method").String(r.Method))
-		span.SetAttributes(attribute.Key("requestUrl").String(requestUrl))
+		mux.Handle("GET /kube-state-metrics", handler)
+	}
 
-		kubeClient.Proxy(user, groups, requestUrl, w, r)
-	})
+	if config.GenerateKubeconfig {
+		mux.HandleFunc("/{pathname...}", func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := tracing.DefaultTracer().Start(r.Context(), "serverRequest")
+			defer span.End()
+
+			user, err := grafanaClient.GetImpersonateUser(ctx, r.Header)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			groups, err := grafanaClient.GetImpersonateGroups(ctx, r.Header)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			pathname := r.PathValue("pathname")
+			requestUrl := fmt.Sprintf("%s?%s", pathname, r.URL.RawQuery)
+
+			logger.Info("Kubernetes server request", "user", user, "groups", groups, "method", r.Method, "requestUrl", requestUrl)
+			span.SetAttributes(attribute.Key("user").String(user))
+			span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+			span.SetAttributes(attribute.Key("method").String(r.Method))
+			span.SetAttributes(attribute.Key("requestUrl").String(requestUrl))
+
+			// Mutating requests and requests to start exec, attach or
+			// port-forward sessions are written to the audit log, when the
+			// request is finished. This also includes requests which are
+			// denied by the plugin. Afterwards the entry is passed to the
+			// notify function, which decides if an annotation is created and
+			// the webhooks are notified.
+			if auditLogger != nil || notify != nil {
+				entry, ok, err := NewAuditEntry(r.Method, "/"+requestUrl)
+				if err != nil {
+					logger.Error("Failed to create audit log entry", "error", err.Error())
+				} else if ok {
+					if entry.User, err = grafanaClient.GetUser(ctx, r.Header); err != nil {
+						logger.Error("Failed to get user for audit log", "error", err.Error())
+					}
+					if entry.Groups, err = grafanaClient.GetTeams(ctx, r.Header); err != nil {
+						logger.Error("Failed to get teams for audit log", "error", err.Error())
+					}
+					entry.Time = time.Now()
+					entry.RequestId = audit.RequestId(ctx)
+
+					rw := audit.NewResponseWriter(w)
+					aw, done := auditLogger.Record(ctx, rw, entry)
+					w = aw
+					defer func() {
+						done()
+
+						if notify != nil {
+							entry.StatusCode = rw.StatusCode()
+							notify(ctx, entry)
+						}
+					}()
+				}
+			}
+
+			if err := requireApproval(approvals, r, "/"+requestUrl); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				WriteStatusError(w, err)
+				return
+			}
+
+			if err := kubeClient.AuthorizeSubresource(r.Method, "/"+requestUrl, func() ([]string, error) { return grafanaClient.GetTeams(ctx, r.Header) }); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				WriteStatusError(w, err)
+				return
+			}
+
+			// If the recording of sessions is enabled, exec and attach sessions
+			// are recorded via the returned response writer.
+			rw, err := kubeClient.RecordSession(r.Method, "/"+requestUrl, func() (string, error) { return grafanaClient.GetUser(ctx, r.Header) }, w)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			kubeClient.Proxy(user, groups, requestUrl, rw, r)
+		})
+	}
 
 	return &server{
 		server: &http.Server{
-			Addr:              fmt.Sprintf(":%d", port),
+			Addr:              fmt.Sprintf(":%d", config.GenerateKubeconfigPort),
 			Handler:           mux,
 			ReadHeaderTimeout: 3 * time.Second,
 		},
 		logger: logger,
 	}, nil
 }
+
+// requireApproval returns a forbidden error, when the request must be approved
+// before it can be run. This is the case for all mutating requests in the
+// namespaces of the approval mode, which are not a dry-run. Requests which
+// open a streaming connection to a pod (e.g. "exec" or "portforward") are not
+// rejected, because they can also not be approved via the plugin. Whether a
+// request is a streaming request is decided by the parsed request and not by
+// the "Upgrade" header, which can be set for any request.
+func requireApproval(approvals *approval.Store, r *http.Request, requestUrl string) error {
+	if approvals == nil {
+		return nil
+	}
+
+	entry, ok, err := NewAuditEntry(r.Method, requestUrl)
+	if err != nil || !ok {
+		return nil
+	}
+	if slices.Contains([]string{"get", "list", "watch"}, entry.Verb) || IsStreamingAuditEntry(entry) || entry.DryRun {
+		return nil
+	}
+
+	if !approvals.Matches(entry.Namespace) {
+		return nil
+	}
+
+	return apierrors.NewForbidden(schema.ParseGroupResource(entry.Resource), entry.Name, errors.New("the request must be approved by a second user, use the plugin to request an approval"))
+}
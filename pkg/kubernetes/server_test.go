@@ -0,0 +1,132 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/approval"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/grafana"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func newTestServerHandler(t *testing.T, statusCode int, approvals *approval.Store, notify func(ctx context.Context, entry audit.Entry)) http.Handler {
+	ctrl := gomock.NewController(t)
+
+	grafanaClient := grafana.NewMockClient(ctrl)
+	grafanaClient.EXPECT().GetImpersonateUser(gomock.Any(), gomock.Any()).Return("admin", nil).AnyTimes()
+	grafanaClient.EXPECT().GetImpersonateGroups(gomock.Any(), gomock.Any()).Return([]string{"team1"}, nil).AnyTimes()
+	grafanaClient.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return("admin", nil).AnyTimes()
+	grafanaClient.EXPECT().GetTeams(gomock.Any(), gomock.Any()).Return([]string{"team1"}, nil).AnyTimes()
+
+	kubeClient := NewMockClient(ctrl)
+	kubeClient.EXPECT().AuthorizeSubresource(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	kubeClient.EXPECT().RecordSession(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(method, requestUrl string, getUser func() (string, error), w http.ResponseWriter) (http.ResponseWriter, error) {
+		return w, nil
+	}).AnyTimes()
+	kubeClient.EXPECT().Proxy(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(func(user string, groups []string, requestUrl string, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+	}).AnyTimes()
+
+	s, err := NewServer(&models.PluginSettings{GenerateKubeconfig: true}, kubeClient, grafanaClient, nil, approvals, notify, log.DefaultLogger)
+	require.NoError(t, err)
+
+	return s.(*server).server.Handler
+}
+
+func TestServerNotify(t *testing.T) {
+	t.Run("should notify about mutating requests", func(t *testing.T) {
+		var entries []audit.Entry
+		handler := newTestServerHandler(t, http.StatusOK, nil, func(ctx context.Context, entry audit.Entry) {
+			entries = append(entries, entry)
+		})
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/api/v1/namespaces/default/pods/echoserver", nil))
+
+		require.Len(t, entries, 1)
+		require.Equal(t, "delete", entries[0].Verb)
+		require.Equal(t, "pods", entries[0].Resource)
+		require.Equal(t, "default", entries[0].Namespace)
+		require.Equal(t, "echoserver", entries[0].Name)
+		require.Equal(t, "admin", entries[0].User)
+		require.Equal(t, []string{"team1"}, entries[0].Groups)
+		require.Equal(t, http.StatusOK, entries[0].StatusCode)
+		require.NotEmpty(t, entries[0].RequestId)
+		require.False(t, entries[0].Time.IsZero())
+	})
+
+	t.Run("should pass status code of failed requests", func(t *testing.T) {
+		var entries []audit.Entry
+		handler := newTestServerHandler(t, http.StatusForbidden, nil, func(ctx context.Context, entry audit.Entry) {
+			entries = append(entries, entry)
+		})
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/api/v1/namespaces/default/pods/echoserver", nil))
+
+		require.Len(t, entries, 1)
+		require.Equal(t, http.StatusForbidden, entries[0].StatusCode)
+	})
+
+	t.Run("should not notify about read requests", func(t *testing.T) {
+		var entries []audit.Entry
+		handler := newTestServerHandler(t, http.StatusOK, nil, func(ctx context.Context, entry audit.Entry) {
+			entries = append(entries, entry)
+		})
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/default/pods/echoserver", nil))
+
+		require.Empty(t, entries)
+	})
+
+	t.Run("should handle requests without notify function", func(t *testing.T) {
+		handler := newTestServerHandler(t, http.StatusOK, nil, nil)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/api/v1/namespaces/default/pods/echoserver", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+}
+
+func TestServerApprovals(t *testing.T) {
+	approvals, err := approval.NewStore(filepath.Join(t.TempDir(), "approvals.json"), time.Hour, []string{"sre"}, []string{"^production$"})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		url        string
+		upgrade    bool
+		statusCode int
+	}{
+		{name: "should reject mutating requests in namespaces which require an approval", method: http.MethodDelete, url: "/api/v1/namespaces/production/pods/echoserver", statusCode: http.StatusForbidden},
+		{name: "should reject patch requests in namespaces which require an approval", method: http.MethodPatch, url: "/apis/apps/v1/namespaces/production/deployments/echoserver/scale", statusCode: http.StatusForbidden},
+		{name: "should allow mutating requests in other namespaces", method: http.MethodDelete, url: "/api/v1/namespaces/default/pods/echoserver", statusCode: http.StatusOK},
+		{name: "should allow read requests", method: http.MethodGet, url: "/api/v1/namespaces/production/pods/echoserver", statusCode: http.StatusOK},
+		{name: "should allow dry-run requests", method: http.MethodDelete, url: "/api/v1/namespaces/production/pods/echoserver?dryRun=All", statusCode: http.StatusOK},
+		{name: "should allow streaming requests", method: http.MethodPost, url: "/api/v1/namespaces/production/pods/echoserver/exec?command=ls", upgrade: true, statusCode: http.StatusOK},
+		{name: "should allow port-forward requests", method: http.MethodPost, url: "/api/v1/namespaces/production/pods/echoserver/portforward?ports=8080", upgrade: true, statusCode: http.StatusOK},
+		{name: "should reject mutating requests with upgrade header", method: http.MethodDelete, url: "/api/v1/namespaces/production/pods/echoserver", upgrade: true, statusCode: http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newTestServerHandler(t, http.StatusOK, approvals, nil)
+
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			if tc.upgrade {
+				req.Header.Set("Upgrade", "websocket")
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			require.Equal(t, tc.statusCode, recorder.Code)
+		})
+	}
+}
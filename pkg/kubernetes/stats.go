@@ -0,0 +1,280 @@
+package kubernetes
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// statsRetention is the duration for which we keep the samples of the kubelet
+// stats in memory.
+const statsRetention = 1 * time.Hour
+
+const (
+	StatsLevelPod       = "pod"
+	StatsLevelContainer = "container"
+	StatsLevelVolume    = "volume"
+
+	StatsMetricCPU             = "cpu"
+	StatsMetricMemory          = "memory"
+	StatsMetricNetworkReceive  = "network-receive"
+	StatsMetricNetworkTransmit = "network-transmit"
+	StatsMetricFilesystem      = "filesystem"
+)
+
+// statsMetrics contains all supported metrics for each level.
+var statsMetrics = map[string][]string{
+	StatsLevelPod:       {StatsMetricCPU, StatsMetricMemory, StatsMetricNetworkReceive, StatsMetricNetworkTransmit, StatsMetricFilesystem},
+	StatsLevelContainer: {StatsMetricCPU, StatsMetricMemory, StatsMetricFilesystem},
+	StatsLevelVolume:    {StatsMetricFilesystem},
+}
+
+type statsSample struct {
+	time  time.Time
+	value float64
+}
+
+type statsSeries struct {
+	level   string
+	metric  string
+	labels  data.Labels
+	samples []statsSample
+}
+
+// statsStore is a thread-safe in-memory store for the samples we get from the
+// "/stats/summary" endpoint of the kubelet. Each call of the endpoint adds a
+// new sample to all series, so that repeated queries build up a short time
+// series, which can be used to render basic usage charts without a metrics
+// stack.
+type statsStore struct {
+	series    map[string]*statsSeries
+	retention time.Duration
+	lock      sync.Mutex
+}
+
+// addSummary adds the samples for all pods, containers and volumes from the
+// provided summary to the store. Afterwards all samples which are older than
+// the retention are removed.
+func (s *statsStore) addSummary(summary StatsSummary) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, pod := range summary.Pods {
+		podLabels := data.Labels{
+			"node":      summary.Node.NodeName,
+			"namespace": pod.PodRef.Namespace,
+			"pod":       pod.PodRef.Name,
+		}
+
+		if pod.CPU != nil {
+			s.add(StatsLevelPod, StatsMetricCPU, podLabels, pod.CPU.Time, pod.CPU.UsageNanoCores, 1e-9)
+		}
+		if pod.Memory != nil {
+			s.add(StatsLevelPod, StatsMetricMemory, podLabels, pod.Memory.Time, pod.Memory.WorkingSetBytes, 1)
+		}
+		if pod.Network != nil {
+			s.add(StatsLevelPod, StatsMetricNetworkReceive, podLabels, pod.Network.Time, pod.Network.RxBytes, 1)
+			s.add(StatsLevelPod, StatsMetricNetworkTransmit, podLabels, pod.Network.Time, pod.Network.TxBytes, 1)
+		}
+		if pod.EphemeralStorage != nil {
+			s.add(StatsLevelPod, StatsMetricFilesystem, podLabels, pod.EphemeralStorage.Time, pod.EphemeralStorage.UsedBytes, 1)
+		}
+
+		for _, container := range pod.Containers {
+			containerLabels := podLabels.Copy()
+			containerLabels["container"] = container.Name
+
+			if container.CPU != nil {
+				s.add(StatsLevelContainer, StatsMetricCPU, containerLabels, container.CPU.Time, container.CPU.UsageNanoCores, 1e-9)
+			}
+			if container.Memory != nil {
+				s.add(StatsLevelContainer, StatsMetricMemory, containerLabels, container.Memory.Time, container.Memory.WorkingSetBytes, 1)
+			}
+			if container.Rootfs != nil {
+				s.add(StatsLevelContainer, StatsMetricFilesystem, containerLabels, container.Rootfs.Time, container.Rootfs.UsedBytes, 1)
+			}
+		}
+
+		// We only record the volumes which are backed by a
+		// PersistentVolumeClaim, because all other volumes (e.g. ConfigMaps,
+		// Secrets, etc.) are not interesting for the usage charts.
+		for _, volume := range pod.VolumeStats {
+			if volume.PVCRef == nil {
+				continue
+			}
+
+			volumeLabels := podLabels.Copy()
+			volumeLabels["volume"] = volume.Name
+			volumeLabels["persistentvolumeclaim"] = volume.PVCRef.Name
+
+			s.add(StatsLevelVolume, StatsMetricFilesystem, volumeLabels, volume.Time, volume.UsedBytes, 1)
+		}
+	}
+
+	s.prune(time.Now())
+}
+
+// add adds a new sample to the series with the provided level, metric and
+// labels. If the value is nil or the time of the sample is not after the time
+// of the last sample in the series, the sample is ignored. This is required,
+// because the kubelet caches the stats, so that multiple calls can return the
+// same sample.
+func (s *statsStore) add(level, metric string, labels data.Labels, t time.Time, value *uint64, scale float64) {
+	if value == nil {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", level, metric, labels.String())
+
+	series, ok := s.series[key]
+	if !ok {
+		series = &statsSeries{
+			level:  level,
+			metric: metric,
+			labels: labels,
+		}
+		s.series[key] = series
+	}
+
+	if len(series.samples) > 0 && !t.After(series.samples[len(series.samples)-1].time) {
+		return
+	}
+
+	series.samples = append(series.samples, statsSample{time: t, value: float64(*value) * scale})
+}
+
+// prune removes all samples which are older than the retention. If a series
+// doesn't contain any samples afterwards, the series is removed.
+func (s *statsStore) prune(now time.Time) {
+	for key, series := range s.series {
+		index := 0
+		for index < len(series.samples) && series.samples[index].time.Before(now.Add(-s.retention)) {
+			index++
+		}
+		series.samples = series.samples[index:]
+
+		if len(series.samples) == 0 {
+			delete(s.series, key)
+		}
+	}
+}
+
+// getDataFrames returns a time series data frame for each series with the
+// provided level and metric, which matches the provided nodes and namespaces.
+// The store is shared by all users, so that the nodes must always be set to
+// the nodes which could be read by the current user. If the filter is set,
+// only series where the pod name matches the filter are returned. If hideNodes
+// is set, the node label is removed from the returned series.
+//
+// The network metrics are cumulative counters, so that we return the rate
+// between two samples (bytes per second) instead of the raw values.
+func (s *statsStore) getDataFrames(level, metric string, nodes, namespaces []string, filter *regexp.Regexp, hideNodes bool, timeRange backend.TimeRange) []*data.Frame {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var frames []*data.Frame
+
+	for _, series := range s.series {
+		if series.level != level || series.metric != metric {
+			continue
+		}
+		if !slices.Contains(nodes, series.labels["node"]) {
+			continue
+		}
+		if len(namespaces) > 0 && !slices.Contains(namespaces, series.labels["namespace"]) {
+			continue
+		}
+		if filter != nil && !filter.MatchString(series.labels["pod"]) {
+			continue
+		}
+
+		var times []time.Time
+		var values []float64
+
+		for i, sample := range series.samples {
+			if sample.time.Before(timeRange.From) || sample.time.After(timeRange.To) {
+				continue
+			}
+
+			if metric == StatsMetricNetworkReceive || metric == StatsMetricNetworkTransmit {
+				if i == 0 {
+					continue
+				}
+
+				previous := series.samples[i-1]
+				if sample.value < previous.value {
+					continue
+				}
+
+				times = append(times, sample.time)
+				values = append(values, (sample.value-previous.value)/sample.time.Sub(previous.time).Seconds())
+			} else {
+				times = append(times, sample.time)
+				values = append(values, sample.value)
+			}
+		}
+
+		if len(times) == 0 {
+			continue
+		}
+
+		name := strings.Join(slices.DeleteFunc([]string{series.labels["namespace"], series.labels["pod"], series.labels["container"], series.labels["volume"]}, func(s string) bool { return s == "" }), "/")
+
+		labels := series.labels
+		if hideNodes {
+			labels = labels.Copy()
+			delete(labels, "node")
+		}
+
+		valueField := data.NewField(metric, labels, values)
+		valueField.SetConfig(&data.FieldConfig{
+			DisplayNameFromDS: name,
+			Unit:              statsUnit(metric),
+		})
+
+		frame := data.NewFrame(
+			name,
+			data.NewField("time", nil, times),
+			valueField,
+		)
+
+		frame.SetMeta(&data.FrameMeta{
+			PreferredVisualization: data.VisTypeGraph,
+			Type:                   data.FrameTypeTimeSeriesMulti,
+		})
+
+		frames = append(frames, frame)
+	}
+
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].Name < frames[j].Name
+	})
+
+	return frames
+}
+
+func statsUnit(metric string) string {
+	switch metric {
+	case StatsMetricMemory, StatsMetricFilesystem:
+		return "bytes"
+	case StatsMetricNetworkReceive, StatsMetricNetworkTransmit:
+		return "Bps"
+	default:
+		return "short"
+	}
+}
+
+func newStatsStore(retention time.Duration) *statsStore {
+	return &statsStore{
+		series:    make(map[string]*statsSeries),
+		retention: retention,
+		lock:      sync.Mutex{},
+	}
+}
@@ -0,0 +1,102 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func uint64Ptr(i uint64) *uint64 {
+	return &i
+}
+
+func newStatsSummary(t time.Time, cpu, rx uint64) StatsSummary {
+	return StatsSummary{
+		Node: StatsNode{NodeName: "node1"},
+		Pods: []StatsPod{{
+			PodRef:  StatsPodReference{Name: "echoserver", Namespace: "default"},
+			CPU:     &StatsCPU{Time: t, UsageNanoCores: uint64Ptr(cpu)},
+			Network: &StatsNetwork{Time: t, RxBytes: uint64Ptr(rx), TxBytes: uint64Ptr(rx)},
+			Containers: []StatsContainer{{
+				Name:   "echoserver",
+				Memory: &StatsMemory{Time: t, WorkingSetBytes: uint64Ptr(1024)},
+			}},
+			VolumeStats: []StatsVolume{
+				{Name: "config", StatsFs: StatsFs{Time: t, UsedBytes: uint64Ptr(1)}},
+				{Name: "data", StatsFs: StatsFs{Time: t, UsedBytes: uint64Ptr(2048)}, PVCRef: &StatsPVCReference{Name: "data-echoserver", Namespace: "default"}},
+			},
+		}},
+	}
+}
+
+func TestStatsStore(t *testing.T) {
+	now := time.Now()
+	timeRange := backend.TimeRange{From: now.Add(-1 * time.Hour), To: now.Add(1 * time.Hour)}
+
+	store := newStatsStore(statsRetention)
+	store.addSummary(newStatsSummary(now.Add(-20*time.Second), 500000000, 1000))
+	store.addSummary(newStatsSummary(now.Add(-20*time.Second), 600000000, 1000))
+	store.addSummary(newStatsSummary(now.Add(-10*time.Second), 250000000, 2000))
+
+	t.Run("should ignore samples with the same time", func(t *testing.T) {
+		frames := store.getDataFrames(StatsLevelPod, StatsMetricCPU, []string{"node1"}, nil, nil, false, timeRange)
+		require.Len(t, frames, 1)
+		require.Equal(t, "default/echoserver", frames[0].Name)
+		require.Equal(t, 2, frames[0].Fields[1].Len())
+		require.Equal(t, 0.5, frames[0].Fields[1].At(0))
+		require.Equal(t, 0.25, frames[0].Fields[1].At(1))
+	})
+
+	t.Run("should return rate for network metrics", func(t *testing.T) {
+		frames := store.getDataFrames(StatsLevelPod, StatsMetricNetworkReceive, []string{"node1"}, nil, nil, false, timeRange)
+		require.Len(t, frames, 1)
+		require.Equal(t, 1, frames[0].Fields[1].Len())
+		require.Equal(t, 100.0, frames[0].Fields[1].At(0))
+	})
+
+	t.Run("should return container metrics", func(t *testing.T) {
+		frames := store.getDataFrames(StatsLevelContainer, StatsMetricMemory, []string{"node1"}, []string{"default"}, nil, false, timeRange)
+		require.Len(t, frames, 1)
+		require.Equal(t, "default/echoserver/echoserver", frames[0].Name)
+		require.Equal(t, "echoserver", frames[0].Fields[1].Labels["container"])
+	})
+
+	t.Run("should only return volumes with persistent volume claim", func(t *testing.T) {
+		frames := store.getDataFrames(StatsLevelVolume, StatsMetricFilesystem, []string{"node1"}, nil, nil, false, timeRange)
+		require.Len(t, frames, 1)
+		require.Equal(t, "data-echoserver", frames[0].Fields[1].Labels["persistentvolumeclaim"])
+	})
+
+	t.Run("should remove node label when nodes are hidden", func(t *testing.T) {
+		frames := store.getDataFrames(StatsLevelPod, StatsMetricCPU, []string{"node1"}, nil, nil, true, timeRange)
+		require.Len(t, frames, 1)
+		require.NotContains(t, frames[0].Fields[1].Labels, "node")
+
+		frames = store.getDataFrames(StatsLevelPod, StatsMetricCPU, []string{"node1"}, nil, nil, false, timeRange)
+		require.Equal(t, "node1", frames[0].Fields[1].Labels["node"])
+	})
+
+	t.Run("should filter by namespace", func(t *testing.T) {
+		frames := store.getDataFrames(StatsLevelPod, StatsMetricCPU, []string{"node1"}, []string{"kube-system"}, nil, false, timeRange)
+		require.Len(t, frames, 0)
+	})
+
+	t.Run("should only return series of the provided nodes", func(t *testing.T) {
+		frames := store.getDataFrames(StatsLevelPod, StatsMetricCPU, []string{"node2"}, nil, nil, false, timeRange)
+		require.Len(t, frames, 0)
+
+		frames = store.getDataFrames(StatsLevelPod, StatsMetricCPU, nil, nil, nil, false, timeRange)
+		require.Len(t, frames, 0)
+	})
+
+	t.Run("should remove samples older than the retention", func(t *testing.T) {
+		store.lock.Lock()
+		store.prune(now.Add(2 * time.Hour))
+		store.lock.Unlock()
+
+		frames := store.getDataFrames(StatsLevelPod, StatsMetricCPU, []string{"node1"}, nil, nil, false, timeRange)
+		require.Len(t, frames, 0)
+	})
+}
@@ -2,6 +2,7 @@ package kubernetes
 
 import (
 	"io"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -45,3 +46,92 @@ type AppSpec struct {
 	Selector *metav1.LabelSelector `json:"selector"`
 	Template v1.PodTemplateSpec    `json:"template"`
 }
+
+// MetricsList represents a list of "PodMetrics" or "NodeMetrics" from the
+// "metrics.k8s.io" API. For "NodeMetrics" the usage is set in the "Usage"
+// field, for "PodMetrics" the usage is set per container in the "Containers"
+// field.
+type MetricsList struct {
+	Items []Metrics `json:"items"`
+}
+
+type Metrics struct {
+	Metadata   metav1.ObjectMeta  `json:"metadata"`
+	Timestamp  metav1.Time        `json:"timestamp"`
+	Usage      v1.ResourceList    `json:"usage,omitempty"`
+	Containers []ContainerMetrics `json:"containers,omitempty"`
+}
+
+type ContainerMetrics struct {
+	Name  string          `json:"name"`
+	Usage v1.ResourceList `json:"usage"`
+}
+
+// StatsSummary represents the response of the "/stats/summary" endpoint of the
+// kubelet. We only define the fields we need to get the CPU, memory, network
+// and filesystem usage of pods, containers and volumes, so that we do not have
+// to import the kubelet module.
+type StatsSummary struct {
+	Node StatsNode  `json:"node"`
+	Pods []StatsPod `json:"pods"`
+}
+
+type StatsNode struct {
+	NodeName string `json:"nodeName"`
+}
+
+type StatsPod struct {
+	PodRef           StatsPodReference `json:"podRef"`
+	Containers       []StatsContainer  `json:"containers"`
+	CPU              *StatsCPU         `json:"cpu,omitempty"`
+	Memory           *StatsMemory      `json:"memory,omitempty"`
+	Network          *StatsNetwork     `json:"network,omitempty"`
+	VolumeStats      []StatsVolume     `json:"volume,omitempty"`
+	EphemeralStorage *StatsFs          `json:"ephemeral-storage,omitempty"`
+}
+
+type StatsPodReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type StatsContainer struct {
+	Name   string       `json:"name"`
+	CPU    *StatsCPU    `json:"cpu,omitempty"`
+	Memory *StatsMemory `json:"memory,omitempty"`
+	Rootfs *StatsFs     `json:"rootfs,omitempty"`
+	Logs   *StatsFs     `json:"logs,omitempty"`
+}
+
+type StatsCPU struct {
+	Time           time.Time `json:"time"`
+	UsageNanoCores *uint64   `json:"usageNanoCores,omitempty"`
+}
+
+type StatsMemory struct {
+	Time            time.Time `json:"time"`
+	WorkingSetBytes *uint64   `json:"workingSetBytes,omitempty"`
+}
+
+type StatsNetwork struct {
+	Time    time.Time `json:"time"`
+	RxBytes *uint64   `json:"rxBytes,omitempty"`
+	TxBytes *uint64   `json:"txBytes,omitempty"`
+}
+
+type StatsFs struct {
+	Time          time.Time `json:"time"`
+	UsedBytes     *uint64   `json:"usedBytes,omitempty"`
+	CapacityBytes *uint64   `json:"capacityBytes,omitempty"`
+}
+
+type StatsVolume struct {
+	StatsFs
+	Name   string             `json:"name"`
+	PVCRef *StatsPVCReference `json:"pvcRef,omitempty"`
+}
+
+type StatsPVCReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
@@ -0,0 +1,297 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	WorkloadActionScale   = "scale"
+	WorkloadActionRestart = "restart"
+	WorkloadActionPause   = "pause"
+	WorkloadActionResume  = "resume"
+)
+
+const (
+	RolloutStatusComplete    = "Complete"
+	RolloutStatusProgressing = "Progressing"
+	RolloutStatusStalled     = "Stalled"
+	RolloutStatusPaused      = "Paused"
+)
+
+// restartedAtAnnotation is the annotation which is set in the pod template of
+// a workload to restart it. This is the same annotation as it is used by the
+// frontend.
+const restartedAtAnnotation = "grafana-kubernetes-plugin.ricoberger.de/restartedAt"
+
+// WorkloadActionOptions are the options for a workload action. The replicas
+// are only required for the "scale" action.
+type WorkloadActionOptions struct {
+	Replicas *int32 `json:"replicas"`
+}
+
+// RolloutState is the state of the rollout of a Deployment, StatefulSet or
+// DaemonSet.
+type RolloutState struct {
+	Kind               string `json:"kind"`
+	Namespace          string `json:"namespace"`
+	Name               string `json:"name"`
+	Status             string `json:"status"`
+	Message            string `json:"message"`
+	Paused             bool   `json:"paused"`
+	DeadlineExceeded   bool   `json:"deadlineExceeded"`
+	Generation         int64  `json:"generation"`
+	ObservedGeneration int64  `json:"observedGeneration"`
+	Replicas           int32  `json:"replicas"`
+	UpdatedReplicas    int32  `json:"updatedReplicas"`
+	ReadyReplicas      int32  `json:"readyReplicas"`
+	AvailableReplicas  int32  `json:"availableReplicas"`
+}
+
+// newWorkloadActionPatch returns the subresource and the JSON merge patch for
+// the provided workload action. The "pause" and "resume" actions are only
+// supported for Deployments and the "scale" action is not supported for
+// DaemonSets.
+func newWorkloadActionPatch(resourceId, action string, options WorkloadActionOptions, now time.Time) (string, []byte, error) {
+	if resourceId != "deployment.apps" && resourceId != "statefulset.apps" && resourceId != "daemonset.apps" {
+		return "", nil, fmt.Errorf("resource %s is not a workload", resourceId)
+	}
+
+	switch action {
+	case WorkloadActionScale:
+		if resourceId == "daemonset.apps" {
+			return "", nil, fmt.Errorf("action %s is not supported for resource %s", action, resourceId)
+		}
+		if options.Replicas == nil || *options.Replicas < 0 {
+			return "", nil, fmt.Errorf("replicas must be provided and can not be negative")
+		}
+
+		patch, err := json.Marshal(map[string]any{"spec": map[string]any{"replicas": *options.Replicas}})
+		return "scale", patch, err
+	case WorkloadActionRestart:
+		patch, err := json.Marshal(map[string]any{"spec": map[string]any{"template": map[string]any{"metadata": map[string]any{"annotations": map[string]string{restartedAtAnnotation: now.UTC().Format(time.RFC3339)}}}}})
+		return "", patch, err
+	case WorkloadActionPause, WorkloadActionResume:
+		if resourceId != "deployment.apps" {
+			return "", nil, fmt.Errorf("action %s is not supported for resource %s", action, resourceId)
+		}
+
+		patch, err := json.Marshal(map[string]any{"spec": map[string]any{"paused": action == WorkloadActionPause}})
+		return "", patch, err
+	default:
+		return "", nil, fmt.Errorf("action %s is not supported", action)
+	}
+}
+
+// newRolloutState returns the rollout state for the provided Deployment,
+// StatefulSet or DaemonSet. The status is computed similar to the
+// "kubectl rollout status" command:
+//
+//   - Stalled: The progress deadline of a Deployment was exceeded.
+//   - Paused: The rollout of a Deployment is paused.
+//   - Progressing: The controller didn't observe the latest generation yet or
+//     not all replicas are updated and available.
+//   - Complete: All replicas are updated and available.
+func newRolloutState(resourceId string, object []byte) (*RolloutState, error) {
+	switch resourceId {
+	case "deployment.apps":
+		var deployment appsv1.Deployment
+		if err := json.Unmarshal(object, &deployment); err != nil {
+			return nil, err
+		}
+
+		state := &RolloutState{
+			Kind:               "Deployment",
+			Namespace:          deployment.Namespace,
+			Name:               deployment.Name,
+			Paused:             deployment.Spec.Paused,
+			Generation:         deployment.Generation,
+			ObservedGeneration: deployment.Status.ObservedGeneration,
+			Replicas:           ptrValue(deployment.Spec.Replicas, 1),
+			UpdatedReplicas:    deployment.Status.UpdatedReplicas,
+			ReadyReplicas:      deployment.Status.ReadyReplicas,
+			AvailableReplicas:  deployment.Status.AvailableReplicas,
+		}
+
+		for _, condition := range deployment.Status.Conditions {
+			if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse && condition.Reason == "ProgressDeadlineExceeded" {
+				state.Status = RolloutStatusStalled
+				state.Message = condition.Message
+				state.DeadlineExceeded = true
+				return state, nil
+			}
+		}
+
+		if state.Paused {
+			state.Status = RolloutStatusPaused
+			state.Message = "rollout is paused"
+			return state, nil
+		}
+
+		setRolloutStatus(state, deployment.Status.Replicas)
+		return state, nil
+	case "statefulset.apps":
+		var statefulSet appsv1.StatefulSet
+		if err := json.Unmarshal(object, &statefulSet); err != nil {
+			return nil, err
+		}
+
+		state := &RolloutState{
+			Kind:               "StatefulSet",
+			Namespace:          statefulSet.Namespace,
+			Name:               statefulSet.Name,
+			Generation:         statefulSet.Generation,
+			ObservedGeneration: statefulSet.Status.ObservedGeneration,
+			Replicas:           ptrValue(statefulSet.Spec.Replicas, 1),
+			UpdatedReplicas:    statefulSet.Status.UpdatedReplicas,
+			ReadyReplicas:      statefulSet.Status.ReadyReplicas,
+			AvailableReplicas:  statefulSet.Status.AvailableReplicas,
+		}
+
+		setRolloutStatus(state, statefulSet.Status.Replicas)
+		return state, nil
+	case "daemonset.apps":
+		var daemonSet appsv1.DaemonSet
+		if err := json.Unmarshal(object, &daemonSet); err != nil {
+			return nil, err
+		}
+
+		state := &RolloutState{
+			Kind:               "DaemonSet",
+			Namespace:          daemonSet.Namespace,
+			Name:               daemonSet.Name,
+			Generation:         daemonSet.Generation,
+			ObservedGeneration: daemonSet.Status.ObservedGeneration,
+			Replicas:           daemonSet.Status.DesiredNumberScheduled,
+			UpdatedReplicas:    daemonSet.Status.UpdatedNumberScheduled,
+			ReadyReplicas:      daemonSet.Status.NumberReady,
+			AvailableReplicas:  daemonSet.Status.NumberAvailable,
+		}
+
+		setRolloutStatus(state, daemonSet.Status.CurrentNumberScheduled)
+		return state, nil
+	default:
+		return nil, fmt.Errorf("resource %s is not a workload", resourceId)
+	}
+}
+
+// setRolloutStatus sets the status and message of the provided rollout state,
+// based on the generation and the number of replicas. The current replicas
+// are the total number of replicas (old and new ones) which are currently
+// running.
+func setRolloutStatus(state *RolloutState, currentReplicas int32) {
+	switch {
+	case state.ObservedGeneration < state.Generation:
+		state.Status = RolloutStatusProgressing
+		state.Message = "waiting for rollout to be observed"
+	case state.UpdatedReplicas < state.Replicas:
+		state.Status = RolloutStatusProgressing
+		state.Message = fmt.Sprintf("%d out of %d new replicas have been updated", state.UpdatedReplicas, state.Replicas)
+	case currentReplicas > state.UpdatedReplicas:
+		state.Status = RolloutStatusProgressing
+		state.Message = fmt.Sprintf("%d old replicas are pending termination", currentReplicas-state.UpdatedReplicas)
+	case state.AvailableReplicas < state.UpdatedReplicas:
+		state.Status = RolloutStatusProgressing
+		state.Message = fmt.Sprintf("%d of %d updated replicas are available", state.AvailableReplicas, state.UpdatedReplicas)
+	default:
+		state.Status = RolloutStatusComplete
+		state.Message = "rollout is complete"
+	}
+}
+
+// rolloutPhase returns the numeric phase of the provided rollout status, so
+// that the status can be used in alert rules. Paused rollouts are reported as
+// progressing, because they are not complete, but also not stalled.
+func rolloutPhase(status string) int64 {
+	switch status {
+	case RolloutStatusComplete:
+		return 0
+	case RolloutStatusStalled:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// createRolloutStatusDataFrames creates the data frames with the rollout
+// status of the provided workloads. The first frame uses the numeric long
+// format, where each row is one workload. The kind, namespace and name are
+// used as labels and the numeric fields as values, so that the frame can be
+// used in alert rules, e.g. to alert on all workloads with the phase 2
+// (Stalled). The status and message of each workload are returned in a
+// second table frame, because they change during a rollout and would create
+// new alert instances if they were used as labels.
+func createRolloutStatusDataFrames(states []RolloutState) []*data.Frame {
+	var kinds, namespaces, names, statuses, messages []string
+	var phases, generations, observedGenerations, replicas, updatedReplicas, readyReplicas, availableReplicas, deadlineExceeded []int64
+
+	for _, state := range states {
+		kinds = append(kinds, state.Kind)
+		namespaces = append(namespaces, state.Namespace)
+		names = append(names, state.Name)
+		statuses = append(statuses, state.Status)
+		messages = append(messages, state.Message)
+		phases = append(phases, rolloutPhase(state.Status))
+		generations = append(generations, state.Generation)
+		observedGenerations = append(observedGenerations, state.ObservedGeneration)
+		replicas = append(replicas, int64(state.Replicas))
+		updatedReplicas = append(updatedReplicas, int64(state.UpdatedReplicas))
+		readyReplicas = append(readyReplicas, int64(state.ReadyReplicas))
+		availableReplicas = append(availableReplicas, int64(state.AvailableReplicas))
+		if state.DeadlineExceeded {
+			deadlineExceeded = append(deadlineExceeded, 1)
+		} else {
+			deadlineExceeded = append(deadlineExceeded, 0)
+		}
+	}
+
+	statusFrame := data.NewFrame(
+		"RolloutStatus",
+		data.NewField("Kind", nil, kinds),
+		data.NewField("Namespace", nil, namespaces),
+		data.NewField("Name", nil, names),
+		data.NewField("Phase", nil, phases),
+		data.NewField("Generation", nil, generations),
+		data.NewField("Observed Generation", nil, observedGenerations),
+		data.NewField("Replicas", nil, replicas),
+		data.NewField("Updated Replicas", nil, updatedReplicas),
+		data.NewField("Ready Replicas", nil, readyReplicas),
+		data.NewField("Available Replicas", nil, availableReplicas),
+		data.NewField("Progress Deadline Exceeded", nil, deadlineExceeded),
+	)
+
+	statusFrame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeNumericLong,
+		TypeVersion:            data.FrameTypeVersion{0, 1},
+	})
+
+	messageFrame := data.NewFrame(
+		"RolloutMessages",
+		data.NewField("Kind", nil, slices.Clone(kinds)),
+		data.NewField("Namespace", nil, slices.Clone(namespaces)),
+		data.NewField("Name", nil, slices.Clone(names)),
+		data.NewField("Status", nil, statuses),
+		data.NewField("Message", nil, messages),
+	)
+
+	messageFrame.SetMeta(&data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+		Type:                   data.FrameTypeTable,
+	})
+
+	return []*data.Frame{statusFrame, messageFrame}
+}
+
+func ptrValue[T any](value *T, defaultValue T) T {
+	if value == nil {
+		return defaultValue
+	}
+	return *value
+}
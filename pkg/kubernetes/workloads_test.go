@@ -0,0 +1,137 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWorkloadActionPatch(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	replicas := int32(3)
+
+	t.Run("should scale via scale subresource", func(t *testing.T) {
+		subresource, patch, err := newWorkloadActionPatch("statefulset.apps", WorkloadActionScale, WorkloadActionOptions{Replicas: &replicas}, now)
+		require.NoError(t, err)
+		require.Equal(t, "scale", subresource)
+		require.JSONEq(t, `{"spec":{"replicas":3}}`, string(patch))
+	})
+
+	t.Run("should restart via pod template annotation", func(t *testing.T) {
+		subresource, patch, err := newWorkloadActionPatch("daemonset.apps", WorkloadActionRestart, WorkloadActionOptions{}, now)
+		require.NoError(t, err)
+		require.Equal(t, "", subresource)
+		require.JSONEq(t, `{"spec":{"template":{"metadata":{"annotations":{"grafana-kubernetes-plugin.ricoberger.de/restartedAt":"2025-01-01T10:00:00Z"}}}}}`, string(patch))
+	})
+
+	t.Run("should pause deployment", func(t *testing.T) {
+		_, patch, err := newWorkloadActionPatch("deployment.apps", WorkloadActionPause, WorkloadActionOptions{}, now)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"spec":{"paused":true}}`, string(patch))
+	})
+
+	t.Run("should fail for unsupported actions", func(t *testing.T) {
+		_, _, err := newWorkloadActionPatch("daemonset.apps", WorkloadActionScale, WorkloadActionOptions{Replicas: &replicas}, now)
+		require.Error(t, err)
+		_, _, err = newWorkloadActionPatch("statefulset.apps", WorkloadActionResume, WorkloadActionOptions{}, now)
+		require.Error(t, err)
+		_, _, err = newWorkloadActionPatch("deployment.apps", WorkloadActionScale, WorkloadActionOptions{}, now)
+		require.Error(t, err)
+		_, _, err = newWorkloadActionPatch("pod", WorkloadActionRestart, WorkloadActionOptions{}, now)
+		require.Error(t, err)
+	})
+}
+
+func TestNewRolloutState(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		resourceId     string
+		object         string
+		expectedStatus string
+	}{
+		{
+			name:           "complete deployment",
+			resourceId:     "deployment.apps",
+			object:         `{"metadata":{"name":"echoserver","generation":2},"spec":{"replicas":2},"status":{"observedGeneration":2,"replicas":2,"updatedReplicas":2,"readyReplicas":2,"availableReplicas":2}}`,
+			expectedStatus: RolloutStatusComplete,
+		},
+		{
+			name:           "progressing deployment",
+			resourceId:     "deployment.apps",
+			object:         `{"metadata":{"name":"echoserver","generation":2},"spec":{"replicas":2},"status":{"observedGeneration":2,"replicas":3,"updatedReplicas":2,"readyReplicas":2,"availableReplicas":2}}`,
+			expectedStatus: RolloutStatusProgressing,
+		},
+		{
+			name:           "stalled deployment",
+			resourceId:     "deployment.apps",
+			object:         `{"metadata":{"name":"echoserver","generation":2},"spec":{"replicas":2},"status":{"observedGeneration":2,"replicas":2,"updatedReplicas":1,"conditions":[{"type":"Progressing","status":"False","reason":"ProgressDeadlineExceeded"}]}}`,
+			expectedStatus: RolloutStatusStalled,
+		},
+		{
+			name:           "paused deployment",
+			resourceId:     "deployment.apps",
+			object:         `{"metadata":{"name":"echoserver","generation":2},"spec":{"replicas":2,"paused":true},"status":{"observedGeneration":1}}`,
+			expectedStatus: RolloutStatusPaused,
+		},
+		{
+			name:           "progressing statefulset",
+			resourceId:     "statefulset.apps",
+			object:         `{"metadata":{"name":"echoserver","generation":2},"spec":{"replicas":2},"status":{"observedGeneration":1}}`,
+			expectedStatus: RolloutStatusProgressing,
+		},
+		{
+			name:           "complete daemonset",
+			resourceId:     "daemonset.apps",
+			object:         `{"metadata":{"name":"echoserver","generation":1},"status":{"observedGeneration":1,"desiredNumberScheduled":3,"currentNumberScheduled":3,"updatedNumberScheduled":3,"numberReady":3,"numberAvailable":3}}`,
+			expectedStatus: RolloutStatusComplete,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			state, err := newRolloutState(tc.resourceId, []byte(tc.object))
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedStatus, state.Status)
+		})
+	}
+}
+
+func TestCreateRolloutStatusDataFrames(t *testing.T) {
+	frames := createRolloutStatusDataFrames([]RolloutState{
+		{Kind: "Deployment", Namespace: "default", Name: "echoserver", Status: RolloutStatusComplete, Message: "rollout is complete", Replicas: 2, UpdatedReplicas: 2},
+		{Kind: "Deployment", Namespace: "default", Name: "nginx", Status: RolloutStatusStalled, DeadlineExceeded: true},
+		{Kind: "Deployment", Namespace: "default", Name: "redis", Status: RolloutStatusPaused},
+	})
+	require.Len(t, frames, 2)
+
+	t.Run("should only use kind, namespace and name as labels", func(t *testing.T) {
+		frame := frames[0]
+		require.Equal(t, 3, frame.Rows())
+		require.Equal(t, data.FrameTypeNumericLong, frame.Meta.Type)
+
+		var labels []string
+		for _, field := range frame.Fields {
+			if field.Type() == data.FieldTypeString {
+				labels = append(labels, field.Name)
+			}
+		}
+		require.Equal(t, []string{"Kind", "Namespace", "Name"}, labels)
+
+		require.Equal(t, "Phase", frame.Fields[3].Name)
+		require.Equal(t, int64(0), frame.Fields[3].At(0))
+		require.Equal(t, int64(2), frame.Fields[3].At(1))
+		require.Equal(t, int64(1), frame.Fields[3].At(2))
+		require.Equal(t, int64(2), frame.Fields[6].At(0))
+		require.Equal(t, int64(1), frame.Fields[10].At(1))
+	})
+
+	t.Run("should return status and message in separate frame", func(t *testing.T) {
+		frame := frames[1]
+		require.Equal(t, 3, frame.Rows())
+		require.Equal(t, data.FrameTypeTable, frame.Meta.Type)
+		require.Equal(t, "Status", frame.Fields[3].Name)
+		require.Equal(t, RolloutStatusStalled, frame.Fields[3].At(1))
+		require.Equal(t, "Message", frame.Fields[4].Name)
+		require.Equal(t, "rollout is complete", frame.Fields[4].At(0))
+	})
+}
@@ -3,16 +3,30 @@ package models
 type QueryType string
 
 const (
-	QueryTypeSettings              = "settings"
-	QueryTypeKubernetesResourceIds = "kubernetes-resourceids"
-	QueryTypeKubernetesNamespaces  = "kubernetes-namespaces"
-	QueryTypeKubernetesResources   = "kubernetes-resources"
-	QueryTypeKubernetesContainers  = "kubernetes-containers"
-	QueryTypeKubernetesLogs        = "kubernetes-logs"
-	QueryTypeHelmReleases          = "helm-releases"
-	QueryTypeHelmReleaseHistory    = "helm-release-history"
+	QueryTypeSettings                 = "settings"
+	QueryTypeKubernetesResourceIds    = "kubernetes-resourceids"
+	QueryTypeKubernetesNamespaces     = "kubernetes-namespaces"
+	QueryTypeKubernetesResources      = "kubernetes-resources"
+	QueryTypeKubernetesContainers     = "kubernetes-containers"
+	QueryTypeKubernetesLogs           = "kubernetes-logs"
+	QueryTypeKubernetesStats          = "kubernetes-stats"
+	QueryTypeKubernetesMetrics        = "kubernetes-metrics"
+	QueryTypeKubernetesEvents         = "kubernetes-events"
+	QueryTypeKubernetesHistory        = "kubernetes-history"
+	QueryTypeKubernetesManagedFields  = "kubernetes-managedfields"
+	QueryTypeKubernetesRolloutHistory = "kubernetes-rollout-history"
+	QueryTypeKubernetesRolloutStatus  = "kubernetes-rollout-status"
+	QueryTypeHelmReleases             = "helm-releases"
+	QueryTypeHelmReleaseHistory       = "helm-release-history"
+	QueryTypeAuditLog                 = "audit-log"
 )
 
+// QueryModelStream is used to get the query type of a streaming request, so
+// that we can decide which query model must be used for the request.
+type QueryModelStream struct {
+	QueryType string `json:"queryType"`
+}
+
 type QueryModelSettings struct {
 	Setting string `json:"setting"`
 }
@@ -41,6 +55,53 @@ type QueryModelKubernetesLogs struct {
 	Previous   bool   `json:"previous"`
 }
 
+type QueryModelKubernetesMetrics struct {
+	ResourceId string `json:"resourceId"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	Containers bool   `json:"containers"`
+	Interval   string `json:"interval"`
+}
+
+type QueryModelKubernetesStats struct {
+	Node      string `json:"node"`
+	Namespace string `json:"namespace"`
+	Filter    string `json:"filter"`
+	Level     string `json:"level"`
+	Metric    string `json:"metric"`
+}
+
+type QueryModelKubernetesEvents struct {
+	Namespace string   `json:"namespace"`
+	Type      string   `json:"type"`
+	Filter    string   `json:"filter"`
+	GroupBy   []string `json:"groupBy"`
+}
+
+type QueryModelKubernetesManagedFields struct {
+	ResourceId string `json:"resourceId"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+}
+
+type QueryModelKubernetesRolloutStatus struct {
+	ResourceId string `json:"resourceId"`
+	Namespace  string `json:"namespace"`
+	Filter     string `json:"filter"`
+}
+
+type QueryModelKubernetesRolloutHistory struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type QueryModelKubernetesHistory struct {
+	ResourceId string `json:"resourceId"`
+	Namespace  string `json:"namespace"`
+	Filter     string `json:"filter"`
+	Mode       string `json:"mode"`
+}
+
 type QueryModelHelmReleases struct {
 	Namespace string `json:"namespace"`
 }
@@ -49,3 +110,7 @@ type QueryModelHelmReleaseHistory struct {
 	Namespace string `json:"namespace"`
 	Name      string `json:"name"`
 }
+
+type QueryModelAuditLog struct {
+	Filter string `json:"filter"`
+}
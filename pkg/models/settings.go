@@ -3,6 +3,7 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
@@ -30,12 +31,86 @@ type PluginSettings struct {
 	IntegrationsMetricsClusterLabel  string                `json:"integrationsMetricsClusterLabel"`
 	IntegrationsMetricsLogs          string                `json:"integrationsMetricsLogs"`
 	IntegrationsTracesQuery          string                `json:"integrationsTracesQuery"`
+	KubeStateMetrics                 bool                  `json:"kubeStateMetrics"`
+	History                          bool                  `json:"history"`
+	HistoryResourceIds               []string              `json:"historyResourceIds"`
+	HistoryPath                      string                `json:"historyPath"`
+	HistoryRetention                 int64                 `json:"historyRetention"`
+	HistoryMaxEntries                int64                 `json:"historyMaxEntries"`
+	ExecAllowlist                    [][]string            `json:"execAllowlist"`
+	ExecTimeout                      int64                 `json:"execTimeout"`
+	DownloadAllowlist                []string              `json:"downloadAllowlist"`
+	DownloadMaxSize                  int64                 `json:"downloadMaxSize"`
+	DebugImages                      []string              `json:"debugImages"`
+	PreviewPorts                     []string              `json:"previewPorts"`
+	ProxyPolicy                      string                `json:"proxyPolicy"`
+	ProxyPolicyRules                 []ProxyPolicyRule     `json:"proxyPolicyRules"`
+	ProxyPodExec                     ProxySubresource      `json:"proxyPodExec"`
+	ProxyPodAttach                   ProxySubresource      `json:"proxyPodAttach"`
+	ProxyPodPortForward              ProxySubresource      `json:"proxyPodPortForward"`
+	ProxyPodProxy                    ProxySubresource      `json:"proxyPodProxy"`
+	Recordings                       bool                  `json:"recordings"`
+	RecordingsPath                   string                `json:"recordingsPath"`
+	RecordingsRetention              int64                 `json:"recordingsRetention"`
+	Audit                            bool                  `json:"audit"`
+	AuditPath                        string                `json:"auditPath"`
+	AuditMaxSize                     int64                 `json:"auditMaxSize"`
+	AuditMaxBackups                  int64                 `json:"auditMaxBackups"`
+	Annotations                      bool                  `json:"annotations"`
+	Webhooks                         []Webhook             `json:"webhooks"`
+	Approvals                        bool                  `json:"approvals"`
+	ApprovalsPath                    string                `json:"approvalsPath"`
+	ApprovalsTeams                   []string              `json:"approvalsTeams"`
+	ApprovalsNamespaces              []string              `json:"approvalsNamespaces"`
+	ApprovalsTTL                     int64                 `json:"approvalsTTL"`
+	AllowedNamespaces                []string              `json:"allowedNamespaces"`
+	HideClusterScopedResources       bool                  `json:"hideClusterScopedResources"`
 	Secrets                          *SecretPluginSettings `json:"-"`
 }
 
+// ProxyPolicyRule is a rule of the proxy policy. A request is allowed by the
+// rule, when the verb, API group, resource, subresource and namespace of the
+// request are matching the rule. Like in RBAC rules, "*" matches all values.
+// An empty list also matches all values. An empty subresource ("") matches
+// requests without a subresource and an empty namespace matches cluster-scoped
+// requests.
+type ProxyPolicyRule struct {
+	Verbs        []string `json:"verbs"`
+	APIGroups    []string `json:"apiGroups"`
+	Resources    []string `json:"resources"`
+	Subresources []string `json:"subresources"`
+	Namespaces   []string `json:"namespaces"`
+}
+
+// ProxySubresource are the settings for a subresource of pods, which can be
+// used to modify a pod or to access its network (e.g. "exec" or
+// "portforward"). The subresource can be disabled or restricted to members of
+// the provided Grafana teams. If no teams are set, the subresource can be used
+// by all users.
+type ProxySubresource struct {
+	Disabled bool     `json:"disabled"`
+	Teams    []string `json:"teams"`
+}
+
+// Webhook is a target, which receives a notification for each action made
+// through the plugin. The events and namespaces are lists of regular
+// expressions, which are used to filter the notifications. An event is the
+// resource and verb of the action (e.g. "helmreleases:rollback" or
+// "deployments.apps:restart"). If no events or namespaces are set, the webhook
+// receives all notifications. The secret, which is used to sign the payload,
+// is stored in the secure JSON data with the key "webhookSecret.<name>".
+type Webhook struct {
+	Name       string   `json:"name"`
+	Url        string   `json:"url"`
+	Events     []string `json:"events"`
+	Namespaces []string `json:"namespaces"`
+}
+
 type SecretPluginSettings struct {
-	ClusterKubeconfig string `json:"clusterKubeconfig"`
-	GrafanaPassword   string `json:"grafanaPassword"`
+	ClusterKubeconfig     string            `json:"clusterKubeconfig"`
+	GrafanaPassword       string            `json:"grafanaPassword"`
+	KubeStateMetricsToken string            `json:"kubeStateMetricsToken"`
+	WebhookSecrets        map[string]string `json:"-"`
 }
 
 // LoadPluginSettings loads the plugin settings from the instance settings of
@@ -54,8 +129,17 @@ func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSetti
 }
 
 func loadSecretPluginSettings(source map[string]string) *SecretPluginSettings {
+	webhookSecrets := make(map[string]string)
+	for key, value := range source {
+		if name, ok := strings.CutPrefix(key, "webhookSecret."); ok {
+			webhookSecrets[name] = value
+		}
+	}
+
 	return &SecretPluginSettings{
-		ClusterKubeconfig: source["clusterKubeconfig"],
-		GrafanaPassword:   source["grafanaPassword"],
+		ClusterKubeconfig:     source["clusterKubeconfig"],
+		GrafanaPassword:       source["grafanaPassword"],
+		KubeStateMetricsToken: source["kubeStateMetricsToken"],
+		WebhookSecrets:        webhookSecrets,
 	}
 }
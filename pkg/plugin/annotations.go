@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// createAnnotation creates an organization-wide Grafana annotation for the
+// provided action, so that changes made through the plugin are visible in all
+// dashboards. The annotation is tagged with the datasource, namespace, resource
+// and user, so that it can be filtered by an annotation query, e.g. for the
+// tag "namespace:default". Errors are only logged, because the action was
+// already successful.
+func (d *Datasource) createAnnotation(ctx context.Context, entry audit.Entry) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "createAnnotation")
+	defer span.End()
+
+	text, tags := newAnnotation(ctx, entry)
+
+	if err := d.grafanaClient.CreateAnnotation(ctx, text, tags, entry.Time); err != nil {
+		d.logger.Error("Failed to create annotation", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// newAnnotation returns the text and tags of the annotation for the provided
+// action, e.g. "rollback helmreleases default/echoserver by admin".
+func newAnnotation(ctx context.Context, entry audit.Entry) (string, []string) {
+	tags := []string{"kubernetes"}
+	if name := datasourceName(ctx); name != "" {
+		tags = append(tags, "datasource:"+name)
+	}
+	if entry.Namespace != "" {
+		tags = append(tags, "namespace:"+entry.Namespace)
+	}
+	tags = append(tags, "resource:"+entry.Resource)
+	if entry.User != "" {
+		tags = append(tags, "user:"+entry.User)
+	}
+
+	return entry.String(), tags
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/grafana"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRecordActionAnnotations(t *testing.T) {
+	ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{Name: "kubernetes"}})
+	entry := audit.Entry{Verb: "rollback", Resource: "helmreleases", Namespace: "default", Name: "echoserver"}
+
+	t.Run("should create annotation for successful actions", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		grafanaClient := grafana.NewMockClient(ctrl)
+
+		grafanaClient.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return("admin", nil)
+		grafanaClient.EXPECT().GetTeams(gomock.Any(), gomock.Any()).Return([]string{"team1"}, nil)
+		grafanaClient.EXPECT().CreateAnnotation(gomock.Any(), "rollback helmreleases default/echoserver by admin", []string{"kubernetes", "datasource:kubernetes", "namespace:default", "resource:helmreleases", "user:admin"}, gomock.Any()).Return(nil)
+
+		ds := Datasource{grafanaClient: grafanaClient, annotations: true, logger: log.DefaultLogger}
+
+		w, done := ds.recordAction(ctx, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), entry)
+		w.WriteHeader(http.StatusOK)
+		done()
+	})
+
+	t.Run("should not create annotation for failed and dry-run actions", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		grafanaClient := grafana.NewMockClient(ctrl)
+
+		grafanaClient.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return("admin", nil).Times(2)
+		grafanaClient.EXPECT().GetTeams(gomock.Any(), gomock.Any()).Return(nil, nil).Times(2)
+
+		ds := Datasource{grafanaClient: grafanaClient, annotations: true, logger: log.DefaultLogger}
+
+		w, done := ds.recordAction(ctx, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), entry)
+		w.WriteHeader(http.StatusForbidden)
+		done()
+
+		dryRunEntry := entry
+		dryRunEntry.DryRun = true
+		w, done = ds.recordAction(ctx, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), dryRunEntry)
+		w.WriteHeader(http.StatusOK)
+		done()
+	})
+
+	t.Run("should not record actions when disabled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		grafanaClient := grafana.NewMockClient(ctrl)
+
+		ds := Datasource{grafanaClient: grafanaClient, logger: log.DefaultLogger}
+
+		recorder := httptest.NewRecorder()
+		w, done := ds.recordAction(ctx, recorder, httptest.NewRequest(http.MethodPost, "/", nil), entry)
+		done()
+		require.Equal(t, recorder, w)
+	})
+}
+
+func TestNotifyAction(t *testing.T) {
+	ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{Name: "kubernetes"}})
+	entry := audit.Entry{Verb: "delete", Resource: "pods", Namespace: "default", Name: "echoserver", User: "admin"}
+
+	t.Run("should create annotation for successful requests", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		grafanaClient := grafana.NewMockClient(ctrl)
+
+		grafanaClient.EXPECT().CreateAnnotation(gomock.Any(), "delete pods default/echoserver by admin", []string{"kubernetes", "datasource:kubernetes", "namespace:default", "resource:pods", "user:admin"}, gomock.Any()).Return(nil)
+
+		ds := Datasource{grafanaClient: grafanaClient, annotations: true, logger: log.DefaultLogger}
+
+		successEntry := entry
+		successEntry.StatusCode = http.StatusOK
+		ds.notifyAction(ctx, successEntry)
+	})
+
+	t.Run("should not create annotation for failed requests", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		grafanaClient := grafana.NewMockClient(ctrl)
+
+		ds := Datasource{grafanaClient: grafanaClient, annotations: true, logger: log.DefaultLogger}
+
+		failedEntry := entry
+		failedEntry.StatusCode = http.StatusForbidden
+		ds.notifyAction(ctx, failedEntry)
+	})
+}
@@ -0,0 +1,410 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/approval"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/kubernetes"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// approvalHeaders are the headers of the original request, which are kept
+// for an approval request. The Grafana id header is required to run the
+// action with the identity of the requester. This means that the id token of
+// the requester is stored in the state file of the approvals and is replayed,
+// when the request is approved. The state file is only readable by the user
+// which runs Grafana, the headers are never returned by the API and a request
+// can only be approved until it expires.
+var approvalHeaders = []string{backend.GrafanaUserSignInTokenHeaderName, "Content-Type"}
+
+// approvalAction returns a function, which describes the action of a request
+// for the approval mode. The namespace and name of the action are taken from
+// the path values of the request. If the verb is empty, the "action" path
+// value is used. If the resource is empty, the resource is taken from the "id"
+// path value.
+func (d *Datasource) approvalAction(verb, resource string) func(r *http.Request) (audit.Entry, bool) {
+	return func(r *http.Request) (audit.Entry, bool) {
+		entry := audit.Entry{Verb: verb, Resource: resource, Namespace: r.PathValue("namespace"), Name: r.PathValue("name")}
+		if entry.Verb == "" {
+			entry.Verb = r.PathValue("action")
+		}
+		if entry.Resource == "" {
+			entry.Resource = d.auditResource(r.Context(), r.PathValue("id"), "")
+		}
+		return entry, true
+	}
+}
+
+// approvalProxyAction describes the action of a request to the Kubernetes
+// proxy. Only mutating requests must be approved. Requests which open a
+// streaming connection to a pod (e.g. "exec" or "portforward") are never
+// approved, because they can not be replayed.
+func (d *Datasource) approvalProxyAction(r *http.Request) (audit.Entry, bool) {
+	requestUrl := "/" + r.PathValue("pathname")
+	if r.URL.RawQuery != "" {
+		requestUrl = requestUrl + "?" + r.URL.RawQuery
+	}
+
+	entry, ok, err := kubernetes.NewAuditEntry(r.Method, requestUrl)
+	if err != nil || !ok {
+		return audit.Entry{}, false
+	}
+	if slices.Contains([]string{"get", "list", "watch"}, entry.Verb) || kubernetes.IsStreamingAuditEntry(entry) {
+		return audit.Entry{}, false
+	}
+
+	return entry, true
+}
+
+// requireApproval is a middleware for mutating endpoints. When the approval
+// mode is enabled and the action must be approved, the action isn't run.
+// Instead a pending approval request with the exact method, url and body of
+// the request is created and returned with the status code "202 Accepted".
+// Dry-run requests and requests which were already approved are passed to the
+// next handler.
+func (d *Datasource) requireApproval(describe func(r *http.Request) (audit.Entry, bool), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.approvals == nil || approval.IsApproved(r.Context()) {
+			next(w, r)
+			return
+		}
+
+		entry, ok := describe(r)
+		if !ok || !d.approvals.Matches(entry.Namespace) {
+			next(w, r)
+			return
+		}
+
+		ctx, span := tracing.DefaultTracer().Start(r.Context(), "requireApproval")
+		defer span.End()
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if entry.DryRun || isDryRun(r.URL.Query(), body) {
+			next(w, r)
+			return
+		}
+
+		user, err := d.grafanaClient.GetUser(ctx, r.Header)
+		if err != nil {
+			d.logger.Error("Failed to get user", "error", err.Error())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		header := make(http.Header)
+		for _, key := range approvalHeaders {
+			if value := r.Header.Get(key); value != "" {
+				header.Set(key, value)
+			}
+		}
+
+		request, err := d.approvals.Create(approval.Request{
+			Requester: user,
+			Verb:      entry.Verb,
+			Resource:  entry.Resource,
+			Namespace: entry.Namespace,
+			Name:      entry.Name,
+			Method:    r.Method,
+			Url:       r.URL.RequestURI(),
+			Body:      string(body),
+		}, header)
+		if err != nil {
+			d.logger.Error("Failed to create approval request", "error", err.Error())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+
+			http.Error(w, err.Error(), statusCodeForError(err))
+			return
+		}
+
+		d.logger.Info("Approval request created", "id", request.Id, "user", user, "verb", request.Verb, "resource", request.Resource, "namespace", request.Namespace, "name", request.Name)
+		span.SetAttributes(attribute.Key("id").String(request.Id))
+
+		writeApprovalRequest(w, request, http.StatusAccepted)
+	}
+}
+
+// isDryRun returns true, when the "dryRun" query parameter is set or the body
+// contains options with the "dryRun" field set to true.
+func isDryRun(query url.Values, body []byte) bool {
+	if query.Get("dryRun") != "" {
+		return true
+	}
+
+	var options struct {
+		DryRun bool `json:"dryRun"`
+	}
+	if err := json.Unmarshal(body, &options); err != nil {
+		return false
+	}
+	return options.DryRun
+}
+
+// handleApprovals returns the approval requests of the user. Approvers get all
+// requests, while all other users only get their own requests, because the
+// body of a request can contain sensitive data, e.g. the manifest of a
+// Secret. If the "status" query parameter is set, only requests with the
+// provided status are returned.
+func (d *Datasource) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleApprovals")
+	defer span.End()
+
+	status := approval.Status(r.URL.Query().Get("status"))
+
+	if d.approvals == nil {
+		http.Error(w, "approvals are not enabled", http.StatusNotFound)
+		return
+	}
+
+	user, err := d.grafanaClient.GetUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	teams, err := d.grafanaClient.GetTeams(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get teams", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d.logger.Info("handleApprovals request", "user", user, "teams", teams, "status", status)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("teams").StringSlice(teams))
+	span.SetAttributes(attribute.Key("status").String(string(status)))
+
+	requests, err := d.approvals.List(status)
+	if err != nil {
+		d.logger.Error("Failed to list approval requests", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	if !d.approvals.IsApprover(teams) {
+		requests = slices.DeleteFunc(requests, func(request approval.Request) bool {
+			return request.Requester != user
+		})
+	}
+
+	data, err := json.Marshal(requests)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleApprovalDecision approves or rejects a pending approval request. A
+// request can only be approved by a member of one of the approver teams, who
+// isn't the requester. When the request is approved, the action is run with
+// the identity of the requester and the result of the action is returned. The
+// requester can also reject the own request, to withdraw it.
+func (d *Datasource) handleApprovalDecision(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleApprovalDecision")
+	defer span.End()
+
+	id := r.PathValue("id")
+	decision := r.PathValue("decision")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if decision != "approve" && decision != "reject" {
+		http.Error(w, fmt.Sprintf("invalid decision %q", decision), http.StatusBadRequest)
+		return
+	}
+	if d.approvals == nil {
+		http.Error(w, "approvals are not enabled", http.StatusNotFound)
+		return
+	}
+
+	user, err := d.grafanaClient.GetUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	teams, err := d.grafanaClient.GetTeams(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get teams", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d.logger.Info("handleApprovalDecision request", "user", user, "teams", teams, "id", id, "decision", decision)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("teams").StringSlice(teams))
+	span.SetAttributes(attribute.Key("id").String(id))
+	span.SetAttributes(attribute.Key("decision").String(decision))
+
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: decision, Resource: "approvals", Name: id})
+	defer done()
+
+	var request approval.Request
+	if decision == "reject" {
+		request, err = d.rejectApproval(id, user, teams)
+	} else {
+		request, err = d.approveApproval(r, id, user, teams)
+	}
+	if err != nil {
+		d.logger.Error("Failed to decide approval request", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	writeApprovalRequest(w, request, http.StatusOK)
+}
+
+// rejectApproval rejects the request with the provided id. Requests can be
+// rejected by all approvers and by the requester.
+func (d *Datasource) rejectApproval(id, user string, teams []string) (approval.Request, error) {
+	if !d.approvals.IsApprover(teams) {
+		requests, err := d.approvals.List("")
+		if err != nil {
+			return approval.Request{}, err
+		}
+
+		i := slices.IndexFunc(requests, func(request approval.Request) bool { return request.Id == id })
+		if i == -1 || requests[i].Requester != user {
+			return approval.Request{}, newApprovalForbiddenError(id, "only approvers and the requester are allowed to reject the request")
+		}
+	}
+
+	return d.approvals.Reject(id, user)
+}
+
+// approveApproval approves the request with the provided id and runs the
+// action. The action is run via the same handler as the original request, with
+// the exact method, url and body of the original request and the headers of
+// the requester, so that the action is run with the identity of the
+// requester.
+func (d *Datasource) approveApproval(r *http.Request, id, user string, teams []string) (approval.Request, error) {
+	if !d.approvals.IsApprover(teams) {
+		return approval.Request{}, newApprovalForbiddenError(id, "only approvers are allowed to approve the request")
+	}
+
+	request, header, err := d.approvals.Approve(id, user)
+	if err != nil {
+		return approval.Request{}, err
+	}
+
+	actionRequest, err := http.NewRequestWithContext(approval.WithApproved(r.Context()), request.Method, request.Url, bytes.NewReader([]byte(request.Body)))
+	if err != nil {
+		return d.approvals.Complete(id, approval.Result{StatusCode: http.StatusInternalServerError, Body: err.Error()})
+	}
+	actionRequest.Header = header
+
+	recorder := newApprovalRecorder()
+	d.approvalHandler.ServeHTTP(recorder, actionRequest)
+
+	d.logger.Info("Approved action finished", "id", id, "approver", user, "requester", request.Requester, "statusCode", recorder.StatusCode())
+
+	return d.approvals.Complete(id, approval.Result{StatusCode: recorder.StatusCode(), Body: recorder.body.String()})
+}
+
+func writeApprovalRequest(w http.ResponseWriter, request approval.Request, statusCode int) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// approvalRecorder is the response writer, which is used to run an approved
+// action. It records the status code and body of the response, which are
+// stored as result of the approval request.
+type approvalRecorder struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *approvalRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *approvalRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+}
+
+func (r *approvalRecorder) Write(p []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	return r.body.Write(p)
+}
+
+// StatusCode returns the recorded status code. If nothing was written, the
+// status code is "200 OK".
+func (r *approvalRecorder) StatusCode() int {
+	if r.statusCode == 0 {
+		return http.StatusOK
+	}
+	return r.statusCode
+}
+
+func newApprovalRecorder() *approvalRecorder {
+	return &approvalRecorder{header: make(http.Header)}
+}
+
+// newApprovalForbiddenError returns a forbidden error for the approval request
+// with the provided id.
+func newApprovalForbiddenError(id, message string) error {
+	return apierrors.NewForbidden(schema.GroupResource{Resource: "approvals"}, id, errors.New(message))
+}
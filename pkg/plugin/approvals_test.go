@@ -0,0 +1,203 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/approval"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/grafana"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestApprovals(t *testing.T) {
+	newTestDatasource := func(t *testing.T) (*Datasource, *http.ServeMux, chan string) {
+		ctrl := gomock.NewController(t)
+		grafanaClient := grafana.NewMockClient(ctrl)
+
+		userFromHeader := func(_ context.Context, header http.Header) (string, error) {
+			return header.Get(backend.GrafanaUserSignInTokenHeaderName), nil
+		}
+		teamsFromHeader := func(_ context.Context, header http.Header) ([]string, error) {
+			if header.Get(backend.GrafanaUserSignInTokenHeaderName) == "bob" {
+				return []string{"sre"}, nil
+			}
+			return []string{"dev"}, nil
+		}
+		grafanaClient.EXPECT().GetUser(gomock.Any(), gomock.Any()).DoAndReturn(userFromHeader).AnyTimes()
+		grafanaClient.EXPECT().GetTeams(gomock.Any(), gomock.Any()).DoAndReturn(teamsFromHeader).AnyTimes()
+
+		store, err := approval.NewStore(filepath.Join(t.TempDir(), "approvals.json"), time.Hour, []string{"sre"}, []string{"^production$"})
+		require.NoError(t, err)
+
+		ds := &Datasource{grafanaClient: grafanaClient, approvals: store, logger: log.DefaultLogger}
+
+		actions := make(chan string, 10)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/helm/{namespace}/{name}/{version}/uninstall", ds.requireApproval(ds.approvalAction("uninstall", "helmreleases"), func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			actions <- r.Header.Get(backend.GrafanaUserSignInTokenHeaderName) + " " + r.PathValue("namespace") + " " + string(body)
+			w.Write([]byte(`{"info":"uninstalled"}`))
+		}))
+		mux.HandleFunc("/kubernetes/proxy/{pathname...}", ds.requireApproval(ds.approvalProxyAction, func(w http.ResponseWriter, r *http.Request) {
+			actions <- r.Header.Get(backend.GrafanaUserSignInTokenHeaderName) + " " + r.Method + " " + r.PathValue("pathname")
+		}))
+		mux.HandleFunc("/approvals", ds.handleApprovals)
+		mux.HandleFunc("/approvals/{id}/{decision}", ds.handleApprovalDecision)
+		ds.approvalHandler = mux
+
+		return ds, mux, actions
+	}
+
+	request := func(mux *http.ServeMux, user, method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set(backend.GrafanaUserSignInTokenHeaderName, user)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("should run action after approval", func(t *testing.T) {
+		_, mux, actions := newTestDatasource(t)
+
+		w := request(mux, "alice", http.MethodPost, "/helm/production/echoserver/1/uninstall", `{"wait":true}`)
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Empty(t, actions)
+
+		var pending approval.Request
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+		require.Equal(t, approval.StatusPending, pending.Status)
+		require.Equal(t, "alice", pending.Requester)
+
+		w = request(mux, "bob", http.MethodGet, "/approvals?status=pending", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), pending.Id)
+
+		w = request(mux, "carol", http.MethodPost, "/approvals/"+pending.Id+"/approve", "")
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = request(mux, "bob", http.MethodPost, "/approvals/"+pending.Id+"/approve", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `alice production {"wait":true}`, <-actions)
+
+		var executed approval.Request
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &executed))
+		require.Equal(t, approval.StatusExecuted, executed.Status)
+		require.Equal(t, "bob", executed.Approver)
+		require.Equal(t, `{"info":"uninstalled"}`, executed.Result.Body)
+
+		w = request(mux, "bob", http.MethodPost, "/approvals/"+pending.Id+"/approve", "")
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.Empty(t, actions)
+	})
+
+	t.Run("should not allow requester to approve", func(t *testing.T) {
+		_, mux, actions := newTestDatasource(t)
+
+		w := request(mux, "bob", http.MethodPost, "/helm/production/echoserver/1/uninstall", `{}`)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var pending approval.Request
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+
+		w = request(mux, "bob", http.MethodPost, "/approvals/"+pending.Id+"/approve", "")
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = request(mux, "bob", http.MethodPost, "/approvals/"+pending.Id+"/reject", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, actions)
+	})
+
+	t.Run("should allow requester to withdraw request", func(t *testing.T) {
+		_, mux, _ := newTestDatasource(t)
+
+		w := request(mux, "alice", http.MethodPost, "/helm/production/echoserver/1/uninstall", `{}`)
+		var pending approval.Request
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+
+		w = request(mux, "carol", http.MethodPost, "/approvals/"+pending.Id+"/reject", "")
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = request(mux, "alice", http.MethodPost, "/approvals/"+pending.Id+"/reject", "")
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should run dry-run and unmatched actions directly", func(t *testing.T) {
+		_, mux, actions := newTestDatasource(t)
+
+		w := request(mux, "alice", http.MethodPost, "/helm/production/echoserver/1/uninstall", `{"dryRun":true}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `alice production {"dryRun":true}`, <-actions)
+
+		w = request(mux, "alice", http.MethodPost, "/helm/staging/echoserver/1/uninstall", `{}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `alice staging {}`, <-actions)
+	})
+
+	t.Run("should only return own requests to other users than approvers", func(t *testing.T) {
+		_, mux, _ := newTestDatasource(t)
+
+		w := request(mux, "alice", http.MethodPost, "/helm/production/echoserver/1/uninstall", `{}`)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var pending approval.Request
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+
+		for user, visible := range map[string]bool{"alice": true, "bob": true, "carol": false} {
+			w = request(mux, user, http.MethodGet, "/approvals", "")
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var requests []approval.Request
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &requests))
+			require.Equal(t, visible, slices.ContainsFunc(requests, func(request approval.Request) bool { return request.Id == pending.Id }), user)
+		}
+	})
+
+	t.Run("should require approval for mutating proxy requests", func(t *testing.T) {
+		_, mux, actions := newTestDatasource(t)
+
+		w := request(mux, "alice", http.MethodDelete, "/kubernetes/proxy/api/v1/namespaces/production/pods/echoserver", "")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Empty(t, actions)
+
+		w = request(mux, "alice", http.MethodGet, "/kubernetes/proxy/api/v1/namespaces/production/pods/echoserver", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "alice GET api/v1/namespaces/production/pods/echoserver", <-actions)
+
+		w = request(mux, "alice", http.MethodDelete, "/kubernetes/proxy/api/v1/namespaces/staging/pods/echoserver", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "alice DELETE api/v1/namespaces/staging/pods/echoserver", <-actions)
+	})
+
+	t.Run("should only skip approval for streaming proxy requests", func(t *testing.T) {
+		_, mux, actions := newTestDatasource(t)
+
+		upgrade := func(method, url string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(method, url, nil)
+			r.Header.Set(backend.GrafanaUserSignInTokenHeaderName, "alice")
+			r.Header.Set("Upgrade", "websocket")
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		w := upgrade(http.MethodDelete, "/kubernetes/proxy/api/v1/namespaces/production/pods/echoserver")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Empty(t, actions)
+
+		w = upgrade(http.MethodPost, "/kubernetes/proxy/api/v1/namespaces/production/pods/echoserver/exec?command=ls")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "alice POST api/v1/namespaces/production/pods/echoserver/exec", <-actions)
+	})
+}
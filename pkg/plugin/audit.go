@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/kubernetes"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/webhook"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// handleAuditLogQueries handles the requests to get the entries of the audit
+// log. It uses the concurrent package to handle multiple queries in parallel.
+func (d *Datasource) handleAuditLogQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleAuditLogQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleAuditLog, 10)
+}
+
+// handleAuditLog returns all entries of the audit log within the time range of
+// the query. Because the audit log contains the actions of all users, it can
+// only be queried by Grafana admins.
+func (d *Datasource) handleAuditLog(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleAuditLog")
+	defer span.End()
+
+	if !isGrafanaAdmin(ctx) {
+		err := fmt.Errorf("only admins are allowed to query the audit log")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var qm models.QueryModelAuditLog
+	err := json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	d.logger.Info("handleAuditLog query", "filter", qm.Filter)
+	span.SetAttributes(attribute.Key("filter").String(qm.Filter))
+
+	var filter *regexp.Regexp
+	if qm.Filter != "" {
+		filter, err = regexp.Compile(qm.Filter)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return backend.ErrorResponseWithErrorSource(err)
+		}
+	}
+
+	entries, err := d.auditLogger.Query(query.DataQuery.TimeRange.From, query.DataQuery.TimeRange.To, filter)
+	if err != nil {
+		d.logger.Error("Failed to query audit log", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, audit.CreateDataFrame(entries))
+
+	return response
+}
+
+// recordAction returns a response writer, which records the status code of
+// the response, and a function, which writes the provided entry to the audit
+// log. For successful actions, which are not a dry-run, the function also
+// creates an annotation and notifies the webhooks. The function must be
+// deferred by the handler of the action. The user and the teams of the entry
+// are always set to the Grafana user and its teams, also when the impersonate
+// feature is disabled.
+func (d *Datasource) recordAction(ctx context.Context, w http.ResponseWriter, r *http.Request, entry audit.Entry) (http.ResponseWriter, func()) {
+	if d.auditLogger == nil && !d.annotations && d.webhooks == nil {
+		return w, func() {}
+	}
+
+	var err error
+	entry.User, err = d.grafanaClient.GetUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user for audit log", "error", err.Error())
+	}
+	entry.Groups, err = d.grafanaClient.GetTeams(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get teams for audit log", "error", err.Error())
+	}
+	entry.Time = time.Now()
+	entry.RequestId = audit.RequestId(ctx)
+
+	rw := audit.NewResponseWriter(w)
+	aw, done := d.auditLogger.Record(ctx, rw, entry)
+
+	return aw, func() {
+		done()
+
+		entry.StatusCode = rw.StatusCode()
+		d.notifyAction(ctx, entry)
+	}
+}
+
+// setAuditStatusCode overwrites the status code, which is recorded for the
+// audit log entry and the notifications of an action. The response writer must
+// be the one which was returned by recordAction.
+func setAuditStatusCode(w http.ResponseWriter, statusCode int) {
+	if rw, ok := w.(*audit.ResponseWriter); ok {
+		rw.SetStatusCode(statusCode)
+	}
+}
+
+// notifyAction creates an annotation and notifies the webhooks for the
+// provided entry, when the action was successful and not a dry-run. It is
+// called for the actions of the plugin and for the mutating requests, which
+// are made via the Kubernetes server, e.g. via kubectl.
+func (d *Datasource) notifyAction(ctx context.Context, entry audit.Entry) {
+	if entry.DryRun || entry.StatusCode < 200 || entry.StatusCode >= 300 {
+		return
+	}
+
+	if d.annotations {
+		d.createAnnotation(ctx, entry)
+	}
+	d.webhooks.Dispatch(webhook.NewPayload(datasourceName(ctx), entry))
+}
+
+// datasourceName returns the name of the datasource from the plugin context.
+func datasourceName(ctx context.Context) string {
+	if settings := backend.PluginConfigFromContext(ctx).DataSourceInstanceSettings; settings != nil {
+		return settings.Name
+	}
+	return ""
+}
+
+// auditResource returns the resource for the provided resource id in the
+// format which is used in the audit log. If the resource can not be found, the
+// resource id is used.
+func (d *Datasource) auditResource(ctx context.Context, resourceId, subresource string) string {
+	resource, err := d.kubeClient.GetResource(ctx, resourceId)
+	if err != nil {
+		if subresource != "" {
+			return resourceId + "/" + subresource
+		}
+		return resourceId
+	}
+
+	return kubernetes.NewAuditResource(*resource, subresource)
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/approval"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
 	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/grafana"
 	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/kubernetes"
 	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/webhook"
 
 	"github.com/google/uuid"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -69,22 +73,87 @@ func NewDatasource(ctx context.Context, pCtx backend.DataSourceInstanceSettings)
 		return nil, err
 	}
 
-	// If the generate Kubeconfig feature is enabled, we create a new Kubernetes
-	// server on the configured port. Afterwards we start the Kubernetes server
-	// in a new Go routine. If the server could not be started, which might
-	// happen when the server is still running from an old data source instance,
-	// because "Dispose" for the old instance is called after "NewDatasource"
-	// for the new instance, we try to start the server until no error is
-	// thrown.
-	var kubeServer kubernetes.Server
-
-	if config.GenerateKubeconfig {
-		kubeServer, err = kubernetes.NewServer(config.GenerateKubeconfigPort, kubeClient, grafanaClient, logger)
+	// If the audit log is enabled, all mutating requests are written to the
+	// configured file. If the audit log is disabled, the logger is nil, which
+	// can be used like an enabled logger, but doesn't write any entries.
+	var auditLogger *audit.Logger
+	if config.Audit {
+		auditLogger, err = audit.NewLogger(config.AuditPath, config.AuditMaxSize, int(config.AuditMaxBackups), logger)
+		if err != nil {
+			logger.Error("Failed to create audit logger", "error", err.Error())
+			kubeClient.Close()
+			return nil, err
+		}
+	}
+
+	// If webhooks are configured, a notification is sent to the webhooks for
+	// each successful action. If no webhooks are configured, the dispatcher is
+	// nil and doesn't send any notifications.
+	webhooks, err := webhook.NewDispatcher(config.Webhooks, config.Secrets.WebhookSecrets, logger)
+	if err != nil {
+		logger.Error("Failed to create webhook dispatcher", "error", err.Error())
+		auditLogger.Close()
+		kubeClient.Close()
+		return nil, err
+	}
+
+	// If the approval mode is enabled, mutating actions must be approved by a
+	// second user, before they are run. If the approval mode is disabled, the
+	// store is nil and all actions are run directly.
+	var approvals *approval.Store
+	if config.Approvals {
+		approvals, err = approval.NewStore(config.ApprovalsPath, time.Duration(config.ApprovalsTTL)*time.Second, config.ApprovalsTeams, config.ApprovalsNamespaces)
+		if err != nil {
+			logger.Error("Failed to create approval store", "error", err.Error())
+			webhooks.Close()
+			auditLogger.Close()
+			kubeClient.Close()
+			return nil, err
+		}
+	}
+
+	ds := &Datasource{
+		generateKubeconfig:             config.GenerateKubeconfig,
+		generateKubeconfigName:         config.GenerateKubeconfigName,
+		generateKubeconfigTTL:          config.GenerateKubeconfigTTL,
+		generateKubeconfigRedirectUrls: config.GenerateKubeconfigRedirectUrls,
+		grafanaClient:                  grafanaClient,
+		kubeClient:                     kubeClient,
+		auditLogger:                    auditLogger,
+		annotations:                    config.Annotations,
+		webhooks:                       webhooks,
+		approvals:                      approvals,
+		logger:                         logger,
+	}
+
+	// If the generate Kubeconfig feature or the kube-state-metrics feature is
+	// enabled, we create a new Kubernetes server on the configured port.
+	// Afterwards we start the Kubernetes server in a new Go routine. If the
+	// server could not be started, which might happen when the server is still
+	// running from an old data source instance, because "Dispose" for the old
+	// instance is called after "NewDatasource" for the new instance, we try to
+	// start the server until no error is thrown.
+	if config.GenerateKubeconfig || config.KubeStateMetrics {
+		// Mutating requests, which are made via the Kubernetes server, are
+		// passed to the "notifyAction" method, so that annotations are created
+		// and webhooks are notified like for the actions of the plugin. The
+		// requests of the server do not contain the plugin context, so that we
+		// have to add the data source settings for the name of the data source.
+		notify := func(ctx context.Context, entry audit.Entry) {
+			ds.notifyAction(backend.WithPluginContext(ctx, backend.PluginContext{DataSourceInstanceSettings: &pCtx}), entry)
+		}
+
+		kubeServer, err := kubernetes.NewServer(config, kubeClient, grafanaClient, auditLogger, approvals, notify, logger)
 		if err != nil {
 			logger.Error("Failed to create Kubernets server", "error", err.Error())
+			webhooks.Close()
+			auditLogger.Close()
+			kubeClient.Close()
 			return nil, err
 		}
 
+		ds.kubeServer = kubeServer
+
 		go func() {
 			for {
 				if err := kubeServer.Start(); err != nil {
@@ -98,17 +167,6 @@ func NewDatasource(ctx context.Context, pCtx backend.DataSourceInstanceSettings)
 		}()
 	}
 
-	ds := &Datasource{
-		generateKubeconfig:             config.GenerateKubeconfig,
-		generateKubeconfigName:         config.GenerateKubeconfigName,
-		generateKubeconfigTTL:          config.GenerateKubeconfigTTL,
-		generateKubeconfigRedirectUrls: config.GenerateKubeconfigRedirectUrls,
-		grafanaClient:                  grafanaClient,
-		kubeClient:                     kubeClient,
-		kubeServer:                     kubeServer,
-		logger:                         logger,
-	}
-
 	queryTypeMux := datasource.NewQueryTypeMux()
 	queryTypeMux.HandleFunc(models.QueryTypeSettings, ds.handleSettingsQueries)
 	queryTypeMux.HandleFunc(models.QueryTypeKubernetesResourceIds, ds.handleKubernetesResourceIdsQueries)
@@ -116,6 +174,13 @@ func NewDatasource(ctx context.Context, pCtx backend.DataSourceInstanceSettings)
 	queryTypeMux.HandleFunc(models.QueryTypeKubernetesResources, ds.handleKubernetesResourcesQueries)
 	queryTypeMux.HandleFunc(models.QueryTypeKubernetesContainers, ds.handleKubernetesContainersQueries)
 	queryTypeMux.HandleFunc(models.QueryTypeKubernetesLogs, ds.handleKubernetesLogsQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeKubernetesStats, ds.handleKubernetesStatsQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeKubernetesEvents, ds.handleKubernetesEventsQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeKubernetesHistory, ds.handleKubernetesHistoryQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeKubernetesManagedFields, ds.handleKubernetesManagedFieldsQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeKubernetesRolloutStatus, ds.handleKubernetesRolloutStatusQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeKubernetesRolloutHistory, ds.handleKubernetesRolloutHistoryQueries)
+	queryTypeMux.HandleFunc(models.QueryTypeAuditLog, ds.handleAuditLogQueries)
 	queryTypeMux.HandleFunc(models.QueryTypeHelmReleases, ds.handleHelmReleasesQueries)
 	queryTypeMux.HandleFunc(models.QueryTypeHelmReleaseHistory, ds.handleHelmReleaseHistoryQueries)
 	ds.queryHandler = queryTypeMux
@@ -124,11 +189,26 @@ func NewDatasource(ctx context.Context, pCtx backend.DataSourceInstanceSettings)
 	mux.HandleFunc("/kubernetes/kubeconfig", ds.handleKubernetesKubeconfig)
 	mux.HandleFunc("/kubernetes/kubeconfig/credentials", ds.handleKubernetesKubeconfigCredentials)
 	mux.HandleFunc("/kubernetes/resource/{id}", ds.handleKubernetesResource)
-	mux.HandleFunc("/kubernetes/proxy/{pathname...}", ds.handleKubernetesProxy)
+	mux.HandleFunc("/kubernetes/resources/{id}/{namespace}/{name}/yaml", ds.handleKubernetesResourceYAML)
+	mux.HandleFunc("/kubernetes/resources/{id}/{namespace}/{name}/apply", ds.requireApproval(ds.approvalAction("apply", ""), ds.handleKubernetesResourceApply))
+	mux.HandleFunc("/kubernetes/workloads/{id}/{namespace}/{name}/{action}", ds.requireApproval(ds.approvalAction("", ""), ds.handleKubernetesWorkloadAction))
+	mux.HandleFunc("/kubernetes/deployments/{namespace}/{name}/{revision}/rollback", ds.requireApproval(ds.approvalAction("rollback", "deployments.apps"), ds.handleKubernetesDeploymentRollback))
+	mux.HandleFunc("/kubernetes/jobs/{id}/{namespace}/{name}/run", ds.requireApproval(ds.approvalAction("run", ""), ds.handleKubernetesJobRun))
+	mux.HandleFunc("/kubernetes/pods/{namespace}/{name}/exec", ds.requireApproval(ds.approvalAction("exec", "pods/exec"), ds.handleKubernetesPodExec))
+	mux.HandleFunc("/kubernetes/pods/{namespace}/{name}/download", ds.handleKubernetesPodDownload)
+	mux.HandleFunc("/kubernetes/pods/{namespace}/{name}/debug", ds.requireApproval(ds.approvalAction("debug", "pods/ephemeralcontainers"), ds.handleKubernetesPodDebug))
+	mux.HandleFunc("/kubernetes/nodes/{name}/{action}", ds.requireApproval(ds.approvalAction("", "nodes"), ds.handleKubernetesNodeAction))
+	mux.HandleFunc("/kubernetes/proxy/{pathname...}", ds.requireApproval(ds.approvalProxyAction, ds.handleKubernetesProxy))
+	mux.HandleFunc("/kubernetes/recordings", ds.handleKubernetesRecordings)
+	mux.HandleFunc("/kubernetes/recordings/{id}", ds.handleKubernetesRecording)
+	mux.HandleFunc("/kubernetes/preview/{kind}/{namespace}/{name}/{port}/{pathname...}", ds.handleKubernetesPreview)
 	mux.HandleFunc("/helm/{namespace}/{name}/{version}", ds.handleHelmGetRelease)
-	mux.HandleFunc("/helm/{namespace}/{name}/{version}/rollback", ds.handleHelmRollback)
-	mux.HandleFunc("/helm/{namespace}/{name}/{version}/uninstall", ds.handleHelmUninstall)
+	mux.HandleFunc("/helm/{namespace}/{name}/{version}/rollback", ds.requireApproval(ds.approvalAction("rollback", "helmreleases"), ds.handleHelmRollback))
+	mux.HandleFunc("/helm/{namespace}/{name}/{version}/uninstall", ds.requireApproval(ds.approvalAction("uninstall", "helmreleases"), ds.handleHelmUninstall))
+	mux.HandleFunc("/approvals", ds.handleApprovals)
+	mux.HandleFunc("/approvals/{id}/{decision}", ds.handleApprovalDecision)
 	ds.resourceHandler = httpadapter.New(mux)
+	ds.approvalHandler = mux
 
 	return ds, nil
 }
@@ -145,7 +225,27 @@ type Datasource struct {
 	grafanaClient                  grafana.Client
 	kubeClient                     kubernetes.Client
 	kubeServer                     kubernetes.Server
+	auditLogger                    *audit.Logger
+	annotations                    bool
+	webhooks                       *webhook.Dispatcher
+	approvals                      *approval.Store
+	approvalHandler                http.Handler
 	logger                         log.Logger
+
+	// streamSubscribers contains the user and groups of the last subscriber
+	// for each stream path. It is used to run streams, which must be run with
+	// the identity of the subscriber (e.g. resource watches), because the
+	// "RunStream" request doesn't contain the user. Grafana runs a single
+	// stream for all subscribers of a path, so the stream is run with the
+	// identity of the subscriber which started it and is shared with all later
+	// subscribers. The entry is only removed when the stream is finished and it
+	// wasn't replaced by a newer subscriber in the meantime.
+	streamSubscribers sync.Map
+}
+
+type streamSubscriber struct {
+	user   string
+	groups []string
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin. The main
@@ -201,9 +301,12 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 // as first subscriber joins channel "RunStream" will be called.
 //
 // Before a user can subscribe to a stream, we verify that the user has access
-// to the stream / logs he wants to subscribe to.
+// to the stream he wants to subscribe to. The verification depends on the
+// query type of the stream and is handled in the corresponding
+// "subscribe<QueryType>Stream" method. If no query type is set, we assume that
+// the user wants to subscribe to a logs stream.
 func (d *Datasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
-	_, span := tracing.DefaultTracer().Start(ctx, "SubscribeStream")
+	ctx, span := tracing.DefaultTracer().Start(ctx, "SubscribeStream")
 	defer span.End()
 
 	d.logger.Debug("SubscribeStream", "path", req.Path)
@@ -225,7 +328,7 @@ func (d *Datasource) SubscribeStream(ctx context.Context, req *backend.Subscribe
 		return nil, err
 	}
 
-	var qm models.QueryModelKubernetesLogs
+	var qm models.QueryModelStream
 	err = json.Unmarshal(req.Data, &qm)
 	if err != nil {
 		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
@@ -234,41 +337,32 @@ func (d *Datasource) SubscribeStream(ctx context.Context, req *backend.Subscribe
 		return nil, err
 	}
 
-	d.logger.Info("SubscribeStream request", "user", user, "groups", groups, "resourceId", qm.ResourceId, "namespace", qm.Namespace, "name", qm.Name, "container", qm.Container, "filter", qm.Filter)
-	span.SetAttributes(attribute.Key("user").String(user))
-	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
-	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
-	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
-	span.SetAttributes(attribute.Key("name").String(qm.Name))
-
-	_, err = d.kubeClient.GetContainers(ctx, user, groups, qm.ResourceId, qm.Namespace, qm.Name)
-	if err != nil {
-		return &backend.SubscribeStreamResponse{
-			Status: backend.SubscribeStreamStatusPermissionDenied,
-		}, nil
+	switch qm.QueryType {
+	case models.QueryTypeKubernetesResources:
+		return d.subscribeKubernetesResourcesStream(ctx, user, groups, req)
+	case models.QueryTypeKubernetesMetrics:
+		return d.subscribeKubernetesMetricsStream(ctx, user, groups, req)
+	default:
+		return d.subscribeKubernetesLogsStream(ctx, user, groups, req)
 	}
-
-	return &backend.SubscribeStreamResponse{
-		Status: backend.SubscribeStreamStatusOK,
-	}, nil
 }
 
 // RunStream is called once for any open channel. It handles all the streaming
 // logic for the channel and sends data to the sender as needed, via the
-// "StreamLogs" method of the Kubernetes client.
+// "run<QueryType>Stream" method for the query type of the stream.
 //
-// We do not pass the user and groups to the "StreamLogs" method here, because
-// multiple we can have multiple subscribers on the same stream and each
-// subscriber might have a different user and groups. Therefore we extract the
-// user and groups inside the "StreamLogs" method for each HTTP request.
+// We do not pass the user and groups to the stream methods of the Kubernetes
+// client here, because multiple we can have multiple subscribers on the same
+// stream and each subscriber might have a different user and groups. Instead
+// each subscriber is verified in the "SubscribeStream" method.
 func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
-	_, span := tracing.DefaultTracer().Start(ctx, "RunStream")
+	ctx, span := tracing.DefaultTracer().Start(ctx, "RunStream")
 	defer span.End()
 
 	d.logger.Debug("RunStream", "path", req.Path)
 	span.SetAttributes(attribute.Key("path").String(req.Path))
 
-	var qm models.QueryModelKubernetesLogs
+	var qm models.QueryModelStream
 	err := json.Unmarshal(req.Data, &qm)
 	if err != nil {
 		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
@@ -277,14 +371,14 @@ func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamReques
 		return err
 	}
 
-	d.logger.Info("RunStream request", "resourceId", qm.ResourceId, "namespace", qm.Namespace, "name", qm.Name, "container", qm.Container, "filter", qm.Filter)
-	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
-	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
-	span.SetAttributes(attribute.Key("name").String(qm.Name))
-	span.SetAttributes(attribute.Key("container").String(qm.Container))
-	span.SetAttributes(attribute.Key("filter").String(qm.Filter))
-
-	return d.kubeClient.StreamLogs(ctx, "", nil, qm.ResourceId, qm.Namespace, qm.Name, qm.Container, qm.Filter, sender)
+	switch qm.QueryType {
+	case models.QueryTypeKubernetesResources:
+		return d.runKubernetesResourcesStream(ctx, req, sender)
+	case models.QueryTypeKubernetesMetrics:
+		return d.runKubernetesMetricsStream(ctx, req, sender)
+	default:
+		return d.runKubernetesLogsStream(ctx, req, sender)
+	}
 }
 
 // PublishStream is called when a client sends a message to the stream. Since
@@ -309,12 +403,22 @@ func (d *Datasource) PublishStream(ctx context.Context, req *backend.PublishStre
 func (d *Datasource) Dispose() {
 	d.logger.Debug("Dispose datasource instance")
 
-	// If the generate Kubeconfig feature is enabled and a new Kubernetes server
-	// was created, we stop the server when the data source instance is
-	// disposed.
+	// If the generate Kubeconfig feature or the kube-state-metrics feature is
+	// enabled and a new Kubernetes server was created, we stop the server when
+	// the data source instance is disposed.
 	if d.kubeServer != nil {
 		if err := d.kubeServer.Stop(); err != nil {
 			d.logger.Error("Failed to stop Kubernetes server", "error", err.Error())
 		}
 	}
+
+	// Stop all background tasks of the Kubernetes client, e.g. the recording
+	// of the resource history.
+	d.kubeClient.Close()
+
+	if err := d.auditLogger.Close(); err != nil {
+		d.logger.Error("Failed to close audit log", "error", err.Error())
+	}
+
+	d.webhooks.Close()
 }
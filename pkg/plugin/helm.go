@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
 	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/helm"
 	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
 
@@ -57,6 +58,17 @@ func (d *Datasource) handleHelmReleases(ctx context.Context, query concurrent.Qu
 	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
 	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
 
+	// If all namespaces are requested, the releases are filtered by the
+	// allowed namespaces of the datasource after they were listed.
+	if qm.Namespace != "" {
+		if err := d.kubeClient.AuthorizeNamespace(qm.Namespace); err != nil {
+			d.logger.Error("Namespace is not allowed", "error", err.Error())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return backend.ErrorResponseWithErrorSource(err)
+		}
+	}
+
 	restConfig := d.kubeClient.RestConfig()
 	helmClient, err := helm.NewClient(ctx, user, groups, qm.Namespace, &restConfig, d.logger)
 	if err != nil {
@@ -74,6 +86,16 @@ func (d *Datasource) handleHelmReleases(ctx context.Context, query concurrent.Qu
 		return backend.ErrorResponseWithErrorSource(err)
 	}
 
+	frame, err = frame.FilterRowsByField(0, func(namespace any) (bool, error) {
+		return d.kubeClient.AuthorizeNamespace(namespace.(string)) == nil, nil
+	})
+	if err != nil {
+		d.logger.Error("Failed to filter Helm releases", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
 	var response backend.DataResponse
 	response.Frames = append(response.Frames, frame)
 
@@ -122,6 +144,13 @@ func (d *Datasource) handleHelmReleaseHistory(ctx context.Context, query concurr
 	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
 	span.SetAttributes(attribute.Key("name").String(qm.Name))
 
+	if err := d.kubeClient.AuthorizeNamespace(qm.Namespace); err != nil {
+		d.logger.Error("Namespace is not allowed", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
 	restConfig := d.kubeClient.RestConfig()
 	helmClient, err := helm.NewClient(ctx, user, groups, qm.Namespace, &restConfig, d.logger)
 	if err != nil {
@@ -189,6 +218,15 @@ func (d *Datasource) handleHelmGetRelease(w http.ResponseWriter, r *http.Request
 	span.SetAttributes(attribute.Key("name").String(name))
 	span.SetAttributes(attribute.Key("version").Int64(version))
 
+	if err := d.kubeClient.AuthorizeNamespace(namespace); err != nil {
+		d.logger.Error("Namespace is not allowed", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
 	restConfig := d.kubeClient.RestConfig()
 	helmClient, err := helm.NewClient(ctx, user, groups, namespace, &restConfig, d.logger)
 	if err != nil {
@@ -264,6 +302,15 @@ func (d *Datasource) handleHelmRollback(w http.ResponseWriter, r *http.Request)
 	span.SetAttributes(attribute.Key("name").String(name))
 	span.SetAttributes(attribute.Key("version").Int64(version))
 
+	if err := d.kubeClient.AuthorizeNamespace(namespace); err != nil {
+		d.logger.Error("Namespace is not allowed", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
 	var options helm.RollbackOptions
 	err = json.NewDecoder(r.Body).Decode(&options)
 	if err != nil {
@@ -275,6 +322,9 @@ func (d *Datasource) handleHelmRollback(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: "rollback", Resource: "helmreleases", Namespace: namespace, Name: name, DryRun: options.DryRun})
+	defer done()
+
 	restConfig := d.kubeClient.RestConfig()
 	helmClient, err := helm.NewClient(ctx, user, groups, namespace, &restConfig, d.logger)
 	if err != nil {
@@ -341,6 +391,15 @@ func (d *Datasource) handleHelmUninstall(w http.ResponseWriter, r *http.Request)
 	span.SetAttributes(attribute.Key("name").String(name))
 	span.SetAttributes(attribute.Key("version").Int64(version))
 
+	if err := d.kubeClient.AuthorizeNamespace(namespace); err != nil {
+		d.logger.Error("Namespace is not allowed", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
 	var options helm.UninstallOptions
 	err = json.NewDecoder(r.Body).Decode(&options)
 	if err != nil {
@@ -352,6 +411,9 @@ func (d *Datasource) handleHelmUninstall(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: "uninstall", Resource: "helmreleases", Namespace: namespace, Name: name, DryRun: options.DryRun})
+	defer done()
+
 	restConfig := d.kubeClient.RestConfig()
 	helmClient, err := helm.NewClient(ctx, user, groups, namespace, &restConfig, d.logger)
 	if err != nil {
@@ -3,11 +3,19 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/kubernetes"
 	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -15,6 +23,7 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientauthenticationv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
 	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
@@ -258,6 +267,606 @@ func (d *Datasource) handleKubernetesLogs(ctx context.Context, query concurrent.
 	return response
 }
 
+// handleKubernetesStatsQueries handles the requests to get the CPU, memory,
+// network or filesystem usage of pods, containers or volumes from the kubelet.
+// It uses the concurrent package to handle multiple queries in parallel.
+func (d *Datasource) handleKubernetesStatsQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesStatsQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleKubernetesStats, 10)
+}
+
+func (d *Datasource) handleKubernetesStats(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesStats")
+	defer span.End()
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var qm models.QueryModelKubernetesStats
+	err = json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	d.logger.Info("handleKubernetesStats query", "user", user, "groups", groups, "node", qm.Node, "namespace", qm.Namespace, "filter", qm.Filter, "level", qm.Level, "metric", qm.Metric)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("node").String(qm.Node))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("filter").String(qm.Filter))
+	span.SetAttributes(attribute.Key("level").String(qm.Level))
+	span.SetAttributes(attribute.Key("metric").String(qm.Metric))
+
+	frames, err := d.kubeClient.GetStats(ctx, user, groups, qm.Node, qm.Namespace, qm.Filter, qm.Level, qm.Metric, query.DataQuery.TimeRange)
+	if err != nil {
+		d.logger.Error("Failed to get stats", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frames...)
+
+	return response
+}
+
+// handleKubernetesEventsQueries handles the requests to get the number of
+// Kubernetes events as time series. It uses the concurrent package to handle
+// multiple queries in parallel.
+func (d *Datasource) handleKubernetesEventsQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesEventsQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleKubernetesEvents, 10)
+}
+
+func (d *Datasource) handleKubernetesEvents(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesEvents")
+	defer span.End()
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var qm models.QueryModelKubernetesEvents
+	err = json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	// Use the interval of the query as bucket size for the events. If the
+	// interval is not set (e.g. in alert rules) we use 1 minute. The interval
+	// is increased when the time range would result in more data points than
+	// allowed for the query.
+	interval := query.DataQuery.Interval
+	if interval <= 0 {
+		interval = 1 * time.Minute
+	}
+	interval = max(interval, 1*time.Second)
+	if maxDataPoints := query.DataQuery.MaxDataPoints; maxDataPoints > 0 {
+		interval = max(interval, query.DataQuery.TimeRange.Duration()/time.Duration(maxDataPoints))
+	}
+
+	d.logger.Info("handleKubernetesEvents query", "user", user, "groups", groups, "namespace", qm.Namespace, "type", qm.Type, "filter", qm.Filter, "groupBy", qm.GroupBy, "interval", interval.String())
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("type").String(qm.Type))
+	span.SetAttributes(attribute.Key("filter").String(qm.Filter))
+	span.SetAttributes(attribute.Key("groupBy").StringSlice(qm.GroupBy))
+	span.SetAttributes(attribute.Key("interval").String(interval.String()))
+
+	frames, err := d.kubeClient.GetEvents(ctx, user, groups, qm.Namespace, qm.Type, qm.Filter, qm.GroupBy, query.DataQuery.TimeRange, interval)
+	if err != nil {
+		d.logger.Error("Failed to get events", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frames...)
+
+	return response
+}
+
+// handleKubernetesHistoryQueries handles the requests to get the recorded
+// history of a resource. It uses the concurrent package to handle multiple
+// queries in parallel.
+func (d *Datasource) handleKubernetesHistoryQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesHistoryQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleKubernetesHistory, 10)
+}
+
+func (d *Datasource) handleKubernetesHistory(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesHistory")
+	defer span.End()
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var qm models.QueryModelKubernetesHistory
+	err = json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	d.logger.Info("handleKubernetesHistory query", "user", user, "groups", groups, "resourceId", qm.ResourceId, "namespace", qm.Namespace, "filter", qm.Filter, "mode", qm.Mode)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("filter").String(qm.Filter))
+	span.SetAttributes(attribute.Key("mode").String(qm.Mode))
+
+	frame, err := d.kubeClient.GetHistory(ctx, user, groups, qm.ResourceId, qm.Namespace, qm.Filter, qm.Mode, query.DataQuery.TimeRange)
+	if err != nil {
+		d.logger.Error("Failed to get history", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// handleKubernetesManagedFieldsQueries handles the requests to get a timeline
+// of the managed fields of a resource. It uses the concurrent package to
+// handle multiple queries in parallel.
+func (d *Datasource) handleKubernetesManagedFieldsQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesManagedFieldsQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleKubernetesManagedFields, 10)
+}
+
+func (d *Datasource) handleKubernetesManagedFields(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesManagedFields")
+	defer span.End()
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var qm models.QueryModelKubernetesManagedFields
+	err = json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	d.logger.Info("handleKubernetesManagedFields query", "user", user, "groups", groups, "resourceId", qm.ResourceId, "namespace", qm.Namespace, "name", qm.Name)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("name").String(qm.Name))
+
+	frame, err := d.kubeClient.GetManagedFields(ctx, user, groups, qm.ResourceId, qm.Namespace, qm.Name)
+	if err != nil {
+		d.logger.Error("Failed to get managed fields", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// handleKubernetesRolloutStatusQueries handles the requests to get the rollout
+// status of workloads. It uses the concurrent package to handle multiple
+// queries in parallel.
+func (d *Datasource) handleKubernetesRolloutStatusQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesRolloutStatusQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleKubernetesRolloutStatus, 10)
+}
+
+func (d *Datasource) handleKubernetesRolloutStatus(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesRolloutStatus")
+	defer span.End()
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var qm models.QueryModelKubernetesRolloutStatus
+	err = json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	d.logger.Info("handleKubernetesRolloutStatus query", "user", user, "groups", groups, "resourceId", qm.ResourceId, "namespace", qm.Namespace, "filter", qm.Filter)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("filter").String(qm.Filter))
+
+	frames, err := d.kubeClient.GetRolloutStatus(ctx, user, groups, qm.ResourceId, qm.Namespace, qm.Filter)
+	if err != nil {
+		d.logger.Error("Failed to get rollout status", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frames...)
+
+	return response
+}
+
+// handleKubernetesRolloutHistoryQueries handles the requests to get the
+// revisions of a Deployment. It uses the concurrent package to handle multiple
+// queries in parallel.
+func (d *Datasource) handleKubernetesRolloutHistoryQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesRolloutHistoryQueries")
+	defer span.End()
+
+	return concurrent.QueryData(ctx, req, d.handleKubernetesRolloutHistory, 10)
+}
+
+func (d *Datasource) handleKubernetesRolloutHistory(ctx context.Context, query concurrent.Query) backend.DataResponse {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "handleKubernetesRolloutHistory")
+	defer span.End()
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, query.Headers)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var qm models.QueryModelKubernetesRolloutHistory
+	err = json.Unmarshal(query.DataQuery.JSON, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	d.logger.Info("handleKubernetesRolloutHistory query", "user", user, "groups", groups, "namespace", qm.Namespace, "name", qm.Name)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("name").String(qm.Name))
+
+	frame, err := d.kubeClient.GetRolloutHistory(ctx, user, groups, qm.Namespace, qm.Name)
+	if err != nil {
+		d.logger.Error("Failed to get rollout history", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var response backend.DataResponse
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// subscribeKubernetesLogsStream verifies that the user has access to the
+// resource for which he wants to stream the logs, by getting the containers of
+// the resource with the users identity.
+func (d *Datasource) subscribeKubernetesLogsStream(ctx context.Context, user string, groups []string, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "subscribeKubernetesLogsStream")
+	defer span.End()
+
+	var qm models.QueryModelKubernetesLogs
+	err := json.Unmarshal(req.Data, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	d.logger.Info("SubscribeStream request", "user", user, "groups", groups, "resourceId", qm.ResourceId, "namespace", qm.Namespace, "name", qm.Name, "container", qm.Container, "filter", qm.Filter)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("name").String(qm.Name))
+
+	_, err = d.kubeClient.GetContainers(ctx, user, groups, qm.ResourceId, qm.Namespace, qm.Name)
+	if err != nil {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusPermissionDenied,
+		}, nil
+	}
+
+	return &backend.SubscribeStreamResponse{
+		Status: backend.SubscribeStreamStatusOK,
+	}, nil
+}
+
+// runKubernetesLogsStream streams the logs of the requested resource via the
+// "StreamLogs" method of the Kubernetes client.
+func (d *Datasource) runKubernetesLogsStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "runKubernetesLogsStream")
+	defer span.End()
+
+	var qm models.QueryModelKubernetesLogs
+	err := json.Unmarshal(req.Data, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	d.logger.Info("RunStream request", "resourceId", qm.ResourceId, "namespace", qm.Namespace, "name", qm.Name, "container", qm.Container, "filter", qm.Filter)
+	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("name").String(qm.Name))
+	span.SetAttributes(attribute.Key("container").String(qm.Container))
+	span.SetAttributes(attribute.Key("filter").String(qm.Filter))
+
+	return d.kubeClient.StreamLogs(ctx, "", nil, qm.ResourceId, qm.Namespace, qm.Name, qm.Container, qm.Filter, sender)
+}
+
+// subscribeKubernetesResourcesStream verifies that the user has access to the
+// resources he wants to watch, by getting the resources with the users
+// identity. If the user has access, we save the user and groups for the stream
+// path, so that the watch can be run with the identity of the subscriber.
+//
+// All subscribers of a path share a single stream, which is run with the
+// identity of the subscriber which started it. This is fine, because the path
+// contains the query and every subscriber must be able to get the resources
+// for the query with its own identity, before it receives the stream.
+func (d *Datasource) subscribeKubernetesResourcesStream(ctx context.Context, user string, groups []string, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "subscribeKubernetesResourcesStream")
+	defer span.End()
+
+	var qm models.QueryModelKubernetesResources
+	err := json.Unmarshal(req.Data, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	d.logger.Info("SubscribeStream request", "user", user, "groups", groups, "resourceId", qm.ResourceId, "namespace", qm.Namespace, "parameterName", qm.ParameterName, "parameterValue", qm.ParameterValue, "wide", qm.Wide)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("parameterName").String(qm.ParameterName))
+	span.SetAttributes(attribute.Key("parameterValue").String(qm.ParameterValue))
+
+	_, err = d.kubeClient.GetResources(ctx, user, groups, qm.ResourceId, qm.Namespace, qm.ParameterName, qm.ParameterValue, qm.Wide)
+	if err != nil {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusPermissionDenied,
+		}, nil
+	}
+
+	d.streamSubscribers.Store(req.Path, &streamSubscriber{user: user, groups: groups})
+
+	return &backend.SubscribeStreamResponse{
+		Status: backend.SubscribeStreamStatusOK,
+	}, nil
+}
+
+// runKubernetesResourcesStream watches the requested resources via the
+// "StreamResources" method of the Kubernetes client. In contrast to the other
+// streams, the watch is run with the identity of the subscriber, which was
+// saved in the "subscribeKubernetesResourcesStream" method.
+func (d *Datasource) runKubernetesResourcesStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "runKubernetesResourcesStream")
+	defer span.End()
+
+	var qm models.QueryModelKubernetesResources
+	err := json.Unmarshal(req.Data, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	// The subscriber is removed when the stream is finished, so that the map
+	// doesn't grow with each new stream path. If another subscriber was saved
+	// for the path while the stream was running, the entry is kept, because
+	// it might be required for the next stream of the path.
+	subscriber, ok := d.streamSubscribers.Load(req.Path)
+	if !ok {
+		err := fmt.Errorf("subscriber for stream %s not found", req.Path)
+		d.logger.Error("Failed to get subscriber", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	defer d.streamSubscribers.CompareAndDelete(req.Path, subscriber)
+
+	user := subscriber.(*streamSubscriber).user
+	groups := subscriber.(*streamSubscriber).groups
+
+	d.logger.Info("RunStream request", "user", user, "groups", groups, "resourceId", qm.ResourceId, "namespace", qm.Namespace, "parameterName", qm.ParameterName, "parameterValue", qm.ParameterValue, "wide", qm.Wide)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("parameterName").String(qm.ParameterName))
+	span.SetAttributes(attribute.Key("parameterValue").String(qm.ParameterValue))
+
+	return d.kubeClient.StreamResources(ctx, user, groups, qm.ResourceId, qm.Namespace, qm.ParameterName, qm.ParameterValue, qm.Wide, sender)
+}
+
+// subscribeKubernetesMetricsStream verifies that the user has access to the
+// "PodMetrics" or "NodeMetrics" he wants to stream, by getting the requested
+// resources with the users identity.
+func (d *Datasource) subscribeKubernetesMetricsStream(ctx context.Context, user string, groups []string, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "subscribeKubernetesMetricsStream")
+	defer span.End()
+
+	var qm models.QueryModelKubernetesMetrics
+	err := json.Unmarshal(req.Data, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	d.logger.Info("SubscribeStream request", "user", user, "groups", groups, "resourceId", qm.ResourceId, "namespace", qm.Namespace, "name", qm.Name, "containers", qm.Containers, "interval", qm.Interval)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("name").String(qm.Name))
+
+	_, err = d.kubeClient.GetResources(ctx, user, groups, qm.ResourceId, qm.Namespace, "", "", false)
+	if err != nil {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusPermissionDenied,
+		}, nil
+	}
+
+	return &backend.SubscribeStreamResponse{
+		Status: backend.SubscribeStreamStatusOK,
+	}, nil
+}
+
+// runKubernetesMetricsStream streams the CPU and memory usage of pods,
+// containers or nodes via the "StreamMetrics" method of the Kubernetes client.
+// If no interval is provided in the query, the metrics are polled every 10
+// seconds. The interval can not be lower than 1 second.
+func (d *Datasource) runKubernetesMetricsStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "runKubernetesMetricsStream")
+	defer span.End()
+
+	var qm models.QueryModelKubernetesMetrics
+	err := json.Unmarshal(req.Data, &qm)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal query model", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	interval := 10 * time.Second
+	if qm.Interval != "" {
+		interval, err = time.ParseDuration(qm.Interval)
+		if err != nil {
+			d.logger.Error("Failed to parse interval", "error", err.Error())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+		interval = max(interval, 1*time.Second)
+	}
+
+	d.logger.Info("RunStream request", "resourceId", qm.ResourceId, "namespace", qm.Namespace, "name", qm.Name, "containers", qm.Containers, "interval", interval.String())
+	span.SetAttributes(attribute.Key("resourceId").String(qm.ResourceId))
+	span.SetAttributes(attribute.Key("namespace").String(qm.Namespace))
+	span.SetAttributes(attribute.Key("name").String(qm.Name))
+	span.SetAttributes(attribute.Key("containers").Bool(qm.Containers))
+	span.SetAttributes(attribute.Key("interval").String(interval.String()))
+
+	return d.kubeClient.StreamMetrics(ctx, "", nil, qm.ResourceId, qm.Namespace, qm.Name, qm.Containers, interval, sender)
+}
+
 // handleKubernetesKubeconfig handles the generation if a kubeconfig, which can
 // be used by a user to interact with the Kubernetes cluster via kubectl by
 // utilizing the handleKubernetesProxy handler.
@@ -265,99 +874,877 @@ func (d *Datasource) handleKubernetesKubeconfig(w http.ResponseWriter, r *http.R
 	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesKubeconfig")
 	defer span.End()
 
-	if !d.generateKubeconfig {
-		http.Error(w, "kubeconfig generation is disabled", http.StatusForbidden)
+	if !d.generateKubeconfig {
+		http.Error(w, "kubeconfig generation is disabled", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Query().Get("redirect") != "" {
+		if !slices.Contains(d.generateKubeconfigRedirectUrls, r.URL.Query().Get("redirect")) {
+			http.Error(w, "invalid redirect url", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Get the user which makes the request. The "GetImpersonateUser" function
+	// only returns a user when the impersonate user feature is enabled. So that
+	// we have to set a user name when an empty string is returned.
+	//
+	// This allows us to have the generate kubeconfig feature enabled, without
+	// the impersonate user feature.
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if user == "" {
+		user = "admin"
+	}
+
+	d.logger.Info("handleKubernetesKubeconfig request", "user", user)
+	span.SetAttributes(attribute.Key("user").String(user))
+
+	var authInfo clientcmdapiv1.AuthInfo
+	if r.URL.Query().Get("type") == "exec" {
+		authInfo.Exec = &clientcmdapiv1.ExecConfig{
+			APIVersion: "client.authentication.k8s.io/v1",
+			Command:    "kubectl",
+			Args: []string{
+				"grafana",
+				"credentials",
+				fmt.Sprintf("--grafana-url=%s", d.grafanaClient.GetUrl().String()),
+				fmt.Sprintf("--grafana-datasource=%s", backend.PluginConfigFromContext(ctx).DataSourceInstanceSettings.UID),
+			},
+			InteractiveMode: clientcmdapiv1.NeverExecInteractiveMode,
+		}
+	} else {
+		// Create a new token for the user. The token is then used in the kubeconfig
+		// to authenticate in the proxy handler against Grafana. When the
+		// impersonate feature is enabled the token is also used to get user and
+		// groups in the proxy handler / server.
+		token, err := d.grafanaClient.CreateUserToken(ctx, user, d.generateKubeconfigTTL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		authInfo.Token = token
+	}
+
+	// Create a kubeconfig and return it.
+	//
+	// The server in the kubeconfig is the Kubernetes server which is started by
+	// the data source. The server then proxies the requests to the Kubernetes
+	// API server. The token is the Grafana token which is used to authenticate
+	// the user in the proxy handler.
+	kubeconfig := clientcmdapiv1.Config{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters: []clientcmdapiv1.NamedCluster{{
+			Name: d.generateKubeconfigName,
+			Cluster: clientcmdapiv1.Cluster{
+				// Currently we can not use the CallResourceHandler endpoint and
+				// have to use our own server here, because Grafan removes some
+				// headers from the request, which are required for "exec" and
+				// "port-forward" commands to work.
+				// Server: fmt.Sprintf("%sapi/datasources/uid/%s/resources/kubernetes/proxy", d.grafanaClient.GetURL().String(), backend.PluginConfigFromContext(ctx).DataSourceInstanceSettings.UID),
+				Server: fmt.Sprintf("%sapi/datasources/proxy/uid/%s/proxy", d.grafanaClient.GetUrl().String(), backend.PluginConfigFromContext(ctx).DataSourceInstanceSettings.UID),
+			},
+		}},
+		Contexts: []clientcmdapiv1.NamedContext{{
+			Name: d.generateKubeconfigName,
+			Context: clientcmdapiv1.Context{
+				Cluster:   d.generateKubeconfigName,
+				AuthInfo:  fmt.Sprintf("%s-%s", d.generateKubeconfigName, user),
+				Namespace: "default",
+			},
+		}},
+		CurrentContext: d.generateKubeconfigName,
+		AuthInfos: []clientcmdapiv1.NamedAuthInfo{{
+			Name:     fmt.Sprintf("%s-%s", d.generateKubeconfigName, user),
+			AuthInfo: authInfo,
+		}},
+	}
+
+	data, err := json.Marshal(kubeconfig)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (d *Datasource) handleKubernetesKubeconfigCredentials(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesKubeconfigCredentials")
+	defer span.End()
+
+	if !d.generateKubeconfig {
+		http.Error(w, "kubeconfig generation is disabled", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Query().Get("redirect") != "" {
+		if !slices.Contains(d.generateKubeconfigRedirectUrls, r.URL.Query().Get("redirect")) {
+			http.Error(w, "invalid redirect url", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Get the user which makes the request. The "GetImpersonateUser" function
+	// only returns a user when the impersonate user feature is enabled. So that
+	// we have to set a user name when an empty string is returned.
+	//
+	// This allows us to have the generate kubeconfig feature enabled, without
+	// the impersonate user feature.
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if user == "" {
+		user = "admin"
+	}
+
+	d.logger.Info("handleKubernetesKubeconfigCredentials request", "user", user)
+	span.SetAttributes(attribute.Key("user").String(user))
+
+	// Create a new token for the user. The token is then used in the kubeconfig
+	// to authenticate in the proxy handler against Grafana. When the
+	// impersonate feature is enabled the token is also used to get user and
+	// groups in the proxy handler / server.
+	token, err := d.grafanaClient.CreateUserToken(ctx, user, d.generateKubeconfigTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Create ExecCredential which is used in the kubeconfig "exec" section to
+	// return the generated token.
+	execCredential := clientauthenticationv1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: clientauthenticationv1.SchemeGroupVersion.String(),
+			Kind:       "ExecCredential",
+		},
+		Status: &clientauthenticationv1.ExecCredentialStatus{
+			ExpirationTimestamp: &metav1.Time{Time: time.Now().Add(time.Duration(d.generateKubeconfigTTL) * time.Second).Add(-60 * time.Second)},
+			Token:               token,
+		},
+	}
+
+	data, err := json.Marshal(execCredential)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleKubernetesResource can be used to get the details of a resource. The
+// endpoint expects the resource id in the path and returns the resource
+// details (e.g. path, resource, scope, etc.). This is required so we can call
+// the proxy endpoint within the Grafana UI with the correct Kubernetes request
+// paths.
+func (d *Datasource) handleKubernetesResource(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesResource")
+	defer span.End()
+
+	id := r.PathValue("id")
+	d.logger.Info("handleKubernetesResource request", "id", id)
+	span.SetAttributes(attribute.Key("id").String(id))
+
+	resource, err := d.kubeClient.GetResource(ctx, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	data, err := json.Marshal(resource)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleKubernetesResourceYAML returns the requested object as YAML without
+// the managed fields. For cluster-scoped resources the namespace path value is
+// ignored.
+func (d *Datasource) handleKubernetesResourceYAML(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesResourceYAML")
+	defer span.End()
+
+	id := r.PathValue("id")
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d.logger.Info("handleKubernetesResourceYAML request", "user", user, "groups", groups, "id", id, "namespace", namespace, "name", name)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("id").String(id))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+
+	manifest, err := d.kubeClient.GetResourceYAML(ctx, user, groups, id, namespace, name)
+	if err != nil {
+		d.logger.Error("Failed to get resource", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(manifest)
+}
+
+// handleKubernetesResourceApply applies the YAML manifest from the request body
+// via server-side apply. If the "dryRun" query parameter is set to "All", the
+// changes are only previewed. Conflicts are returned with the status code 409
+// and a JSON body, which contains the type of the conflict and the conflicting
+// fields, so that the frontend can show them to the user.
+func (d *Datasource) handleKubernetesResourceApply(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesResourceApply")
+	defer span.End()
+
+	id := r.PathValue("id")
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+	dryRun := r.URL.Query().Get("dryRun") == "All"
+	force := r.URL.Query().Get("force") == "true"
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d.logger.Info("handleKubernetesResourceApply request", "user", user, "groups", groups, "id", id, "namespace", namespace, "name", name, "dryRun", dryRun, "force", force)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("id").String(id))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("dryRun").Bool(dryRun))
+	span.SetAttributes(attribute.Key("force").Bool(force))
+
+	manifest, err := io.ReadAll(r.Body)
+	if err != nil {
+		d.logger.Error("Failed to read manifest", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: "apply", Resource: d.auditResource(ctx, id, ""), Namespace: namespace, Name: name, DryRun: dryRun})
+	defer done()
+
+	result, err := d.kubeClient.ApplyResourceYAML(ctx, user, groups, id, namespace, name, manifest, dryRun, force)
+	if err != nil {
+		d.logger.Error("Failed to apply resource", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		var conflictErr *kubernetes.ApplyConflictError
+		if errors.As(err, &conflictErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(conflictErr)
+			return
+		}
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleKubernetesWorkloadAction runs an action for a Deployment, StatefulSet
+// or DaemonSet and returns the rollout state of the workload afterwards. The
+// supported actions are "scale", "restart", "pause" and "resume". For the
+// "scale" action the number of replicas must be provided in the request body.
+func (d *Datasource) handleKubernetesWorkloadAction(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesWorkloadAction")
+	defer span.End()
+
+	id := r.PathValue("id")
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+	action := r.PathValue("action")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var options kubernetes.WorkloadActionOptions
+	err = json.NewDecoder(r.Body).Decode(&options)
+	if err != nil && err != io.EOF {
+		d.logger.Error("Failed to unmarshal options", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.logger.Info("handleKubernetesWorkloadAction request", "user", user, "groups", groups, "id", id, "namespace", namespace, "name", name, "action", action)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("id").String(id))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("action").String(action))
+
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: action, Resource: d.auditResource(ctx, id, ""), Namespace: namespace, Name: name})
+	defer done()
+
+	state, err := d.kubeClient.RunWorkloadAction(ctx, user, groups, id, namespace, name, action, options)
+	if err != nil {
+		d.logger.Error("Failed to run workload action", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleKubernetesJobRun creates a new Job from a CronJob or re-runs a
+// finished Job. The id from the path must be "cronjob.batch" or "job.batch".
+// Environment variables which should be overridden for the run can be set in
+// the request body.
+func (d *Datasource) handleKubernetesJobRun(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesJobRun")
+	defer span.End()
+
+	id := r.PathValue("id")
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if r.URL.Query().Get("redirect") != "" {
-		if !slices.Contains(d.generateKubeconfigRedirectUrls, r.URL.Query().Get("redirect")) {
-			http.Error(w, "invalid redirect url", http.StatusForbidden)
-			return
-		}
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var options kubernetes.JobRunOptions
+	err = json.NewDecoder(r.Body).Decode(&options)
+	if err != nil && err != io.EOF {
+		d.logger.Error("Failed to unmarshal options", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.logger.Info("handleKubernetesJobRun request", "user", user, "groups", groups, "id", id, "namespace", namespace, "name", name)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("id").String(id))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: "run", Resource: d.auditResource(ctx, id, ""), Namespace: namespace, Name: name})
+	defer done()
+
+	result, err := d.kubeClient.RunJob(ctx, user, groups, id, namespace, name, options)
+	if err != nil {
+		d.logger.Error("Failed to run job", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleKubernetesPodExec runs a non-interactive command in a container of
+// the requested pod. The container and command must be set in the request
+// body. The response contains the stdout, stderr and exit code of the command.
+// A non-zero exit code is not handled as error.
+func (d *Datasource) handleKubernetesPodExec(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesPodExec")
+	defer span.End()
+
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var options kubernetes.ExecOptions
+	err = json.NewDecoder(r.Body).Decode(&options)
+	if err != nil {
+		d.logger.Error("Failed to unmarshal options", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.logger.Info("handleKubernetesPodExec request", "user", user, "groups", groups, "namespace", namespace, "name", name, "container", options.Container, "command", options.Command)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("container").String(options.Container))
+	span.SetAttributes(attribute.Key("command").StringSlice(options.Command))
+
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: "exec", Resource: "pods/exec", Namespace: namespace, Name: name})
+	defer done()
+
+	if err := d.authorizeSubresource(ctx, r, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/exec", url.PathEscape(namespace), url.PathEscape(name))); err != nil {
+		d.logger.Error("Failed to run command", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	result, err := d.kubeClient.Exec(ctx, user, groups, namespace, name, options)
+	if err != nil {
+		d.logger.Error("Failed to run command", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleKubernetesPodDownload streams a file or directory from a container of
+// the requested pod. The container, path and format ("tar" or "file") must be
+// set via the query parameters.
+func (d *Datasource) handleKubernetesPodDownload(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesPodDownload")
+	defer span.End()
+
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+	options := kubernetes.DownloadOptions{
+		Container: r.URL.Query().Get("container"),
+		Path:      r.URL.Query().Get("path"),
+		Format:    r.URL.Query().Get("format"),
+	}
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d.logger.Info("handleKubernetesPodDownload request", "user", user, "groups", groups, "namespace", namespace, "name", name, "container", options.Container, "path", options.Path, "format", options.Format)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("container").String(options.Container))
+	span.SetAttributes(attribute.Key("path").String(options.Path))
+	span.SetAttributes(attribute.Key("format").String(options.Format))
+
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: "download", Resource: "pods/exec", Namespace: namespace, Name: name})
+	defer done()
+
+	if err := d.authorizeSubresource(ctx, r, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/exec", url.PathEscape(namespace), url.PathEscape(name))); err != nil {
+		d.logger.Error("Failed to download file", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	download, err := d.kubeClient.Download(ctx, user, groups, namespace, name, options)
+	if err != nil {
+		d.logger.Error("Failed to download file", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+	defer download.Close()
+
+	w.Header().Set("Content-Type", download.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": download.Filename}))
+
+	// The headers are already sent when the download fails, so that we can
+	// only log the error. The client will notice the incomplete download.
+	if _, err := io.Copy(w, download); err != nil {
+		d.logger.Error("Failed to stream download", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// handleKubernetesPodDebug adds an ephemeral debug container to the requested
+// pod. The image, target container and command can be set in the request body.
+// The response contains the name of the debug container, which can be used to
+// attach to the container.
+func (d *Datasource) handleKubernetesPodDebug(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesPodDebug")
+	defer span.End()
+
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var options kubernetes.DebugOptions
+	err = json.NewDecoder(r.Body).Decode(&options)
+	if err != nil && err != io.EOF {
+		d.logger.Error("Failed to unmarshal options", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.logger.Info("handleKubernetesPodDebug request", "user", user, "groups", groups, "namespace", namespace, "name", name, "image", options.Image, "targetContainer", options.TargetContainer)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("image").String(options.Image))
+	span.SetAttributes(attribute.Key("targetContainer").String(options.TargetContainer))
+
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: "debug", Resource: "pods/ephemeralcontainers", Namespace: namespace, Name: name})
+	defer done()
+
+	result, err := d.kubeClient.Debug(ctx, user, groups, namespace, name, options)
+	if err != nil {
+		d.logger.Error("Failed to add debug container", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// nodeActionProgress is a single line of the streamed response of a node
+// action. Each line contains either the result of an evicted pod, the final
+// result of the action or an error. When a drain is incomplete, the last line
+// contains the final result and the error.
+type nodeActionProgress struct {
+	Pod    *kubernetes.NodeDrainPodResult `json:"pod,omitempty"`
+	Result *kubernetes.NodeActionResult   `json:"result,omitempty"`
+	Error  string                         `json:"error,omitempty"`
+}
+
+// handleKubernetesNodeAction runs the action from the path for the requested
+// node. The action can be "cordon", "uncordon" or "drain". For the "drain"
+// action the grace period, timeout, dry run mode and the force and
+// deleteEmptyDirData options can be set in the request body and the response
+// contains the result for each pod on the node. When not all pods could be
+// evicted, the drain fails with a "409 Conflict" error.
+//
+// Since draining a node can take several minutes, the client can request a
+// streamed response via the "Accept: application/x-ndjson" header. The
+// response then contains one JSON object per line: A line with the result of
+// each pod, as soon as the eviction is finished, and a last line with the
+// final result or the error.
+func (d *Datasource) handleKubernetesNodeAction(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesNodeAction")
+	defer span.End()
+
+	name := r.PathValue("name")
+	action := r.PathValue("action")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// Get the user which makes the request. The "GetImpersonateUser" function
-	// only returns a user when the impersonate user feature is enabled. So that
-	// we have to set a user name when an empty string is returned.
-	//
-	// This allows us to have the generate kubeconfig feature enabled, without
-	// the impersonate user feature.
 	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
 	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
+	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if user == "" {
-		user = "admin"
+	var options kubernetes.NodeDrainOptions
+	err = json.NewDecoder(r.Body).Decode(&options)
+	if err != nil && err != io.EOF {
+		d.logger.Error("Failed to unmarshal options", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	d.logger.Info("handleKubernetesKubeconfig request", "user", user)
+	d.logger.Info("handleKubernetesNodeAction request", "user", user, "groups", groups, "name", name, "action", action, "dryRun", options.DryRun)
 	span.SetAttributes(attribute.Key("user").String(user))
-
-	var authInfo clientcmdapiv1.AuthInfo
-	if r.URL.Query().Get("type") == "exec" {
-		authInfo.Exec = &clientcmdapiv1.ExecConfig{
-			APIVersion: "client.authentication.k8s.io/v1",
-			Command:    "kubectl",
-			Args: []string{
-				"grafana",
-				"credentials",
-				fmt.Sprintf("--grafana-url=%s", d.grafanaClient.GetUrl().String()),
-				fmt.Sprintf("--grafana-datasource=%s", backend.PluginConfigFromContext(ctx).DataSourceInstanceSettings.UID),
-			},
-			InteractiveMode: clientcmdapiv1.NeverExecInteractiveMode,
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("action").String(action))
+	span.SetAttributes(attribute.Key("dryRun").Bool(options.DryRun))
+
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: action, Resource: "nodes", Name: name, DryRun: options.DryRun})
+	defer done()
+
+	// When the response is streamed, the headers are written with the first
+	// line, so that errors which occur before the first pod is evicted (e.g.
+	// when the user is not allowed to cordon the node) are still returned with
+	// the correct status code.
+	stream := strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	var streamStarted bool
+	writeProgress := func(progress nodeActionProgress) {
+		if !streamStarted {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			streamStarted = true
 		}
-	} else {
-		// Create a new token for the user. The token is then used in the kubeconfig
-		// to authenticate in the proxy handler against Grafana. When the
-		// impersonate feature is enabled the token is also used to get user and
-		// groups in the proxy handler / server.
-		token, err := d.grafanaClient.CreateUserToken(ctx, user, d.generateKubeconfigTTL)
+
+		data, err := json.Marshal(progress)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			d.logger.Error("Failed to marshal node action progress", "error", err.Error())
 			return
 		}
-		authInfo.Token = token
+		w.Write(append(data, '\n'))
+		http.NewResponseController(w).Flush()
 	}
 
-	// Create a kubeconfig and return it.
-	//
-	// The server in the kubeconfig is the Kubernetes server which is started by
-	// the data source. The server then proxies the requests to the Kubernetes
-	// API server. The token is the Grafana token which is used to authenticate
-	// the user in the proxy handler.
-	kubeconfig := clientcmdapiv1.Config{
-		APIVersion: "v1",
-		Kind:       "Config",
-		Clusters: []clientcmdapiv1.NamedCluster{{
-			Name: d.generateKubeconfigName,
-			Cluster: clientcmdapiv1.Cluster{
-				// Currently we can not use the CallResourceHandler endpoint and
-				// have to use our own server here, because Grafan removes some
-				// headers from the request, which are required for "exec" and
-				// "port-forward" commands to work.
-				// Server: fmt.Sprintf("%sapi/datasources/uid/%s/resources/kubernetes/proxy", d.grafanaClient.GetURL().String(), backend.PluginConfigFromContext(ctx).DataSourceInstanceSettings.UID),
-				Server: fmt.Sprintf("%sapi/datasources/proxy/uid/%s/proxy", d.grafanaClient.GetUrl().String(), backend.PluginConfigFromContext(ctx).DataSourceInstanceSettings.UID),
-			},
-		}},
-		Contexts: []clientcmdapiv1.NamedContext{{
-			Name: d.generateKubeconfigName,
-			Context: clientcmdapiv1.Context{
-				Cluster:   d.generateKubeconfigName,
-				AuthInfo:  fmt.Sprintf("%s-%s", d.generateKubeconfigName, user),
-				Namespace: "default",
-			},
-		}},
-		CurrentContext: d.generateKubeconfigName,
-		AuthInfos: []clientcmdapiv1.NamedAuthInfo{{
-			Name:     fmt.Sprintf("%s-%s", d.generateKubeconfigName, user),
-			AuthInfo: authInfo,
-		}},
+	if stream {
+		options.Progress = func(result kubernetes.NodeDrainPodResult) {
+			writeProgress(nodeActionProgress{Pod: &result})
+		}
 	}
 
-	data, err := json.Marshal(kubeconfig)
+	result, err := d.kubeClient.RunNodeAction(ctx, user, groups, name, action, options)
+	if err != nil {
+		d.logger.Error("Failed to run node action", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		// The status code of a streamed response was already written, so that
+		// the error is returned as last line and the status code of the audit
+		// log entry is set to the status code of the error. Otherwise a failed
+		// drain would create an annotation and notify the webhooks.
+		if streamStarted {
+			writeProgress(nodeActionProgress{Result: result, Error: err.Error()})
+			setAuditStatusCode(w, statusCodeForError(err))
+			return
+		}
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	if stream {
+		writeProgress(nodeActionProgress{Result: result})
+		return
+	}
+
+	data, err := json.Marshal(result)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -367,94 +1754,84 @@ func (d *Datasource) handleKubernetesKubeconfig(w http.ResponseWriter, r *http.R
 	w.Write(data)
 }
 
-func (d *Datasource) handleKubernetesKubeconfigCredentials(w http.ResponseWriter, r *http.Request) {
-	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesKubeconfigCredentials")
+// handleKubernetesDeploymentRollback rolls back a Deployment to the revision
+// from the path. If the revision is 0, the Deployment is rolled back to the
+// previous revision.
+func (d *Datasource) handleKubernetesDeploymentRollback(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesDeploymentRollback")
 	defer span.End()
 
-	if !d.generateKubeconfig {
-		http.Error(w, "kubeconfig generation is disabled", http.StatusForbidden)
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if r.URL.Query().Get("redirect") != "" {
-		if !slices.Contains(d.generateKubeconfigRedirectUrls, r.URL.Query().Get("redirect")) {
-			http.Error(w, "invalid redirect url", http.StatusForbidden)
-			return
-		}
+	revision, err := strconv.ParseInt(r.PathValue("revision"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to parse revision", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Get the user which makes the request. The "GetImpersonateUser" function
-	// only returns a user when the impersonate user feature is enabled. So that
-	// we have to set a user name when an empty string is returned.
-	//
-	// This allows us to have the generate kubeconfig feature enabled, without
-	// the impersonate user feature.
 	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
 	if err != nil {
+		d.logger.Error("Failed to get user", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if user == "" {
-		user = "admin"
-	}
-
-	d.logger.Info("handleKubernetesKubeconfigCredentials request", "user", user)
-	span.SetAttributes(attribute.Key("user").String(user))
-
-	// Create a new token for the user. The token is then used in the kubeconfig
-	// to authenticate in the proxy handler against Grafana. When the
-	// impersonate feature is enabled the token is also used to get user and
-	// groups in the proxy handler / server.
-	token, err := d.grafanaClient.CreateUserToken(ctx, user, d.generateKubeconfigTTL)
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
 	if err != nil {
+		d.logger.Error("Failed to get groups", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Create ExecCredential which is used in the kubeconfig "exec" section to
-	// return the generated token.
-	execCredential := clientauthenticationv1.ExecCredential{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: clientauthenticationv1.SchemeGroupVersion.String(),
-			Kind:       "ExecCredential",
-		},
-		Status: &clientauthenticationv1.ExecCredentialStatus{
-			ExpirationTimestamp: &metav1.Time{Time: time.Now().Add(time.Duration(d.generateKubeconfigTTL) * time.Second).Add(-60 * time.Second)},
-			Token:               token,
-		},
-	}
+	var options kubernetes.DeploymentRollbackOptions
+	err = json.NewDecoder(r.Body).Decode(&options)
+	if err != nil && err != io.EOF {
+		d.logger.Error("Failed to unmarshal options", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 
-	data, err := json.Marshal(execCredential)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(data)
-}
-
-// handleKubernetesResource can be used to get the details of a resource. The
-// endpoint expects the resource id in the path and returns the resource
-// details (e.g. path, resource, scope, etc.). This is required so we can call
-// the proxy endpoint within the Grafana UI with the correct Kubernetes request
-// paths.
-func (d *Datasource) handleKubernetesResource(w http.ResponseWriter, r *http.Request) {
-	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesResource")
-	defer span.End()
+	d.logger.Info("handleKubernetesDeploymentRollback request", "user", user, "groups", groups, "namespace", namespace, "name", name, "revision", revision, "dryRun", options.DryRun)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("revision").Int64(revision))
+	span.SetAttributes(attribute.Key("dryRun").Bool(options.DryRun))
 
-	id := r.PathValue("id")
-	d.logger.Info("handleKubernetesResource request", "id", id)
-	span.SetAttributes(attribute.Key("id").String(id))
+	w, done := d.recordAction(ctx, w, r, audit.Entry{Verb: "rollback", Resource: "deployments.apps", Namespace: namespace, Name: name, DryRun: options.DryRun})
+	defer done()
 
-	resource, err := d.kubeClient.GetResource(ctx, id)
+	result, err := d.kubeClient.RollbackDeployment(ctx, user, groups, namespace, name, revision, options)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		d.logger.Error("Failed to rollback deployment", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
 		return
 	}
 
-	data, err := json.Marshal(resource)
+	data, err := json.Marshal(result)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -464,6 +1841,18 @@ func (d *Datasource) handleKubernetesResource(w http.ResponseWriter, r *http.Req
 	w.Write(data)
 }
 
+// statusCodeForError returns the status code of the provided error, if it was
+// returned by the Kubernetes API. For all other errors the status code 500 is
+// returned.
+func statusCodeForError(err error) int {
+	var statusErr apierrors.APIStatus
+	if errors.As(err, &statusErr) && statusErr.Status().Code != 0 {
+		return int(statusErr.Status().Code)
+	}
+
+	return http.StatusInternalServerError
+}
+
 // handleKubernetesProxy proxies a request to the Kubernetes API. The path which
 // should be requested at the Kubernetes API must be set in the "pathname" path
 // value.
@@ -492,5 +1881,162 @@ func (d *Datasource) handleKubernetesProxy(w http.ResponseWriter, r *http.Reques
 	span.SetAttributes(attribute.Key("method").String(r.Method))
 	span.SetAttributes(attribute.Key("requestUrl").String(requestUrl))
 
+	// Mutating requests are written to the audit log, when the request is
+	// finished. This also includes requests which are denied by the plugin.
+	entry, ok, err := kubernetes.NewAuditEntry(r.Method, "/"+requestUrl)
+	if err != nil {
+		d.logger.Error("Failed to create audit log entry", "error", err.Error())
+	} else if ok {
+		var done func()
+		w, done = d.recordAction(ctx, w, r, entry)
+		defer done()
+	}
+
+	if err := d.authorizeSubresource(ctx, r, r.Method, "/"+requestUrl); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		kubernetes.WriteStatusError(w, err)
+		return
+	}
+
 	d.kubeClient.Proxy(user, groups, requestUrl, w, r)
 }
+
+// authorizeSubresource checks if the user is allowed to use the "exec",
+// "attach", "portforward" or "proxy" subresource of a pod, when it is used by
+// the request for the provided method and url. The Grafana teams of the user
+// are only requested, when a subresource is restricted to some teams.
+func (d *Datasource) authorizeSubresource(ctx context.Context, r *http.Request, method, requestUrl string) error {
+	return d.kubeClient.AuthorizeSubresource(method, requestUrl, func() ([]string, error) {
+		return d.grafanaClient.GetTeams(ctx, r.Header)
+	})
+}
+
+// handleKubernetesPreview proxies a HTTP request to a port of a pod or service.
+// The kind ("pods" or "services"), namespace, name and port are set via the
+// path values. The "pathname" path value is the path which should be
+// requested in the pod or service.
+func (d *Datasource) handleKubernetesPreview(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesPreview")
+	defer span.End()
+
+	user, err := d.grafanaClient.GetImpersonateUser(ctx, r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups, err := d.grafanaClient.GetImpersonateGroups(ctx, r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	kind := r.PathValue("kind")
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+	port := r.PathValue("port")
+	requestUrl := fmt.Sprintf("%s?%s", r.PathValue("pathname"), r.URL.RawQuery)
+
+	// The public path is the path under which the preview is served by Grafana.
+	// It is required to rewrite the links of the previewed application.
+	publicPath := fmt.Sprintf("%s/api/datasources/uid/%s/resources/kubernetes/preview/%s/%s/%s/%s", strings.TrimSuffix(d.grafanaClient.GetUrl().Path, "/"), backend.PluginConfigFromContext(ctx).DataSourceInstanceSettings.UID, kind, namespace, name, port)
+
+	d.logger.Info("handleKubernetesPreview request", "user", user, "groups", groups, "method", r.Method, "kind", kind, "namespace", namespace, "name", name, "port", port, "requestUrl", requestUrl)
+	span.SetAttributes(attribute.Key("user").String(user))
+	span.SetAttributes(attribute.Key("groups").StringSlice(groups))
+	span.SetAttributes(attribute.Key("method").String(r.Method))
+	span.SetAttributes(attribute.Key("kind").String(kind))
+	span.SetAttributes(attribute.Key("namespace").String(namespace))
+	span.SetAttributes(attribute.Key("name").String(name))
+	span.SetAttributes(attribute.Key("port").String(port))
+	span.SetAttributes(attribute.Key("requestUrl").String(requestUrl))
+
+	if err := d.authorizeSubresource(ctx, r, r.Method, fmt.Sprintf("/api/v1/namespaces/%s/%s/%s:%s/proxy", url.PathEscape(namespace), url.PathEscape(kind), url.PathEscape(name), url.PathEscape(port))); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		kubernetes.WriteStatusError(w, err)
+		return
+	}
+
+	d.kubeClient.Preview(user, groups, kind, namespace, name, port, requestUrl, publicPath, w, r)
+}
+
+// isGrafanaAdmin returns true if the user which makes the request has the
+// "Admin" role in the current Grafana organization.
+func isGrafanaAdmin(ctx context.Context) bool {
+	user := backend.PluginConfigFromContext(ctx).User
+	return user != nil && user.Role == "Admin"
+}
+
+// handleKubernetesRecordings returns the metadata of all recorded exec and
+// attach sessions. Because the recordings can contain sensitive data, they
+// can only be listed by Grafana admins.
+func (d *Datasource) handleKubernetesRecordings(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesRecordings")
+	defer span.End()
+
+	if !isGrafanaAdmin(ctx) {
+		http.Error(w, "only admins are allowed to list recordings", http.StatusForbidden)
+		return
+	}
+
+	d.logger.Info("handleKubernetesRecordings request")
+
+	recordings, err := d.kubeClient.ListRecordings(ctx)
+	if err != nil {
+		d.logger.Error("Failed to list recordings", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+
+	data, err := json.Marshal(recordings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleKubernetesRecording downloads the recording with the requested id in
+// the asciicast v2 format, so that it can be replayed with asciinema. Like the
+// list of recordings, a recording can only be downloaded by Grafana admins.
+func (d *Datasource) handleKubernetesRecording(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.DefaultTracer().Start(r.Context(), "handleKubernetesRecording")
+	defer span.End()
+
+	id := r.PathValue("id")
+
+	if !isGrafanaAdmin(ctx) {
+		http.Error(w, "only admins are allowed to download recordings", http.StatusForbidden)
+		return
+	}
+
+	d.logger.Info("handleKubernetesRecording request", "id", id)
+	span.SetAttributes(attribute.Key("id").String(id))
+
+	recording, err := d.kubeClient.GetRecording(ctx, id)
+	if err != nil {
+		d.logger.Error("Failed to get recording", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		http.Error(w, err.Error(), statusCodeForError(err))
+		return
+	}
+	defer recording.Close()
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": id + ".cast"}))
+
+	if _, err := io.Copy(w, recording); err != nil {
+		d.logger.Error("Failed to stream recording", "error", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,232 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/grafana"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/kubernetes"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/webhook"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestHandleKubernetesNodeAction(t *testing.T) {
+	newTestDatasource := func(t *testing.T) (*Datasource, *kubernetes.MockClient) {
+		ctrl := gomock.NewController(t)
+		grafanaClient := grafana.NewMockClient(ctrl)
+		kubeClient := kubernetes.NewMockClient(ctrl)
+
+		grafanaClient.EXPECT().GetImpersonateUser(gomock.Any(), gomock.Any()).Return("admin", nil).AnyTimes()
+		grafanaClient.EXPECT().GetImpersonateGroups(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+		grafanaClient.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return("admin", nil).AnyTimes()
+		grafanaClient.EXPECT().GetTeams(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+		return &Datasource{grafanaClient: grafanaClient, kubeClient: kubeClient, logger: log.DefaultLogger}, kubeClient
+	}
+
+	// enableNotifications enables the annotations and a webhook for the
+	// datasource and returns the number of received webhook notifications.
+	// Since no annotation is expected by the Grafana client mock, the test
+	// fails when an annotation is created.
+	enableNotifications := func(t *testing.T, ds *Datasource) *atomic.Int32 {
+		var notifications atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			notifications.Add(1)
+		}))
+		t.Cleanup(server.Close)
+
+		webhooks, err := webhook.NewDispatcher([]models.Webhook{{Name: "test", Url: server.URL}}, nil, log.DefaultLogger)
+		require.NoError(t, err)
+		t.Cleanup(webhooks.Close)
+
+		ds.annotations = true
+		ds.webhooks = webhooks
+		return &notifications
+	}
+
+	request := func(ds *Datasource, accept string) *httptest.ResponseRecorder {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/kubernetes/nodes/{name}/{action}", ds.handleKubernetesNodeAction)
+
+		r := httptest.NewRequest(http.MethodPost, "/kubernetes/nodes/node1/drain", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	drain := func(_ context.Context, _ string, _ []string, name, action string, options kubernetes.NodeDrainOptions) (*kubernetes.NodeActionResult, error) {
+		pods := []kubernetes.NodeDrainPodResult{
+			{Namespace: "default", Name: "echoserver", Status: kubernetes.NodeDrainPodStatusEvicted},
+			{Namespace: "default", Name: "nginx", Status: kubernetes.NodeDrainPodStatusEvicted},
+		}
+		for _, pod := range pods {
+			if options.Progress != nil {
+				options.Progress(pod)
+			}
+		}
+		return &kubernetes.NodeActionResult{Node: name, Action: action, Unschedulable: true, Pods: pods}, nil
+	}
+
+	blockedDrain := func(_ context.Context, _ string, _ []string, name, action string, options kubernetes.NodeDrainOptions) (*kubernetes.NodeActionResult, error) {
+		pods := []kubernetes.NodeDrainPodResult{
+			{Namespace: "default", Name: "echoserver", Status: kubernetes.NodeDrainPodStatusEvicted},
+			{Namespace: "default", Name: "nginx", Status: kubernetes.NodeDrainPodStatusTimedOut, PodDisruptionBudget: "nginx"},
+		}
+		for _, pod := range pods {
+			if options.Progress != nil {
+				options.Progress(pod)
+			}
+		}
+		return &kubernetes.NodeActionResult{Node: name, Action: action, Unschedulable: true, Pods: pods}, apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, name, fmt.Errorf("drain is incomplete"))
+	}
+
+	t.Run("should return result", func(t *testing.T) {
+		ds, kubeClient := newTestDatasource(t)
+		kubeClient.EXPECT().RunNodeAction(gomock.Any(), "admin", gomock.Any(), "node1", "drain", gomock.Any()).DoAndReturn(drain)
+
+		w := request(ds, "application/json")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var result kubernetes.NodeActionResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		require.Len(t, result.Pods, 2)
+	})
+
+	t.Run("should stream progress", func(t *testing.T) {
+		ds, kubeClient := newTestDatasource(t)
+		kubeClient.EXPECT().RunNodeAction(gomock.Any(), "admin", gomock.Any(), "node1", "drain", gomock.Any()).DoAndReturn(drain)
+
+		w := request(ds, "application/x-ndjson")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+		var lines []nodeActionProgress
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var line nodeActionProgress
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			lines = append(lines, line)
+		}
+
+		require.Len(t, lines, 3)
+		require.Equal(t, "echoserver", lines[0].Pod.Name)
+		require.Equal(t, kubernetes.NodeDrainPodStatusEvicted, lines[1].Pod.Status)
+		require.Equal(t, "node1", lines[2].Result.Node)
+		require.Len(t, lines[2].Result.Pods, 2)
+	})
+
+	t.Run("should return status code for errors before the first line", func(t *testing.T) {
+		ds, kubeClient := newTestDatasource(t)
+		kubeClient.EXPECT().RunNodeAction(gomock.Any(), "admin", gomock.Any(), "node1", "drain", gomock.Any()).Return(nil, apierrors.NewForbidden(schema.GroupResource{Resource: "nodes"}, "node1", nil))
+
+		w := request(ds, "application/x-ndjson")
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("should notify about successful drain", func(t *testing.T) {
+		ds, kubeClient := newTestDatasource(t)
+		kubeClient.EXPECT().RunNodeAction(gomock.Any(), "admin", gomock.Any(), "node1", "drain", gomock.Any()).DoAndReturn(drain)
+		ds.grafanaClient.(*grafana.MockClient).EXPECT().CreateAnnotation(gomock.Any(), "drain nodes node1 by admin", gomock.Any(), gomock.Any()).Return(nil)
+		notifications := enableNotifications(t, ds)
+
+		w := request(ds, "application/x-ndjson")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Eventually(t, func() bool { return notifications.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should return error for blocked drain", func(t *testing.T) {
+		ds, kubeClient := newTestDatasource(t)
+		kubeClient.EXPECT().RunNodeAction(gomock.Any(), "admin", gomock.Any(), "node1", "drain", gomock.Any()).DoAndReturn(blockedDrain)
+		notifications := enableNotifications(t, ds)
+
+		w := request(ds, "application/json")
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.Never(t, func() bool { return notifications.Load() > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+	})
+
+	t.Run("should stream error for blocked drain", func(t *testing.T) {
+		ds, kubeClient := newTestDatasource(t)
+		kubeClient.EXPECT().RunNodeAction(gomock.Any(), "admin", gomock.Any(), "node1", "drain", gomock.Any()).DoAndReturn(blockedDrain)
+		notifications := enableNotifications(t, ds)
+
+		w := request(ds, "application/x-ndjson")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var lines []nodeActionProgress
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var line nodeActionProgress
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			lines = append(lines, line)
+		}
+
+		require.Len(t, lines, 3)
+		require.Equal(t, kubernetes.NodeDrainPodStatusTimedOut, lines[1].Pod.Status)
+		require.Equal(t, "node1", lines[2].Result.Node)
+		require.NotEmpty(t, lines[2].Error)
+		require.Never(t, func() bool { return notifications.Load() > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+	})
+}
+
+func TestKubernetesResourcesStream(t *testing.T) {
+	newTestDatasource := func(t *testing.T) (*Datasource, *kubernetes.MockClient) {
+		ctrl := gomock.NewController(t)
+		kubeClient := kubernetes.NewMockClient(ctrl)
+		kubeClient.EXPECT().GetResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+		return &Datasource{kubeClient: kubeClient, logger: log.DefaultLogger}, kubeClient
+	}
+
+	subscribe := func(t *testing.T, ds *Datasource, user string) {
+		res, err := ds.subscribeKubernetesResourcesStream(context.Background(), user, []string{user}, &backend.SubscribeStreamRequest{Path: "resources", Data: []byte(`{}`)})
+		require.NoError(t, err)
+		require.Equal(t, backend.SubscribeStreamStatusOK, res.Status)
+	}
+
+	run := func(ds *Datasource) error {
+		return ds.runKubernetesResourcesStream(context.Background(), &backend.RunStreamRequest{Path: "resources", Data: []byte(`{}`)}, nil)
+	}
+
+	t.Run("should run stream with identity of subscriber which started it", func(t *testing.T) {
+		ds, kubeClient := newTestDatasource(t)
+
+		kubeClient.EXPECT().StreamResources(gomock.Any(), "alice", []string{"alice"}, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, _ []string, _, _, _, _ string, _ bool, _ *backend.StreamSender) error {
+			// A second subscriber of the same path shares the running stream.
+			subscribe(t, ds, "bob")
+			return nil
+		})
+		kubeClient.EXPECT().StreamResources(gomock.Any(), "bob", []string{"bob"}, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+		subscribe(t, ds, "alice")
+		require.NoError(t, run(ds))
+
+		// The entry of the subscriber, which was saved while the stream was
+		// running, must not be removed, so that it can be used for the next
+		// stream of the path.
+		require.NoError(t, run(ds))
+
+		_, ok := ds.streamSubscribers.Load("resources")
+		require.False(t, ok)
+	})
+
+	t.Run("should return error without subscriber", func(t *testing.T) {
+		ds, _ := newTestDatasource(t)
+		require.Error(t, run(ds))
+	})
+}
@@ -0,0 +1,286 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	// queueSize is the number of notifications, which can be queued for each
+	// webhook. When the queue is full, new notifications are dropped, so that
+	// the actions are never blocked by a slow webhook.
+	queueSize = 100
+	// maxAttempts is the number of attempts to deliver a notification to a
+	// webhook, before it is dropped.
+	maxAttempts = 5
+	// initialBackoff is the time to wait before the first retry. The time is
+	// doubled for each following retry.
+	initialBackoff = 1 * time.Second
+	// requestTimeout is the timeout for a single request to a webhook.
+	requestTimeout = 10 * time.Second
+)
+
+// Payload is the JSON payload, which is sent to the webhooks. It contains all
+// fields of the audit log entry for the action, the event, the name of the
+// datasource and a text, which can be used by chat tools like Slack.
+type Payload struct {
+	Event      string `json:"event"`
+	Datasource string `json:"datasource"`
+	Text       string `json:"text"`
+	audit.Entry
+}
+
+// NewPayload returns the payload for the provided action.
+func NewPayload(datasource string, entry audit.Entry) Payload {
+	return Payload{
+		Event:      Event(entry),
+		Datasource: datasource,
+		Text:       fmt.Sprintf("%s: %s", datasource, entry.String()),
+		Entry:      entry,
+	}
+}
+
+// Event returns the event for the provided action, which is the resource and
+// verb of the action, e.g. "helmreleases:rollback".
+func Event(entry audit.Entry) string {
+	return entry.Resource + ":" + entry.Verb
+}
+
+type target struct {
+	name       string
+	url        string
+	secret     string
+	events     []*regexp.Regexp
+	namespaces []*regexp.Regexp
+	queue      chan Payload
+}
+
+// matches returns true, when the webhook should receive the provided payload.
+func (t target) matches(payload Payload) bool {
+	match := func(filters []*regexp.Regexp, value string) bool {
+		return len(filters) == 0 || slices.ContainsFunc(filters, func(filter *regexp.Regexp) bool {
+			return filter.MatchString(value)
+		})
+	}
+
+	return match(t.events, payload.Event) && match(t.namespaces, payload.Namespace)
+}
+
+// Dispatcher sends the notifications for actions to the configured webhooks.
+// The notifications are delivered asynchronously, so that an action isn't
+// delayed by a webhook. If a webhook returns an error, the delivery is retried
+// with an exponential backoff. Each webhook has its own queue and worker, so
+// that a slow or failing webhook doesn't delay the notifications for the other
+// webhooks.
+//
+// All methods can be called on a nil dispatcher, which is used when no
+// webhooks are configured.
+type Dispatcher struct {
+	targets []target
+	client  *http.Client
+	backoff time.Duration
+	logger  log.Logger
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// Dispatch queues the provided payload for all webhooks, which are matching
+// the event and namespace of the payload.
+func (d *Dispatcher) Dispatch(payload Payload) {
+	if d == nil {
+		return
+	}
+
+	for _, t := range d.targets {
+		if !t.matches(payload) {
+			continue
+		}
+
+		select {
+		case t.queue <- payload:
+		default:
+			d.logger.Error("Failed to queue webhook notification", "webhook", t.name, "error", "queue is full")
+		}
+	}
+}
+
+// Close stops the delivery of the notifications. Notifications which are not
+// delivered yet are dropped.
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+
+	d.cancel()
+	d.wg.Wait()
+}
+
+// run delivers the queued notifications for the provided webhook, until the
+// dispatcher is closed.
+func (d *Dispatcher) run(t target) {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case payload := <-t.queue:
+			d.deliver(t, payload)
+		}
+	}
+}
+
+// deliver sends the notification to the webhook. If the request fails or the
+// webhook returns a server error or "429 Too Many Requests", the request is
+// retried until the maximum number of attempts is reached.
+func (d *Dispatcher) deliver(t target, payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		d.logger.Error("Failed to marshal webhook payload", "webhook", t.name, "error", err.Error())
+		return
+	}
+
+	backoff := d.backoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		retry, err := d.send(t, payload, body)
+		if err == nil {
+			return
+		}
+
+		d.logger.Warn("Failed to send webhook notification", "webhook", t.name, "attempt", attempt, "error", err.Error())
+		if !retry || attempt == maxAttempts {
+			break
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+			backoff = backoff * 2
+		}
+	}
+
+	d.logger.Error("Failed to deliver webhook notification", "webhook", t.name, "event", payload.Event, "requestId", payload.RequestId)
+}
+
+// send sends a single request to the webhook. It returns if the request
+// should be retried, when an error is returned.
+func (d *Dispatcher) send(t target, payload Payload, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", payload.Event)
+	req.Header.Set("X-Request-Id", payload.RequestId)
+	if t.secret != "" {
+		req.Header.Set("X-Signature-256", Sign(t.secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("webhook returned status code %d", resp.StatusCode)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Sign returns the signature of the provided body, which is sent in the
+// "X-Signature-256" header. The signature is the hex encoded HMAC-SHA256 of
+// the body with the secret of the webhook, prefixed with "sha256=".
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewDispatcher returns a new dispatcher for the provided webhooks. The
+// secrets of the webhooks are looked up by the name of the webhook. If no
+// webhooks are configured, nil is returned.
+func NewDispatcher(webhooks []models.Webhook, secrets map[string]string, logger log.Logger) (*Dispatcher, error) {
+	if len(webhooks) == 0 {
+		return nil, nil
+	}
+
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var filters []*regexp.Regexp
+		for _, pattern := range patterns {
+			filter, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+		return filters, nil
+	}
+
+	var targets []target
+	for _, webhook := range webhooks {
+		if webhook.Url == "" {
+			return nil, fmt.Errorf("url for webhook %q is required", webhook.Name)
+		}
+
+		events, err := compile(webhook.Events)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event filter for webhook %q: %w", webhook.Name, err)
+		}
+
+		namespaces, err := compile(webhook.Namespaces)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace filter for webhook %q: %w", webhook.Name, err)
+		}
+
+		targets = append(targets, target{
+			name:       webhook.Name,
+			url:        webhook.Url,
+			secret:     secrets[webhook.Name],
+			events:     events,
+			namespaces: namespaces,
+			queue:      make(chan Payload, queueSize),
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &Dispatcher{
+		targets: targets,
+		client:  &http.Client{},
+		backoff: initialBackoff,
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for _, t := range targets {
+		d.wg.Add(1)
+		go d.run(t)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/audit"
+	"github.com/ricoberger/grafana-kubernetes-plugin/pkg/models"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+type testRequest struct {
+	header  http.Header
+	body    []byte
+	payload Payload
+}
+
+func newTestReceiver(t *testing.T, statusCodes ...int) (*httptest.Server, chan testRequest) {
+	var attempts atomic.Int64
+	requests := make(chan testRequest, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var payload Payload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		requests <- testRequest{header: r.Header, body: body, payload: payload}
+
+		attempt := int(attempts.Add(1))
+		if attempt <= len(statusCodes) {
+			w.WriteHeader(statusCodes[attempt-1])
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+func receive(t *testing.T, requests chan testRequest) testRequest {
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+		return testRequest{}
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	entry := audit.Entry{Time: time.Now(), RequestId: "abc", User: "admin", Verb: "rollback", Resource: "helmreleases", Namespace: "production", Name: "echoserver", StatusCode: http.StatusOK}
+
+	t.Run("should send signed payload", func(t *testing.T) {
+		server, requests := newTestReceiver(t)
+
+		dispatcher, err := NewDispatcher([]models.Webhook{{Name: "slack", Url: server.URL}}, map[string]string{"slack": "secret"}, log.DefaultLogger)
+		require.NoError(t, err)
+		defer dispatcher.Close()
+
+		dispatcher.Dispatch(NewPayload("kubernetes", entry))
+
+		req := receive(t, requests)
+		require.Equal(t, "application/json", req.header.Get("Content-Type"))
+		require.Equal(t, "helmreleases:rollback", req.header.Get("X-Webhook-Event"))
+		require.Equal(t, "abc", req.header.Get("X-Request-Id"))
+		require.Equal(t, Sign("secret", req.body), req.header.Get("X-Signature-256"))
+		require.Equal(t, "helmreleases:rollback", req.payload.Event)
+		require.Equal(t, "kubernetes", req.payload.Datasource)
+		require.Equal(t, "kubernetes: rollback helmreleases production/echoserver by admin", req.payload.Text)
+		require.Equal(t, "admin", req.payload.User)
+		require.Equal(t, "echoserver", req.payload.Name)
+	})
+
+	t.Run("should not sign payload without secret", func(t *testing.T) {
+		server, requests := newTestReceiver(t)
+
+		dispatcher, err := NewDispatcher([]models.Webhook{{Name: "teams", Url: server.URL}}, nil, log.DefaultLogger)
+		require.NoError(t, err)
+		defer dispatcher.Close()
+
+		dispatcher.Dispatch(NewPayload("kubernetes", entry))
+
+		req := receive(t, requests)
+		require.Empty(t, req.header.Get("X-Signature-256"))
+	})
+
+	t.Run("should retry failed requests", func(t *testing.T) {
+		server, requests := newTestReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+
+		dispatcher, err := NewDispatcher([]models.Webhook{{Name: "slack", Url: server.URL}}, nil, log.DefaultLogger)
+		require.NoError(t, err)
+		defer dispatcher.Close()
+		dispatcher.backoff = 10 * time.Millisecond
+
+		dispatcher.Dispatch(NewPayload("kubernetes", entry))
+
+		for range 3 {
+			req := receive(t, requests)
+			require.Equal(t, "abc", req.payload.RequestId)
+		}
+	})
+
+	t.Run("should not retry client errors", func(t *testing.T) {
+		server, requests := newTestReceiver(t, http.StatusBadRequest)
+
+		dispatcher, err := NewDispatcher([]models.Webhook{{Name: "slack", Url: server.URL}}, nil, log.DefaultLogger)
+		require.NoError(t, err)
+		defer dispatcher.Close()
+		dispatcher.backoff = 10 * time.Millisecond
+
+		dispatcher.Dispatch(NewPayload("kubernetes", entry))
+
+		receive(t, requests)
+		select {
+		case <-requests:
+			t.Fatal("webhook was called again")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("should filter events and namespaces", func(t *testing.T) {
+		server, requests := newTestReceiver(t)
+
+		dispatcher, err := NewDispatcher([]models.Webhook{{Name: "slack", Url: server.URL, Events: []string{"^helmreleases:"}, Namespaces: []string{"^production$"}}}, nil, log.DefaultLogger)
+		require.NoError(t, err)
+		defer dispatcher.Close()
+
+		dispatcher.Dispatch(NewPayload("kubernetes", audit.Entry{Verb: "restart", Resource: "deployments.apps", Namespace: "production", Name: "echoserver"}))
+		dispatcher.Dispatch(NewPayload("kubernetes", audit.Entry{Verb: "uninstall", Resource: "helmreleases", Namespace: "staging", Name: "echoserver"}))
+		dispatcher.Dispatch(NewPayload("kubernetes", audit.Entry{Verb: "uninstall", Resource: "helmreleases", Namespace: "production", Name: "echoserver"}))
+
+		req := receive(t, requests)
+		require.Equal(t, "helmreleases:uninstall", req.payload.Event)
+		require.Equal(t, "production", req.payload.Namespace)
+
+		select {
+		case req := <-requests:
+			t.Fatalf("unexpected webhook call for %s", req.payload.Event)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("should not delay other webhooks", func(t *testing.T) {
+		release := make(chan struct{})
+		slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		t.Cleanup(slowServer.Close)
+		t.Cleanup(func() { close(release) })
+
+		server, requests := newTestReceiver(t)
+
+		dispatcher, err := NewDispatcher([]models.Webhook{{Name: "slow", Url: slowServer.URL}, {Name: "slack", Url: server.URL}}, nil, log.DefaultLogger)
+		require.NoError(t, err)
+		defer dispatcher.Close()
+
+		dispatcher.Dispatch(NewPayload("kubernetes", audit.Entry{Verb: "restart", Resource: "deployments.apps", Namespace: "default", Name: "echoserver"}))
+		dispatcher.Dispatch(NewPayload("kubernetes", audit.Entry{Verb: "uninstall", Resource: "helmreleases", Namespace: "default", Name: "echoserver"}))
+
+		require.Equal(t, "deployments.apps:restart", receive(t, requests).payload.Event)
+		require.Equal(t, "helmreleases:uninstall", receive(t, requests).payload.Event)
+	})
+
+	t.Run("should return nil dispatcher without webhooks", func(t *testing.T) {
+		dispatcher, err := NewDispatcher(nil, nil, log.DefaultLogger)
+		require.NoError(t, err)
+		require.Nil(t, dispatcher)
+
+		dispatcher.Dispatch(NewPayload("kubernetes", entry))
+		dispatcher.Close()
+	})
+
+	t.Run("should return error for invalid webhooks", func(t *testing.T) {
+		_, err := NewDispatcher([]models.Webhook{{Name: "slack"}}, nil, log.DefaultLogger)
+		require.Error(t, err)
+
+		_, err = NewDispatcher([]models.Webhook{{Name: "slack", Url: "http://localhost", Events: []string{"["}}}, nil, log.DefaultLogger)
+		require.Error(t, err)
+	})
+}
@@ -10,6 +10,7 @@ import { GenerateKubeconfig } from './GenerateKubeconfig';
 import { Grafana } from './Grafana';
 import { Impersonate } from './Impersonate';
 import { Integrations } from './Integrations';
+import { ProxyPolicy } from './ProxyPolicy';
 
 interface Props
   extends DataSourcePluginOptionsEditorProps<
@@ -23,6 +24,7 @@ export function ConfigEditor({ options, onOptionsChange }: Props) {
       <Cluster options={options} onOptionsChange={onOptionsChange} />
       <Grafana options={options} onOptionsChange={onOptionsChange} />
       <Impersonate options={options} onOptionsChange={onOptionsChange} />
+      <ProxyPolicy options={options} onOptionsChange={onOptionsChange} />
       <GenerateKubeconfig options={options} onOptionsChange={onOptionsChange} />
       <Integrations options={options} onOptionsChange={onOptionsChange} />
     </>
@@ -0,0 +1,59 @@
+import { css } from '@emotion/css';
+import {
+  DataSourcePluginOptionsEditorProps,
+  GrafanaTheme2,
+} from '@grafana/data';
+import { InlineField, RadioButtonGroup, useStyles2 } from '@grafana/ui';
+import React from 'react';
+
+import {
+  DataSourceOptions,
+  KubernetesSecureJsonData,
+  ProxyPolicy as ProxyPolicyMode,
+} from '../../types/settings';
+
+interface Props
+  extends DataSourcePluginOptionsEditorProps<
+    DataSourceOptions,
+    KubernetesSecureJsonData
+  > { }
+
+export function ProxyPolicy({ options, onOptionsChange }: Props) {
+  const styles = useStyles2((theme: GrafanaTheme2) => {
+    return {
+      container: css({
+        paddingTop: theme.spacing(5),
+      }),
+    };
+  });
+
+  return (
+    <div className={styles.container}>
+      <h3>Proxy Policy</h3>
+      <InlineField
+        label="Policy"
+        labelWidth={20}
+        tooltip="Restrict the requests through the proxy and the actions of the plugin. The rules of the custom policy must be configured via provisioning."
+        interactive
+      >
+        <RadioButtonGroup<ProxyPolicyMode>
+          options={[
+            { label: 'None', value: '' },
+            { label: 'Read-only', value: 'readOnly' },
+            { label: 'Custom', value: 'custom' },
+          ]}
+          value={options.jsonData.proxyPolicy ?? ''}
+          onChange={(value: ProxyPolicyMode) => {
+            onOptionsChange({
+              ...options,
+              jsonData: {
+                ...options.jsonData,
+                proxyPolicy: value,
+              },
+            });
+          }}
+        />
+      </InlineField>
+    </div>
+  );
+}
@@ -14,6 +14,13 @@ export type ClusterProvider = 'incluster' | 'path' | 'kubeconfig';
  */
 export type GrafanaServiceAccountRole = 'Admin' | 'Editor' | 'Viewer';
 
+/**
+ * ProxyPolicy defines the policy for the requests which are made through the
+ * proxy and for the actions of the plugin. The rules of the "custom" policy
+ * can only be configured via provisioning.
+ */
+export type ProxyPolicy = '' | 'readOnly' | 'custom';
+
 /**
  * These are options configured for each DataSource instance. A user must select
  * a provider and depending on the selected provider a user must provide the
@@ -27,6 +34,7 @@ export interface DataSourceOptions extends DataSourceJsonData {
   grafanaServiceAccountRole?: GrafanaServiceAccountRole;
   impersonateUser?: boolean;
   impersonateGroups?: boolean;
+  proxyPolicy?: ProxyPolicy;
   generateKubeconfig?: boolean;
   generateKubeconfigName?: string;
   generateKubeconfigTTL?: number;